@@ -0,0 +1,120 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Background agent that runs jobs dropped into a folder.
+
+AutoSuite can write files more reliably than it can pass command line arguments, so instead it can
+write a job file to JOB_DIR, e.g. `balance.json` containing
+
+    {"command": "balance", "mode": 3}
+
+The agent runs the job with the JSON keys as arguments, same as in `jobs.py`, writes the result to
+`results/<job name>.json` in the job folder and moves the job file to `done/`. The result file is
+written to a temporary file first and renamed, so a result file that exists is always complete. A
+changed config file is reloaded before the next jobs are run, see config_watch.py.
+
+To run the agent in the background on the robot PC, start `aurora-rt agent` with Task Scheduler at
+log on, or install it as a Windows service with a service wrapper like NSSM.
+
+Usage:
+    Started with `aurora-rt agent`.
+"""
+
+import json
+import logging
+import time
+from pathlib import Path
+
+from aurora_robot_tools.config import AGENT_POLL_INTERVAL, DATABASE_FILEPATH, JOB_DIR
+from aurora_robot_tools.config_watch import ConfigWatcher
+from aurora_robot_tools.errors import AbortedError, ExitCode, get_exit_code
+from aurora_robot_tools.jobs import job_arguments, run_job
+from aurora_robot_tools.shutdown import temporary
+
+logger = logging.getLogger(__name__)
+
+PARTIAL_FILE_AGE = 5  # seconds, unreadable job files younger than this may still be being written
+
+
+def write_result(path: Path, result: dict) -> None:
+    """Write a result file atomically."""
+    path.parent.mkdir(parents=True, exist_ok=True)
+    tmp_path = path.with_suffix(".tmp")
+    with temporary(tmp_path):
+        tmp_path.write_text(json.dumps(result, indent=4, default=str), encoding="utf-8")
+        tmp_path.replace(path)
+
+
+def being_written(job_path: Path) -> bool:
+    """Whether a job file was modified so recently that it may not be complete yet."""
+    try:
+        return time.time() - job_path.stat().st_mtime < PARTIAL_FILE_AGE
+    except FileNotFoundError:
+        return False
+
+
+def process_job(job_path: Path, db_path: Path, dry_run: bool = False) -> bool:
+    """Run one job file and write the result, return False if the file is not complete yet.
+
+    A job that cannot be run gets a failed result and is moved to done/ like any other, so it is not
+    tried again.
+    """
+    try:
+        job = json.loads(job_path.read_text(encoding="utf-8"))
+        if not isinstance(job, dict):
+            msg = "Job file must contain a JSON object"
+            raise TypeError(msg)
+    except FileNotFoundError:
+        logger.warning("Job file %s was removed before it was run", job_path.name)
+        return True
+    except (json.JSONDecodeError, TypeError, OSError) as e:
+        if being_written(job_path):
+            return False
+        logger.exception("Invalid job file %s", job_path.name)
+        result = {"ok": False, "exit_code": int(ExitCode.CONFIG_ERROR), "error": str(e), "messages": []}
+    else:
+        try:
+            command, args = job_arguments(job, dry_run)
+            logger.info("Running job %s: %s %s", job_path.name, command, args)
+            result = run_job(command, db_path, args)
+        except Exception as e:
+            exit_code = get_exit_code(e)
+            logger.exception("Job %s failed (exit code %d)", job_path.name, exit_code)
+            result = {"ok": False, "exit_code": int(exit_code), "error": str(e), "messages": []}
+        logger.info("Job %s finished with exit code %d", job_path.name, result["exit_code"])
+    result["job"] = job_path.name
+    write_result(job_path.parent / "results" / job_path.name, result)
+    done_path = job_path.parent / "done" / job_path.name
+    done_path.parent.mkdir(parents=True, exist_ok=True)
+    try:
+        job_path.replace(done_path)
+    except FileNotFoundError:
+        logger.warning("Job file %s was removed while it ran", job_path.name)
+    return True
+
+
+def main(
+    job_dir: Path = JOB_DIR,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+    poll_interval: float = AGENT_POLL_INTERVAL,
+) -> None:
+    """Watch the job folder and run jobs in the order they were written, until interrupted."""
+    job_dir = Path(job_dir)
+    job_dir.mkdir(parents=True, exist_ok=True)
+    logger.info("Watching %s for job files", job_dir)
+    config_watcher = ConfigWatcher()
+    try:
+        while True:
+            db_path = config_watcher.reload_if_changed(db_path)
+            for job_path in sorted(job_dir.glob("*.json"), key=lambda p: p.stat().st_mtime):
+                process_job(job_path, db_path, dry_run)
+            time.sleep(poll_interval)
+    except (KeyboardInterrupt, AbortedError):
+        logger.info("Agent stopped")
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("agent")
+    main()
@@ -0,0 +1,100 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Choose the order the robot assembles the planned cells in.
+
+The robot assembles the cells in cell number order, so the order is set when the cells are numbered
+by balancing. ASSEMBLY_ORDER in the config, or `--order` of balance and rebalance, is one of:
+    - rack: in rack position order, the default
+    - electrolyte: cells with the same electrolyte one after the other, so the robot changes the
+      electrolyte vial, and cleans the tool, as few times as possible
+    - chemistry: cells with the same anode and cathode type one after the other, and with the same
+      electrolyte within each chemistry
+    - round-robin: one cell from each rack column in turn, the columns are linked to the presses in
+      PRESS_TO_RACK, so consecutive cells go to different presses and all presses are kept busy
+
+Groups come in the order of their first rack position, within a group the cells stay in rack order.
+The number of electrolyte changes is logged, compared to the rack order.
+
+Usage:
+    Set ASSEMBLY_ORDER in the config, or e.g. `aurora-rt balance --order electrolyte`.
+"""
+
+import logging
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import ASSEMBLY_ORDER, PRESS_TO_RACK
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+ORDERS = ("rack", "electrolyte", "chemistry", "round-robin")
+
+strategy: str | None = None  # Set by --order, None to use ASSEMBLY_ORDER
+
+
+def group_rank(keys: pd.Series) -> np.ndarray:
+    """Number the groups of equal keys in the order they first appear."""
+    first = dict.fromkeys(keys)
+    ranks = {key: rank for rank, key in enumerate(first)}
+    return keys.map(ranks).to_numpy()
+
+
+def electrolyte_changes(electrolytes: pd.Series) -> int:
+    """Number of times the electrolyte changes between consecutive cells."""
+    values = electrolytes.fillna(-1).to_numpy()
+    return int((values[1:] != values[:-1]).sum())
+
+
+def current_order() -> str:
+    """The assembly order from --order or the config."""
+    order = strategy or ASSEMBLY_ORDER
+    if order not in ORDERS:
+        msg = f"Assembly order must be one of {', '.join(ORDERS)}, got '{order}'."
+        raise ConfigError(msg)
+    return order
+
+
+def order_cells(df: pd.DataFrame, indices: np.ndarray, order: str | None = None) -> np.ndarray:
+    """Sort the rows of the cells to number into the order they are assembled in.
+
+    Args:
+        df: The Cell_Assembly_Table, sorted by rack position
+        indices: Rows of the cells to number, in rack order
+        order: One of ORDERS, default from --order or ASSEMBLY_ORDER
+
+    Returns:
+        The rows in assembly order
+
+    """
+    order = order or current_order()
+    indices = np.asarray(indices)
+    if order == "rack" or indices.size <= 1:
+        return indices
+    cells = df.iloc[indices]
+    electrolyte = group_rank(cells["Electrolyte Position"].fillna(-1))
+    if order == "electrolyte":
+        keys = (electrolyte,)
+    elif order == "chemistry":
+        chemistry = cells["Anode Type"].astype(str) + " | " + cells["Cathode Type"].astype(str)
+        keys = (group_rank(chemistry), electrolyte)
+    else:
+        n_presses = len(PRESS_TO_RACK)
+        column = (cells["Rack Position"].astype(int) - 1) % n_presses + 1
+        press_of_column = {rack: press for press, rack in PRESS_TO_RACK.items()}
+        turn = column.groupby(column).cumcount().to_numpy()
+        keys = (turn, column.map(press_of_column).to_numpy())
+    # lexsort sorts by the last key first, and is stable, so cells stay in rack order within a group
+    return indices[np.lexsort(tuple(reversed(keys)))]
+
+
+def log_order(df: pd.DataFrame) -> None:
+    """Log the assembly order of the numbered cells and how many electrolyte changes it needs."""
+    cells = df[df["Cell Number"] > 0]
+    logger.info(
+        "Assembling in %s order, %d electrolyte changes, %d in rack order",
+        current_order(),
+        electrolyte_changes(cells.sort_values("Cell Number")["Electrolyte Position"]),
+        electrolyte_changes(cells.sort_values("Rack Position")["Electrolyte Position"]),
+    )
@@ -8,14 +8,18 @@ presses by updating both tables. The AutoSuite software will then use this infor
 the appropriate cells.
 
 Usage:
-    The script is called from assign_cells_to_press.exe by the AutoSuite software.
+    The script is called with `aurora-rt assign` by the AutoSuite software.
     It can also be called from the command line.
 
     There are two optional parameters that can set with the command line call.
 
     - `link_rack_pos_to_press` (bool, default False):
         If set, press 1 will only accept cells from rack positions 1, 7, 13, 19, 25, 31. Press 2
-        only accepts cells from rack positions 2, 8, 14, 20, 26, 32, and so on.
+        only accepts cells from rack positions 4, 10, 16, 22, 28, 34, and so on.
+
+    The presses and their linked rack positions are defined by PRESS_TO_RACK in the config, presses
+    that are out of service are disabled with `aurora-rt press disable`, see presses.py, or listed
+    in DISABLED_PRESSES.
 
     - `limit_electrolytes_per_batch` (int, default 0):
         0 - No limit on the number of different electrolytes in a batch of up to 6 cells.
@@ -23,99 +27,175 @@ Usage:
             This is useful if the electrolyte is volatile, since the cleaning step between each
             electrolyte switch is time-consuming.
 
+    - `minimize_travel` (bool, default False):
+        If rack positions are not linked to presses, assign each press the available cell in the
+        rack column closest to the press, instead of the first available cell, to reduce the
+        distance the robot arm moves.
+
     e.g. `py assign_cells_to_press.py 1 2`
     This will ensure that rack positions and press positions are linked (rack 1 only goes to press
     1, rack 2 to press 2, etc.) and limit the number of different electrolytes in each batch to 2.
+
+    Each press takes the first available cell in cell number order, the order the cells are
+    assembled in, see assembly_order.py.
+
+    A press is occupied while a cell is loaded, either in the Cell_Assembly_Table "Current Press
+    Number" or in the Press_Table "Current Cell Number Loaded", e.g. a cell resting in the press.
+    New cells are only assigned to free presses. The time a cell was loaded is stored in the
+    "Loaded Time" column of the Press_Table as a unix timestamp, so the log shows how long presses
+    have been occupied.
+
+    Cells with a Pressure Tolerance (%) in the input need a press with a calibration error of at most
+    that many percent, stored with `aurora-rt press calibrate`, see presses.py. If any press is
+    calibrated, the presses are filled from the best calibrated one, and each press takes the cell
+    with the tightest tolerance it can accept first, so those cells get the best presses.
+
+    Cells pinned to a press with `aurora-rt overrides` are only loaded into that press, before any
+    other cell, see overrides.py.
+
+    If a target stack height is set, the spacers of the cells are chosen before they are written,
+    see stack.py.
 """
 
-import sqlite3
+import logging
 import sys
+import time
+from pathlib import Path
 from tkinter import Tk, messagebox
 
 import numpy as np
 import pandas as pd
 
-from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.cell_state import get_states, in_state, set_state
+from aurora_robot_tools.config import DATABASE_FILEPATH, PRESS_TO_RACK
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.presses import disabled_presses, press_calibration
+from aurora_robot_tools.stack import assign_spacers, read_spacers
+from aurora_robot_tools.watchdog import waiting
 
-RETURN_STEP = 140  # Step number for returned cell in robot recipe
+logger = logging.getLogger(__name__)
 
-PRESS_TO_RACK = {
-    1: 1,
-    2: 4,
-    3: 2,
-    4: 5,
-    5: 3,
-    6: 6,
-}
+RETURN_STEP = 140  # Step number for returned cell in robot recipe
 
 
-def main(link_rack_pos_to_press: bool, limit_electrolytes_per_batch: int) -> None:
+def main(
+    link_rack_pos_to_press: bool,
+    limit_electrolytes_per_batch: int,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+    minimize_travel: bool = False,
+) -> None:
     """Assign cells to pressing tools.
 
     Args:
         link_rack_pos_to_press: Whether to only assign certain rack positions to certain pressing tools
         limit_electrolytes_per_batch: The maximum number of different electrolytes to assign to a batch
+        db_path: Path to the robot database
+        dry_run: Log the changes instead of writing them to the database
+        minimize_travel: Assign the cell closest to each press instead of the first available cell
 
     """
     # Read the Cell_Assembly_Table and Press_Table tables from the database.
-    with sqlite3.connect(DATABASE_FILEPATH) as conn:
-        df = pd.read_sql("SELECT * FROM Cell_Assembly_Table", conn)
-        df_press = pd.read_sql("SELECT * FROM Press_Table", conn)
-
-    # Check where the cell number loaded is 0 and where the error code is 0 for the presses
-    working_press_numbers = np.where(df_press["Error Code"] == 0)[0] + 1
+    df, df_press = read_tables(db_path, "Cell_Assembly_Table", "Press_Table")
+    df_spacer = read_spacers(db_path)
+    disabled = disabled_presses(db_path)
+    calibration = press_calibration(db_path)
+    if "Loaded Time" not in df_press.columns:  # Databases imported by older versions
+        df_press["Loaded Time"] = 0
 
     # Find rack positions with cells that are assigned for assembly (Cell Number > 0), have not
     # finished assembly, with no error code, and find their cell numbers and electrolyte positions
-    available_rack_pos = (
-        np.where(
-            (df["Cell Number"] > 0)
-            & (df["Last Completed Step"] < RETURN_STEP)
-            & (df["Error Code"] == 0)
-            & (df["Current Press Number"] == 0),
-        )[0]
-        + 1
+    waiting = (
+        (df["Cell Number"] > 0)
+        & (df["Last Completed Step"] < RETURN_STEP)
+        & (df["Error Code"] == 0)
+        & (df["Current Press Number"] == 0)
     )
+    ready = in_state(df, "balanced", "electrolyte calculated")
+    if (waiting & ~ready).any():
+        states = get_states(df)[waiting & ~ready]
+        logger.warning(
+            "Cells %s are not assigned, they must be balanced first but are %s.",
+            df.loc[states.index, "Cell Number"].astype(int).tolist(),
+            ", ".join(sorted(set(states))),
+        )
+    available_rack_pos = np.where(waiting & ready)[0] + 1
+    # The robot assembles in cell number order, see assembly_order.py
+    available_rack_pos = available_rack_pos[
+        np.argsort(df.loc[available_rack_pos - 1, "Cell Number"].to_numpy(), kind="stable")
+    ]
     available_cell_numbers = df.loc[available_rack_pos - 1, "Cell Number"].to_numpy().astype(int)
     available_electrolytes = df.loc[available_rack_pos - 1, "Electrolyte Position"].to_numpy().astype(int)
+    if "Pressure Tolerance (%)" in df.columns:
+        available_tolerances = df.loc[available_rack_pos - 1, "Pressure Tolerance (%)"].to_numpy(dtype=float)
+    else:
+        available_tolerances = np.full(len(available_rack_pos), np.nan)
+    if "Pinned Press Number" in df.columns:
+        available_pins = df.loc[available_rack_pos - 1, "Pinned Press Number"].to_numpy(dtype=float)
+    else:
+        available_pins = np.full(len(available_rack_pos), np.nan)
+    warn_unreachable_tolerances(available_cell_numbers, available_tolerances, calibration, disabled)
+    pinned_to_disabled = np.isin(available_pins, list(disabled))
+    if pinned_to_disabled.any():
+        logger.warning(
+            "Cells %s are pinned to disabled presses, they are not loaded until the press is enabled",
+            ", ".join(str(cell) for cell in available_cell_numbers[pinned_to_disabled]),
+        )
 
+    n_presses = len(PRESS_TO_RACK)
     if link_rack_pos_to_press:
-        print(
-            f"Limited to press:cell pairs {', '.join([str(k) + ':' + str(v) + '+6x' for k, v in PRESS_TO_RACK.items()])}"
+        logger.info(
+            "Limited to press:cell pairs %s",
+            ", ".join([f"{k}:{v}+{n_presses}x" for k, v in PRESS_TO_RACK.items()]),
         )
+    if disabled:
+        logger.info("Presses disabled: %s", ", ".join(f"{p} ({reason})" for p, reason in disabled.items()))
     if limit_electrolytes_per_batch:
-        print(f"Limiting electrolytes to {limit_electrolytes_per_batch} per batch")
+        logger.info("Limiting electrolytes to %d per batch", limit_electrolytes_per_batch)
+    if minimize_travel and not link_rack_pos_to_press:
+        logger.info("Assigning the closest cell to each press")
 
     electrolytes_used = []
-    presses_with_errors = df_press.loc[df_press["Error Code"] != 0, "Press Number"].to_numpy()
+    presses_with_errors = [
+        *df_press.loc[df_press["Error Code"] != 0, "Press Number"].to_numpy(),
+        *disabled,
+    ]
     presses_already_loaded = df.loc[df["Current Press Number"] > 0, "Current Press Number"].to_numpy()
     cells_already_loaded = df.loc[df["Current Press Number"] > 0, "Cell Number"].to_numpy()
     rack_already_loaded = df.loc[df["Current Press Number"] > 0, "Rack Position"].to_numpy()
+    presses_occupied = df_press.loc[df_press["Current Cell Number Loaded"] > 0, "Press Number"].to_numpy()
+    now = int(time.time())
     presses_to_load = []
     cells_to_load = []
     rack_to_load = []
 
-    # Loop through presses, check conditions then assign the first available cell to the press
-    for press in range(1, 7):
+    # Loop through presses, best calibrated first, check conditions then assign the first available cell
+    for press in sorted(PRESS_TO_RACK, key=lambda p: (p not in calibration, calibration.get(p, 0))):
         availability_mask = np.ones(len(available_rack_pos), dtype=bool)
 
         # If no more cells available, stop
         if available_cell_numbers.size == 0:
-            print("No more cells available")
+            logger.info("No more cells available")
             break
 
         # If using link_rack_pos_to_press and press has an error code,
         # add an error code to all rack positions linked to that press
         if (press in presses_with_errors) and link_rack_pos_to_press:
-            error_mask = (available_rack_pos - 1) % 6 + 1 == PRESS_TO_RACK[press]
+            error_mask = (available_rack_pos - 1) % n_presses + 1 == PRESS_TO_RACK[press]
             if available_cell_numbers[error_mask].size > 0:
-                print(
-                    f"Press {press} has an error, "
-                    f"giving error code to cells with rack position {available_cell_numbers[error_mask]}"
+                logger.warning(
+                    "Press %d has an error, giving error code to cells with rack position %s",
+                    press,
+                    available_cell_numbers[error_mask],
                 )
                 df.loc[available_rack_pos[error_mask] - 1, "Error Code"] = 301
             else:
-                print(f"Press {press} has an error")
+                logger.warning("Press %d has an error", press)
+            continue
+
+        # Never assign cells to presses that are disabled
+        if press in disabled:
+            logger.info("Press %d is disabled: %s", press, disabled[press])
             continue
 
         # If press already has a cell loaded
@@ -131,35 +211,64 @@ def main(link_rack_pos_to_press: bool, limit_electrolytes_per_batch: int) -> Non
             if df["Error Code"].loc[idxs[0]] == 0:
                 electrolyte = df["Electrolyte Position"].loc[idxs[0]]
                 electrolytes_used.append(electrolyte)
+            log_occupied(df_press, press, df["Cell Number"].loc[idxs[0]], now)
+            continue
+
+        # If the press is still occupied by a cell that is not in this batch, e.g. resting
+        if press in presses_occupied:
+            cell = df_press.loc[df_press["Press Number"] == press, "Current Cell Number Loaded"].iloc[0]
+            log_occupied(df_press, press, cell, now)
             continue
 
         # If using link_rack_pos_to_press, only consider cells in the correct rack position
         if link_rack_pos_to_press:
-            availability_mask = (available_rack_pos - 1) % 6 + 1 == PRESS_TO_RACK[press]
+            availability_mask = (available_rack_pos - 1) % n_presses + 1 == PRESS_TO_RACK[press]
 
         # Only allow limit_electrolytes_per_batch different electrolytes to be loaded at once (if > 0)
         if limit_electrolytes_per_batch and len(set(electrolytes_used)) >= limit_electrolytes_per_batch:
             availability_mask &= [electrolyte in electrolytes_used for electrolyte in available_electrolytes]
 
-        # Assign the first available cell to the press
+        # Cells with a pressure tolerance only go to presses calibrated within the tolerance
+        error = calibration.get(press, np.inf)
+        availability_mask &= np.isnan(available_tolerances) | (available_tolerances >= error)
+
+        # Cells pinned to a press only go to that press
+        availability_mask &= np.isnan(available_pins) | (available_pins == press)
+
+        # Assign the first available cell to the press, a cell pinned to it, the one with the tightest
+        # pressure tolerance, or the closest one if minimizing travel
         final_available_cell_numbers = available_cell_numbers[availability_mask]
         if final_available_cell_numbers.size > 0:
-            loaded_cell = final_available_cell_numbers[0]
+            choice = 0
+            tolerances = np.nan_to_num(available_tolerances[availability_mask], nan=np.inf)
+            pinned = available_pins[availability_mask] == press
+            if pinned.any():
+                choice = int(np.argmax(pinned))
+            elif np.isfinite(tolerances).any():
+                choice = int(np.argmin(tolerances))
+            elif minimize_travel and not link_rack_pos_to_press:
+                rack_columns = (available_rack_pos[availability_mask] - 1) % n_presses + 1
+                choice = int(np.argmin(np.abs(rack_columns - PRESS_TO_RACK[press])))
+            loaded_cell = final_available_cell_numbers[choice]
             cells_to_load.append(loaded_cell)
             presses_to_load.append(press)
-            rack_to_load.append(available_rack_pos[availability_mask][0])
+            rack_to_load.append(available_rack_pos[availability_mask][choice])
             if limit_electrolytes_per_batch:
                 electrolytes_used.append(loaded_cell)
-            df_press.loc[press - 1, "Current Cell Number Loaded"] = loaded_cell
+            df_press.loc[df_press["Press Number"] == press, "Current Cell Number Loaded"] = loaded_cell
+            df_press.loc[df_press["Press Number"] == press, "Loaded Time"] = now
             df.loc[df["Cell Number"] == loaded_cell, "Current Press Number"] = press
+            set_state(df, df["Cell Number"] == loaded_cell, "press assigned")
 
             # Remove the loaded cell from the available cells
             removed_idx = np.where(available_cell_numbers == loaded_cell)[0][0]
             available_cell_numbers = np.delete(available_cell_numbers, removed_idx)
             available_rack_pos = np.delete(available_rack_pos, removed_idx)
             available_electrolytes = np.delete(available_electrolytes, removed_idx)
+            available_tolerances = np.delete(available_tolerances, removed_idx)
+            available_pins = np.delete(available_pins, removed_idx)
         else:
-            print(f"Press {press} has no available cells to load")
+            logger.info("Press %d has no available cells to load", press)
             continue
 
     # If there are cells already loaded into presses and new cells that can be loaded
@@ -167,39 +276,72 @@ def main(link_rack_pos_to_press: bool, limit_electrolytes_per_batch: int) -> Non
     if len(presses_already_loaded) > 0 and len(cells_to_load) > 0:
         root = Tk()
         root.withdraw()
-        load_new_cells = messagebox.askyesno(
-            title="Cells already loaded",
-            message="Some cells are already loaded into presses:\n\nPress | Rack | Cell\n"
-            + "".join(
-                [
-                    f"{p:<10} {r:<9} {c:<9}\n"
-                    for p, r, c in zip(presses_already_loaded, rack_already_loaded, cells_already_loaded)
-                ]
+        with waiting("the operator to confirm loading new cells"):
+            load_new_cells = messagebox.askyesno(
+                title="Cells already loaded",
+                message="Some cells are already loaded into presses:\n\nPress | Rack | Cell\n"
+                + "".join(
+                    [
+                        f"{p:<10} {r:<9} {c:<9}\n"
+                        for p, r, c in zip(presses_already_loaded, rack_already_loaded, cells_already_loaded)
+                    ]
+                )
+                + "\nDo you also want to load new cells?\n\nPress | Rack | Cell\n"
+                + "".join(
+                    [f"{p:<10} {r:<9} {c:<9}\n" for p, r, c in zip(presses_to_load, rack_to_load, cells_to_load)]
+                ),
             )
-            + "\nDo you also want to load new cells?\n\nPress | Rack | Cell\n"
-            + "".join([f"{p:<10} {r:<9} {c:<9}\n" for p, r, c in zip(presses_to_load, rack_to_load, cells_to_load)]),
-        )
     else:
         load_new_cells = True
 
     # Write the updated tables back to the database
     if load_new_cells and len(cells_to_load) > 0:
-        print(
-            "Loading:\n"
-            + "Press | Rack | Cell\n"
-            + "".join([f"{p:<7} {r:<6} {c:<6}\n" for p, r, c in zip(presses_to_load, rack_to_load, cells_to_load)])
+        logger.info(
+            "Loading:\nPress | Rack | Cell\n%s",
+            "".join([f"{p:<7} {r:<6} {c:<6}\n" for p, r, c in zip(presses_to_load, rack_to_load, cells_to_load)]),
         )
-        with sqlite3.connect(DATABASE_FILEPATH) as conn:
-            df_press.to_sql("Press_Table", conn, index=False, if_exists="replace")
-            df.to_sql("Cell_Assembly_Table", conn, index=False, if_exists="replace")
-        print("Successfully updated the database")
+        assign_spacers(df, df_spacer)
+        write_tables(db_path, {"Press_Table": df_press, "Cell_Assembly_Table": df}, dry_run=dry_run)
+        if not dry_run:
+            logger.info("Successfully updated the database")
     elif len(cells_to_load) == 0:
-        print("No cells available to load")
+        logger.info("No cells available to load")
+    else:
+        logger.info("Not loading new cells - finishing current assembly first")
+
+
+def warn_unreachable_tolerances(
+    cell_numbers: np.ndarray,
+    tolerances: np.ndarray,
+    calibration: dict[int, float],
+    disabled: dict[int, str],
+) -> None:
+    """Warn about cells with a pressure tolerance tighter than the calibration of every press."""
+    errors = [error for press, error in calibration.items() if press in PRESS_TO_RACK and press not in disabled]
+    best = min(errors, default=np.inf)
+    unreachable = ~np.isnan(tolerances) & (tolerances < best)
+    if unreachable.any():
+        logger.warning(
+            "Cells %s need a press calibrated within %s %%, the best available press is %s",
+            ", ".join(str(cell) for cell in cell_numbers[unreachable]),
+            ", ".join(f"{tolerance:g}" for tolerance in tolerances[unreachable]),
+            f"within {best:g} %" if errors else "not calibrated",
+        )
+
+
+def log_occupied(df_press: pd.DataFrame, press: int, cell: int, now: int) -> None:
+    """Log which cell a press is occupied by and for how long."""
+    loaded_time = df_press.loc[df_press["Press Number"] == press, "Loaded Time"].iloc[0]
+    if loaded_time > 0:
+        logger.info("Press %d is occupied by cell %d for %.0f min", press, cell, (now - loaded_time) / 60)
     else:
-        print("Not loading new cells - finishing current assembly first")
+        logger.info("Press %d is occupied by cell %d", press, cell)
 
 
 if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("assign")
     link = bool(sys.argv[1]) if len(sys.argv) >= 2 else True
     limit = int(sys.argv[2]) if len(sys.argv) >= 3 else 0
     main(link, limit)
@@ -2,40 +2,150 @@
 
 Script to backup the chemspeedDB database to a folder with the base sample ID as the filename.
 
+Before a command first writes to the database, a snapshot is also saved automatically to the Auto
+folder in the backup folder, named by time and command. Only the most recent AUTO_BACKUP_KEEP
+snapshots are kept. A snapshot can be restored with `aurora-rt restore`, which snapshots the current
+database first, so a restore can also be undone.
+
+Snapshots are made with the SQLite backup API, so they are consistent even if another program is
+using the database, and include the changes still in the write-ahead log of a database in WAL mode.
 """
 
-import shutil
+import logging
 import sqlite3
+from contextlib import closing
 from datetime import datetime
+from pathlib import Path
 
 import pytz
 
-from aurora_robot_tools.config import DATABASE_BACKUP_DIR, DATABASE_FILEPATH, TIME_ZONE
+from aurora_robot_tools import progress
+from aurora_robot_tools.config import AUTO_BACKUP_KEEP, DATABASE_BACKUP_DIR, DATABASE_FILEPATH, TIME_ZONE
+from aurora_robot_tools.database import get_setting
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+
+def auto_backup_dir() -> Path:
+    """Folder for the automatic snapshots."""
+    return Path(DATABASE_BACKUP_DIR) / "Auto"
+
+
+def copy_database(source: Path, target: Path) -> None:
+    """Copy a database with the SQLite backup API."""
+    with closing(sqlite3.connect(source)) as src, closing(sqlite3.connect(target)) as dst:
+        src.backup(dst)
+        # The copy is one file, also if the source is in WAL mode
+        dst.execute("PRAGMA journal_mode = DELETE")
+
+
+def auto_backup(db_path: Path = DATABASE_FILEPATH, keep: int | None = None) -> Path | None:
+    """Snapshot the database before it is modified and remove old snapshots, keep AUTO_BACKUP_KEEP if not given.
+
+    Returns:
+        Path to the snapshot, or None if disabled or the snapshot failed.
+
+    """
+    if keep is None:
+        keep = AUTO_BACKUP_KEEP
+    if keep <= 0:
+        return None
+    backup_dir = auto_backup_dir()
+    timestamp = datetime.now(pytz.timezone(TIME_ZONE)).strftime("%Y-%m-%d_%H-%M-%S")
+    name = f"{timestamp}_{progress.status.get('command', 'aurora-rt')}"
+    backup_filepath = backup_dir / f"{name}.db"
+    i = 1
+    while backup_filepath.exists():
+        backup_filepath = backup_dir / f"{name}_{i}.db"
+        i += 1
+    try:
+        backup_dir.mkdir(parents=True, exist_ok=True)
+        copy_database(db_path, backup_filepath)
+    except (OSError, sqlite3.Error) as e:
+        logger.warning("Could not snapshot database to %s: %s", backup_dir, e)
+        return None
+    logger.info("Database snapshot saved to %s.", backup_filepath)
+    for old_file in list_backups()[keep:]:
+        old_file.unlink(missing_ok=True)
+    return backup_filepath
+
+
+def list_backups() -> list[Path]:
+    """Automatic snapshots, newest first."""
+    return sorted(auto_backup_dir().glob("*.db"), key=lambda p: p.stat().st_mtime, reverse=True)
+
+
+def restore(backup: str | None = None, db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
+    """Replace the database with a snapshot, the most recent one if not given.
+
+    Args:
+        backup: Path or filename in the Auto backup folder of the snapshot to restore.
+        db_path: Path to the robot database.
+        dry_run: Only log which snapshot would be restored.
+
+    """
+    if backup is None:
+        backups = list_backups()
+        if not backups:
+            msg = f"No snapshots found in {auto_backup_dir()}."
+            raise ConfigError(msg)
+        backup_filepath = backups[0]
+    else:
+        backup_filepath = Path(backup)
+        if not backup_filepath.exists():
+            backup_filepath = auto_backup_dir() / backup
+    if not backup_filepath.exists():
+        msg = f"Snapshot {backup} not found."
+        raise ConfigError(msg)
+    if dry_run:
+        logger.info("Dry run, would restore database %s from %s.", db_path, backup_filepath)
+        return
+    # Read the snapshot into memory first, it could be removed when snapshotting the current database
+    with closing(sqlite3.connect(":memory:")) as snapshot:
+        with closing(sqlite3.connect(backup_filepath)) as src:
+            src.backup(snapshot)
+        if Path(db_path).exists() and not auto_backup(db_path):
+            logger.warning("Restoring without a snapshot of the current database.")
+        with closing(sqlite3.connect(db_path)) as dst:
+            snapshot.backup(dst)
+    logger.info("Database %s restored from %s.", db_path, backup_filepath)
 
 
-def main() -> None:
+def log_backups() -> None:
+    """Log the automatic snapshots, newest first."""
+    backups = list_backups()
+    if not backups:
+        logger.info("No snapshots found in %s.", auto_backup_dir())
+        return
+    logger.info("Snapshots in %s:\n%s", auto_backup_dir(), "\n".join(b.name for b in backups))
+
+
+def main(db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
     """Make a backup of the database to the backup folder."""
     value = ""
     try:
-        with sqlite3.connect(DATABASE_FILEPATH) as conn:
-            cursor = conn.cursor()
-            cursor.execute("SELECT value FROM Settings_Table WHERE key = 'Base Sample ID'")
-            result = cursor.fetchone()
-            value = result[0] if result is not None else ""
+        value = get_setting(db_path, "Base Sample ID") or ""
     except sqlite3.Error as e:
-        print("Database error: ", e)
+        logger.warning("Database error: %s", e)
 
     if value == "":
         tz = pytz.timezone(TIME_ZONE)
         value = datetime.now(tz).strftime("%Y-%m-%d_%H-%M-%S")
-        print("Base Sample ID not found in the database. Using current timestamp instead.")
+        logger.warning("Base Sample ID not found in the database. Using current timestamp instead.")
 
     # copy database file to backup folder with the base sample ID as the filename
-    DATABASE_BACKUP_DIR.mkdir(parents=True, exist_ok=True)
     backup_filepath = DATABASE_BACKUP_DIR / (value + ".db")
-    shutil.copy(DATABASE_FILEPATH, backup_filepath)
-    print(f"Database backed up to {backup_filepath}.")
+    if dry_run:
+        logger.info("Dry run, would back up database to %s.", backup_filepath)
+        return
+    DATABASE_BACKUP_DIR.mkdir(parents=True, exist_ok=True)
+    copy_database(db_path, backup_filepath)
+    logger.info("Database backed up to %s.", backup_filepath)
 
 
 if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("backup")
     main()
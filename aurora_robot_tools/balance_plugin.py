@@ -0,0 +1,136 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Use an external script to pair the electrodes, instead of the built-in sorting methods.
+
+With sorting method 8, each batch is passed to the command in BALANCE_PLUGIN in the config, e.g.
+"python C:/Modules/Plugins/my_pairing.py", so new pairing rules can be tried without changing the
+tools. "python" runs the interpreter of the tools' environment if there is one, see pyenv.py. The
+script reads one JSON object from stdin:
+
+    {
+        "rejection_cost_factor": 2.0,
+        "cells": [
+            {
+                "index": 0,
+                "anode_type": "Graphite",
+                "anode_capacity_mAh": 2.1,
+                "anode_diameter_mm": 15.0,
+                "cathode_type": "NMC811",
+                "cathode_capacity_mAh": 1.9,
+                "cathode_diameter_mm": 14.0,
+                "np_ratio_target": 1.1,
+                "np_ratio_minimum": 1.05,
+                "np_ratio_maximum": 1.15
+            },
+            ...
+        ]
+    }
+
+and writes one JSON object to stdout with, for each position in the batch, the index of the anode,
+cathode and N:P ratio limits to put there:
+
+    {"anode": [0, 1, 2], "cathode": [2, 0, 1], "ratio": [0, 1, 2]}
+
+"ratio" can be left out to keep the N:P ratio limits with their anode. Each list must use every
+index exactly once. Anything the script writes to stderr is logged.
+"""
+
+import json
+import logging
+import os
+import shlex
+import subprocess
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import BALANCE_PLUGIN, BALANCE_PLUGIN_TIMEOUT
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+from aurora_robot_tools.integrity import check_command
+from aurora_robot_tools.pyenv import resolve_command
+from aurora_robot_tools.shutdown import run_child
+
+logger = logging.getLogger(__name__)
+
+CELL_FIELDS = {
+    "anode_type": "Anode Type",
+    "anode_capacity_mAh": "Anode Balancing Capacity (mAh)",
+    "anode_diameter_mm": "Anode Diameter (mm)",
+    "cathode_type": "Cathode Type",
+    "cathode_capacity_mAh": "Cathode Balancing Capacity (mAh)",
+    "cathode_diameter_mm": "Cathode Diameter (mm)",
+    "np_ratio_target": "N:P Ratio Target",
+    "np_ratio_minimum": "N:P Ratio Minimum",
+    "np_ratio_maximum": "N:P Ratio Maximum",
+}
+
+
+def batch_to_json(df_batch: pd.DataFrame, rejection_cost_factor: float) -> str:
+    """Describe the cells of a batch for the plugin."""
+    cells = []
+    for i, (_, row) in enumerate(df_batch.iterrows()):
+        cell = {"index": i}
+        for field, column in CELL_FIELDS.items():
+            value = row.get(column)
+            cell[field] = None if pd.isna(value) else value
+        cells.append(cell)
+    return json.dumps({"rejection_cost_factor": rejection_cost_factor, "cells": cells}, default=float)
+
+
+def parse_pairings(output: str, n_rows: int) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
+    """Check and convert the plugin output to anode, cathode and ratio indices."""
+    try:
+        pairings = json.loads(output)
+    except json.JSONDecodeError as e:
+        msg = f"Balancing plugin did not return valid JSON: {e}"
+        raise ConfigError(msg) from e
+    if not isinstance(pairings, dict):
+        msg = "Balancing plugin must return a JSON object."
+        raise ConfigError(msg)
+    pairings.setdefault("ratio", pairings.get("anode"))
+    indices = []
+    for key in ("anode", "cathode", "ratio"):
+        values = pairings.get(key)
+        if not isinstance(values, list) or sorted(values) != list(range(n_rows)):
+            msg = f"Balancing plugin must return '{key}' as a list using each index from 0 to {n_rows - 1} once."
+            raise ConfigError(msg)
+        indices.append(np.array(values, dtype=int))
+    return indices[0], indices[1], indices[2]
+
+
+def plugin_assign(
+    df_batch: pd.DataFrame,
+    rejection_cost_factor: float = 2,
+    command: str | None = None,
+    timeout: float | None = None,
+) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
+    """Run the plugin on one batch, BALANCE_PLUGIN with BALANCE_PLUGIN_TIMEOUT if not given.
+
+    Returns:
+        Indices of the anodes, cathodes and N:P ratios for each row of the batch
+
+    """
+    if command is None:
+        command = BALANCE_PLUGIN
+    if timeout is None:
+        timeout = BALANCE_PLUGIN_TIMEOUT
+    if not command:
+        msg = "Sorting method 8 needs BALANCE_PLUGIN set to a command in the config."
+        raise ConfigError(msg)
+    args = resolve_command(shlex.split(command, posix=os.name != "nt"))
+    check_command(args)
+    try:
+        # Stopped with the command if it is aborted, see shutdown.py
+        result = run_child(args, batch_to_json(df_batch, rejection_cost_factor), timeout)
+    except FileNotFoundError as e:
+        msg = f"Balancing plugin {args[0]} not found."
+        raise EnvironmentProblemError(msg) from e
+    except subprocess.TimeoutExpired as e:
+        msg = f"Balancing plugin took longer than {timeout} s."
+        raise ConfigError(msg) from e
+    for line in result.stderr.splitlines():
+        logger.info("Plugin: %s", line)
+    if result.returncode != 0:
+        msg = f"Balancing plugin exited with code {result.returncode}."
+        raise ConfigError(msg)
+    return parse_pairings(result.stdout, len(df_batch))
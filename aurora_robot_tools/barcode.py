@@ -0,0 +1,142 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Read barcodes from a scanner and match them against the rack positions in the database.
+
+Scanners act as a keyboard (stdin) or a serial port, and send one barcode per line. Each line is
+either a barcode, which belongs to the next rack position, starting at 1, or `position,barcode` to
+give the rack position explicitly. Scanning stops at an empty line, `END` or the end of input.
+
+Each barcode is compared to the Barcode column of the Cell_Assembly_Table:
+    - If the rack position has no barcode yet, it is stored
+    - If it matches the stored barcode or the sample ID of the cell, it is accepted
+    - Otherwise it is a mismatch, e.g. a mislabeled rack, and nothing is written
+
+Usage:
+    Called with `aurora-rt scan` before assigning cells to presses, add `--port COM3` to read from a
+    serial scanner.
+"""
+
+import logging
+import re
+import sys
+from collections.abc import Iterator
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+END_OF_SCAN = {"", "END"}
+EXPLICIT_POSITION = re.compile(r"^(\d+)\s*[,;\t]\s*(.+)$")
+
+
+def read_stdin() -> Iterator[str]:
+    """Yield scanned lines from stdin."""
+    for line in sys.stdin:
+        yield line.strip()
+
+
+def read_serial(port: str, baud_rate: int) -> Iterator[str]:
+    """Yield scanned lines from a serial port."""
+    import serial
+
+    with serial.Serial(port, baud_rate) as ser:
+        while True:
+            yield ser.readline().decode("utf-8", errors="replace").strip()
+
+
+def parse_scans(lines: Iterator[str]) -> list[tuple[int, str]]:
+    """Turn scanned lines into (rack position, barcode) pairs."""
+    scans = []
+    next_position = 1
+    for line in lines:
+        if line.upper() in END_OF_SCAN:
+            break
+        match = EXPLICIT_POSITION.match(line)
+        if match:
+            position, barcode = int(match.group(1)), match.group(2).strip()
+        else:
+            position, barcode = next_position, line
+        scans.append((position, barcode))
+        next_position = position + 1
+    return scans
+
+
+def match_barcodes(df: pd.DataFrame, scans: list[tuple[int, str]]) -> tuple[dict[int, str], list[str]]:
+    """Compare scanned barcodes with the database.
+
+    Returns:
+        New barcodes to store by rack position, and a list of mismatch descriptions
+
+    """
+    new_barcodes: dict[int, str] = {}
+    mismatches = []
+    rows = df.set_index("Rack Position")
+    for position, barcode in scans:
+        if position not in rows.index:
+            mismatches.append(f"Rack position {position} does not exist (scanned {barcode})")
+            continue
+        stored = rows.at[position, "Barcode"]
+        stored = "" if pd.isna(stored) else str(stored)
+        sample_id = rows.at[position, "Sample ID"]
+        if barcode in (stored, sample_id):
+            continue
+        if stored == "":
+            new_barcodes[position] = barcode
+        else:
+            mismatches.append(f"Rack position {position} has barcode {stored}, scanned {barcode}")
+    return new_barcodes, mismatches
+
+
+def main(
+    port: str | None = None,
+    baud_rate: int = 9600,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Read barcodes, check them against the database and store new ones.
+
+    Args:
+        port: Serial port of the scanner, e.g. COM3, reads from stdin if None
+        baud_rate: Baud rate of the serial scanner
+        db_path: Path to the robot database
+        dry_run: Log the changes instead of writing them to the database
+
+    Raises:
+        ConfigError: If any barcode does not match the database
+
+    """
+    if port:
+        logger.info("Reading barcodes from %s, scan END to finish", port)
+        scans = parse_scans(read_serial(port, baud_rate))
+    else:
+        logger.info("Scan barcodes, one per line, empty line to finish")
+        scans = parse_scans(read_stdin())
+    logger.info("Scanned %d barcodes", len(scans))
+
+    (df,) = read_tables(db_path, "Cell_Assembly_Table")
+    new_barcodes, mismatches = match_barcodes(df, scans)
+    if mismatches:
+        for mismatch in mismatches:
+            logger.error(mismatch)
+        msg = f"{len(mismatches)} barcodes do not match the database, check the racks. Database not updated."
+        raise ConfigError(msg)
+
+    if not new_barcodes:
+        logger.info("All barcodes match the database")
+        return
+    for position, barcode in new_barcodes.items():
+        df.loc[df["Rack Position"] == position, "Barcode"] = barcode
+    logger.info("Storing %d new barcodes", len(new_barcodes))
+    write_tables(db_path, {"Cell_Assembly_Table": df}, dry_run=dry_run)
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("scan")
+    main()
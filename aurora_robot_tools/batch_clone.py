@@ -0,0 +1,174 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Start a new batch from the parameters of an earlier one, for repeat experiments.
+
+`aurora-rt batch clone 42 --cells 32 --name "NMC-repro"` adds a new batch in the free rack
+positions, i.e. those without a batch or cell number, with the parameters of the cells of batch 42:
+electrode types, diameters, current collector masses, mass fractions and specific capacities, N:P
+ratio limits, electrolyte and amounts, separator, casing, spacers and any other input column. If the
+batch had cells with different parameters they are repeated in rack order, so cloning a batch of 4
+variations into 32 cells gives 8 of each.
+
+Everything that belongs to the cells that were assembled is left empty: the electrode masses and
+rack positions, lots, cell numbers and sample IDs, the press, the progress of the robot and the mass
+check. Weigh the new electrodes, e.g. with `aurora-rt weigh`, then balance the batch as usual.
+
+The batch can also be cloned from another database, e.g. a backup of an earlier run, with
+`--source`, the electrolytes it uses are then copied too, at the same positions.
+
+The new batch is numbered after the batches in the database unless `--batch` is given, and the
+name, if given, is written to the Comments of its cells.
+
+Usage:
+    `aurora-rt batch clone 42 --cells 32 --name "NMC-repro"`, or
+    `aurora-rt batch clone 42 --source C:/Modules/Database/Backup/run_17.db`.
+"""
+
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.batch_transfer import electrolyte_positions, merge_electrolytes
+from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.errors import ConfigError
+from aurora_robot_tools.migrations import CELL_ASSEMBLY_COLUMNS
+
+logger = logging.getLogger(__name__)
+
+# Columns that describe the cells that were assembled, not the parameters of the batch
+CLEARED_COLUMNS = {
+    "Cell Number": 0,
+    "Current Press Number": 0,
+    "Last Completed Step": 0,
+    "Error Code": 0,
+    "Comments": None,
+    "Cell State": "planned",
+    "Sample ID": None,
+    "Barcode": None,
+    "Anode Rack Position": None,
+    "Anode Mass (mg)": None,
+    "Anode Active Material Mass (mg)": None,
+    "Anode Balancing Capacity (mAh)": None,
+    "Anode Areal Capacity (mAh/cm2)": None,
+    "Cathode Rack Position": None,
+    "Cathode Mass (mg)": None,
+    "Cathode Active Material Mass (mg)": None,
+    "Cathode Balancing Capacity (mAh)": None,
+    "Cathode Areal Capacity (mAh/cm2)": None,
+    "N:P Ratio": None,
+    "N:P ratio overlap factor": None,
+    "Allowed Anode Lots": None,
+    "Allowed Cathode Lots": None,
+    "Pinned Cathode Rack Position": None,
+    "Pinned Press Number": None,
+    "Cell Mass (mg)": None,
+    "Expected Cell Mass (mg)": None,
+    "Mass Check": None,
+    "Crimp Force (N)": None,
+    "Electrolyte Dispense Before Separator (uL)": None,
+    "Electrolyte Dispense After Separator (uL)": None,
+}
+
+
+def free_positions(df: pd.DataFrame) -> pd.Index:
+    """Rows of the rack positions without a batch or cell, in rack order."""
+    free = df["Batch Number"].isna() & (df["Cell Number"].fillna(0) == 0)
+    return df[free].sort_values("Rack Position").index
+
+
+def clone_cells(source: pd.DataFrame, n_cells: int, batch: int, name: str | None) -> pd.DataFrame:
+    """Parameters of the source cells repeated in rack order for the new cells, with the rest cleared."""
+    source = source.sort_values("Rack Position").reset_index(drop=True)
+    cells = source.iloc[np.arange(n_cells) % len(source)].reset_index(drop=True)
+    for column, value in CLEARED_COLUMNS.items():
+        if column in cells.columns:
+            cells[column] = value
+    cells["Batch Number"] = batch
+    if name:
+        cells["Comments"] = name
+    return cells
+
+
+def clone_batch(  # noqa: PLR0913
+    batch: int,
+    n_cells: int | None = None,
+    name: str | None = None,
+    new_batch: int | None = None,
+    source_db: Path | None = None,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> int:
+    """Add a new batch with the parameters of an existing one in the free rack positions.
+
+    Args:
+        batch: Batch number to copy the parameters from
+        n_cells: Number of cells in the new batch, default the number in the source batch
+        name: Name of the new batch, written to the Comments of its cells
+        new_batch: Number of the new batch, default one more than the largest in the database
+        source_db: Database to copy the batch from, default the robot database
+        db_path: Path to the robot database
+        dry_run: Show the changes without writing them
+
+    Returns:
+        The number of the new batch
+
+    """
+    df, df_electrolyte = read_tables(db_path, "Cell_Assembly_Table", "Electrolyte_Table")
+    if source_db is None:
+        df_source, df_source_electrolyte = df, df_electrolyte
+    else:
+        df_source, df_source_electrolyte = read_tables(source_db, "Cell_Assembly_Table", "Electrolyte_Table")
+    source = df_source[df_source["Batch Number"] == batch]
+    if source.empty:
+        msg = f"Batch {batch} is not in {source_db or db_path}."
+        raise ConfigError(msg)
+    n_cells = len(source) if n_cells is None else n_cells
+    if n_cells < 1:
+        msg = f"The new batch needs at least one cell, got {n_cells}."
+        raise ConfigError(msg)
+    if n_cells < len(source):
+        logger.warning(
+            "Batch %d has %d cells, only the first %d in rack order are cloned.",
+            batch,
+            len(source),
+            n_cells,
+        )
+    if new_batch is None:
+        new_batch = int(np.nan_to_num(df["Batch Number"].max())) + 1
+    elif (df["Batch Number"] == new_batch).any():
+        msg = f"Batch {new_batch} already exists, choose another number with --batch."
+        raise ConfigError(msg)
+    free = free_positions(df)
+    if len(free) < n_cells:
+        msg = f"Only {len(free)} rack positions are free, {n_cells} are needed for the new batch."
+        raise ConfigError(msg)
+
+    rows = free[:n_cells]
+    cells = clone_cells(source, n_cells, new_batch, name)
+    cells["Rack Position"] = df.loc[rows, "Rack Position"].to_numpy()
+    df = pd.concat([df.drop(index=rows), cells], ignore_index=True)
+    df = df.sort_values("Rack Position").reset_index(drop=True)
+    tables = {"Cell_Assembly_Table": df}
+    dtypes = {"Cell_Assembly_Table": {col: t for col, t in CELL_ASSEMBLY_COLUMNS.items() if col in df.columns}}
+    if source_db is not None:
+        used = set(source["Electrolyte Position"].dropna().astype(int))
+        positions = electrolyte_positions(df_source_electrolyte, used)
+        electrolytes = df_source_electrolyte[df_source_electrolyte["Electrolyte Position"].isin(positions)]
+        if not electrolytes.empty:
+            others = df[df["Batch Number"] != new_batch]
+            tables["Electrolyte_Table"] = merge_electrolytes(df_electrolyte, electrolytes, others)
+
+    write_tables(db_path, tables, dtypes=dtypes, dry_run=dry_run)
+    logger.info(
+        "Cloned batch %d into batch %d%s with %d cells at rack positions %s.",
+        batch,
+        new_batch,
+        f" ({name})" if name else "",
+        n_cells,
+        ", ".join(str(int(position)) for position in cells["Rack Position"]),
+    )
+    logger.info("Weigh the electrodes of the new batch, then run `aurora-rt balance`.")
+    return new_batch
@@ -0,0 +1,266 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Move a batch from one robot database to another, e.g. to assemble a plan made at one site on the
+robot at another site.
+
+`aurora-rt db export --batch 42 --out batch42.json` writes every row that belongs to the batch to
+one JSON file:
+    - Cell_Assembly_Table: the cells of the batch, with their electrodes, balancing and press
+    - Electrolyte_Table: the electrolytes the cells use, and the stocks they are mixed from
+    - Press_Table: the presses the cells are assigned to
+    - Electrode_Inventory_Table and Spacer_Table: the electrodes of the batch and the spacers the
+      cells use, if the tables exist
+
+`aurora-rt db import batch42.json` adds the batch to the database of this robot. The cells go to
+the same rack positions, which must be empty unless `--replace` is given, and are numbered after the
+cells already in the database if their cell numbers are taken, with new Sample IDs from the Base
+Sample ID of this database. `--batch` imports it under another batch number. Electrolytes replace
+the rows at the same positions, unless other cells use a different electrolyte there. The live state
+of the presses is not copied, the presses only have to exist on this robot, and spacer types that
+already exist are kept. The schema version of the file must not be newer than the database, run
+`aurora-rt db migrate` first.
+
+The mixing steps are not copied, run `aurora-rt electrolyte` after importing.
+
+Usage:
+    `aurora-rt db export --batch 42 --out batch42.json`, then on the other robot
+    `aurora-rt db import batch42.json`.
+"""
+
+import json
+import logging
+from datetime import datetime, timezone
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.database import read_query, read_tables, write_tables
+from aurora_robot_tools.errors import ConfigError, DatabaseError
+from aurora_robot_tools.inventory import INVENTORY_DTYPES, INVENTORY_TABLE
+from aurora_robot_tools.migrations import CELL_ASSEMBLY_COLUMNS, get_version
+from aurora_robot_tools.version import __version__
+
+logger = logging.getLogger(__name__)
+
+EXPORT_FORMAT = "aurora-batch"
+EXPORT_VERSION = 1
+
+
+def existing_tables(db_path: Path) -> set[str]:
+    """Names of the tables in the database."""
+    return set(read_query(db_path, "SELECT name FROM sqlite_master WHERE type = 'table'")["name"])
+
+
+def to_records(df: pd.DataFrame) -> list[dict]:
+    """Rows of a dataframe as JSON-serialisable dicts, NaN as None."""
+    return json.loads(df.to_json(orient="records"))
+
+
+def electrolyte_positions(df_electrolyte: pd.DataFrame, positions: set[int]) -> set[int]:
+    """The electrolyte positions used, and the positions they are mixed from."""
+    sources = set(positions)
+    for _, row in df_electrolyte[df_electrolyte["Electrolyte Position"].isin(positions)].iterrows():
+        for col in row.index:
+            if col.startswith("Mix ") and col[4:].isdigit() and pd.notna(row[col]) and row[col] > 0:
+                sources.add(int(col[4:]))
+    return sources
+
+
+def build_export(db_path: Path, batch: int) -> dict:
+    """Collect the rows of a batch from every table."""
+    df, df_electrolyte, df_press = read_tables(db_path, "Cell_Assembly_Table", "Electrolyte_Table", "Press_Table")
+    cells = df[df["Batch Number"] == batch]
+    if cells.empty:
+        msg = f"Batch {batch} is not in {db_path}."
+        raise ConfigError(msg)
+    if (cells["Last Completed Step"] > 0).any():
+        logger.warning("Some cells of batch %d have started assembly, they are exported as they are.", batch)
+    positions = electrolyte_positions(df_electrolyte, set(cells["Electrolyte Position"].dropna().astype(int)))
+    tables = {
+        "Cell_Assembly_Table": cells,
+        "Electrolyte_Table": df_electrolyte[df_electrolyte["Electrolyte Position"].isin(positions)],
+        "Press_Table": df_press[df_press["Press Number"].isin(cells["Current Press Number"])],
+    }
+    available = existing_tables(db_path)
+    if INVENTORY_TABLE in available:
+        (inventory,) = read_tables(db_path, INVENTORY_TABLE)
+        tables[INVENTORY_TABLE] = inventory[inventory["Batch Number"] == batch]
+    if "Spacer_Table" in available:
+        (spacers,) = read_tables(db_path, "Spacer_Table")
+        used = {spacer for col in ("Bottom Spacer Type", "Top Spacer Type") for spacer in cells.get(col, [])}
+        tables["Spacer_Table"] = spacers[spacers["Spacer Type"].isin(used)]
+    return {
+        "format": EXPORT_FORMAT,
+        "version": EXPORT_VERSION,
+        "batch": batch,
+        "schema_version": get_version(db_path),
+        "tools_version": __version__,
+        "exported": datetime.now(timezone.utc).isoformat(timespec="seconds"),
+        "source": str(db_path),
+        "tables": {table: to_records(df_table) for table, df_table in tables.items()},
+    }
+
+
+def export_batch(batch: int, out: Path, db_path: Path = DATABASE_FILEPATH) -> Path:
+    """Write the rows of a batch to a JSON file."""
+    export = build_export(db_path, batch)
+    out = Path(out)
+    out.parent.mkdir(parents=True, exist_ok=True)
+    with out.open("w", encoding="utf-8") as f:
+        json.dump(export, f, indent=2)
+    logger.info(
+        "Exported batch %d to %s: %s",
+        batch,
+        out,
+        ", ".join(f"{len(rows)} rows of {table}" for table, rows in export["tables"].items()),
+    )
+    return out
+
+
+def read_export(path: Path) -> dict:
+    """Read and check an export file."""
+    path = Path(path)
+    if not path.is_file():
+        msg = f"Export file {path} not found."
+        raise ConfigError(msg)
+    try:
+        with path.open(encoding="utf-8") as f:
+            export = json.load(f)
+    except json.JSONDecodeError as e:
+        msg = f"{path.name} is not valid JSON: {e}"
+        raise ConfigError(msg) from e
+    if not isinstance(export, dict) or export.get("format") != EXPORT_FORMAT:
+        msg = f"{path.name} is not a batch export from `aurora-rt db export`."
+        raise ConfigError(msg)
+    if export.get("version", 0) > EXPORT_VERSION:
+        msg = f"{path.name} was written by newer tools ({export.get('tools_version')}), update the tools."
+        raise ConfigError(msg)
+    return export
+
+
+def merge_cells(df: pd.DataFrame, cells: pd.DataFrame, replace: bool, base_sample_id: str) -> pd.DataFrame:
+    """Put the imported cells at their rack positions, renumbering them if the cell numbers are taken."""
+    racks = cells["Rack Position"]
+    unknown = set(racks) - set(df["Rack Position"])
+    if unknown:
+        msg = f"Rack positions {', '.join(str(int(r)) for r in sorted(unknown))} do not exist on this robot."
+        raise ConfigError(msg)
+    target = df["Rack Position"].isin(racks)
+    occupied = target & (df["Batch Number"].notna() | (df["Cell Number"] > 0))
+    if occupied.any() and not replace:
+        msg = (
+            f"Rack positions {', '.join(str(int(r)) for r in df.loc[occupied, 'Rack Position'])} are in use, "
+            "use --replace to overwrite them."
+        )
+        raise ConfigError(msg)
+    others = df[~target]
+    if others["Batch Number"].isin(cells["Batch Number"]).any():
+        msg = f"Batch {int(cells['Batch Number'].iloc[0])} already exists, import it with --batch under another number."
+        raise ConfigError(msg)
+    numbered = cells["Cell Number"] > 0
+    if others["Cell Number"].isin(cells.loc[numbered, "Cell Number"]).any():
+        first = int(others["Cell Number"].max()) + 1
+        order = cells.loc[numbered, "Cell Number"].rank(method="first").astype(int) - 1
+        cells.loc[numbered, "Cell Number"] = first + order
+        # The Sample ID ends with the cell number, see capacity_balance.py
+        cells.loc[numbered, "Sample ID"] = [
+            f"{base_sample_id}_{int(number):02d}" for number in cells.loc[numbered, "Cell Number"]
+        ]
+        logger.info("Cell numbers are taken, the imported cells are numbered from %d with new Sample IDs.", first)
+    df = pd.concat([others, cells], ignore_index=True)
+    return df.sort_values("Rack Position").reset_index(drop=True)
+
+
+def merge_electrolytes(
+    df_electrolyte: pd.DataFrame,
+    electrolytes: pd.DataFrame,
+    df_cells: pd.DataFrame,
+) -> pd.DataFrame:
+    """Replace the electrolytes at the imported positions, unless other cells use a different one there."""
+    positions = set(electrolytes["Electrolyte Position"])
+    names = electrolytes.set_index("Electrolyte Position")["Name"]
+    existing = df_electrolyte[df_electrolyte["Electrolyte Position"].isin(positions)]
+    used = set(df_cells["Electrolyte Position"].dropna())
+    clashes = [
+        int(row["Electrolyte Position"])
+        for _, row in existing.iterrows()
+        if row["Name"] != names[row["Electrolyte Position"]] and row["Electrolyte Position"] in used
+    ]
+    if clashes:
+        msg = f"Electrolyte positions {', '.join(map(str, clashes))} hold other electrolytes used by other cells."
+        raise ConfigError(msg)
+    df_electrolyte = df_electrolyte[~df_electrolyte["Electrolyte Position"].isin(positions)]
+    df_electrolyte = pd.concat([df_electrolyte, electrolytes], ignore_index=True)
+    df_electrolyte = df_electrolyte.sort_values("Electrolyte Position").reset_index(drop=True)
+    # Every position needs a mix column for the mixing calculation
+    for position in range(1, int(df_electrolyte["Electrolyte Position"].max()) + 1):
+        if f"Mix {position}" not in df_electrolyte.columns:
+            df_electrolyte[f"Mix {position}"] = 0.0
+    return df_electrolyte
+
+
+def import_batch(
+    path: Path,
+    batch: int | None = None,
+    replace: bool = False,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Add a batch from an export file to the database."""
+    export = read_export(path)
+    if export.get("schema_version", 0) > get_version(db_path):
+        msg = f"{Path(path).name} needs database version {export['schema_version']}, run `aurora-rt db migrate` first."
+        raise DatabaseError(msg)
+    imported = {table: pd.DataFrame(rows) for table, rows in export["tables"].items()}
+    cells = imported["Cell_Assembly_Table"]
+    if batch is not None:
+        cells["Batch Number"] = batch
+        if INVENTORY_TABLE in imported:
+            imported[INVENTORY_TABLE]["Batch Number"] = batch
+    batch = int(cells["Batch Number"].iloc[0])
+
+    df, df_electrolyte, df_press, df_settings = read_tables(
+        db_path,
+        "Cell_Assembly_Table",
+        "Electrolyte_Table",
+        "Press_Table",
+        "Settings_Table",
+    )
+    base_sample_id = df_settings.loc[df_settings["key"] == "Base Sample ID", "value"].to_numpy()[0]
+    presses = set(imported["Press_Table"].get("Press Number", pd.Series(dtype=int)))
+    missing = presses - set(df_press["Press Number"])
+    if missing:
+        msg = f"Presses {', '.join(str(int(p)) for p in sorted(missing))} do not exist here, run `aurora-rt assign`."
+        raise ConfigError(msg)
+    df = merge_cells(df, cells, replace, base_sample_id)
+    tables = {"Cell_Assembly_Table": df}
+    dtypes = {"Cell_Assembly_Table": {col: t for col, t in CELL_ASSEMBLY_COLUMNS.items() if col in df.columns}}
+    if not imported["Electrolyte_Table"].empty:
+        others = df[~df["Batch Number"].eq(batch)]
+        tables["Electrolyte_Table"] = merge_electrolytes(df_electrolyte, imported["Electrolyte_Table"], others)
+
+    available = existing_tables(db_path)
+    if INVENTORY_TABLE in imported:
+        inventory = read_tables(db_path, INVENTORY_TABLE)[0] if INVENTORY_TABLE in available else pd.DataFrame()
+        if not inventory.empty:
+            inventory = inventory[inventory["Batch Number"] != batch]
+        tables[INVENTORY_TABLE] = pd.concat([inventory, imported[INVENTORY_TABLE]], ignore_index=True)
+        dtypes[INVENTORY_TABLE] = INVENTORY_DTYPES
+    if "Spacer_Table" in imported and not imported["Spacer_Table"].empty:
+        spacers = read_tables(db_path, "Spacer_Table")[0] if "Spacer_Table" in available else pd.DataFrame()
+        new = imported["Spacer_Table"]
+        if not spacers.empty:
+            new = new[~new["Spacer Type"].isin(spacers["Spacer Type"])]
+        tables["Spacer_Table"] = pd.concat([spacers, new], ignore_index=True)
+
+    write_tables(db_path, tables, dtypes=dtypes, dry_run=dry_run)
+    logger.info(
+        "Imported batch %d with %d cells from %s (exported %s from %s).",
+        batch,
+        len(cells),
+        Path(path).name,
+        export.get("exported"),
+        export.get("source"),
+    )
+    logger.info("Run `aurora-rt electrolyte` to calculate the mixing steps.")
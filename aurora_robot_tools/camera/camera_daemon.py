@@ -13,6 +13,7 @@ import numpy as np
 
 from aurora_robot_tools.camera.ringlight import set_light
 from aurora_robot_tools.config import CAMERA_PORT, DATABASE_FILEPATH
+from aurora_robot_tools.database import retry_if_locked
 
 PHOTO_PATH = Path("C:/Aurora_webcam_images/")
 
@@ -146,6 +147,7 @@ def capture_top(client_socket: socket.socket) -> None:
     print(f"Frame saved as {photo_path!s}")
 
 
+@retry_if_locked
 def write_coords_to_db(cell: int, step: int, dx_mm: float, dy_mm: float) -> None:
     """Write the coordinates to the database."""
     with sqlite3.connect(DATABASE_FILEPATH) as conn:
@@ -17,10 +17,10 @@ anode is tied to its target N:P ratio, so the sorting is not optimal if the user
 N:P ratios within one batch of cells.
 
 Usage:
-    The script is called from capacity_balance.exe, which is called from the AutoSuite software.
+    The script is called with `aurora-rt balance` from the AutoSuite software.
     It can also be called from the command line.
 
-    There is one additional parameter that can be set:
+    There are two additional parameters that can be set:
 
     - `sorting_method`:
         0 - Do not sort, do not check N:P ratio
@@ -41,36 +41,105 @@ Usage:
                 (method 5), if too slow use greedy 3D (method 4)
         7 - Sort the anodes and cathodes by capacity in reverse order
                 Maximises the spread of N:P ratios
+        8 - Use an external plugin script
+                The command in BALANCE_PLUGIN in the config pairs the electrodes, see
+                balance_plugin.py
+
+    - `rejection_cost_factor` (float, default 2):
+        Cost of rejecting a cell in the cost matrix methods (3-6). Lower values reject more cells but
+        give better N:P ratios for the accepted cells, higher values reject fewer cells.
+
+    Both can also be set with the AURORA_BALANCE_MODE and AURORA_BALANCE_REJECTION_COST_FACTOR
+    environment variables when AutoSuite cannot pass arguments.
+
+    Batches are independent, so up to BALANCE_WORKERS batches from the config are matched at the same
+    time.
+
+    Lots can be constrained per cell with columns in the input or the batch import file, so the
+    electrodes of a batch are not treated as one pool:
+        - Allowed Anode Lots, Allowed Cathode Lots: comma separated lots the cell may be made with,
+          e.g. "A" on rack positions 1-16 so those cells only use cathode lot A
+        - Keep Lots Separate: "anode" to only pair anodes with cathodes from cells of the same anode
+          lot, i.e. never mix anode lots, "cathode" to keep the cathode lot of the cell, or both
+    The cost matrix methods (3-6) only consider allowed pairs, cells that still break a constraint,
+    e.g. with the other methods, are not accepted. The same holds for cathodes pinned to a cell with
+    `aurora-rt overrides`, see overrides.py.
+
+    After balancing, every accepted cell is checked against NP_RATIO_MINIMUM and NP_RATIO_MAXIMUM in
+    the config. By default the database is not updated if any cell is out of spec, with
+    `--reject-out-of-spec` those cells are rejected instead and given error code 201.
 
 Todo:
-    - Make rejection_cost_factor an argument when AutoSuite supports it.
     - [Long term] Pre-calculate the possible matchings using different rejection_cost_factors and
       allow the user to choose the best one.
 
 """
 
 import itertools
-import sqlite3
+import logging
 import sys
+from concurrent.futures import ThreadPoolExecutor, as_completed
+from pathlib import Path
 
 import numpy as np
 import pandas as pd
 import pulp
 from scipy.optimize import linear_sum_assignment
 
-from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools import progress
+from aurora_robot_tools.assembly_order import log_order, order_cells
+from aurora_robot_tools.cell_state import batches_done, set_state
+from aurora_robot_tools.config import (
+    BALANCE_WORKERS,
+    DATABASE_FILEPATH,
+    NP_RATIO_MAXIMUM,
+    NP_RATIO_MINIMUM,
+    SPECIFIC_CAPACITIES,
+)
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.errors import ConfigError, InfeasibleError
+from aurora_robot_tools.inventory import INVENTORY_DTYPES, INVENTORY_TABLE, build_inventory, warn_if_running_out
+from aurora_robot_tools.overrides import OVERRIDE_COLUMNS
+
+logger = logging.getLogger(__name__)
+
+NP_RATIO_ERROR_CODE = 201  # Error code for cells rejected by the N:P ratio validation
 
 TIMEOUT_SECONDS = 30
 
+# Columns with the lot constraints of each cell, empty for no constraint
+LOT_CONSTRAINT_COLUMNS = ("Allowed Anode Lots", "Allowed Cathode Lots", "Keep Lots Separate")
+SEPARATE_LOT_OPTIONS = ("anode", "cathode")
+
+
+def fill_specific_capacity(df: pd.DataFrame, xode: str) -> None:
+    """Fill in missing balancing specific capacities in-place from SPECIFIC_CAPACITIES in the config."""
+    column = f"{xode} Balancing Specific Capacity (mAh/g)"
+    if column not in df.columns:
+        df[column] = np.nan
+    missing = df[column].isna() & df[f"{xode} Type"].isin(list(SPECIFIC_CAPACITIES))
+    if missing.any():
+        df.loc[missing, column] = df.loc[missing, f"{xode} Type"].map(SPECIFIC_CAPACITIES)
+        logger.info(
+            "Using the specific capacity from the config for %s types %s",
+            xode.lower(),
+            ", ".join(sorted(df.loc[missing, f"{xode} Type"].astype(str).unique())),
+        )
+
 
 def calculate_capacity(df: pd.DataFrame) -> None:
     """Calculate the capacity of the anodes and cathodes in-place in the main dataframe, df.
 
+    The active material mass is the measured electrode mass minus the current collector mass, times
+    the active material mass fraction. With the specific capacity this gives the capacity of each
+    electrode, and divided by the electrode area the areal capacity, which is stored per electrode.
+
     Args:
         df (pandas.DataFrame): The dataframe containing the cell assembly data.
 
     """
     for xode in ["Anode", "Cathode"]:
+        fill_specific_capacity(df, xode)
         df[f"{xode} Active Material Mass (mg)"] = (
             df[f"{xode} Mass (mg)"] - df[f"{xode} Current Collector Mass (mg)"]
         ) * df[f"{xode} Active Material Mass Fraction"]
@@ -78,8 +147,60 @@ def calculate_capacity(df: pd.DataFrame) -> None:
             1e-3 * df[f"{xode} Active Material Mass (mg)"] * df[f"{xode} Balancing Specific Capacity (mAh/g)"]
         )
         if (df[f"{xode} Balancing Capacity (mAh)"] < 0).any():
-            print(f"WARNING: {xode} capacities below 0, setting to NaN")
+            logger.warning("%s capacities below 0, setting to NaN", xode)
             df.loc[df[f"{xode} Balancing Capacity (mAh)"] < 0, f"{xode} Balancing Capacity (mAh)"] = np.nan
+        area_cm2 = np.pi * (df[f"{xode} Diameter (mm)"] / 20) ** 2
+        df[f"{xode} Areal Capacity (mAh/cm2)"] = df[f"{xode} Balancing Capacity (mAh)"] / area_cm2.replace(0, np.nan)
+
+
+def split_lots(value: object) -> set[str]:
+    """Split a comma separated list of lots, empty if there is no constraint."""
+    if pd.isna(value):
+        return set()
+    return {lot.strip() for lot in str(value).split(",") if lot.strip()}
+
+
+def allowed_pairs(df: pd.DataFrame) -> np.ndarray:
+    """Find which anodes and cathodes can be made into a cell under the lot constraints and pins.
+
+    Anodes stay in their rack position, so the constraints of the anode's row apply to the cell. A
+    cell with a pinned cathode can only have that cathode, and no other cell can have it.
+
+    Returns:
+        n x n boolean array, True if the anode of row i can be paired with the cathode of row j
+
+    """
+    n = len(df)
+    allowed = np.ones((n, n), dtype=bool)
+    anode_lots = df["Anode Type"].astype(str).to_numpy()
+    cathode_lots = df["Cathode Type"].astype(str).to_numpy()
+    for i, (_, row) in enumerate(df.iterrows()):
+        allowed_anodes = split_lots(row.get("Allowed Anode Lots"))
+        allowed_cathodes = split_lots(row.get("Allowed Cathode Lots"))
+        separate = {option.lower() for option in split_lots(row.get("Keep Lots Separate"))}
+        if separate - set(SEPARATE_LOT_OPTIONS):
+            msg = (
+                f"Keep Lots Separate must be {' and/or '.join(SEPARATE_LOT_OPTIONS)}, "
+                f"got '{row['Keep Lots Separate']}' at rack position {row['Rack Position']}."
+            )
+            raise ConfigError(msg)
+        if allowed_anodes and anode_lots[i] not in allowed_anodes:
+            allowed[i] = False
+        if allowed_cathodes:
+            allowed[i] &= np.isin(cathode_lots, list(allowed_cathodes))
+        if "anode" in separate:
+            allowed[i] &= anode_lots == anode_lots[i]
+        if "cathode" in separate:
+            allowed[i] &= cathode_lots == cathode_lots[i]
+    if "Pinned Cathode Rack Position" in df.columns:
+        cathode_racks = df["Cathode Rack Position"].to_numpy()
+        for i, pin in enumerate(df["Pinned Cathode Rack Position"]):
+            if pd.isna(pin):
+                continue
+            pinned = cathode_racks == pin
+            allowed[np.ix_(np.arange(n) != i, pinned)] = False
+            allowed[i] &= pinned
+    return allowed
 
 
 def cost_matrix_assign(df: pd.DataFrame, rejection_cost_factor: float = 2) -> tuple[list[int], list[int]]:
@@ -122,6 +243,8 @@ def cost_matrix_assign(df: pd.DataFrame, rejection_cost_factor: float = 2) -> tu
             cost_matrix[i, i] = 999.99999999
     # otherwise unassigned cells have the same cost
     cost_matrix = np.nan_to_num(cost_matrix, nan=1000)
+    # Pairs that break the lot constraints or pins cost the same as unassigned cells
+    cost_matrix[~allowed_pairs(df)] = 1000
 
     # Find the optimal matching of anodes and cathodes using linear sum assignment
     anode_ind, cathode_ind = linear_sum_assignment(cost_matrix, maximize=False)
@@ -156,12 +279,12 @@ def exact_npartite_matching(cost_matrix: np.ndarray) -> tuple[np.ndarray, np.nda
         problem += pulp.lpSum(x[a] for a in assignments if a[2] == i) == 1
 
     # Solve the problem
-    print(f"Attempting exact matching, will give up if a solution not found in {TIMEOUT_SECONDS} seconds...")
+    logger.info("Attempting exact matching, will give up if a solution not found in %s seconds...", TIMEOUT_SECONDS)
     problem.solve(pulp.PULP_CBC_CMD(options=[f"sec={TIMEOUT_SECONDS}"], msg=False))
     if pulp.LpStatus[problem.status] != "Optimal":
         msg = f"Optimal solution not found. Status: {pulp.LpStatus[problem.status]}"
         raise ValueError(msg)
-    print("Optimal solution found")
+    logger.info("Optimal solution found")
     # Get the optimal assignments
     optimal_assignments = np.array([a for a in assignments if pulp.value(x[a]) == 1])
     i_idx, j_idx, k_idx = optimal_assignments[:, 0], optimal_assignments[:, 1], optimal_assignments[:, 2]
@@ -253,6 +376,7 @@ def cost_matrix_assign_3d(
         if np.isnan(cost_matrix[i, i, i]):
             cost_matrix[i, i, i] = 999.999
     cost_matrix = np.nan_to_num(cost_matrix, nan=1000)
+    cost_matrix[~allowed_pairs(df)] = 1000
 
     # Find the optimal matching of anodes and cathodes using greedy algorithm
     if exact:
@@ -282,8 +406,10 @@ def rearrange_electrode_columns(
         ratio_ind (numpy.ndarray): Ratio indices for optimal matching.
 
     """
-    anode_columns = [col for col in df.columns if "Anode" in col]
-    cathode_columns = [col for col in df.columns if "Cathode" in col]
+    # Lot constraints and pins belong to the cell, they stay in the rack position
+    cell_columns = (*LOT_CONSTRAINT_COLUMNS, *OVERRIDE_COLUMNS)
+    anode_columns = [col for col in df.columns if "Anode" in col and col not in cell_columns]
+    cathode_columns = [col for col in df.columns if "Cathode" in col and col not in cell_columns]
     ratio_columns = ["N:P Ratio Target", "N:P Ratio Minimum", "N:P Ratio Maximum"]
     df_immutable = df.copy()
     for column in anode_columns:
@@ -296,6 +422,197 @@ def rearrange_electrode_columns(
     df["N:P ratio overlap factor"] = (df["Cathode Diameter (mm)"] ** 2 / df["Anode Diameter (mm)"] ** 2).fillna(0)
 
 
+def match_batch(
+    df_batch: pd.DataFrame,
+    sorting_method: int,
+    rejection_cost_factor: float = 2,
+) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
+    """Find the new order of the anodes, cathodes and N:P ratios within one batch.
+
+    Args:
+        df_batch: The rows of the batch to rearrange.
+        sorting_method: The method to use for sorting the electrodes, see main.
+        rejection_cost_factor: The cost of rejecting a cell in the cost matrix methods.
+
+    Returns:
+        Indices of the anodes, cathodes and N:P ratios for each row of the batch
+
+    """
+    n_rows = len(df_batch)
+    # Reorder the anode and cathode rack positions based on the sorting method
+    match sorting_method:
+        case 0:  # Do not sort, do not check N:P ratio
+            anode_ind = np.arange(n_rows)
+            cathode_ind = np.arange(n_rows)
+            ratio_ind = np.arange(n_rows)
+
+        case 1:  # Do not sort
+            anode_ind = np.arange(n_rows)
+            cathode_ind = np.arange(n_rows)
+            ratio_ind = np.arange(n_rows)
+
+        case 2:  # Order by capacity
+            # I think this is always worse than the cost matrix approach
+            anode_sort = np.argsort(df_batch["Anode Balancing Capacity (mAh)"])
+            cathode_sort = np.argsort(df_batch["Cathode Balancing Capacity (mAh)"])
+            # Ensure that anode positions do not change
+            anode_ind = np.arange(n_rows)
+            cathode_ind = cathode_sort.iloc[np.argsort(anode_sort)]
+            ratio_ind = np.arange(n_rows)
+
+        case 3:  # Use cost matrix and linear sum assignment
+            anode_ind, cathode_ind = cost_matrix_assign(df_batch, rejection_cost_factor)
+            ratio_ind = np.arange(n_rows)
+
+        case 4:  # Use greedy 3D matching
+            anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch, rejection_cost_factor)
+
+        case 5:  # Use exact 3D matching
+            try:
+                anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(
+                    df_batch, rejection_cost_factor, exact=True
+                )
+            except ValueError:
+                logger.warning("Exact matching took too long, using greedy matching instead")
+                anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch, rejection_cost_factor)
+
+        case 6:  # Choose automatically
+            # If all ratios are the same, use 2d matching
+            if (
+                len(df_batch["N:P Ratio Target"].unique())
+                == 1 & len(df_batch["N:P Ratio Minimum"].unique())
+                == 1 & len(df_batch["N:P Ratio Maximum"].unique())
+                == 1
+            ):
+                anode_ind, cathode_ind = cost_matrix_assign(df_batch, rejection_cost_factor)
+                ratio_ind = np.arange(n_rows)
+            # Otherwise, try exact matching, if timeout use greedy matching
+            else:
+                try:
+                    anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(
+                        df_batch, rejection_cost_factor, exact=True
+                    )
+                except ValueError:
+                    logger.warning("Exact matching took too long, using greedy matching instead")
+                    anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch, rejection_cost_factor)
+
+        case 7:  # Reverse order by capacity
+            # maximises N:P spread
+            anode_sort = np.argsort(df_batch["Anode Balancing Capacity (mAh)"])
+            cathode_sort = np.argsort(df_batch["Cathode Balancing Capacity (mAh)"]).iloc[::-1]
+            # Ensure that anode positions do not change
+            anode_ind = np.arange(n_rows)
+            cathode_ind = cathode_sort.iloc[np.argsort(anode_sort)]
+            ratio_ind = np.arange(n_rows)
+
+        case 8:  # External plugin
+            from aurora_robot_tools.balance_plugin import plugin_assign
+
+            anode_ind, cathode_ind, ratio_ind = plugin_assign(df_batch, rejection_cost_factor)
+
+        case _:
+            msg = f"Unknown sorting method {sorting_method}, must be 0 to 8."
+            raise ConfigError(msg)
+    return np.asarray(anode_ind), np.asarray(cathode_ind), np.asarray(ratio_ind)
+
+
+def balance_batches(  # noqa: PLR0913
+    df: pd.DataFrame,
+    sorting_method: int,
+    rejection_cost_factor: float = 2,
+    include_incomplete: bool = False,
+    workers: int | None = None,
+    skip_batches: set | None = None,
+) -> pd.Series:
+    """Rearrange the electrodes in-place within each batch using the sorting method.
+
+    Only cells that have not started assembly and have no error code are rearranged. Batches are
+    independent, so up to `workers` batches are matched at the same time. The exact 3D matching runs
+    the CBC solver in a separate process, so this is much faster for runs with several chemistries.
+
+    Args:
+        df: The dataframe containing the cell assembly data, with capacities calculated.
+        sorting_method: The method to use for sorting the electrodes, see main.
+        rejection_cost_factor: The cost of rejecting a cell in the cost matrix methods.
+        include_incomplete: Also rearrange rows with only an anode or only a cathode, e.g. electrodes
+            returned from rejected cells.
+        workers: Number of batches to match at the same time, 1 matches them one after another,
+            BALANCE_WORKERS if not given.
+        skip_batches: Batch numbers to leave as they are, e.g. batches already balanced when resuming.
+
+    Returns:
+        Mask of the rows whose electrodes break the lot constraints or pins, which must not be accepted.
+
+    """
+    if workers is None:
+        workers = BALANCE_WORKERS
+    # Split the dataframe into sub-dataframes for each batch number
+    batch_numbers = df["Batch Number"].unique()
+    batch_numbers = batch_numbers[~np.isnan(batch_numbers)]
+    if include_incomplete:
+        has_electrodes = (df["Anode Balancing Capacity (mAh)"] > 0) | (df["Cathode Balancing Capacity (mAh)"] > 0)
+    else:
+        has_electrodes = (df["Anode Balancing Capacity (mAh)"] > 0) & (df["Cathode Balancing Capacity (mAh)"] > 0)
+
+    batches = {}
+    for batch_number in batch_numbers:
+        if skip_batches and batch_number in skip_batches:
+            logger.info("Skipping batch number %s as it is already balanced.", batch_number)
+            continue
+        batch_mask = (
+            (df["Batch Number"] == batch_number)
+            & (df["Last Completed Step"] == 0)
+            & (df["Error Code"] == 0)
+            & has_electrodes
+        )
+        # if no cells in this batch, skip
+        if not batch_mask.any():
+            logger.info("Skipping batch number %s as there are no available cells.", batch_number)
+            continue
+        row_indices = np.where(batch_mask)[0]
+        n_rows_skipped = sum(df["Batch Number"] == batch_number) - len(row_indices)
+        logger.info("Batch number %s has %d cells.", batch_number, len(row_indices))
+        if n_rows_skipped:
+            logger.info(
+                "Ignoring %d cells that do not have Last Completed Step = 0 and Error Code = 0.", n_rows_skipped
+            )
+        batches[batch_number] = row_indices
+
+    # Match the batches concurrently, then rearrange the main dataframe one batch at a time
+    results = {}
+    with ThreadPoolExecutor(max_workers=max(1, workers)) as executor:
+        futures = {
+            executor.submit(match_batch, df.iloc[row_indices], sorting_method, rejection_cost_factor): batch_number
+            for batch_number, row_indices in batches.items()
+        }
+        for i_batch, future in enumerate(as_completed(futures)):
+            results[futures[future]] = future.result()
+            progress.report(
+                5 + 85 * (i_batch + 1) / len(batches),
+                f"Balanced batch {i_batch + 1} of {len(batches)}",
+            )
+    breaks_constraints = pd.Series(False, index=df.index)
+    for batch_number, row_indices in batches.items():
+        anode_ind, cathode_ind, _ratio_ind = results[batch_number]
+        allowed = allowed_pairs(df.iloc[row_indices])[anode_ind, cathode_ind]
+        breaks_constraints.iloc[row_indices[~allowed]] = True
+        rearrange_electrode_columns(df, row_indices, *results[batch_number])
+    return breaks_constraints
+
+
+def reject_lot_constraints(df: pd.DataFrame, breaks_constraints: pd.Series, base_sample_id: str) -> None:
+    """Do not accept the cells that break the lot constraints or pins, and renumber the other cells."""
+    rejected = breaks_constraints & (df["Cell Number"] > 0)
+    if not rejected.any():
+        return
+    logger.warning(
+        "Not accepting %d cells that break the lot constraints or pins, rack positions %s",
+        rejected.sum(),
+        ", ".join(str(int(rack)) for rack in df.loc[rejected, "Rack Position"]),
+    )
+    number_cells(df, np.where((df["Cell Number"] > 0) & ~rejected)[0], base_sample_id)
+
+
 def update_cell_numbers(df: pd.DataFrame, base_sample_id: str, check_NP_ratio: bool = True) -> None:
     """Update the cell numbers in the main dataframe, df, based on the accepted cells.
 
@@ -306,9 +623,7 @@ def update_cell_numbers(df: pd.DataFrame, base_sample_id: str, check_NP_ratio: b
 
     """
     if check_NP_ratio:
-        df["N:P Ratio"] = (df["Anode Balancing Capacity (mAh)"] / df["Anode Diameter (mm)"] ** 2) / (
-            df["Cathode Balancing Capacity (mAh)"] / df["Cathode Diameter (mm)"] ** 2
-        )
+        df["N:P Ratio"] = calculate_np_ratio(df)
         cell_meets_criteria = (df["N:P Ratio"] >= df["N:P Ratio Minimum"]) & (
             df["N:P Ratio"] <= df["N:P Ratio Maximum"]
         )
@@ -317,26 +632,121 @@ def update_cell_numbers(df: pd.DataFrame, base_sample_id: str, check_NP_ratio: b
         average_deviation = np.mean(
             np.abs(df["N:P Ratio"][accepted_cell_indices] - df["N:P Ratio Target"][accepted_cell_indices])
         )
-        print(
-            f"Accepted {len(accepted_cell_indices)} cells "
-            f"with average N:P deviation from target: {average_deviation:.4f}\n"
-            f"Rejected {len(rejected_cell_indices)} cells."
+        logger.info(
+            "Accepted %d cells with average N:P deviation from target: %.4f\nRejected %d cells.",
+            len(accepted_cell_indices),
+            average_deviation,
+            len(rejected_cell_indices),
         )
     else:
         # accept any cell with an anode and cathode
         accepted_cell_indices = np.where(
             ~df["Anode Type"].isna() & ~df["Cathode Type"].isna(),
         )[0]
-        print(f"Accepted {len(accepted_cell_indices)} cells without checking N:P ratio.")
+        logger.info("Accepted %d cells without checking N:P ratio.", len(accepted_cell_indices))
 
-    # Re-write the Cell Number column to only include cells with both anode and cathode
+    number_cells(df, accepted_cell_indices, base_sample_id)
+
+
+def calculate_np_ratio(df: pd.DataFrame) -> pd.Series:
+    """Calculate the N:P ratio of every row from the balancing capacities and electrode diameters."""
+    return (df["Anode Balancing Capacity (mAh)"] / df["Anode Diameter (mm)"] ** 2) / (
+        df["Cathode Balancing Capacity (mAh)"] / df["Cathode Diameter (mm)"] ** 2
+    )
+
+
+def number_cells(df: pd.DataFrame, accepted_cell_indices: np.ndarray, base_sample_id: str) -> None:
+    """Give the accepted cells consecutive cell numbers and sample IDs in assembly order, all other cells get 0."""
     df["Cell Number"] = 0
-    for cell_number, cell_index in enumerate(accepted_cell_indices):
+    for cell_number, cell_index in enumerate(order_cells(df, accepted_cell_indices)):
         df.loc[cell_index, "Cell Number"] = cell_number + 1
         df.loc[cell_index, "Sample ID"] = f"{base_sample_id}_{cell_number + 1:02d}"
 
 
-def main(sorting_method: int) -> None:
+def validate_np_ratio(
+    df: pd.DataFrame,
+    base_sample_id: str,
+    reject_out_of_spec: bool = False,
+    minimum: float | None = None,
+    maximum: float | None = None,
+) -> None:
+    """Check the N:P ratio of all accepted cells is within the limits from the config.
+
+    Args:
+        df: The dataframe containing the cell assembly data, after updating the cell numbers.
+        base_sample_id: The run ID for the cells.
+        reject_out_of_spec: Reject cells outside the limits instead of raising an error.
+        minimum: Lowest allowed N:P ratio, 0 for no limit, NP_RATIO_MINIMUM if not given.
+        maximum: Highest allowed N:P ratio, 0 for no limit, NP_RATIO_MAXIMUM if not given.
+
+    Raises:
+        InfeasibleError: If any cell is outside the limits and reject_out_of_spec is not set.
+
+    """
+    out_of_spec = find_out_of_spec(df, df["Cell Number"] > 0, reject_out_of_spec, minimum, maximum)
+    if out_of_spec.any():
+        number_cells(df, np.where((df["Cell Number"] > 0) & ~out_of_spec)[0], base_sample_id)
+
+
+def find_out_of_spec(
+    df: pd.DataFrame,
+    cells: pd.Series,
+    reject_out_of_spec: bool = False,
+    minimum: float | None = None,
+    maximum: float | None = None,
+) -> pd.Series:
+    """Find cells with N:P ratio outside the limits from the config, give them an error code.
+
+    Args:
+        df: The dataframe containing the cell assembly data.
+        cells: Mask of the cells to check.
+        reject_out_of_spec: Reject cells outside the limits instead of raising an error.
+        minimum: Lowest allowed N:P ratio, 0 for no limit, NP_RATIO_MINIMUM if not given.
+        maximum: Highest allowed N:P ratio, 0 for no limit, NP_RATIO_MAXIMUM if not given.
+
+    Returns:
+        Mask of the rejected cells, which still need to be renumbered.
+
+    Raises:
+        InfeasibleError: If any cell is outside the limits and reject_out_of_spec is not set.
+
+    """
+    if minimum is None:
+        minimum = NP_RATIO_MINIMUM
+    if maximum is None:
+        maximum = NP_RATIO_MAXIMUM
+    if not minimum and not maximum:
+        return pd.Series(False, index=df.index)
+    ratio = calculate_np_ratio(df)
+    out_of_spec = ratio.isna()
+    if minimum:
+        out_of_spec |= ratio < minimum
+    if maximum:
+        out_of_spec |= ratio > maximum
+    out_of_spec &= cells
+    limits = f"{minimum or '-'} - {maximum or '-'}"
+    if not out_of_spec.any():
+        logger.info("All cells have N:P ratio within limits %s.", limits)
+        return out_of_spec
+    details = ", ".join(f"{i}: {r:.3f}" for i, r in zip(df.loc[out_of_spec, "Sample ID"], ratio[out_of_spec]))
+    if not reject_out_of_spec:
+        msg = (
+            f"{out_of_spec.sum()} cells have N:P ratio outside limits {limits}: {details}. Database not updated."
+        )
+        raise InfeasibleError(msg)
+    logger.warning("Rejecting %d cells with N:P ratio out of spec: %s", out_of_spec.sum(), details)
+    df.loc[out_of_spec, "Error Code"] = NP_RATIO_ERROR_CODE
+    return out_of_spec
+
+
+def main(  # noqa: PLR0913
+    sorting_method: int,
+    rejection_cost_factor: float = 2,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+    reject_out_of_spec: bool = False,
+    resume: bool = False,
+) -> None:
     """Full function to match cathodes with anodes and update the database.
 
     Read the cell assembly data from the database, calculate the capacity of the anodes and
@@ -353,120 +763,60 @@ def main(sorting_method: int) -> None:
             5 - Exact 3D matching
             6 - Choose automatically (default)
             7 - Reverse sort by capacity
+            8 - External plugin script
+        rejection_cost_factor: The cost of rejecting a cell in the cost matrix methods.
+        db_path: Path to the robot database.
+        dry_run: Log the changes instead of writing them to the database.
+        reject_out_of_spec: Reject cells with N:P ratio outside the config limits instead of aborting.
+        resume: Only balance batches without balanced cells, e.g. after an interrupted run.
 
     """
-    print(f"Reading from database {DATABASE_FILEPATH}")
-    print(f"Using sorting method {sorting_method}")
+    logger.info("Reading from database %s", db_path)
+    logger.info("Using sorting method %s", sorting_method)
 
-    # Connect to the database and create the Cell_Assembly_Table
-    with sqlite3.connect(DATABASE_FILEPATH) as conn:
-        df = pd.read_sql("SELECT * FROM Cell_Assembly_Table", conn)
-        df_settings = pd.read_sql("SELECT * FROM Settings_Table", conn)
+    # Read the Cell_Assembly_Table and Settings_Table from the database
+    df, df_settings = read_tables(db_path, "Cell_Assembly_Table", "Settings_Table")
     base_sample_id = df_settings.loc[df_settings["key"] == "Base Sample ID", "value"].to_numpy()[0]
 
     calculate_capacity(df)
 
-    # Split the dataframe into sub-dataframes for each batch number
-    batch_numbers = df["Batch Number"].unique()
-    batch_numbers = batch_numbers[~np.isnan(batch_numbers)]
-
-    for batch_number in batch_numbers:
-        batch_mask = (
-            (df["Batch Number"] == batch_number)
-            & (df["Last Completed Step"] == 0)
-            & (df["Error Code"] == 0)
-            & (df["Anode Balancing Capacity (mAh)"] > 0)
-            & (df["Cathode Balancing Capacity (mAh)"] > 0)
-        )
-        df_batch = df[batch_mask]
-        # if no cells in this batch, skip
-        if len(df_batch) == 0:
-            print(f"Skipping batch number {batch_number} as there are no available cells.")
-            continue
-        row_indices = np.where(batch_mask)[0]
-        n_rows = len(row_indices)
-        n_rows_skipped = sum(df["Batch Number"] == batch_number) - n_rows
-        print(f"Batch number {batch_number} has {n_rows} cells.")
-        if n_rows_skipped:
-            print(f"Ignoring {n_rows_skipped} cells that do not have Last Completed Step = 0 and Error Code = 0.")
-
-        # Reorder the anode and cathode rack positions based on the sorting method
-        match sorting_method:
-            case 0:  # Do not sort, do not check N:P ratio
-                anode_ind = np.arange(n_rows)
-                cathode_ind = np.arange(n_rows)
-                ratio_ind = np.arange(n_rows)
-
-            case 1:  # Do not sort
-                anode_ind = np.arange(n_rows)
-                cathode_ind = np.arange(n_rows)
-                ratio_ind = np.arange(n_rows)
-
-            case 2:  # Order by capacity
-                # I think this is always worse than the cost matrix approach
-                anode_sort = np.argsort(df_batch["Anode Balancing Capacity (mAh)"])
-                cathode_sort = np.argsort(df_batch["Cathode Balancing Capacity (mAh)"])
-                # Ensure that anode positions do not change
-                anode_ind = np.arange(n_rows)
-                cathode_ind = cathode_sort.iloc[np.argsort(anode_sort)]
-                ratio_ind = np.arange(n_rows)
-
-            case 3:  # Use cost matrix and linear sum assignment
-                anode_ind, cathode_ind = cost_matrix_assign(df_batch)
-                ratio_ind = np.arange(n_rows)
-
-            case 4:  # Use greedy 3D matching
-                anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch)
-
-            case 5:  # Use exact 3D matching
-                try:
-                    anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch, exact=True)
-                except ValueError:
-                    print("Exact matching took too long, using greedy matching instead")
-                    anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch)
-
-            case 6:  # Choose automatically
-                # If all ratios are the same, use 2d matching
-                if (
-                    len(df_batch["N:P Ratio Target"].unique())
-                    == 1 & len(df_batch["N:P Ratio Minimum"].unique())
-                    == 1 & len(df_batch["N:P Ratio Maximum"].unique())
-                    == 1
-                ):
-                    anode_ind, cathode_ind = cost_matrix_assign(df_batch)
-                    ratio_ind = np.arange(n_rows)
-                # Otherwise, try exact matching, if timeout use greedy matching
-                else:
-                    try:
-                        anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch, exact=True)
-                    except ValueError:
-                        print("Exact matching took too long, using greedy matching instead")
-                        anode_ind, cathode_ind, ratio_ind = cost_matrix_assign_3d(df_batch)
-
-            case 7:  # Reverse order by capacity
-                # maximises N:P spread
-                anode_sort = np.argsort(df_batch["Anode Balancing Capacity (mAh)"])
-                cathode_sort = np.argsort(df_batch["Cathode Balancing Capacity (mAh)"]).iloc[::-1]
-                # Ensure that anode positions do not change
-                anode_ind = np.arange(n_rows)
-                cathode_ind = cathode_sort.iloc[np.argsort(anode_sort)]
-                ratio_ind = np.arange(n_rows)
-
-        # Rearrange the electrodes in the main dataframe
-        rearrange_electrode_columns(df, row_indices, anode_ind, cathode_ind, ratio_ind)
+    skip_batches = batches_done(df, "balanced") if resume else set()
+    breaks_constraints = balance_batches(df, sorting_method, rejection_cost_factor, skip_batches=skip_batches)
 
     # Update the N:P Ratio, accepted cell numbers and sample ID in the main dataframe
     if sorting_method == 0:
         update_cell_numbers(df, base_sample_id, check_NP_ratio=False)
     else:
         update_cell_numbers(df, base_sample_id)
-
-    # Write the updated table back to the database
-    with sqlite3.connect(DATABASE_FILEPATH) as conn:
-        df.to_sql("Cell_Assembly_Table", conn, index=False, if_exists="replace")
-    print("Updated database successfully")
+    reject_lot_constraints(df, breaks_constraints, base_sample_id)
+    validate_np_ratio(df, base_sample_id, reject_out_of_spec)
+    if not (df["Cell Number"] > 0).any():
+        msg = "No cells could be made from the available electrodes, database not updated."
+        raise InfeasibleError(msg)
+    log_order(df)
+    balanced = (df["Last Completed Step"] == 0) & ~df["Batch Number"].isin(skip_batches)
+    set_state(df, balanced, "planned")
+    set_state(df, balanced & (df["Cell Number"] > 0), "balanced")
+
+    # Update the electrode inventory with the planned cells
+    inventory = build_inventory(df)
+    warn_if_running_out(inventory)
+
+    # Write the updated tables back to the database
+    progress.report(95, "Writing to database")
+    write_tables(
+        db_path,
+        {"Cell_Assembly_Table": df, INVENTORY_TABLE: inventory},
+        dtypes={INVENTORY_TABLE: INVENTORY_DTYPES},
+        dry_run=dry_run,
+    )
+    if not dry_run:
+        logger.info("Updated database successfully")
 
 
 if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("balance")
     sorting_method = int(sys.argv[1]) if len(sys.argv) >= 2 else 6
     main(sorting_method)
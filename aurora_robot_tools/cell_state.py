@@ -0,0 +1,89 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Track where each cell is in the assembly pipeline.
+
+Every row of the Cell_Assembly_Table has a Cell State, which only moves forward as the tools and the
+robot work on it:
+    planned -> balanced -> electrolyte calculated -> press assigned -> assembled -> crimped
+
+The first states are set by the tools: import-excel plans the cells, balance sets the accepted cells
+to balanced (and every other cell back to planned), electrolyte and assign-press move them on. The
+last two come from the robot's Last Completed Step, assembled once the top casing is placed and
+crimped once the cell is pressed. Databases imported before the state was stored get it from the
+cell number, press number and last completed step.
+
+Each tool only works on cells in the states it expects, e.g. assign-press only loads balanced cells,
+and `--resume` lets a tool that was interrupted skip the work that was already done, e.g. batches
+that were already balanced.
+
+Usage:
+    Used by the tools, `aurora-rt states` shows the state of every cell.
+"""
+
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, STEP_DEFINITION
+from aurora_robot_tools.database import read_tables
+
+logger = logging.getLogger(__name__)
+
+STATES = ("planned", "balanced", "electrolyte calculated", "press assigned", "assembled", "crimped")
+STATE_COLUMN = "Cell State"
+TOP_STEP = next(k for k, v in STEP_DEFINITION.items() if v["Step"] == "Top")
+PRESS_STEP = next(k for k, v in STEP_DEFINITION.items() if v["Step"] == "Press")
+
+
+def get_states(df: pd.DataFrame) -> pd.Series:
+    """Get the state of every row, from the stored state and the progress of the robot."""
+    rank = {state: i for i, state in enumerate(STATES)}
+    step = df["Last Completed Step"].fillna(0)
+    inferred = pd.Series(
+        np.select(
+            [step >= PRESS_STEP, step >= TOP_STEP, df["Current Press Number"] > 0, df["Cell Number"] > 0],
+            [rank["crimped"], rank["assembled"], rank["press assigned"], rank["balanced"]],
+            rank["planned"],
+        ),
+        index=df.index,
+    )
+    if STATE_COLUMN in df.columns:
+        stored = df[STATE_COLUMN].map(rank).fillna(inferred)
+    else:
+        stored = inferred
+    # The robot does not update the stored state, its progress always counts
+    robot = inferred.where(step >= TOP_STEP, -1)
+    return np.maximum(stored, robot).astype(int).map(dict(enumerate(STATES)))
+
+
+def in_state(df: pd.DataFrame, *states: str) -> pd.Series:
+    """Rows that are in one of the states."""
+    return get_states(df).isin(states)
+
+
+def set_state(df: pd.DataFrame, rows: pd.Series, state: str) -> None:
+    """Set the state of some rows in-place, the other rows keep their current state."""
+    df[STATE_COLUMN] = get_states(df)
+    df.loc[rows, STATE_COLUMN] = state
+
+
+def batches_done(df: pd.DataFrame, state: str) -> set:
+    """Batch numbers in which some cells have already reached a state, used to resume."""
+    done = get_states(df).map(STATES.index) >= STATES.index(state)
+    return set(df.loc[done & df["Batch Number"].notna(), "Batch Number"])
+
+
+def main(db_path: Path = DATABASE_FILEPATH) -> None:
+    """Log the state of every cell and the number of cells in each state."""
+    (df,) = read_tables(db_path, "Cell_Assembly_Table")
+    df = df[df["Anode Type"].notna() | df["Cathode Type"].notna()].fillna({"Cell Number": 0})
+    states = get_states(df)
+    lines = [
+        f"{int(row['Rack Position']):<6} {int(row['Cell Number']):<6} {state}"
+        for (_, row), state in zip(df.iterrows(), states)
+    ]
+    logger.info("Rack | Cell | State\n%s", "\n".join(lines))
+    counts = states.value_counts()
+    logger.info("%s", ", ".join(f"{counts.get(state, 0)} {state}" for state in STATES))
@@ -0,0 +1,79 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Inject failures to rehearse how the AutoSuite workflow reacts when a tool fails.
+
+Hidden options of `aurora-rt`, not shown in the help, make a command fail on purpose without breaking
+anything on the robot:
+    - `--fail-after-step N`: fail with an unexpected error (exit code 1) after the command has
+      reported progress N times, 0 fails before the command does any work
+    - `--simulate-db-lock`: every connection to the database finds it locked, so the command retries
+      like it would with another program holding the database, then exits with code 21
+    - `--slow SECONDS`: wait this long at every progress step and database connection, to test
+      timeouts and a workflow that polls the status file
+
+Nothing is written to the database by a failed command, as with a real failure: the command stops
+before the write, or, for a failure during the write, the transaction is rolled back.
+
+Usage:
+    e.g. `aurora-rt --fail-after-step 2 balance` or `aurora-rt --simulate-db-lock assign`.
+"""
+
+import logging
+import sqlite3
+import time
+
+from aurora_robot_tools.errors import AuroraError
+
+logger = logging.getLogger(__name__)
+
+# Set by the options of `aurora-rt`, see cli.py
+fail_after_step: int | None = None
+simulate_db_lock = False
+slow = 0.0
+
+steps = 0  # Progress steps reported so far
+
+
+class InjectedFailureError(AuroraError):
+    """Failure injected with --fail-after-step."""
+
+
+def enable(fail_after: int | None, db_lock: bool, delay: float) -> None:
+    """Turn on failure injection, warn so the failure is not mistaken for a real one."""
+    global fail_after_step, simulate_db_lock, slow  # noqa: PLW0603
+    fail_after_step, simulate_db_lock, slow = fail_after, db_lock, max(delay, 0.0)
+    if fail_after_step is None and not simulate_db_lock and not slow:
+        return
+    logger.warning(
+        "Failure injection on: fail after step %s, simulated database lock %s, %.1f s delay.",
+        "never" if fail_after_step is None else fail_after_step,
+        "on" if simulate_db_lock else "off",
+        slow,
+    )
+    if fail_after_step == 0:
+        fail()
+
+
+def fail() -> None:
+    """Raise the injected failure."""
+    msg = f"Injected failure after {steps} progress steps (--fail-after-step {fail_after_step})."
+    raise InjectedFailureError(msg)
+
+
+def on_step() -> None:
+    """Called for every progress step, delay or fail if injection is on."""
+    global steps  # noqa: PLW0603
+    if slow:
+        time.sleep(slow)
+    if fail_after_step is not None and steps >= fail_after_step:
+        fail()
+    steps += 1
+
+
+def on_connect() -> None:
+    """Called for every database connection, delay or find the database locked if injection is on."""
+    if slow:
+        time.sleep(slow)
+    if simulate_db_lock:
+        msg = "database is locked (simulated with --simulate-db-lock)"
+        raise sqlite3.OperationalError(msg)
@@ -1,56 +1,634 @@
 """Command line interface for robot tools."""
 
+import json
+import logging
+import os
+import sys
+import time
+from datetime import datetime, timezone
+from pathlib import Path
 from typing import Annotated
 
-from typer import Argument, Typer
+from typer import Argument, BadParameter, Context, Exit, Option, Typer
+
+from aurora_robot_tools.config import DATABASE_FILEPATH
+
+logger = logging.getLogger(__name__)
 
 app = Typer(
-    add_completion=False,
+    epilog="Run aurora-rt COMMAND --help for the options and examples of a command.",
     pretty_exceptions_enable=False,
 )
+db_app = Typer(help="Create, upgrade and query the robot database.")
+app.add_typer(db_app, name="db")
+press_app = Typer(help="Take presses out of service, store their calibration and show their state.")
+app.add_typer(press_app, name="press")
+plan_app = Typer(help="Show the plan of the batch.")
+app.add_typer(plan_app, name="plan")
+pyenv_app = Typer(help="Manage the dedicated Python environment of the tools.")
+app.add_typer(pyenv_app, name="pyenv")
+batch_app = Typer(help="Start new batches from earlier ones.")
+app.add_typer(batch_app, name="batch")
+dispenser_app = Typer(help="Calibrate the syringes of the liquid handler and show their calibration.")
+app.add_typer(dispenser_app, name="dispenser")
+credentials_app = Typer(help="Store tokens and passwords of the integrations encrypted, instead of in the config.")
+app.add_typer(credentials_app, name="credentials")
+
+# Commands that write to the database, only one of them can run at a time
+LOCKED_COMMANDS = {
+    "import-excel",
+    "import-batch",
+    "electrolyte",
+    "doe",
+    "balance",
+    "rebalance",
+    "review",
+    "assign",
+    "overrides",
+    "inventory",
+    "scan",
+    "weigh",
+    "verify-mass",
+    "labels",
+    "restore",
+    "recover",
+    "commit",
+    "discard",
+    "db",
+    "press",
+    "dispenser",
+    "batch",
+}
+
+# Subcommands of locked commands that only read the database, checked with read_only()
+READ_ONLY_COMMANDS = {("db", "query"), ("db", "export")}
+
+# Commands that run jobs from other software, which can write to the database
+JOB_COMMANDS = {"agent", "listen", "serve"}
+
+# Commands that wait for an operator, a device or other software, the activity watchdog is not started
+WAITING_COMMANDS = {*JOB_COMMANDS, "standby", "review", "scan", "weigh", "startcam", "credentials"}
+
+# Commands that change the cells to assemble, staged if PLAN_APPROVAL is "required", their JSON output
+# includes the plan afterwards
+PLAN_COMMANDS = {
+    "import-excel",
+    "import-batch",
+    "electrolyte",
+    "doe",
+    "balance",
+    "rebalance",
+    "review",
+    "assign",
+    "overrides",
+    "weigh",
+    "batch",
+}
+OUTPUT_FORMATS = ("text", "json")
+
+# Options shared by all commands, set in the app callback
+state = {
+    "db_path": DATABASE_FILEPATH,
+    "dry_run": False,
+    "command": None,
+    "log_file": None,
+    "output": "text",
+    "messages": [],
+    "stdout": sys.stdout,
+    "snapshot": None,
+    "rerun": None,
+    "hooks": False,
+}
+
+
+def profile_path(profile: str, db: Path | None) -> Path:
+    """Get the database path of a profile in the config."""
+    from aurora_robot_tools import config
+    from aurora_robot_tools.errors import ConfigError
+
+    if db is not None:
+        msg = "Give either --db or --db-profile, not both."
+        raise ConfigError(msg)
+    if profile not in config.DATABASE_PROFILES:
+        msg = f"Unknown database profile '{profile}', must be one of {', '.join(config.DATABASE_PROFILES) or 'none'}."
+        raise ConfigError(msg)
+    return config.DATABASE_PROFILES[profile]
+
+
+def complete_robots() -> list[str]:
+    """Robot names for shell completion of --robot."""
+    from aurora_robot_tools.config import ROBOT_PROFILES
+
+    return list(ROBOT_PROFILES)
+
+
+def complete_db_profiles() -> list[str]:
+    """Profile names for shell completion of --db-profile."""
+    from aurora_robot_tools.config import DATABASE_PROFILES
+
+    return list(DATABASE_PROFILES)
+
+
+def complete_orders() -> list[str]:
+    """Assembly orders for shell completion of --order."""
+    from aurora_robot_tools.assembly_order import ORDERS
+
+    return list(ORDERS)
+
+
+def command_args(ctx: Context) -> list[str]:
+    """Get the arguments after the command name, which click only parses after the app callback."""
+    args = sys.argv[1:]
+    return args[args.index(ctx.invoked_subcommand) + 1 :] if ctx.invoked_subcommand in args else []
+
+
+def read_only(ctx: Context) -> bool:
+    """Check if the command only reads the database, e.g. `db query` without --write."""
+    args = command_args(ctx)
+    return (ctx.invoked_subcommand, *args[:1]) in READ_ONLY_COMMANDS and "--write" not in args
+
+
+def print_version(value: bool) -> None:  # noqa: FBT001
+    """Print the version, commit and build date for --version."""
+    if value:
+        from aurora_robot_tools.provenance import short_version
+
+        print(short_version())
+        raise Exit
+
+
+@app.callback()
+def main(
+    ctx: Context,
+    robot: str | None = Option(
+        None,
+        help="Name of a robot in ROBOT_PROFILES in the config, e.g. one, to plan for that robot.",
+        envvar="AURORA_ROBOT",
+        autocompletion=complete_robots,
+    ),
+    db: Path | None = Option(None, help="Path to the robot database, overrides config.", envvar="AURORA_DB"),
+    db_profile: str | None = Option(
+        None,
+        help="Name of a database in DATABASE_PROFILES in the config, e.g. test.",
+        envvar="AURORA_DB_PROFILE",
+        autocompletion=complete_db_profiles,
+    ),
+    allow_production: bool = Option(
+        False,  # noqa: FBT003
+        "--allow-production",
+        help="Allow writing to the production profile database.",
+        envvar="AURORA_ALLOW_PRODUCTION",
+    ),
+    args_file: Path | None = Option(
+        None,
+        help='JSON file of default arguments per command, e.g. {"balance": {"mode": 3}}.',
+        envvar="AURORA_ARGS_FILE",
+    ),
+    decimal_comma: bool | None = Option(
+        None,
+        "--decimal-comma/--decimal-point",
+        help="Decimal separator of imported CSV and Excel files, default is to detect it, e.g. 3,5 for 3.5.",
+        envvar="AURORA_DECIMAL_COMMA",
+        show_default=False,
+    ),
+    timeout: float | None = Option(
+        None,
+        help="Abort with exit code 50 if the command takes longer than this many seconds.",
+        envvar="AURORA_TIMEOUT",
+    ),
+    dry_run: bool = Option(
+        False,  # noqa: FBT003
+        "--dry-run",
+        help="Do all calculations and show the changes, but do not write anything.",
+        envvar="AURORA_DRY_RUN",
+    ),
+    wait_for_lock: float = Option(
+        0,
+        help="Seconds to wait if another command is using the database, then exit with code 60.",
+        envvar="AURORA_WAIT_FOR_LOCK",
+    ),
+    output: str = Option(
+        "text",
+        help="text, or json to print the result, messages and plan as one JSON object on stdout.",
+        envvar="AURORA_OUTPUT",
+        autocompletion=lambda: list(OUTPUT_FORMATS),
+    ),
+    snapshot: bool = Option(
+        False,  # noqa: FBT003
+        "--snapshot",
+        help="Work on a temporary copy of the database that is thrown away, for what-if planning during a run.",
+        envvar="AURORA_SNAPSHOT",
+    ),
+    force: bool = Option(
+        False,  # noqa: FBT003
+        "--force",
+        help="Run the command even if it already ran with the same arguments and the database has not changed.",
+        envvar="AURORA_FORCE",
+    ),
+    force_clean: bool = Option(
+        False,  # noqa: FBT003
+        "--force-clean",
+        help="Remove locks and roll back unfinished operations left by commands that crashed.",
+        envvar="AURORA_FORCE_CLEAN",
+    ),
+    allow_modified: bool = Option(
+        False,  # noqa: FBT003
+        "--allow-modified",
+        help="Only warn if the installed files or companion scripts were edited on this PC.",
+        envvar="AURORA_ALLOW_MODIFIED",
+    ),
+    verbose: int = Option(
+        0,
+        "--verbose",
+        "-v",
+        count=True,
+        help="-v shows how the command was launched and debug messages, -vv also timings and tracebacks.",
+        envvar="AURORA_VERBOSE",
+    ),
+    # Failure injection to rehearse the AutoSuite workflow, hidden from the help, see chaos.py
+    fail_after_step: int | None = Option(None, hidden=True, envvar="AURORA_FAIL_AFTER_STEP"),
+    simulate_db_lock: bool = Option(
+        False,  # noqa: FBT003
+        "--simulate-db-lock",
+        hidden=True,
+        envvar="AURORA_SIMULATE_DB_LOCK",
+    ),
+    slow: float = Option(0, hidden=True, envvar="AURORA_SLOW"),
+    version: bool = Option(
+        False,  # noqa: FBT003
+        "--version",
+        help="Print the version, commit and build date and exit.",
+        callback=print_version,
+        is_eager=True,
+    ),
+) -> None:
+    """Tools for the Aurora battery assembly robot."""
+    if "--help" in command_args(ctx):
+        # Only show the help of the command, without locking or touching the database
+        return
+    if "--version" in command_args(ctx):
+        # e.g. aurora-rt balance --version, the same for every command
+        print_version(True)  # noqa: FBT003
+    if robot is not None:
+        from aurora_robot_tools import config
+        from aurora_robot_tools.errors import ConfigError
+
+        # Before anything else is imported, modules read the settings when they are imported
+        try:
+            config.apply_robot_profile(robot)
+        except ConfigError as e:
+            raise BadParameter(str(e), param_hint="--robot") from e
+        state["db_path"] = config.DATABASE_FILEPATH
+
+        from aurora_robot_tools import config_watch
+
+        config_watch.robot = robot
+    from aurora_robot_tools.log import setup_logging
+
+    if output not in OUTPUT_FORMATS:
+        msg = f"Output must be one of {', '.join(OUTPUT_FORMATS)}, got '{output}'."
+        raise BadParameter(msg, param_hint="--output")
+    state["command"] = ctx.invoked_subcommand
+    state["output"] = output
+    if output == "json":
+        # stdout only has the result, everything else printed or logged goes to stderr
+        state["stdout"], sys.stdout = sys.stdout, sys.stderr
+    state["log_file"] = setup_logging(ctx.invoked_subcommand or "aurora-rt")
+    from aurora_robot_tools.config import credential_errors
+
+    for error in credential_errors.values():
+        logger.warning("%s The setting is left empty.", error)
+    if verbose:
+        from aurora_robot_tools import trace
+
+        trace.enable(verbose)
+        trace.describe_launch()
+    if ctx.invoked_subcommand and ctx.invoked_subcommand not in {"doctor", "version"}:
+        from aurora_robot_tools import integrity
 
+        integrity.allow_modified = allow_modified
+        integrity.check_package()
+    if ctx.invoked_subcommand:
+        from aurora_robot_tools import shutdown
 
-@app.command()
+        shutdown.install()
+    # Messages for the JSON output and the result file
+    from aurora_robot_tools.jobs import MessageCollector
+
+    collector = MessageCollector()
+    logging.getLogger("aurora_robot_tools").addHandler(collector)
+    state["messages"] = collector.messages
+    if ctx.invoked_subcommand:
+        from aurora_robot_tools import progress
+
+        progress.start(ctx.invoked_subcommand)
+
+        from aurora_robot_tools import chaos
+
+        chaos.enable(fail_after_step, simulate_db_lock, slow)
+    if decimal_comma is not None:
+        from aurora_robot_tools import decimals
+
+        decimals.decimal_comma = decimal_comma
+    if timeout:
+        from aurora_robot_tools.watchdog import start_timeout
+
+        start_timeout(timeout)
+    if db is not None:
+        state["db_path"] = db
+    if db_profile is not None:
+        state["db_path"] = profile_path(db_profile, db)
+    state["dry_run"] = dry_run
+    if snapshot and ctx.invoked_subcommand:
+        from aurora_robot_tools.snapshot import create_snapshot
+
+        # Deleted after the result is written, see run()
+        state["db_path"] = state["snapshot"] = create_snapshot(state["db_path"])
+    locked = ctx.invoked_subcommand in LOCKED_COMMANDS and not read_only(ctx) and not snapshot
+    if (locked or ctx.invoked_subcommand in JOB_COMMANDS) and not dry_run and not allow_production and not snapshot:
+        from aurora_robot_tools.production import refuse_production
+
+        refuse_production(state["db_path"])
+    if ctx.invoked_subcommand in JOB_COMMANDS:
+        from aurora_robot_tools import production
+
+        # A service that follows a new DATABASE_FILEPATH checks it again, see config_watch.py
+        production.allowed = allow_production or dry_run
+    if locked and not dry_run:
+        from aurora_robot_tools.lock import acquire_lock
+
+        # Released when the command finishes
+        ctx.with_resource(acquire_lock(state["db_path"], ctx.invoked_subcommand, wait_for_lock, force_clean))
+        if ctx.invoked_subcommand not in ("recover", "restore"):
+            from aurora_robot_tools.journal import check_unfinished, clean_unfinished
+
+            if force_clean:
+                clean_unfinished(state["db_path"])
+            check_unfinished(state["db_path"])
+    elif ctx.invoked_subcommand:
+        from aurora_robot_tools.lock import warn_if_stale
+
+        warn_if_stale(state["db_path"])
+    if ctx.invoked_subcommand in PLAN_COMMANDS and not snapshot:
+        from aurora_robot_tools.staging import approval_required, stage
+
+        # The robot database stays locked, the plan is written to the staging copy until it is committed
+        if approval_required():
+            state["db_path"] = stage(state["db_path"], dry_run)
+    # AutoSuite cannot always pass arguments, so they can be read from a sidecar file instead
+    if args_file is not None:
+        with args_file.open(encoding="utf-8") as f:
+            ctx.default_map = json.load(f)
+    from aurora_robot_tools.rerun import RERUN_COMMANDS
+
+    if ctx.invoked_subcommand in RERUN_COMMANDS and not dry_run and not snapshot:
+        from aurora_robot_tools.rerun import already_done
+
+        defaults = (ctx.default_map or {}).get(ctx.invoked_subcommand)
+        if not force and already_done(state["db_path"], ctx.invoked_subcommand, command_args(ctx), defaults):
+            ctx.exit(0)
+        # Recorded when the command succeeds, see record_rerun()
+        state["rerun"] = (command_args(ctx), defaults)
+    if ctx.invoked_subcommand and ctx.invoked_subcommand not in WAITING_COMMANDS:
+        from aurora_robot_tools.config import ACTIVITY_KILL_S, ACTIVITY_WARNING_S
+        from aurora_robot_tools.watchdog import start_activity_watchdog
+
+        # After waiting for the lock, so only the command itself is watched
+        start_activity_watchdog(ACTIVITY_WARNING_S, ACTIVITY_KILL_S, state["db_path"])
+    if ctx.invoked_subcommand:
+        from aurora_robot_tools.hooks import run_hooks
+
+        # The after hooks run in run(), also if a before hook fails
+        state["hooks"] = True
+        run_hooks("before", ctx.invoked_subcommand, state["db_path"], state["log_file"], dry_run)
+
+
+@app.command(epilog="Examples:\n\naurora-rt import-excel\n\naurora-rt --dry-run import-excel")
 def import_excel() -> None:
     """Import excel file and load into robot database."""
     from aurora_robot_tools.import_excel import main as import_excel_main
 
-    import_excel_main()
+    import_excel_main(state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Example: aurora-rt import-batch samples.xlsx --template input.xlsx")
+def import_batch(
+    sample_list: Path = Argument(..., help="ELN sample list, .xlsx or .csv with one row per cell."),
+    template: Path = Option(..., help="Input Excel file with the component and electrolyte properties."),
+) -> None:
+    """Import the cells to assemble from a sample list."""
+    from aurora_robot_tools.import_batch import main as import_batch_main
+
+    import_batch_main(sample_list, template, state["db_path"], state["dry_run"])
 
 
-@app.command()
-def electrolyte(safety_factor: float = Argument(1.1)) -> None:
+@app.command(epilog="Examples:\n\naurora-rt electrolyte\n\naurora-rt electrolyte 1.2 --resume")
+def electrolyte(
+    safety_factor: float | None = Argument(
+        None,
+        help="Multiplier for the electrolyte volumes, default ELECTROLYTE_SAFETY_FACTOR from the config.",
+        envvar="AURORA_ELECTROLYTE_SAFETY_FACTOR",
+    ),
+    resume: bool = Option(
+        False,  # noqa: FBT003
+        "--resume",
+        help="Skip if every balanced cell already has its electrolyte calculated, e.g. after an interruption.",
+    ),
+) -> None:
     """Determine electrolyte mixing steps."""
+    from aurora_robot_tools.config import ELECTROLYTE_SAFETY_FACTOR
     from aurora_robot_tools.electrolyte_calculation import main as electrolyte_main
 
-    electrolyte_main(safety_factor)
+    if safety_factor is None:
+        safety_factor = ELECTROLYTE_SAFETY_FACTOR
+    electrolyte_main(safety_factor, state["db_path"], state["dry_run"], resume)
+
+
+@app.command(
+    epilog="Example: aurora-rt doe --base LP30 --factor 'FEC (wt%)=0,2,5' --factor 'VC (wt%)=0,1' --batch 1",
+)
+def doe(
+    base: str = Option(..., help="Name of the base electrolyte in the Electrolyte Properties."),
+    factor: list[str] = Option(..., help='Component and levels, e.g. "FEC (wt%)=0,2,5", repeat for each component.'),
+    design: str = Option("full", help="full for every combination, latin for a Latin square of three components."),
+    batch: int | None = Option(None, help="Batch to assign the formulations to, default is every cell."),
+    output_dir: Path | None = Option(None, help="Folder for the design matrix, default is the output folder."),
+) -> None:
+    """Generate an electrolyte additive design and assign the formulations to the cells."""
+    from aurora_robot_tools.config import OUTPUT_DIR
+    from aurora_robot_tools.doe import main as doe_main
+
+    doe_main(base, factor, design, batch, state["db_path"], output_dir or OUTPUT_DIR, state["dry_run"])
 
 
-@app.command()
+@app.command(epilog="Example: aurora-rt backup")
 def backup() -> None:
     """Backup the robot database."""
     from aurora_robot_tools.backup_database import main as backup_main
 
-    backup_main()
+    backup_main(state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Examples:\n\naurora-rt restore --list\n\naurora-rt restore 2025-03-14_10-15-00_balance.db")
+def restore(
+    backup: str | None = Argument(None, help="Snapshot file to restore, default is the most recent one."),
+    list_backups: bool = Option(False, "--list", help="List the snapshots instead of restoring."),  # noqa: FBT003
+) -> None:
+    """Restore the robot database from an automatic snapshot."""
+    from aurora_robot_tools.backup_database import log_backups
+    from aurora_robot_tools.backup_database import restore as restore_main
+
+    if list_backups:
+        log_backups()
+        return
+    restore_main(backup, state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Examples:\n\naurora-rt recover --back\n\naurora-rt recover --forward")
+def recover(
+    forward: bool = Option(
+        False,  # noqa: FBT003
+        "--forward/--back",
+        help="Finish the unfinished steps, or undo the whole operation.",
+    ),
+) -> None:
+    """Recover from an operation that did not finish, e.g. after a crash."""
+    from aurora_robot_tools.journal import recover as recover_main
 
+    recover_main(state["db_path"], forward, state["dry_run"])
 
-@app.command()
-def balance(mode: int = Argument(6)) -> None:
+
+@app.command(
+    epilog=(
+        "Examples:\n\naurora-rt balance\n\naurora-rt balance 3 --rejection-cost-factor 4 --reject-out-of-spec"
+        "\n\naurora-rt balance --order electrolyte"
+    ),
+)
+def balance(
+    mode: int = Argument(
+        6,
+        help="Sorting method, 0-8: 3 2D matching, 5 exact 3D, 6 automatic, 8 plugin, see capacity_balance.py.",
+        envvar="AURORA_BALANCE_MODE",
+    ),
+    rejection_cost_factor: float = Option(
+        2.0,
+        help="Cost of rejecting a cell, higher values reject fewer cells at the expense of worse N:P ratios.",
+        envvar="AURORA_BALANCE_REJECTION_COST_FACTOR",
+    ),
+    reject_out_of_spec: bool = Option(
+        False,  # noqa: FBT003
+        "--reject-out-of-spec",
+        help="Reject cells with N:P ratio outside the config limits instead of aborting.",
+        envvar="AURORA_BALANCE_REJECT_OUT_OF_SPEC",
+    ),
+    resume: bool = Option(
+        False,  # noqa: FBT003
+        "--resume",
+        help="Only balance batches with no balanced cells yet, e.g. after an interruption.",
+    ),
+    order: str | None = Option(
+        None,
+        help="Assembly order: rack, electrolyte, chemistry or round-robin, default ASSEMBLY_ORDER in the config.",
+        envvar="AURORA_BALANCE_ORDER",
+        autocompletion=complete_orders,
+    ),
+) -> None:
     """Perform electrode balancing."""
+    from aurora_robot_tools import assembly_order
     from aurora_robot_tools.capacity_balance import main as balance_main
 
-    balance_main(mode)
+    assembly_order.strategy = order
+
+    balance_main(mode, rejection_cost_factor, state["db_path"], state["dry_run"], reject_out_of_spec, resume)
+
+
+@app.command(epilog="Example: aurora-rt rebalance 4 7 --lost cathode")
+def rebalance(
+    cells: list[int] = Argument(help="Cell numbers to reject."),
+    lost: str = Option("both", help="Electrodes lost from the rejected cells: both, anode, cathode or none."),
+    mode: int = Option(6, help="Sorting method, see balance."),
+    rejection_cost_factor: float = Option(2.0, help="Cost of rejecting a cell, see balance."),
+    link: bool = Option(True, "--link/--no-link", help="Link rack positions to presses, see assign."),  # noqa: FBT003
+    elyte_limit: int = Option(0, help="Limit of different electrolytes per batch, see assign."),
+    reject_out_of_spec: bool = Option(
+        False,  # noqa: FBT003
+        "--reject-out-of-spec",
+        help="Reject cells with N:P ratio outside the config limits instead of aborting.",
+    ),
+    order: str | None = Option(
+        None,
+        help="Assembly order of the remaining cells, see balance.",
+        autocompletion=complete_orders,
+    ),
+) -> None:
+    """Reject cells during a run and re-balance the remaining cells."""
+    from aurora_robot_tools import assembly_order
+    from aurora_robot_tools.rebalance import main as rebalance_main
+
+    assembly_order.strategy = order
+
+    rebalance_main(
+        cells,
+        lost,
+        mode,
+        rejection_cost_factor,
+        link,
+        elyte_limit,
+        state["db_path"],
+        state["dry_run"],
+        reject_out_of_spec,
+    )
+
+
+@app.command(epilog="Examples:\n\naurora-rt --dry-run commit\n\naurora-rt commit")
+def commit() -> None:
+    """Approve the staged plan so the robot can use it, see PLAN_APPROVAL."""
+    from aurora_robot_tools.staging import commit as commit_main
+
+    commit_main(state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Example: aurora-rt discard")
+def discard() -> None:
+    """Throw away the staged plan."""
+    from aurora_robot_tools.staging import discard as discard_main
+
+    discard_main(state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Example: aurora-rt review")
+def review() -> None:
+    """Review the cell pairings, swap electrodes or exclude cells, and commit the edited plan."""
+    from aurora_robot_tools.review import main as review_main
+
+    review_main(state["db_path"], state["dry_run"])
 
 
-@app.command()
-def assign(link: bool = Argument(True), elyte_limit: int = Argument(0)) -> None:  # noqa: FBT003
+@app.command(epilog="Examples:\n\naurora-rt assign\n\naurora-rt assign False 2 --minimize-travel")
+def assign(
+    link: bool = Argument(
+        True,  # noqa: FBT003
+        help="Only load rack positions into the presses they are linked to in PRESS_TO_RACK.",
+        envvar="AURORA_ASSIGN_LINK",
+    ),
+    elyte_limit: int = Argument(
+        0,
+        help="Maximum number of different electrolytes per batch, 0 for no limit.",
+        envvar="AURORA_ASSIGN_ELYTE_LIMIT",
+    ),
+    minimize_travel: bool = Option(
+        False,  # noqa: FBT003
+        "--minimize-travel",
+        help="Without linked rack positions, assign the cell closest to each press to reduce robot arm travel.",
+    ),
+) -> None:
     """Assign cells to presses."""
     from aurora_robot_tools.assign_cells_to_press import main as assign_main
 
-    assign_main(link, elyte_limit)
+    assign_main(link, elyte_limit, state["db_path"], state["dry_run"], minimize_travel)
 
 
-@app.command()
+@app.command(epilog="Example: aurora-rt startcam")
 def startcam() -> None:
     """Start the camera daemon."""
     from aurora_robot_tools.camera.camera_daemon import main as startcam_main
@@ -58,7 +636,7 @@ def startcam() -> None:
     startcam_main()
 
 
-@app.command()
+@app.command(epilog="Example: aurora-rt top-photo")
 def top_photo() -> None:
     """Save a photo of the pressing tools."""
     from aurora_robot_tools.camera.send_camera_command import send_command
@@ -66,7 +644,7 @@ def top_photo() -> None:
     send_command("capturetop")
 
 
-@app.command()
+@app.command(epilog="Example: aurora-rt bottom-photo")
 def bottom_photo() -> None:
     """Save a photo from the bottom-up alignment camera."""
     from aurora_robot_tools.camera.send_camera_command import send_command
@@ -74,25 +652,547 @@ def bottom_photo() -> None:
     send_command("capturebottom")
 
 
-@app.command()
+@app.command(epilog="Example: aurora-rt output")
 def output() -> None:
     """Output the robot database to a JSON file."""
     from aurora_robot_tools.output_json import main as output_main
 
-    output_main()
+    output_main(state["db_path"])
+
+
+@app.command(epilog="Example: aurora-rt export-plan --output-dir D:/Runs")
+def export_plan(
+    output_dir: Path | None = Option(None, help="Folder for the run sheet, default is the output folder."),
+) -> None:
+    """Export the batch plan to Excel and CSV files for operators."""
+    from aurora_robot_tools.config import OUTPUT_DIR
+    from aurora_robot_tools.export_plan import main as export_plan_main
+
+    export_plan_main(state["db_path"], output_dir or OUTPUT_DIR)
+
+
+@app.command(epilog="Examples:\n\naurora-rt overrides pins.csv\n\naurora-rt overrides --clear")
+def overrides(
+    file: Path | None = Argument(None, help="Overrides file, .csv or .xlsx, with the pins of each rack position."),
+    clear: bool = Option(False, "--clear", help="Remove all pins."),  # noqa: FBT003
+) -> None:
+    """Pin cells to presses or cathodes, balancing and press assignment only optimize the rest."""
+    from aurora_robot_tools.overrides import main as overrides_main
+
+    overrides_main(file, clear, state["db_path"], dry_run=state["dry_run"])
+
+
+@app.command(epilog="Examples:\n\naurora-rt report\n\naurora-rt report --verify 250314_kigr_01_report.html")
+def report(
+    output_dir: Path | None = Option(None, help="Folder for the report, default is the output folder."),
+    verify: Path | None = Option(None, help="Check the checksum of a report instead of writing one."),
+) -> None:
+    """Write an HTML batch report with the plan, assembly, warnings and versions for the lab notebook."""
+    from aurora_robot_tools import report as batch_report
+    from aurora_robot_tools.config import OUTPUT_DIR
+
+    if verify is not None:
+        batch_report.verify(verify)
+        return
+    batch_report.main(state["db_path"], output_dir or OUTPUT_DIR, dry_run=state["dry_run"])
+
+
+@app.command(epilog="Example: aurora-rt estimate --start 08:30")
+def estimate(
+    start: str | None = Option(None, help="Time of day the run starts, e.g. 08:30, default is now."),
+) -> None:
+    """Estimate how long the planned run takes and when it finishes."""
+    from aurora_robot_tools.estimate import main as estimate_main
+
+    estimate_main(state["db_path"], start)
+
 
+@app.command(epilog="Examples:\n\naurora-rt diff old_plan.csv\n\naurora-rt diff old.json new.json")
+def diff(
+    old: Path = Argument(..., help="Earlier plan, .csv or .xlsx run sheet, .json output or .db database."),
+    new: Path | None = Argument(None, help="Later plan, default is the current database."),
+) -> None:
+    """Show which cells changed press, pairing or electrolyte between two plans."""
+    from aurora_robot_tools.plan_diff import main as diff_main
+
+    diff_main(old, new, state["db_path"])
+
+
+@app.command(epilog="Example: aurora-rt export-cycler --format csv --push")
+def export_cycler(
+    file_format: str = Option("json", "--format", help="json or csv."),
+    push: bool = Option(False, "--push", help="Copy the file to CYCLER_SAMPLE_DIR from the config."),  # noqa: FBT003
+    output_dir: Path | None = Option(None, help="Folder for the file, default is the output folder."),
+) -> None:
+    """Export the metadata of the planned cells for the Aurora cycler."""
+    from aurora_robot_tools.config import OUTPUT_DIR
+    from aurora_robot_tools.cycler_export import main as export_cycler_main
+
+    export_cycler_main(file_format, push and not state["dry_run"], state["db_path"], output_dir or OUTPUT_DIR)
+
+
+@app.command(epilog="Examples:\n\naurora-rt shortfall --stock stock.csv\n\naurora-rt shortfall --format json")
+def shortfall(
+    stock: Path | None = Option(None, help="CSV or Excel file with what is in stock, default STOCK_FILE."),
+    file_format: str = Option("csv", "--format", help="csv or json for the shopping list."),
+    output_dir: Path | None = Option(None, help="Folder for the shopping list, default is the output folder."),
+) -> None:
+    """Compare what the plan needs with the stock and write a shopping list."""
+    from aurora_robot_tools.config import OUTPUT_DIR
+    from aurora_robot_tools.shortfall import main as shortfall_main
+
+    shortfall_main(state["db_path"], stock, file_format, output_dir or OUTPUT_DIR)
+
+
+@app.command(epilog="Example: aurora-rt serve --port 8765")
+def serve(
+    host: str | None = Option(None, help="Address to listen on, default from config."),
+    port: int | None = Option(None, help="Port to listen on, default from config."),
+) -> None:
+    """Serve the tools over HTTP for remote calls."""
+    from aurora_robot_tools.config import SERVER_HOST, SERVER_PORT
+    from aurora_robot_tools.server import main as serve_main
+
+    serve_main(host or SERVER_HOST, port or SERVER_PORT, state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Example: aurora-rt doctor")
+def doctor() -> None:
+    """Check the Python environment, database, folders and press configuration."""
+    from aurora_robot_tools.doctor import main as doctor_main
+
+    doctor_main(state["db_path"])
+
+
+@app.command(epilog="Examples:\n\naurora-rt version\n\naurora-rt version --stamp")
+def version(
+    stamp: bool = Option(
+        False,  # noqa: FBT003
+        "--stamp",
+        help="Write the commit and date into the package before building a wheel.",
+    ),
+) -> None:
+    """Show the version, commit, database schema and companion script hashes, for support tickets."""
+    from aurora_robot_tools import provenance
+
+    if stamp:
+        provenance.stamp()
+        return
+    provenance.main(state["db_path"])
+
+
+@app.command(epilog="Example: aurora-rt inventory")
+def inventory() -> None:
+    """Update and summarise the electrode inventory."""
+    from aurora_robot_tools.inventory import main as inventory_main
+
+    inventory_main(state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Example: aurora-rt load-list")
+def load_list(
+    output_dir: Path | None = Option(None, help="Folder for the load list, default is the output folder."),
+) -> None:
+    """List the vials and consumables to place in the robot before the run."""
+    from aurora_robot_tools.config import OUTPUT_DIR
+    from aurora_robot_tools.load_list import main as load_list_main
+
+    load_list_main(state["db_path"], output_dir or OUTPUT_DIR)
+
+
+@app.command(epilog="Example: aurora-rt scan --port COM3")
+def scan(
+    port: str | None = Option(None, help="Serial port of the barcode scanner, e.g. COM3, default reads stdin."),
+    baud_rate: int = Option(9600, help="Baud rate of the serial scanner."),
+) -> None:
+    """Read rack barcodes from a scanner and check them against the database."""
+    from aurora_robot_tools.barcode import main as scan_main
+
+    scan_main(port, baud_rate, state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Examples:\n\naurora-rt labels\n\naurora-rt labels --reprint")
+def labels(
+    reprint: bool = Option(False, "--reprint", help="Print labels for all planned cells again."),  # noqa: FBT003
+) -> None:
+    """Reserve unique cell IDs and print labels for the planned cells."""
+    from aurora_robot_tools.labels import main as labels_main
 
-@app.command()
-def led(setting: str) -> None:
+    labels_main(reprint, state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Examples:\n\naurora-rt weigh anode\n\naurora-rt weigh cathode --port COM5 --protocol sartorius")
+def weigh(
+    electrode: str = Argument(help="Electrode to weigh, anode or cathode."),
+    port: str | None = Option(None, help="Serial port of the balance, e.g. COM4, default from config."),
+    baud_rate: int | None = Option(None, help="Baud rate of the balance, default from config."),
+    protocol: str | None = Option(None, help="Balance protocol, mt-sics or sartorius, default from config."),
+) -> None:
+    """Weigh electrodes on an analytical balance and store the masses."""
+    from aurora_robot_tools.config import BALANCE_BAUD_RATE, BALANCE_PORT, BALANCE_PROTOCOL
+    from aurora_robot_tools.weigh import main as weigh_main
+
+    weigh_main(
+        electrode,
+        port or BALANCE_PORT,
+        baud_rate or BALANCE_BAUD_RATE,
+        protocol or BALANCE_PROTOCOL,
+        state["db_path"],
+        state["dry_run"],
+    )
+
+
+@app.command(
+    "verify-mass",
+    epilog="Examples:\n\naurora-rt verify-mass masses.csv\n\naurora-rt verify-mass --port COM5",
+)
+def verify_mass(
+    file: Path | None = Argument(None, help="CSV file with the cell masses, default is to weigh on the balance."),
+    port: str | None = Option(None, help="Serial port of the balance, e.g. COM4, default from config."),
+    baud_rate: int | None = Option(None, help="Baud rate of the balance, default from config."),
+    protocol: str | None = Option(None, help="Balance protocol, mt-sics or sartorius, default from config."),
+) -> None:
+    """Weigh the assembled cells and flag cells with a missing component."""
+    from aurora_robot_tools.config import BALANCE_BAUD_RATE, BALANCE_PORT, BALANCE_PROTOCOL
+    from aurora_robot_tools.verify_mass import main as verify_mass_main
+
+    verify_mass_main(
+        file,
+        port or BALANCE_PORT,
+        baud_rate or BALANCE_BAUD_RATE,
+        protocol or BALANCE_PROTOCOL,
+        state["db_path"],
+        state["dry_run"],
+    )
+
+
+@app.command(epilog="Example: aurora-rt agent --job-dir C:/Modules/Jobs")
+def agent(job_dir: Path | None = Option(None, help="Folder to watch for job files, default from config.")) -> None:
+    """Run jobs from files dropped in a folder, until stopped."""
+    from aurora_robot_tools.agent import main as agent_main
+    from aurora_robot_tools.config import JOB_DIR
+
+    agent_main(job_dir or JOB_DIR, state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Example: aurora-rt listen")
+def listen(port: int | None = Option(None, help="Local port to listen on, default from config.")) -> None:
+    """Listen for JSON job requests on a local TCP port, until stopped."""
+    from aurora_robot_tools.config import JOB_PORT
+    from aurora_robot_tools.job_socket import listen as listen_main
+
+    listen_main(port or JOB_PORT, state["db_path"], state["dry_run"])
+
+
+@app.command(epilog="Examples:\n\naurora-rt standby\n\naurora-rt standby --once")
+def standby(
+    interval: float | None = Option(None, help="Seconds between checks, default from config."),
+    once: bool = Option(False, "--once", help="Check once, exit with an error if a check fails."),  # noqa: FBT003
+) -> None:
+    """Keep checking the database and write a green or red status file for AutoSuite, until stopped."""
+    from aurora_robot_tools.config import STANDBY_FILE, STANDBY_INTERVAL
+    from aurora_robot_tools.standby import main as standby_main
+
+    standby_main(state["db_path"], STANDBY_FILE, interval or STANDBY_INTERVAL, once)
+
+
+@app.command(
+    epilog="Examples:\n\naurora-rt send-job '{\"command\": \"balance\", \"mode\": 3}'\n\naurora-rt send-job job.json",
+)
+def send_job(
+    request: str = Argument(help="JSON request, or path to a JSON file, e.g. '{\"command\": \"balance\"}'."),
+    port: int | None = Option(None, help="Port of the listener, default from config."),
+) -> None:
+    """Send a job request to the listener, exit with the exit code of the job."""
+    from aurora_robot_tools.config import JOB_PORT
+    from aurora_robot_tools.job_socket import send_job as send_job_main
+
+    request_path = Path(request)
+    if request_path.suffix == ".json" and request_path.is_file():
+        request = request_path.read_text(encoding="utf-8")
+    result = send_job_main(json.loads(request), port or JOB_PORT)
+    print(json.dumps(result, indent=4))
+    raise Exit(result["exit_code"])
+
+
+@db_app.command(epilog="Example: aurora-rt db migrate")
+def migrate() -> None:
+    """Create or upgrade the tables in the robot database."""
+    from aurora_robot_tools.migrations import migrate as migrate_main
+
+    migrate_main(state["db_path"], state["dry_run"])
+
+
+@db_app.command("status", epilog="Example: aurora-rt db status")
+def db_status() -> None:
+    """Show the schema version of the robot database and pending migrations."""
+    from aurora_robot_tools.migrations import status
+
+    status(state["db_path"])
+
+
+@db_app.command(epilog='Example: aurora-rt db query "SELECT * FROM Press_Table" --format csv')
+def query(
+    sql: str = Argument(..., help='SQL statement, e.g. "SELECT * FROM Press_Table".'),
+    file_format: str = Option("table", "--format", help="table, csv or json."),
+    write: bool = Option(
+        False,  # noqa: FBT003
+        "--write",
+        help="Allow the statement to change the database, which is backed up first.",
+    ),
+) -> None:
+    """Run an SQL query on the robot database, read-only unless --write is given."""
+    from aurora_robot_tools.query import main as query_main
+
+    query_main(sql, file_format, write, state["db_path"], state["dry_run"])
+
+
+@db_app.command("export", epilog="Example: aurora-rt db export --batch 42 --out batch42.json")
+def db_export(
+    batch: int = Option(..., help="Batch number to export."),
+    out: Path = Option(..., help="JSON file to write."),
+) -> None:
+    """Export a batch with its cells, electrodes, electrolytes and presses, to import it on another robot."""
+    from aurora_robot_tools.batch_transfer import export_batch
+
+    export_batch(batch, out, state["db_path"])
+
+
+@db_app.command(
+    "import",
+    epilog="Examples:\n\naurora-rt db import batch42.json\n\naurora-rt db import batch42.json --batch 7 --replace",
+)
+def db_import(
+    file: Path = Argument(..., help="JSON file from db export."),
+    batch: int | None = Option(None, help="Import under this batch number, default is the exported one."),
+    replace: bool = Option(False, "--replace", help="Overwrite cells at the same rack positions."),  # noqa: FBT003
+) -> None:
+    """Import a batch exported from another robot database."""
+    from aurora_robot_tools.batch_transfer import import_batch
+
+    import_batch(file, batch, replace, state["db_path"], state["dry_run"])
+
+
+@press_app.command(epilog='Example: aurora-rt press disable 3 --reason "load cell drift"')
+def disable(
+    press: int = Argument(..., help="Press number."),
+    reason: str = Option("", help="Why the press is out of service, e.g. 'load cell drift'."),
+) -> None:
+    """Stop assigning cells to a press until it is enabled again."""
+    from aurora_robot_tools.presses import set_disabled
+
+    set_disabled(press, True, reason, state["db_path"], state["dry_run"])  # noqa: FBT003
+
+
+@press_app.command(epilog="Example: aurora-rt press enable 3")
+def enable(press: int = Argument(..., help="Press number.")) -> None:
+    """Put a disabled press back into service."""
+    from aurora_robot_tools.presses import set_disabled
+
+    set_disabled(press, False, "", state["db_path"], state["dry_run"])  # noqa: FBT003
+
+
+@press_app.command(epilog="Example: aurora-rt press calibrate 3 --error 1.5")
+def calibrate(
+    press: int = Argument(..., help="Press number."),
+    error: float = Option(..., help="Calibration error of the load cell in percent, against a reference."),
+) -> None:
+    """Store the calibration error of a press, used to route cells with a pressure tolerance."""
+    from aurora_robot_tools.presses import set_calibration
+
+    set_calibration(press, error, state["db_path"], state["dry_run"])
+
+
+@press_app.command(epilog="Example: aurora-rt press force 3 --duration 15")
+def force(
+    press: int = Argument(..., help="Press number."),
+    duration: float | None = Option(None, help="Seconds to read the load cell for, default from config."),
+) -> None:
+    """Record the peak crimp force of the cell in a press from its load cell."""
+    from aurora_robot_tools.config import PRESS_FORCE_DURATION_S
+    from aurora_robot_tools.press_force import record_force
+
+    record_force(press, duration or PRESS_FORCE_DURATION_S, state["db_path"], state["dry_run"])
+
+
+@press_app.command("status", epilog="Example: aurora-rt press status")
+def press_status() -> None:
+    """Show which presses are available, loaded, in error or disabled, and their calibration."""
+    from aurora_robot_tools.presses import status
+
+    status(state["db_path"])
+
+
+@batch_app.command(
+    epilog='Examples:\n\naurora-rt batch clone 42 --cells 32 --name "NMC-repro"\n\n'
+    "aurora-rt batch clone 42 --source C:/Modules/Database/Backup/run_17.db",
+)
+def clone(
+    batch: int = Argument(..., help="Batch number to copy the parameters from."),
+    cells: int | None = Option(None, help="Number of cells in the new batch, default as many as the batch has."),
+    name: str | None = Option(None, help="Name of the new batch, written to the Comments of its cells."),
+    new_batch: int | None = Option(None, "--batch", help="Number of the new batch, default the next free one."),
+    source: Path | None = Option(None, help="Database to clone the batch from, e.g. a backup of an earlier run."),
+) -> None:
+    """Add a new batch with the chemistry, electrolyte and ratios of an existing one, in the free rack positions."""
+    from aurora_robot_tools.batch_clone import clone_batch
+
+    clone_batch(batch, cells, name, new_batch, source, state["db_path"], state["dry_run"])
+
+
+@dispenser_app.command(
+    "calibrate",
+    epilog="Examples:\n\naurora-rt dispenser calibrate 1 calibration.csv\n\n"
+    "aurora-rt dispenser calibrate 2 calibration.xlsx --density 1.25",
+)
+def dispenser_calibrate(
+    syringe: str = Argument(..., help="Name of the syringe, as in DISPENSER_SYRINGE."),
+    file: Path = Argument(..., help="CSV or Excel file with Commanded (uL) and Mass (mg) or Mass (g) columns."),
+    density: float | None = Option(
+        None,
+        help="Density of the calibration liquid in g/mL, default DISPENSER_CALIBRATION_DENSITY_G_ML.",
+    ),
+) -> None:
+    """Store a gravimetric calibration run of a syringe, used to correct the dispensed volumes."""
+    from aurora_robot_tools.config import DISPENSER_CALIBRATION_DENSITY_G_ML
+    from aurora_robot_tools.dispenser_calibration import record_calibration
+
+    record_calibration(syringe, file, density or DISPENSER_CALIBRATION_DENSITY_G_ML, state["db_path"], state["dry_run"])
+
+
+@dispenser_app.command("status", epilog="Example: aurora-rt dispenser status")
+def dispenser_status() -> None:
+    """Show the latest calibration of each syringe and whether it has expired."""
+    from aurora_robot_tools.dispenser_calibration import status
+
+    status(state["db_path"])
+
+
+@credentials_app.command(
+    "set",
+    epilog="Examples:\n\naurora-rt credentials set teams-webhook\n\naurora-rt credentials set teams-webhook --machine",
+)
+def set_credential(
+    name: str = Argument(..., help='Name to refer to it in the config, as "credential:<name>".'),
+    machine: bool = Option(
+        False,  # noqa: FBT003
+        "--machine",
+        help="Let every Windows user of this PC decrypt it, e.g. if AutoSuite runs as another user.",
+    ),
+) -> None:
+    """Store a token or password encrypted, it is asked for without showing it."""
+    import getpass
+
+    from aurora_robot_tools.config import CREDENTIALS_FILE
+    from aurora_robot_tools.credentials import set_credential as set_credential_main
+
+    value = getpass.getpass(f"Value of {name}: ") if sys.stdin.isatty() else sys.stdin.readline().rstrip("\n")
+    set_credential_main(name, value, CREDENTIALS_FILE, machine)
+
+
+@credentials_app.command("list", epilog="Example: aurora-rt credentials list")
+def list_credentials() -> None:
+    """Show the names of the stored credentials, not their values."""
+    from aurora_robot_tools.config import CREDENTIALS_FILE
+    from aurora_robot_tools.credentials import list_credentials as list_credentials_main
+
+    list_credentials_main(CREDENTIALS_FILE)
+
+
+@credentials_app.command("remove", epilog="Example: aurora-rt credentials remove teams-webhook")
+def remove_credential(name: str = Argument(..., help="Name of the credential.")) -> None:
+    """Remove a stored credential."""
+    from aurora_robot_tools.config import CREDENTIALS_FILE
+    from aurora_robot_tools.credentials import remove_credential as remove_credential_main
+
+    remove_credential_main(name, CREDENTIALS_FILE)
+
+
+@plan_app.command(epilog="Examples:\n\naurora-rt plan show\n\naurora-rt plan show --format svg --out map.svg")
+def show(
+    map_format: str = Option("text", "--format", help="text, or svg for a printable map."),
+    out: Path | None = Option(None, help="File to write the map to, default for svg is the output folder."),
+) -> None:
+    """Draw a map of the presses and rack positions with the planned cells, coloured by chemistry."""
+    from aurora_robot_tools.config import OUTPUT_DIR
+    from aurora_robot_tools.plan_map import MAP_FORMATS
+    from aurora_robot_tools.plan_map import main as plan_map_main
+
+    if map_format not in MAP_FORMATS:
+        msg = f"Must be one of {', '.join(MAP_FORMATS)}."
+        raise BadParameter(msg, param_hint="--format")
+    plan_map_main(state["db_path"], map_format, out, OUTPUT_DIR)
+
+
+@pyenv_app.command(epilog="Example: aurora-rt pyenv setup")
+def setup(
+    package: str | None = Option(None, help="What to install the tools from, default this checkout or version."),
+) -> None:
+    """Create the environment from the pinned requirements file and install the tools into it."""
+    from aurora_robot_tools.config import PYTHON_ENV_DIR, PYTHON_REQUIREMENTS
+    from aurora_robot_tools.pyenv import setup as setup_main
+
+    setup_main(PYTHON_ENV_DIR, PYTHON_REQUIREMENTS, package, state["dry_run"])
+
+
+@pyenv_app.command(epilog="Example: aurora-rt pyenv freeze")
+def freeze() -> None:
+    """Write the pinned requirements file from the packages of this Python."""
+    from aurora_robot_tools.config import PYTHON_REQUIREMENTS
+    from aurora_robot_tools.pyenv import freeze as freeze_main
+
+    freeze_main(PYTHON_REQUIREMENTS, state["dry_run"])
+
+
+@pyenv_app.command("status", epilog="Example: aurora-rt pyenv status")
+def pyenv_status() -> None:
+    """Show if the environment matches the pinned requirements and is the one running."""
+    from aurora_robot_tools.config import PYTHON_ENV_DIR, PYTHON_REQUIREMENTS
+    from aurora_robot_tools.pyenv import status as pyenv_status_main
+
+    pyenv_status_main(PYTHON_ENV_DIR, PYTHON_REQUIREMENTS)
+
+
+@app.command(epilog="Example: aurora-rt hash-script balance_plugin.py")
+def hash_script(path: Path = Argument(..., help="Companion script, e.g. the balancing plugin.")) -> None:
+    """Print the SCRIPT_HASHES config line for a companion script."""
+    from aurora_robot_tools.integrity import hash_script as hash_script_main
+
+    print(hash_script_main(path))
+
+
+@app.command(epilog="Example: aurora-rt states")
+def states() -> None:
+    """Show where each cell is in the assembly pipeline."""
+    from aurora_robot_tools.cell_state import main as states_main
+
+    states_main(state["db_path"])
+
+
+@app.command(epilog="Example: aurora-rt history --limit 5 --command balance")
+def history(
+    limit: int = Option(20, help="Number of runs to show."),
+    command: str | None = Option(None, help="Only show runs of this command."),
+) -> None:
+    """Show the most recent runs of the tools."""
+    from aurora_robot_tools.history import main as history_main
+
+    history_main(limit, command)
+
+
+@app.command(epilog="Examples:\n\naurora-rt led green\n\naurora-rt led off")
+def led(
+    setting: str = Argument(..., help="off, red, green, blue, white, on, party or qr, or the short forms r, g, b, w."),
+) -> None:
     """Set the LED ring light color."""
     from aurora_robot_tools.camera.ringlight import set_light
 
     set_light(setting)
 
 
-@app.command()
+@app.command(epilog="Example: aurora-rt find-circles C:/Aurora_images/run_1")
 def find_circles(
-    folder: str = Argument(None),
+    folder: str = Argument(None, help="Folder with the images, default is the current folder."),
 ) -> None:
     """Find circles in images."""
     from pathlib import Path
@@ -104,11 +1204,14 @@ def find_circles(
     process_folder(folder_path)
 
 
-@app.command()
+@app.command(epilog="Example: aurora-rt recalibrate robot.app alignment.json")
 def recalibrate(
-    app_path: str,
-    calibration_path: Annotated[list[str] | None, Argument()] = None,
-    fit_to_grid: bool = True,
+    app_path: str = Argument(..., help="Chemspeed APP file to recalibrate."),
+    calibration_path: Annotated[
+        list[str] | None,
+        Argument(help="Alignment JSON files, default is every *alignment*.json in the current folder."),
+    ] = None,
+    fit_to_grid: bool = Option(True, help="Fit the positions to a regular grid."),  # noqa: FBT003
 ) -> None:
     """Recalibrate the APP file."""
     from aurora_robot_tools.chemapp_edit import realign_app
@@ -116,29 +1219,184 @@ def recalibrate(
     realign_app(app_path, calibration_path, fit_to_grid)
 
 
-@app.command()
-def app_to_xml(filepath: str) -> None:
+@app.command(epilog="Example: aurora-rt app-to-xml robot.app")
+def app_to_xml(filepath: str = Argument(..., help="Chemspeed APP file.")) -> None:
     """Convert Chemspeed APP to XML."""
     from aurora_robot_tools.chemapp_edit import app_to_xml
 
     app_to_xml(filepath)
 
 
-@app.command()
-def xml_to_app(filepath: str) -> None:
-    """Convert Chemspeed APP to XML."""
+@app.command(epilog="Example: aurora-rt xml-to-app robot.xml")
+def xml_to_app(filepath: str = Argument(..., help="XML file from app-to-xml.")) -> None:
+    """Convert XML back to a Chemspeed APP."""
     from aurora_robot_tools.chemapp_edit import xml_to_app
 
     xml_to_app(filepath)
 
 
-@app.command()
-def led(input: str) -> None:
-    """Set the LED ring light color."""
-    from aurora_robot_tools.camera.ringlight import set_light
+def record_history(started: datetime, start_time: float, exit_code: int) -> None:
+    """Add this run to the run history."""
+    if state["command"] in (None, "history"):
+        return
+    from aurora_robot_tools.history import record_run
+
+    # Only commands that imported the database module can have changed cells
+    database = sys.modules.get("aurora_robot_tools.database")
+    record_run(
+        command=state["command"],
+        arguments=sys.argv[1:],
+        environment={k: v for k, v in os.environ.items() if k.startswith("AURORA_")},
+        db_path=state["db_path"],
+        dry_run=state["dry_run"],
+        started=started.isoformat(timespec="seconds"),
+        duration=time.monotonic() - start_time,
+        exit_code=exit_code,
+        cells_changed=database.cells_changed if database else 0,
+        log_file=state["log_file"],
+    )
+
+
+def record_rerun(exit_code: int) -> None:
+    """Record a successful planning command, so running it again can be recognised."""
+    if state["rerun"] is None or exit_code != 0:
+        return
+    from aurora_robot_tools.rerun import record
+
+    arguments, defaults = state["rerun"]
+    record(state["db_path"], state["command"], arguments, defaults)
+
+
+def run_after_hooks(start_time: float, exit_code: int, error: str | None = None) -> None:
+    """Run the after hooks of the command, once the database is unlocked."""
+    if not state["hooks"]:
+        return
+    from aurora_robot_tools.hooks import run_hooks
+
+    database = sys.modules.get("aurora_robot_tools.database")
+    run_hooks(
+        "after",
+        state["command"],
+        state["db_path"],
+        state["log_file"],
+        state["dry_run"],
+        exit_code=exit_code,
+        error=error,
+        duration_s=round(time.monotonic() - start_time, 1),
+        cells_changed=database.cells_changed if database else 0,
+    )
+
+
+def save_result(start_time: float, exit_code: int, error: str | None = None) -> None:
+    """Log the warnings of the run together and write the result file for AutoSuite."""
+    if state["command"] is None:
+        return
+    from aurora_robot_tools.result import build_result, log_warning_summary, write_result
+
+    log_warning_summary(state["messages"])
+
+    database = sys.modules.get("aurora_robot_tools.database")
+    write_result(
+        build_result(
+            command=state["command"],
+            exit_code=exit_code,
+            error=error,
+            messages=state["messages"],
+            duration=time.monotonic() - start_time,
+            db_path=state["db_path"],
+            dry_run=state["dry_run"],
+            cells_changed=database.cells_changed if database else 0,
+            log_file=state["log_file"],
+        ),
+    )
+
+
+def send_notification(start_time: float, exit_code: int, error: str | None = None) -> None:
+    """Notify the configured webhook that the command finished."""
+    from aurora_robot_tools.notify import notify
+
+    notify(
+        command=state["command"],
+        exit_code=exit_code,
+        duration=time.monotonic() - start_time,
+        error=error,
+        db_path=state["db_path"],
+        dry_run=state["dry_run"],
+    )
+
+
+def print_result(exit_code: int, error: str | None = None) -> None:
+    """Print the result of the command as JSON on stdout, if JSON output was chosen."""
+    if state["output"] != "json":
+        return
+    result = {
+        "ok": exit_code == 0,
+        "command": state["command"],
+        "exit_code": exit_code,
+        "dry_run": state["dry_run"],
+        "error": error,
+        "warnings": [m["message"] for m in state["messages"] if m["level"] in ("WARNING", "ERROR", "CRITICAL")],
+        "messages": state["messages"],
+    }
+    if exit_code == 0 and state["command"] in PLAN_COMMANDS:
+        from aurora_robot_tools.export_plan import read_plan
+
+        result["plan"] = read_plan(state["db_path"]).to_dict(orient="records")
+    print(json.dumps(result, default=str), file=state["stdout"])
+
+
+def remove_snapshot() -> None:
+    """Delete the database copy of --snapshot."""
+    if state["snapshot"] is not None:
+        from aurora_robot_tools.snapshot import remove_snapshot as remove
+
+        remove(state["snapshot"])
+
+
+def checkpoint_databases() -> None:
+    """Move the write-ahead log of the databases the command used into the database files."""
+    if "aurora_robot_tools.database" in sys.modules:
+        from aurora_robot_tools.database import checkpoint_all
+
+        checkpoint_all()
+
+
+def run() -> None:
+    """Run the command line interface, exit with a code describing the type of failure."""
+    from aurora_robot_tools import progress
+
+    started = datetime.now(timezone.utc)
+    start_time = time.monotonic()
+    try:
+        # Every option can also be set with an environment variable AURORA_<COMMAND>_<OPTION>
+        app(auto_envvar_prefix="AURORA")
+    except SystemExit as e:
+        exit_code = e.code if isinstance(e.code, int) else int(e.code is not None)
+        progress.finish(exit_code)
+        record_history(started, start_time, exit_code)
+        record_rerun(exit_code)
+        run_after_hooks(start_time, exit_code)
+        save_result(start_time, exit_code)
+        send_notification(start_time, exit_code)
+        print_result(exit_code)
+        remove_snapshot()
+        checkpoint_databases()
+        raise
+    except Exception as e:
+        from aurora_robot_tools.errors import get_exit_code
 
-    set_light(input)
+        exit_code = get_exit_code(e)
+        logger.critical("%s (exit code %d)", e, exit_code, exc_info=e)
+        progress.finish(exit_code)
+        record_history(started, start_time, exit_code)
+        run_after_hooks(start_time, exit_code, str(e))
+        save_result(start_time, exit_code, str(e))
+        send_notification(start_time, exit_code, str(e))
+        print_result(exit_code, str(e))
+        remove_snapshot()
+        checkpoint_databases()
+        sys.exit(exit_code)
 
 
 if __name__ == "__main__":
-    app()
+    run()
@@ -1,15 +1,269 @@
-"""Common configuration settings for the Aurora robot tools."""
+"""Common configuration settings for the Aurora robot tools.
 
+The defaults below can be overridden per robot PC with an aurora.toml file, using the lowercase
+setting names as keys, e.g.
+
+    database_filepath = "D:/Modules/Database/chemspeedDB.db"
+    disabled_presses = [3]
+
+    [press_to_rack]
+    1 = 1
+    2 = 4
+
+    [database_profiles]
+    production = "//robot-pc/Modules/Database/chemspeedDB.db"
+    test = "C:/Dev/chemspeedDB_test.db"
+
+    [robot_profiles.one]
+    database_filepath = "//robot-one/Modules/Database/chemspeedDB.db"
+    rack_positions = 24
+    press_to_rack = {1 = 1, 2 = 3, 3 = 2, 4 = 4}
+
+    [chemistry_presets.NMC811-Gr]
+    np_ratio_target = 1.1
+    np_ratio_minimum = 1.05
+    np_ratio_maximum = 1.2
+    electrolyte = "LP57"
+    electrolyte_amount_before_separator_ul = 50
+    electrolyte_amount_after_separator_ul = 0
+    minimum_voltage_v = 3.0
+    maximum_voltage_v = 4.2
+
+Files are read in this order, later files override earlier ones:
+    1. aurora.toml in the directory of the Python executable
+    2. %APPDATA%/aurora-robot-tools/aurora.toml
+    3. The file given by the AURORA_CONFIG environment variable
+
+Settings can also be overridden with environment variables AURORA_<SETTING NAME>, e.g.
+AURORA_LOG_DIR, lists are comma separated and tables are JSON objects, e.g.
+AURORA_PRESS_TO_RACK={"1": 4, "2": 2}. Environment variables in paths, e.g. %userprofile%, are
+expanded.
+
+Tokens and passwords, e.g. in webhook_url, are stored encrypted with `aurora-rt credentials set` and
+given as "credential:<name>", see credentials.py.
+
+The services `aurora-rt serve`, `agent` and `listen` reload the config files between jobs when they
+change, see config_watch.py.
+"""
+
+import copy
+import json
+import os
+import sys
 from pathlib import Path
 
+from aurora_robot_tools.errors import ConfigError
+
+if sys.version_info >= (3, 11):
+    import tomllib
+else:
+    import tomli as tomllib
+
 DATABASE_FILEPATH = Path("C:/Modules/Database/chemspeedDB.db")
 DATABASE_BACKUP_DIR = Path("C:/Modules/Database/Backup/")
+# Named databases selected with --db-profile, e.g. {"production": ..., "test": ...}. Writing to the
+# "production" profile database is refused unless --allow-production is given
+DATABASE_PROFILES: dict[str, Path] = {}
+AUTO_BACKUP_KEEP = 20  # Snapshots taken before each command writes to the database, 0 to disable
+HISTORY_FILEPATH = Path("C:/Modules/Database/history.db")  # Record of every command run, see history.py
+CELL_ID_FILEPATH = Path("C:/Modules/Database/cell_ids.db")  # Cell IDs reserved so far, see labels.py
 TIME_ZONE = "Europe/Zurich"
 INPUT_DIR = Path("%userprofile%/Desktop/Inputs/")
 OUTPUT_DIR = Path("%userprofile%/Desktop/Outputs/")
 IMAGE_DIR = Path("C:/Aurora_images/")
+LOG_DIR = Path("C:/Modules/Logs/")
+LOG_KEEP_OUTPUT_RUNS = 200  # Number of runs to keep the captured stdout and stderr files for
+# Central log server the JSON log records are also sent to, syslog://host:514 (UDP),
+# syslog+tcp://host:601 or the Elasticsearch bulk API, e.g. http://elk:9200/_bulk, see log_shipping.py.
+# Records that can not be sent are spooled in LOG_DIR and sent later, up to LOG_SHIP_SPOOL_RECORDS.
+LOG_SHIP_URL = ""
+LOG_SHIP_INDEX = "aurora-robot-tools"
+LOG_SHIP_LEVEL = "INFO"
+LOG_SHIP_INTERVAL_S = 30.0
+LOG_SHIP_TIMEOUT = 5.0  # seconds
+LOG_SHIP_SPOOL_RECORDS = 100000
+STATUS_FILE = Path("C:/Modules/Logs/status.json")  # Progress of the running command, see progress.py
+RESULT_FILE = Path("C:/Modules/Logs/result.ini")  # Result of the last command, .json or .ini, see result.py
+JOB_DIR = Path("C:/Modules/Jobs/")  # Drop folder for job files from AutoSuite, see agent.py
+AGENT_POLL_INTERVAL = 1.0  # seconds
+# Status of the checks before a run, for the first step of the AutoSuite workflow, see standby.py
+STANDBY_FILE = Path("C:/Modules/Logs/standby.ini")
+STANDBY_INTERVAL = 10.0  # seconds between checks
+STANDBY_MIN_FREE_MB = 500  # Free disk space needed next to the database
+
+# Dedicated Python environment built from pinned requirements, see pyenv.py
+PYTHON_ENV_DIR = Path("C:/Modules/Python/aurora-env")
+PYTHON_REQUIREMENTS = Path("C:/Modules/Python/requirements.txt")
+
+# Retries if the database is locked, e.g. by AutoSuite, delay in seconds doubles after each attempt
+DB_RETRY_ATTEMPTS = 5
+DB_RETRY_DELAY = 0.5
+# Journal mode the database is switched to, "wal" so readers and writers do not block each other,
+# "delete" for the SQLite default, or "" to leave it as it is, see database.py
+DB_JOURNAL_MODE = "wal"
+
+# Order cells are numbered and assembled in: "rack", "electrolyte", "chemistry" or "round-robin",
+# see assembly_order.py
+ASSEMBLY_ORDER = "rack"
 
 CAMERA_PORT = 13865
+JOB_PORT = 13866  # Local TCP port for job requests, see job_socket.py
+
+# Analytical balance for weighing electrodes, see weigh.py, protocol is "mt-sics" or "sartorius"
+BALANCE_PORT = "COM4"
+BALANCE_BAUD_RATE = 9600
+BALANCE_PROTOCOL = "mt-sics"
+BALANCE_STABLE_READINGS = 3  # Consecutive stable readings required
+BALANCE_TOLERANCE_MG = 0.02  # Maximum difference between the stable readings
+BALANCE_TIMEOUT = 30.0  # seconds to wait for a stable reading
+
+# Unique cell IDs and labels, see labels.py for the pattern fields, LABEL_PRINTER is "host:port" of a
+# ZPL label printer, leave empty to only write the labels to a file
+CELL_ID_PATTERN = "{project}-{date:%y%m%d}-{chemistry}-{sequence:05d}"
+CELL_ID_PROJECT = "AUR"
+LABEL_PRINTER = ""
+
+# Folder the Aurora cycler reads sample metadata from, e.g. a network share, used by
+# `aurora-rt export-cycler --push`, see cycler_export.py
+CYCLER_SAMPLE_DIR = ""
+
+# Webhook notified when a command fails, or every command with WEBHOOK_ON = "always", see notify.py
+# WEBHOOK_FORMAT is "teams", "slack" or "generic", leave WEBHOOK_URL empty to send nothing
+WEBHOOK_URL = ""
+WEBHOOK_FORMAT = "generic"
+WEBHOOK_ON = "failure"
+WEBHOOK_TIMEOUT = 10.0  # seconds
+
+# Commands run before and after aurora-rt commands, by command name or "*" for every command, e.g.
+# {"balance": "C:/Scripts/backup.bat"}, with the run in AURORA_HOOK_* environment variables, see hooks.py
+HOOKS_BEFORE: dict[str, str] = {}
+HOOKS_AFTER: dict[str, str] = {}
+HOOK_TIMEOUT_S = 60.0  # A before hook that takes longer stops the command
+
+# Warn if a command has no progress reports, child process output or database writes for this many
+# seconds, and abort it with exit code 51 after ACTIVITY_KILL_S, 0 to disable, see watchdog.py
+ACTIVITY_WARNING_S = 300.0
+ACTIVITY_KILL_S = 0.0
+
+# Encrypted tokens and passwords, referred to as "credential:<name>" in any setting, see credentials.py
+CREDENTIALS_FILE = Path("C:/Modules/Credentials/credentials.json")
+
+# HTTP server for remote tool calls, use 0.0.0.0 as host to allow connections from other computers
+SERVER_HOST = "127.0.0.1"
+SERVER_PORT = 8765
+
+# Number of positions in the electrode rack, filled from 1 in the input file
+RACK_POSITIONS = 36
+
+# Press topology, press number: the rack position it takes cells from when rack positions are linked
+# to presses, e.g. press 2 takes cells from rack positions 4, 10, 16, ... (every len(PRESS_TO_RACK))
+PRESS_TO_RACK = {
+    1: 1,
+    2: 4,
+    3: 2,
+    4: 5,
+    5: 3,
+    6: 6,
+}
+# Presses that are out of service, no cells are assigned to them
+DISABLED_PRESSES: list[int] = []
+# Controllers of the press load cells, press number: "host:port" for Modbus TCP or "opc.tcp://host:port"
+# for OPC-UA, the holding register with the force, 1 or 2 registers (16 or 32-bit, signed), N per count,
+# the OPC-UA node with the force in N, and how long to read during a crimp, see press_force.py
+PRESS_FORCE_CONTROLLERS: dict[int, str] = {}
+PRESS_FORCE_NODE = "ns=2;s=Force"
+PRESS_FORCE_REGISTER = 0
+PRESS_FORCE_WORDS = 1
+PRESS_FORCE_SCALE_N = 0.1
+PRESS_FORCE_UNIT_ID = 1
+PRESS_FORCE_DURATION_S = 10.0
+PRESS_FORCE_POLL_S = 0.05
+
+# Robots that can be planned for from this installation, each a table of the settings that differ for
+# that robot, e.g. its database, rack positions and presses, selected with --robot or ROBOT
+ROBOT_PROFILES: dict[str, dict] = {}
+ROBOT = ""  # Robot profile used by default, empty to only use the settings above
+
+# Limits on the N:P ratio of every accepted cell after balancing, in addition to the per-cell limits
+# in the input file, 0 means no limit
+NP_RATIO_MINIMUM = 0.0
+NP_RATIO_MAXIMUM = 0.0
+
+# "required" to stage plans for approval, planning commands then only change a copy of the database
+# until `aurora-rt commit`, see staging.py, or "off" to write straight to the robot database
+PLAN_APPROVAL = "off"
+
+# Number of batches balanced at the same time, 1 balances them one after another
+BALANCE_WORKERS = 4
+
+# Command for sorting method 8, run once per batch with the cells as JSON on stdin, see balance_plugin.py
+BALANCE_PLUGIN = ""
+BALANCE_PLUGIN_TIMEOUT = 60.0  # seconds
+
+# Expected SHA-256 hashes of companion scripts run by the tools, e.g. the balancing plugin, by path,
+# checked before they are started, see integrity.py
+SCRIPT_HASHES: dict[str, str] = {}
+
+# Specific capacity of the active material in mAh/g by electrode type, e.g. {"NMC811": 195.0}, used for
+# electrodes the input file gives no balancing specific capacity for, see capacity_balance.py
+SPECIFIC_CAPACITIES: dict[str, float] = {}
+
+# Where the operator places each consumable, by type or item, e.g. {"Celgard 2325": "Separator magazine 1"},
+# and how many spares of each are added to the load list, see load_list.py
+CONSUMABLE_POSITIONS: dict[str, str] = {}
+LOAD_LIST_SPARES = 2
+# CSV or Excel file with the consumables and electrolytes in stock, checked against the plan, see shortfall.py
+STOCK_FILE = ""
+
+# Mass in mg of one piece of each consumable, by item and type, type or item, e.g.
+# {"Bottom casing CR2032": 1050.0, "Celgard 2325": 1.8, "Spring": 310.0}, the electrolyte density and
+# the largest deviation allowed between the weighed and expected mass of a cell, see verify_mass.py
+COMPONENT_MASSES_MG: dict[str, float] = {}
+ELECTROLYTE_DENSITY_G_ML = 1.25
+CELL_MASS_TOLERANCE_MG = 1.0
+
+# Typical duration of each robot step in seconds by step name, for the run time estimate, see estimate.py.
+# "Press" is how long a cell stays in the press, the robot carries on with the next cell meanwhile.
+STEP_DURATIONS_S: dict[str, float] = {
+    "Bottom": 45.0,
+    "Spacer": 40.0,
+    "Anode": 60.0,
+    "Cathode": 60.0,
+    "Electrolyte": 50.0,
+    "Separator": 55.0,
+    "Spring": 40.0,
+    "Top": 45.0,
+    "Press": 180.0,
+    "Return": 40.0,
+}
+MIXING_STEP_DURATION_S = 90.0  # One electrolyte mixing step of the liquid handler, before assembly
+# Time the building closes, e.g. "18:00", to warn if the estimated end of a run is later, "" to not check
+RUN_END_TIME = ""
+
+# Cell chemistry presets by name, chosen per batch with the Chemistry Preset column of the input file
+# to fill in the N:P ratios, electrolyte and voltage limits left empty, see import_excel.py
+CHEMISTRY_PRESETS: dict[str, dict] = {}
+
+# Default multiplier for electrolyte volumes in the mixing calculation
+ELECTROLYTE_SAFETY_FACTOR = 1.1
+
+# Stack height for the target pressure, used to choose spacers if the casing has no Casing Stack
+# Height (mm) in the input file, 0 to not choose spacers, see stack.py
+STACK_TARGET_HEIGHT_MM = 0.0
+STACK_TOLERANCE_MM = 0.05
+
+# Liquid handler volumes, added to every vial that is dispensed from, see electrolyte_calculation.py
+ELECTROLYTE_DEAD_VOLUME_UL = 0.0  # Left in the vial, the needle cannot reach it
+ELECTROLYTE_PRIMING_VOLUME_UL = 0.0  # Drawn to prime the syringe and needle before dispensing
+ELECTROLYTE_MIN_DISPENSE_UL = 0.0  # Smallest volume that can be dispensed accurately
+ELECTROLYTE_RESOLUTION_UL = 0.0  # Volumes are rounded to a multiple of this, e.g. 0.5, 0 to not round
+ELECTROLYTE_VIAL_VOLUME_UL = 0.0  # Capacity of a vial if not given in the Electrolyte_Table, 0 to not check
+
+# Gravimetric calibration of the liquid handler syringes, see dispenser_calibration.py
+DISPENSER_SYRINGE = ""  # Syringe that dispenses into the cells, "" to dispense the volumes uncorrected
+DISPENSER_MIXING_SYRINGE = ""  # Syringe for the mixing steps, "" for the same as DISPENSER_SYRINGE
+DISPENSER_CALIBRATION_DENSITY_G_ML = 0.998  # Density of the calibration liquid, e.g. water
+DISPENSER_CALIBRATION_MAX_AGE_DAYS = 30  # Warn about calibrations older than this, 0 to not check
 
 # Current step definitions
 STEP_DEFINITION = {
@@ -118,3 +372,265 @@ STEP_DEFINITION_0_1 = {
         "Description": "Return completed cell to rack",
     },
 }
+
+
+CONFIG_FILENAME = "aurora.toml"
+# Settings that can be overridden, all other module level names are fixed
+CONFIGURABLE = (
+    "DATABASE_FILEPATH",
+    "DATABASE_PROFILES",
+    "DATABASE_BACKUP_DIR",
+    "AUTO_BACKUP_KEEP",
+    "HISTORY_FILEPATH",
+    "CELL_ID_FILEPATH",
+    "TIME_ZONE",
+    "INPUT_DIR",
+    "OUTPUT_DIR",
+    "IMAGE_DIR",
+    "LOG_DIR",
+    "LOG_KEEP_OUTPUT_RUNS",
+    "LOG_SHIP_URL",
+    "LOG_SHIP_INDEX",
+    "LOG_SHIP_LEVEL",
+    "LOG_SHIP_INTERVAL_S",
+    "LOG_SHIP_TIMEOUT",
+    "LOG_SHIP_SPOOL_RECORDS",
+    "STATUS_FILE",
+    "RESULT_FILE",
+    "JOB_DIR",
+    "AGENT_POLL_INTERVAL",
+    "STANDBY_FILE",
+    "STANDBY_INTERVAL",
+    "STANDBY_MIN_FREE_MB",
+    "PYTHON_ENV_DIR",
+    "PYTHON_REQUIREMENTS",
+    "DB_RETRY_ATTEMPTS",
+    "DB_RETRY_DELAY",
+    "DB_JOURNAL_MODE",
+    "ASSEMBLY_ORDER",
+    "CAMERA_PORT",
+    "JOB_PORT",
+    "BALANCE_PORT",
+    "BALANCE_BAUD_RATE",
+    "BALANCE_PROTOCOL",
+    "BALANCE_STABLE_READINGS",
+    "BALANCE_TOLERANCE_MG",
+    "BALANCE_TIMEOUT",
+    "CELL_ID_PATTERN",
+    "CELL_ID_PROJECT",
+    "LABEL_PRINTER",
+    "CYCLER_SAMPLE_DIR",
+    "WEBHOOK_URL",
+    "WEBHOOK_FORMAT",
+    "WEBHOOK_ON",
+    "WEBHOOK_TIMEOUT",
+    "HOOKS_BEFORE",
+    "HOOKS_AFTER",
+    "HOOK_TIMEOUT_S",
+    "ACTIVITY_WARNING_S",
+    "ACTIVITY_KILL_S",
+    "CREDENTIALS_FILE",
+    "SERVER_HOST",
+    "SERVER_PORT",
+    "RACK_POSITIONS",
+    "PRESS_TO_RACK",
+    "DISABLED_PRESSES",
+    "PRESS_FORCE_CONTROLLERS",
+    "PRESS_FORCE_NODE",
+    "PRESS_FORCE_REGISTER",
+    "PRESS_FORCE_WORDS",
+    "PRESS_FORCE_SCALE_N",
+    "PRESS_FORCE_UNIT_ID",
+    "PRESS_FORCE_DURATION_S",
+    "PRESS_FORCE_POLL_S",
+    "ROBOT_PROFILES",
+    "ROBOT",
+    "NP_RATIO_MINIMUM",
+    "NP_RATIO_MAXIMUM",
+    "PLAN_APPROVAL",
+    "BALANCE_WORKERS",
+    "BALANCE_PLUGIN",
+    "BALANCE_PLUGIN_TIMEOUT",
+    "SCRIPT_HASHES",
+    "SPECIFIC_CAPACITIES",
+    "CONSUMABLE_POSITIONS",
+    "LOAD_LIST_SPARES",
+    "STOCK_FILE",
+    "COMPONENT_MASSES_MG",
+    "ELECTROLYTE_DENSITY_G_ML",
+    "CELL_MASS_TOLERANCE_MG",
+    "STEP_DURATIONS_S",
+    "MIXING_STEP_DURATION_S",
+    "RUN_END_TIME",
+    "CHEMISTRY_PRESETS",
+    "ELECTROLYTE_SAFETY_FACTOR",
+    "ELECTROLYTE_DEAD_VOLUME_UL",
+    "ELECTROLYTE_PRIMING_VOLUME_UL",
+    "ELECTROLYTE_MIN_DISPENSE_UL",
+    "ELECTROLYTE_RESOLUTION_UL",
+    "ELECTROLYTE_VIAL_VOLUME_UL",
+    "DISPENSER_SYRINGE",
+    "DISPENSER_MIXING_SYRINGE",
+    "DISPENSER_CALIBRATION_DENSITY_G_ML",
+    "DISPENSER_CALIBRATION_MAX_AGE_DAYS",
+    "STACK_TARGET_HEIGHT_MM",
+    "STACK_TOLERANCE_MM",
+)
+
+
+def config_files() -> list[Path]:
+    """Get the possible config file locations, in order of increasing priority."""
+    files = [Path(sys.executable).parent / CONFIG_FILENAME]
+    if "APPDATA" in os.environ:
+        files.append(Path(os.environ["APPDATA"]) / "aurora-robot-tools" / CONFIG_FILENAME)
+    if "AURORA_CONFIG" in os.environ:
+        files.append(Path(os.environ["AURORA_CONFIG"]))
+    return files
+
+
+def convert_setting(name: str, value: object) -> object:
+    """Convert a value from a config file or environment variable to the type of the default."""
+    default = globals()[name]
+    if isinstance(default, Path):
+        return Path(os.path.expandvars(str(value)))
+    if isinstance(default, dict):
+        if isinstance(value, str):
+            # From an environment variable
+            try:
+                value = json.loads(value)
+            except json.JSONDecodeError as e:
+                msg = f"AURORA_{name} must be a JSON object: {e}"
+                raise ConfigError(msg) from e
+            if not isinstance(value, dict):
+                msg = f"AURORA_{name} must be a JSON object, got {json.dumps(value)}."
+                raise ConfigError(msg)
+        if not isinstance(value, dict):
+            msg = f"{name} must be a table in the config file."
+            raise ConfigError(msg)
+        if name == "DATABASE_PROFILES":
+            return {str(k): Path(os.path.expandvars(str(v))) for k, v in value.items()}
+        if name == "CHEMISTRY_PRESETS":
+            if not all(isinstance(v, dict) for v in value.values()):
+                msg = f"Each preset in {name} must be a table in the config file."
+                raise ConfigError(msg)
+            return {str(k): dict(v) for k, v in value.items()}
+        if name == "ROBOT_PROFILES":
+            return {str(robot): convert_profile(str(robot), profile) for robot, profile in value.items()}
+        if name == "PRESS_FORCE_CONTROLLERS":
+            return {int(k): str(v) for k, v in value.items()}
+        if name in ("SCRIPT_HASHES", "CONSUMABLE_POSITIONS", "HOOKS_BEFORE", "HOOKS_AFTER"):
+            return {str(k): str(v) for k, v in value.items()}
+        if name in ("SPECIFIC_CAPACITIES", "STEP_DURATIONS_S", "COMPONENT_MASSES_MG"):
+            return {str(k): float(v) for k, v in value.items()}
+        return {int(k): int(v) for k, v in value.items()}
+    if isinstance(default, list):
+        if isinstance(value, str):
+            value = [v for v in value.split(",") if v.strip()]
+        return [int(v) for v in value]
+    return type(default)(value)
+
+
+def convert_profile(robot: str, profile: object) -> dict[str, object]:
+    """Convert the settings of a robot profile, by setting name."""
+    if not isinstance(profile, dict):
+        msg = f"Robot profile '{robot}' must be a table in the config file."
+        raise ConfigError(msg)
+    settings = {}
+    for key, value in profile.items():
+        name = key.upper()
+        if name not in CONFIGURABLE or name in ("ROBOT", "ROBOT_PROFILES"):
+            msg = f"Unknown setting '{key}' in robot profile '{robot}'."
+            raise ConfigError(msg)
+        settings[name] = convert_setting(name, value)
+    return settings
+
+
+def apply_robot_profile(robot: str) -> None:
+    """Override the settings with those of a robot profile.
+
+    Modules read the settings when they are imported, so this must be called before the tools are
+    imported, as the command line does for --robot.
+    """
+    if robot not in ROBOT_PROFILES:
+        msg = f"Unknown robot '{robot}', must be one of {', '.join(ROBOT_PROFILES) or 'none'}."
+        raise ConfigError(msg)
+    globals().update(ROBOT_PROFILES[robot])
+    globals()["ROBOT"] = robot
+    resolve_credentials()
+
+
+def resolve_credentials() -> None:
+    """Replace the settings that refer to a stored credential with its value.
+
+    A credential that can not be read leaves the setting empty, so the integration is off, and the
+    error in credential_errors, which commands log as a warning. Otherwise no command could run,
+    including `aurora-rt credentials set` to store it.
+    """
+    for name in CONFIGURABLE:
+        value = globals()[name]
+        if isinstance(value, str) and value.startswith("credential:"):
+            from aurora_robot_tools.credentials import resolve
+
+            try:
+                globals()[name] = resolve(name, value, CREDENTIALS_FILE)
+                credential_errors.pop(name, None)
+            except ConfigError as e:
+                globals()[name] = ""
+                credential_errors[name] = str(e)
+
+
+def load_config() -> None:
+    """Override the default settings with config files and environment variables."""
+    for path in config_files():
+        if not path.is_file():
+            continue
+        with path.open("rb") as f:
+            config = tomllib.load(f)
+        for key, value in config.items():
+            name = key.upper()
+            if name not in CONFIGURABLE:
+                msg = f"Unknown setting '{key}' in {path}."
+                raise ConfigError(msg)
+            globals()[name] = convert_setting(name, value)
+    for name in CONFIGURABLE:
+        if f"AURORA_{name}" in os.environ:
+            globals()[name] = convert_setting(name, os.environ[f"AURORA_{name}"])
+    # Expand environment variables in the defaults as well
+    for name in CONFIGURABLE:
+        if isinstance(globals()[name], Path):
+            globals()[name] = convert_setting(name, globals()[name])
+    if ROBOT:
+        apply_robot_profile(ROBOT)
+    resolve_credentials()
+
+
+def reload_config(robot: str = "") -> dict[str, tuple[object, object]]:
+    """Read the defaults, config files and environment variables again.
+
+    If the new config is invalid, the settings are not changed and the error is raised.
+
+    Args:
+        robot: Robot profile to apply afterwards, e.g. the one given with --robot
+
+    Returns:
+        The old and new value of every setting that changed, by name
+
+    """
+    current = {name: globals()[name] for name in CONFIGURABLE}
+    globals().update(copy.deepcopy(DEFAULTS))
+    try:
+        load_config()
+        if robot:
+            apply_robot_profile(robot)
+    except Exception:
+        globals().update(current)
+        raise
+    return {name: (value, globals()[name]) for name, value in current.items() if globals()[name] != value}
+
+
+# Settings whose credential could not be read, with the error, see resolve_credentials()
+credential_errors: dict[str, str] = {}
+
+# Before any config is applied, for reload_config()
+DEFAULTS = copy.deepcopy({name: globals()[name] for name in CONFIGURABLE})
+load_config()
@@ -0,0 +1,105 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Reload the config in the services without restarting them.
+
+Restarting `aurora-rt serve`, `agent` or `listen` is disruptive while AutoSuite is in the middle of
+a workflow, so the services check the config files before every job, and reload them if one was
+changed, added or removed. Jobs never see a config that changes while they run.
+
+The new settings replace the old ones in every module of the tools that has already been imported,
+see propagate(). Only module attributes are replaced, not the defaults of function arguments, so the
+functions the jobs run look the settings up when they are called, e.g. a minimum of None means
+NP_RATIO_MINIMUM. A new press layout, DISABLED_PRESSES, N:P ratio limits, electrolyte dead volume or
+BALANCE_WORKERS are then used by the next job. If the service was started without --db or
+--db-profile, it also follows a new DATABASE_FILEPATH, unless it is the production database and the
+service was started without --allow-production. The robot profile given with --robot is applied
+again after reloading.
+
+If the new config is invalid, the error is logged and the service keeps the old settings until the
+file is fixed. Some settings are only read when a service starts and still need a restart, a
+warning is logged when they change.
+
+Usage:
+    Used by the services, edit aurora.toml while they run.
+"""
+
+import logging
+import sys
+from pathlib import Path
+
+from aurora_robot_tools import config, production
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+# Settings that only take effect when a service is restarted
+RESTART_SETTINGS = ("SERVER_HOST", "SERVER_PORT", "JOB_PORT", "JOB_DIR", "AGENT_POLL_INTERVAL", "LOG_DIR")
+
+robot = ""  # Robot profile given with --robot, set by the command line, see cli.py
+
+
+def modification_times() -> dict[Path, int | None]:
+    """Modification time of every config file location, None if there is no file."""
+    return {path: path.stat().st_mtime_ns if path.is_file() else None for path in config.config_files()}
+
+
+def propagate(changes: dict[str, tuple[object, object]]) -> None:
+    """Replace the old values of changed settings in the modules that imported them from the config."""
+    for name, module in list(sys.modules.items()):
+        if not name.startswith("aurora_robot_tools.") or module is config:
+            continue
+        for setting, (old, new) in changes.items():
+            if getattr(module, setting, None) is old:
+                setattr(module, setting, new)
+
+
+def switch_database(db_path: Path, new_db_path: Path) -> Path:
+    """The new database, or the old one if it is the production database and that is not allowed."""
+    if not production.allowed:
+        try:
+            production.refuse_production(new_db_path)
+        except ConfigError as e:
+            logger.warning("Not switching to the new DATABASE_FILEPATH, keeping %s: %s", db_path, e)
+            return db_path
+    logger.info("Switching from %s to %s", db_path, new_db_path)
+    return new_db_path
+
+
+class ConfigWatcher:
+    """Reload the config when a config file changes, see reload_if_changed()."""
+
+    def __init__(self) -> None:
+        """Start from the config files as they are now."""
+        self.mtimes = modification_times()
+
+    def reload_if_changed(self, db_path: Path) -> Path:
+        """Reload the config if a config file changed since the last check.
+
+        Args:
+            db_path: Database the service works on
+
+        Returns:
+            The database to work on, the new DATABASE_FILEPATH if db_path was the old one
+
+        """
+        mtimes = modification_times()
+        if mtimes == self.mtimes:
+            return db_path
+        self.mtimes = mtimes
+        try:
+            changes = config.reload_config(robot)
+        except Exception:
+            logger.exception("Cannot reload the config, keeping the old settings.")
+            return db_path
+        if not changes:
+            logger.info("Config files changed, no settings changed.")
+            return db_path
+        propagate(changes)
+        for name, (old, new) in changes.items():
+            logger.info("Reloaded %s: %s -> %s", name, old, new)
+        restart = [name for name in changes if name in RESTART_SETTINGS]
+        if restart:
+            logger.warning("Restart the service for %s to take effect.", ", ".join(restart))
+        if "DATABASE_FILEPATH" in changes and Path(db_path) == changes["DATABASE_FILEPATH"][0]:
+            return switch_database(db_path, config.DATABASE_FILEPATH)
+        return db_path
@@ -0,0 +1,188 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Keep the tokens and passwords of the integrations out of the config files.
+
+Webhook URLs and log server URLs contain tokens or passwords, and the config files on a shared robot
+PC can be read by everyone who uses it. Instead, a credential is stored encrypted with the Windows
+data protection API (DPAPI) in CREDENTIALS_FILE, and the config refers to it by name:
+
+    webhook_url = "credential:teams-webhook"
+
+When the config is loaded, every setting that starts with "credential:" is replaced by the decrypted
+value. A credential stored for the user can only be decrypted by the same Windows user on the same
+PC, one stored with `--machine` by any user of the PC, e.g. when AutoSuite runs as another user.
+The file only holds the encrypted values, copying it to another PC does not reveal them.
+
+DPAPI is only available on Windows, on other systems give the value in an environment variable
+instead, e.g. AURORA_WEBHOOK_URL.
+
+Usage:
+    `aurora-rt credentials set teams-webhook` asks for the value without showing it,
+    `aurora-rt credentials list` shows the names, `aurora-rt credentials remove teams-webhook`.
+"""
+
+import base64
+import ctypes
+import json
+import logging
+import re
+import sys
+from datetime import datetime, timezone
+from pathlib import Path
+
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+
+logger = logging.getLogger(__name__)
+
+PREFIX = "credential:"
+NAME = re.compile(r"^[A-Za-z0-9_.-]+$")
+# Mixed into the encryption, so other programs using DPAPI for the same user can not read the values
+ENTROPY = b"aurora-robot-tools"
+CRYPTPROTECT_UI_FORBIDDEN = 0x1
+CRYPTPROTECT_LOCAL_MACHINE = 0x4
+
+
+class DataBlob(ctypes.Structure):
+    """DATA_BLOB of the Windows crypto API."""
+
+    _fields_ = [("cbData", ctypes.c_uint32), ("pbData", ctypes.POINTER(ctypes.c_char))]
+
+
+def blob(data: bytes) -> tuple[DataBlob, ctypes.Array]:
+    """Wrap bytes in a DATA_BLOB, the buffer must be kept alive while the blob is used."""
+    buffer = ctypes.create_string_buffer(data, len(data))
+    return DataBlob(len(data), ctypes.cast(buffer, ctypes.POINTER(ctypes.c_char))), buffer
+
+
+def dpapi(data: bytes, encrypt: bool, machine: bool = False) -> bytes:
+    """Encrypt or decrypt with DPAPI, for the current user or the whole PC."""
+    if sys.platform != "win32":
+        msg = "Credentials are encrypted with Windows DPAPI, give the value in an environment variable instead."
+        raise EnvironmentProblemError(msg)
+    crypt32 = ctypes.windll.crypt32
+    # The buffers are kept until the blobs are no longer used
+    data_in, _data_buffer = blob(data)
+    entropy, _entropy_buffer = blob(ENTROPY)
+    data_out = DataBlob()
+    flags = CRYPTPROTECT_UI_FORBIDDEN | (CRYPTPROTECT_LOCAL_MACHINE if machine and encrypt else 0)
+    if encrypt:
+        ok = crypt32.CryptProtectData(
+            ctypes.byref(data_in),
+            ctypes.c_wchar_p("aurora-robot-tools"),
+            ctypes.byref(entropy),
+            None,
+            None,
+            flags,
+            ctypes.byref(data_out),
+        )
+    else:
+        ok = crypt32.CryptUnprotectData(
+            ctypes.byref(data_in),
+            None,
+            ctypes.byref(entropy),
+            None,
+            None,
+            flags,
+            ctypes.byref(data_out),
+        )
+    if not ok:
+        raise ctypes.WinError()
+    try:
+        return ctypes.string_at(data_out.pbData, data_out.cbData)
+    finally:
+        ctypes.windll.kernel32.LocalFree(data_out.pbData)
+
+
+def read_store(path: Path) -> dict[str, dict]:
+    """The stored credentials by name, empty if there is no file yet."""
+    path = Path(path)
+    if not path.is_file():
+        return {}
+    try:
+        return json.loads(path.read_text(encoding="utf-8"))
+    except (OSError, json.JSONDecodeError) as e:
+        msg = f"Could not read the credentials file {path}: {e}"
+        raise ConfigError(msg) from e
+
+
+def write_store(path: Path, store: dict[str, dict]) -> None:
+    """Write the credentials file."""
+    path = Path(path)
+    path.parent.mkdir(parents=True, exist_ok=True)
+    path.write_text(json.dumps(store, indent=2) + "\n", encoding="utf-8")
+
+
+def check_name(name: str) -> None:
+    """Check a credential name can be used in the config."""
+    if not NAME.match(name):
+        msg = f"Credential names may only contain letters, digits, '_', '.' and '-', got '{name}'."
+        raise ConfigError(msg)
+
+
+def get_credential(name: str, path: Path) -> str:
+    """Decrypt a stored credential."""
+    store = read_store(path)
+    if name not in store:
+        msg = f"Credential '{name}' not found in {path}, store it with `aurora-rt credentials set {name}`."
+        raise ConfigError(msg)
+    try:
+        value = dpapi(base64.b64decode(store[name]["data"]), encrypt=False)
+    except OSError as e:
+        msg = (
+            f"Could not decrypt credential '{name}', it can only be read by the Windows user on the PC it was "
+            f"stored on: {e}"
+        )
+        raise ConfigError(msg) from e
+    return value.decode()
+
+
+def resolve(name: str, value: str, path: Path) -> str:
+    """Replace a "credential:<name>" config value with the stored credential."""
+    if not value.startswith(PREFIX):
+        return value
+    try:
+        return get_credential(value.removeprefix(PREFIX), path)
+    except EnvironmentProblemError as e:
+        msg = f"{name} refers to a credential, but {e}"
+        raise ConfigError(msg) from e
+
+
+def set_credential(name: str, value: str, path: Path, machine: bool = False) -> None:
+    """Encrypt and store a credential, replacing one with the same name."""
+    check_name(name)
+    if not value:
+        msg = "The credential is empty."
+        raise ConfigError(msg)
+    store = read_store(path)
+    store[name] = {
+        "scope": "machine" if machine else "user",
+        "data": base64.b64encode(dpapi(value.encode(), encrypt=True, machine=machine)).decode(),
+        "stored": datetime.now(timezone.utc).isoformat(timespec="seconds"),
+    }
+    write_store(path, store)
+    logger.info("Stored credential '%s' in %s, use \"%s%s\" in the config.", name, path, PREFIX, name)
+
+
+def remove_credential(name: str, path: Path) -> None:
+    """Remove a stored credential."""
+    store = read_store(path)
+    if name not in store:
+        msg = f"Credential '{name}' not found in {path}."
+        raise ConfigError(msg)
+    del store[name]
+    write_store(path, store)
+    logger.info("Removed credential '%s' from %s.", name, path)
+
+
+def list_credentials(path: Path) -> list[str]:
+    """Log the names of the stored credentials, never their values."""
+    store = read_store(path)
+    if not store:
+        logger.info("No credentials stored in %s.", path)
+        return []
+    lines = [
+        f"{name:<24} {entry.get('scope', 'user'):<8} stored {entry.get('stored', '?')}"
+        for name, entry in sorted(store.items())
+    ]
+    logger.info("Credentials in %s:\n%s", path, "\n".join(lines))
+    return sorted(store)
@@ -0,0 +1,151 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Export the metadata of the planned cells for the Aurora cycler.
+
+After balancing, the cycler needs the active mass and theoretical capacity of each cell to set the
+C-rates of its protocol. One record per planned cell is written with the same column names as the
+JSON file from `aurora-rt output`:
+    - Sample ID, Cell ID and Run ID
+    - Anode Type, Cathode Type and the active material masses
+    - C-rate Definition Capacity (mAh): from the cathode C-rate definition specific capacity, or
+      the areal capacity if no specific capacity is given
+    - N:P Ratio, Electrolyte Name and Electrolyte Amount (uL)
+    - Minimum Voltage (V) and Maximum Voltage (V) if given, e.g. by a chemistry preset
+    - Assembly Date: the date of the export, cells are assembled the day they are planned
+
+The file is written to the output folder as JSON or CSV, and copied to CYCLER_SAMPLE_DIR from the
+config with `--push`, e.g. the network share "//cycler-pc/Samples/" the cycler reads samples from.
+
+Usage:
+    Called with `aurora-rt export-cycler`, after balancing.
+"""
+
+import logging
+import shutil
+from datetime import datetime
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+import pytz
+
+from aurora_robot_tools.config import CYCLER_SAMPLE_DIR, DATABASE_FILEPATH, OUTPUT_DIR, TIME_ZONE
+from aurora_robot_tools.database import get_setting, read_query
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+
+logger = logging.getLogger(__name__)
+
+FORMATS = ("json", "csv")
+CYCLER_COLUMNS = [
+    "Sample ID",
+    "Cell ID",
+    "Run ID",
+    "Anode Type",
+    "Anode Active Material Mass (mg)",
+    "Cathode Type",
+    "Cathode Active Material Mass (mg)",
+    "C-rate Definition Capacity (mAh)",
+    "N:P Ratio",
+    "Electrolyte Name",
+    "Electrolyte Amount (uL)",
+    "Minimum Voltage (V)",
+    "Maximum Voltage (V)",
+    "Assembly Date",
+    "Mass Check",
+    "Crimp Force (N)",
+]
+
+
+def c_rate_capacity(df: pd.DataFrame) -> pd.Series:
+    """Theoretical capacity of each cell used to define the C-rate, from the cathode."""
+    specific = df.get("Cathode C-rate Definition Specific Capacity (mAh/g)", pd.Series(np.nan, index=df.index))
+    areal = df.get("Cathode C-rate Definition Areal Capacity (mAh/cm2)", pd.Series(np.nan, index=df.index))
+    area_cm2 = np.pi * (df["Cathode Diameter (mm)"] / 20) ** 2
+    from_mass = 1e-3 * df["Cathode Active Material Mass (mg)"] * specific.replace(0, np.nan)
+    return from_mass.fillna(areal.replace(0, np.nan) * area_cm2)
+
+
+def build_metadata(df: pd.DataFrame, run_id: str) -> pd.DataFrame:
+    """Get the metadata of the planned cells in the cycler format."""
+    df = df.copy()
+    df["Run ID"] = run_id
+    df["C-rate Definition Capacity (mAh)"] = c_rate_capacity(df)
+    df["Assembly Date"] = datetime.now(pytz.timezone(TIME_ZONE)).strftime("%Y-%m-%d")
+    columns = [col for col in CYCLER_COLUMNS if col in df.columns]
+    missing = df["C-rate Definition Capacity (mAh)"].isna()
+    if missing.any():
+        logger.warning(
+            "No C-rate definition capacity for cells %s, check the cathode capacities in the input file.",
+            ", ".join(df.loc[missing, "Sample ID"].astype(str)),
+        )
+    if "Mass Check" in df.columns:
+        failed = df["Mass Check"].notna() & (df["Mass Check"] != "ok")
+        if failed.any():
+            logger.warning(
+                "Cells %s failed the mass check, see aurora-rt verify-mass.",
+                ", ".join(df.loc[failed, "Sample ID"].astype(str)),
+            )
+    return df[columns].reset_index(drop=True)
+
+
+def push(filepath: Path, sample_dir: str = CYCLER_SAMPLE_DIR) -> Path:
+    """Copy the metadata file to the folder the cycler reads samples from."""
+    if not sample_dir:
+        msg = "Set CYCLER_SAMPLE_DIR in the config to push to the cycler."
+        raise ConfigError(msg)
+    target = Path(sample_dir) / filepath.name
+    try:
+        shutil.copyfile(filepath, target)
+    except OSError as e:
+        msg = f"Could not copy {filepath.name} to {sample_dir}: {e}"
+        raise EnvironmentProblemError(msg) from e
+    return target
+
+
+def main(
+    output_format: str = "json",
+    push_to_cycler: bool = False,
+    db_path: Path = DATABASE_FILEPATH,
+    output_dir: Path = OUTPUT_DIR,
+) -> Path | None:
+    """Write the cycler metadata of the planned cells.
+
+    Args:
+        output_format: "json" or "csv"
+        push_to_cycler: Also copy the file to CYCLER_SAMPLE_DIR
+        db_path: Path to the robot database
+        output_dir: Folder to write the file to
+
+    Returns:
+        Path of the file written, None if no cells are planned
+
+    """
+    if output_format not in FORMATS:
+        msg = f"Format must be one of {', '.join(FORMATS)}, got '{output_format}'."
+        raise ConfigError(msg)
+    df = read_query(db_path, "SELECT * FROM Cell_Assembly_Table WHERE `Cell Number` > 0 ORDER BY `Cell Number`")
+    if df.empty:
+        logger.info("No cells assigned for assembly, run capacity balancing first. No metadata exported.")
+        return None
+    run_id = get_setting(db_path, "Base Sample ID") or "cells"
+    df_metadata = build_metadata(df, run_id)
+
+    output_dir = Path(output_dir)
+    output_dir.mkdir(parents=True, exist_ok=True)
+    filepath = output_dir / f"{run_id}_cycler.{output_format}"
+    if output_format == "json":
+        df_metadata.to_json(filepath, orient="records", indent=4)
+    else:
+        df_metadata.to_csv(filepath, index=False)
+    logger.info("Exported cycler metadata for %d cells to %s", len(df_metadata), filepath)
+    if push_to_cycler:
+        target = push(filepath)
+        logger.info("Copied cycler metadata to %s", target)
+    return filepath
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("export-cycler")
+    main()
@@ -0,0 +1,332 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Read and write tables in the chemspeedDB database.
+
+All of the tools read tables from the database into dataframes, manipulate them, and write the
+full tables back. These functions keep the connection handling in one place.
+
+Tables are written to temporary tables first and then swapped in within a single transaction, so
+AutoSuite never sees a partially written table, and a failure while writing several tables leaves
+all of them untouched.
+
+If the database is locked by another program, e.g. AutoSuite writing at the same time, operations
+are retried with an increasing delay before giving up.
+
+The first time a command opens the database, it is switched to DB_JOURNAL_MODE, by default WAL
+(write-ahead logging). In WAL mode readers, e.g. the monitoring dashboard, do not block writers and
+writers do not block readers, only two writers at the same time wait for each other. The mode is
+stored in the database file, so AutoSuite also uses it. Changes are written to the -wal file next to
+the database first, and moved into the database file (checkpointed) when the command exits, so the
+-wal file does not keep growing. WAL does not work on network drives, if the mode can not be set
+there a warning is logged and the database stays in its mode.
+
+Tables and columns added by later migrations are optional: on an older database, e.g. a robot PC
+that has updated the tools but not run `aurora-rt db migrate` yet, the features that need them are
+skipped with a warning instead of failing, see has_table() and has_column().
+
+Before the first write of a command, a snapshot of the database is saved to the Auto folder in the
+backup folder, see backup_database.py.
+
+In a dry run the changes that would be written are logged instead.
+"""
+
+import functools
+import logging
+import sqlite3
+import time
+from collections.abc import Callable
+from contextlib import closing
+from pathlib import Path
+from typing import ParamSpec, TypeVar
+
+import pandas as pd
+
+from aurora_robot_tools import chaos
+from aurora_robot_tools.config import DATABASE_FILEPATH, DB_JOURNAL_MODE, DB_RETRY_ATTEMPTS, DB_RETRY_DELAY
+from aurora_robot_tools.errors import ConfigError, DatabaseError
+from aurora_robot_tools.migrations import LATEST_VERSION, get_version
+
+logger = logging.getLogger(__name__)
+
+MAX_LOGGED_CHANGES = 50
+
+# Number of Cell_Assembly_Table rows changed in this process, recorded in the run history
+cells_changed = 0
+
+# Number of times an operation was retried because the database was locked, see metrics.py
+lock_retries = 0
+
+# Databases already backed up by this process, only the state before the first write is kept
+backed_up: set[Path] = set()
+
+# Optional tables and columns this process found missing, each is only warned about once
+missing_schema: set[tuple[Path, str, str]] = set()
+
+# Journal modes for DB_JOURNAL_MODE, empty to leave the database in the mode it has
+JOURNAL_MODES = ("wal", "delete", "")
+
+# Databases opened by this process with the journal mode checked, checkpointed when the command exits
+journal_checked: set[Path] = set()
+CHECKPOINT_TIMEOUT = 1.0  # seconds to wait for readers when checkpointing
+
+P = ParamSpec("P")
+R = TypeVar("R")
+
+
+def is_locked_error(error: Exception) -> bool:
+    """Check if an error is caused by the database being locked."""
+    return isinstance(error, (sqlite3.OperationalError, pd.errors.DatabaseError)) and "locked" in str(error)
+
+
+def retry_if_locked(func: Callable[P, R]) -> Callable[P, R]:
+    """Retry a database operation with exponential backoff if the database is locked."""
+
+    @functools.wraps(func)
+    def wrapper(*args: P.args, **kwargs: P.kwargs) -> R:
+        global lock_retries  # noqa: PLW0603
+        delay = DB_RETRY_DELAY
+        for attempt in range(1, DB_RETRY_ATTEMPTS):
+            try:
+                return func(*args, **kwargs)
+            except (sqlite3.OperationalError, pd.errors.DatabaseError) as e:
+                if not is_locked_error(e):
+                    raise
+                logger.warning("Database is locked, retrying in %.1f s (attempt %d)", delay, attempt)
+                lock_retries += 1
+                time.sleep(delay)
+                delay *= 2
+        # Last attempt, errors are raised to the caller
+        return func(*args, **kwargs)
+
+    return wrapper
+
+
+def connect(db_path: Path = DATABASE_FILEPATH, create: bool = False) -> sqlite3.Connection:
+    """Open a connection to the database, raise if the file does not exist unless create is set."""
+    db_path = Path(db_path)
+    if not create and not db_path.exists():
+        msg = f"Database {db_path} does not exist."
+        raise DatabaseError(msg)
+    chaos.on_connect()
+    conn = sqlite3.connect(db_path)
+    set_journal_mode(conn, db_path)
+    return conn
+
+
+def set_journal_mode(conn: sqlite3.Connection, db_path: Path) -> None:
+    """Switch the database to DB_JOURNAL_MODE, once per process."""
+    mode = DB_JOURNAL_MODE.lower()
+    if mode not in JOURNAL_MODES:
+        msg = f"DB_JOURNAL_MODE must be one of {', '.join(repr(m) for m in JOURNAL_MODES)}, got '{DB_JOURNAL_MODE}'."
+        raise ConfigError(msg)
+    key = Path(db_path).resolve()
+    if not mode or key in journal_checked:
+        return
+    current = conn.execute("PRAGMA journal_mode").fetchone()[0]
+    if current != mode:
+        try:
+            current = conn.execute(f"PRAGMA journal_mode = {mode}").fetchone()[0]
+        except sqlite3.OperationalError as e:
+            if not is_locked_error(e):
+                raise
+            # Another program is writing, tried again on the next connection
+            logger.debug("Could not switch %s to %s mode yet: %s", db_path, mode, e)
+            return
+        if current == mode:
+            logger.info("Switched %s to %s journal mode.", db_path, mode.upper())
+        else:
+            logger.warning(
+                "Could not switch %s to %s journal mode, e.g. because it is on a network drive, it stays in %s mode.",
+                db_path,
+                mode.upper(),
+                current.upper(),
+            )
+    journal_checked.add(key)
+
+
+def checkpoint(db_path: Path = DATABASE_FILEPATH) -> None:
+    """Move the changes in the write-ahead log into the database file and empty the log."""
+    try:
+        with closing(sqlite3.connect(db_path, timeout=CHECKPOINT_TIMEOUT)) as conn:
+            if conn.execute("PRAGMA journal_mode").fetchone()[0] != "wal":
+                return
+            busy, log_pages, moved_pages = conn.execute("PRAGMA wal_checkpoint(TRUNCATE)").fetchone()
+    except sqlite3.Error as e:
+        logger.warning("Could not checkpoint %s: %s", db_path, e)
+        return
+    if busy:
+        # Still being read, the rest is moved by the next checkpoint
+        logger.debug("Checkpointed %d of %d pages of %s, it is being read", moved_pages, log_pages, db_path)
+
+
+def checkpoint_all() -> None:
+    """Checkpoint every database this process opened, when the command exits."""
+    for db_path in sorted(journal_checked):
+        if db_path.exists():
+            checkpoint(db_path)
+
+
+def warn_missing(db_path: Path, table: str, column: str, skipped: str) -> None:
+    """Warn once that an optional table or column is missing and what is skipped because of it."""
+    key = (Path(db_path).resolve(), table, column)
+    if not skipped or key in missing_schema:
+        return
+    missing_schema.add(key)
+    what = f"column {column} in {table}" if column else table
+    if get_version(db_path) < LATEST_VERSION:
+        hint = "Run `aurora-rt db migrate` to add it."
+    else:
+        # Migrating does not add it again, the table was replaced, e.g. by an older import-excel
+        hint = "The database is already migrated, import the batch again or restore a backup to add it."
+    logger.warning("%s has no %s, %s. %s", db_path, what, skipped, hint)
+
+
+def has_table(db_path: Path, table: str, skipped: str = "") -> bool:
+    """Check the database has a table, warn once about what is skipped if not."""
+    with connect(db_path) as conn:
+        found = conn.execute("SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?", (table,)).fetchone()
+    if found is None:
+        warn_missing(db_path, table, "", skipped)
+    return found is not None
+
+
+def has_column(db_path: Path, table: str, column: str, skipped: str = "") -> bool:
+    """Check a table of the database has a column, warn once about what is skipped if not."""
+    with connect(db_path) as conn:
+        found = column in {row[1] for row in conn.execute(f"PRAGMA table_info(`{table}`)")}
+    if not found:
+        warn_missing(db_path, table, column, skipped)
+    return found
+
+
+@retry_if_locked
+def read_tables(db_path: Path, *tables: str) -> tuple[pd.DataFrame, ...]:
+    """Read full tables from the database as dataframes."""
+    with connect(db_path) as conn:
+        return tuple(pd.read_sql(f"SELECT * FROM `{table}`", conn) for table in tables)  # noqa: S608
+
+
+@retry_if_locked
+def read_query(db_path: Path, query: str) -> pd.DataFrame:
+    """Read the result of an SQL query as a dataframe."""
+    with connect(db_path) as conn:
+        return pd.read_sql(query, conn)
+
+
+@retry_if_locked
+def get_setting(db_path: Path, key: str) -> str | None:
+    """Get a value from the Settings_Table, None if the key or table is missing."""
+    with connect(db_path) as conn:
+        try:
+            result = conn.execute("SELECT `value` FROM Settings_Table WHERE `key` = ?", (key,)).fetchone()
+        except sqlite3.OperationalError as e:
+            if is_locked_error(e):
+                raise
+            return None
+    return result[0] if result is not None else None
+
+
+@retry_if_locked
+def write_tables(
+    db_path: Path,
+    tables: dict[str, pd.DataFrame],
+    dtypes: dict[str, dict[str, str]] | None = None,
+    create: bool = False,
+    dry_run: bool = False,
+) -> None:
+    """Replace tables in the database with dataframes in one transaction.
+
+    Args:
+        db_path: Path to the database.
+        tables: Dict of table name to dataframe to write.
+        dtypes: Optional dict of table name to SQL column types, passed to pandas.to_sql.
+        create: Create the database if it does not exist.
+        dry_run: Log the changes instead of writing them.
+
+    """
+    if dry_run:
+        for table, df in tables.items():
+            log_changes(db_path, table, df)
+        logger.info("Dry run, database %s not modified.", db_path)
+        return
+    global cells_changed  # noqa: PLW0603
+    if Path(db_path) not in backed_up and Path(db_path).exists():
+        from aurora_robot_tools.backup_database import auto_backup
+
+        auto_backup(db_path)
+        backed_up.add(Path(db_path))
+    dtypes = dtypes or {}
+    n_cells_changed = 0
+    if "Cell_Assembly_Table" in tables:
+        n_cells_changed = count_cells_changed(db_path, tables["Cell_Assembly_Table"])
+    with connect(db_path, create=create) as conn:
+        # pandas commits after every to_sql, so write to temporary tables first
+        for table, df in tables.items():
+            df.to_sql(f"_new_{table}", conn, index=False, if_exists="replace", dtype=dtypes.get(table))
+        try:
+            conn.execute("BEGIN")
+            for table in tables:
+                conn.execute(f"DROP TABLE IF EXISTS `{table}`")
+                conn.execute(f"ALTER TABLE `_new_{table}` RENAME TO `{table}`")
+            conn.commit()
+            cells_changed += n_cells_changed
+        except sqlite3.Error:
+            conn.rollback()
+            for table in tables:
+                conn.execute(f"DROP TABLE IF EXISTS `_new_{table}`")
+            conn.commit()
+            raise
+
+
+def find_changed_rows(df_old: pd.DataFrame, df: pd.DataFrame) -> tuple[pd.DataFrame, list[str]]:
+    """Compare two tables with the same rows, return a mask of changed cells and the common columns."""
+    columns = [col for col in df.columns if col in df_old.columns]
+    new = df[columns].reset_index(drop=True)
+    old = df_old[columns].reset_index(drop=True)
+    return (new != old) & ~(new.isna() & old.isna()), columns
+
+
+def count_cells_changed(db_path: Path, df: pd.DataFrame) -> int:
+    """Count the rows of the Cell_Assembly_Table that would change, all rows if it is replaced."""
+    try:
+        (df_old,) = read_tables(db_path, "Cell_Assembly_Table")
+    except (DatabaseError, pd.errors.DatabaseError):
+        return len(df)
+    if len(df_old) != len(df):
+        return len(df)
+    changed, _columns = find_changed_rows(df_old, df)
+    return int(changed.any(axis=1).sum())
+
+
+def log_changes(db_path: Path, table: str, df: pd.DataFrame) -> None:
+    """Log the differences between a table in the database and a dataframe that would replace it."""
+    try:
+        (df_old,) = read_tables(db_path, table)
+    except (DatabaseError, pd.errors.DatabaseError):
+        logger.info("Would create %s with %d rows.", table, len(df))
+        return
+    added_columns = [col for col in df.columns if col not in df_old.columns]
+    removed_columns = [col for col in df_old.columns if col not in df.columns]
+    if added_columns:
+        logger.info("Would add columns to %s: %s", table, ", ".join(added_columns))
+    if removed_columns:
+        logger.info("Would remove columns from %s: %s", table, ", ".join(removed_columns))
+    if len(df_old) != len(df):
+        logger.info("Would replace %s, %d rows with %d rows.", table, len(df_old), len(df))
+        return
+
+    # Compare the common columns row by row
+    changed, columns = find_changed_rows(df_old, df)
+    new = df[columns].reset_index(drop=True)
+    old = df_old[columns].reset_index(drop=True)
+    changed_rows = changed.any(axis=1)
+    if not changed_rows.any():
+        logger.info("No changes to %s.", table)
+        return
+    logger.info("Would change %d rows in %s:", changed_rows.sum(), table)
+    for n_logged, i in enumerate(changed_rows[changed_rows].index):
+        if n_logged >= MAX_LOGGED_CHANGES:
+            logger.info("... and %d more rows.", changed_rows.sum() - MAX_LOGGED_CHANGES)
+            break
+        changes = ", ".join(f"{col}: {old.at[i, col]} -> {new.at[i, col]}" for col in columns if changed.at[i, col])
+        logger.info("  Row %d: %s", i + 1, changes)
@@ -0,0 +1,153 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Read numbers written with a decimal comma, e.g. from balance exports or ELN CSVs on German PCs.
+
+With a German locale a mass of 3.5 mg is written 3,5 and the CSV separator is a semicolon. Read as
+usual, 3,5 stays text or becomes 35, so every file that is imported is read with this module:
+    - CSV files are read with the separator guessed. If it is not a comma, the text of the cells is
+      checked for a decimal comma, e.g. 3,5 or 1.234,5, and the file is read with it. A file with a
+      comma separator always uses decimal points.
+    - In Excel files, numbers are stored independent of the locale, only numbers typed as text,
+      e.g. a pasted '3,5', are converted, in columns that only contain numbers.
+
+A value like 1,234 can be 1.234 or 1234, so it is read like the other numbers of the file. If there
+are only such values, the file is read with decimal points and a warning is logged. If a file has
+both decimal commas and decimal points, it is refused. `--decimal-comma` or `--decimal-point` skips the detection.
+
+Usage:
+    Used by the import commands, e.g. `aurora-rt --decimal-comma import-batch samples.csv ...`.
+"""
+
+import csv
+import io
+import logging
+import re
+from collections.abc import Iterable
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+decimal_comma: bool | None = None  # Set by --decimal-comma or --decimal-point, None to detect
+
+# A number with an optional decimal part and optional thousands separators
+NUMBER = re.compile(r"^[+-]?(\d+|\d{1,3}([.,]\d{3})+)([.,]\d+)?$")
+SEPARATORS = ",;\t"
+
+
+def uses_decimal_comma(text: str) -> bool | None:
+    """Whether a number uses a decimal comma, None if it can be read either way, e.g. 1,234."""
+    text = text.strip()
+    if "," in text and "." in text:
+        return text.rfind(",") > text.rfind(".")
+    separator = "," if "," in text else "."
+    parts = text.split(separator)
+    if len(parts) == 1 or (len(parts) == 2 and len(parts[1]) == 3):
+        return None
+    # One separator is decimal, several are thousands separators
+    return (separator == ",") == (len(parts) == 2)
+
+
+def detect_decimal_comma(values: Iterable[str], source: str) -> bool:
+    """Decide from the numbers in a file if it uses a decimal comma.
+
+    Raises:
+        ConfigError: if the file has both decimal commas and decimal points
+
+    """
+    if decimal_comma is not None:
+        return decimal_comma
+    comma, point, ambiguous = [], [], []
+    for value in values:
+        if not NUMBER.match(value.strip()):
+            continue
+        kind = uses_decimal_comma(value)
+        if kind is None:
+            if "," in value or "." in value:
+                ambiguous.append(value)
+            continue
+        (comma if kind else point).append(value)
+    if comma and point:
+        msg = (
+            f"{source} has numbers with decimal commas, e.g. {comma[0]}, and decimal points, e.g. {point[0]}, "
+            "give --decimal-comma or --decimal-point."
+        )
+        raise ConfigError(msg)
+    if comma:
+        logger.info("Reading %s with decimal commas, e.g. %s.", source, comma[0])
+        return True
+    if ambiguous and not point:
+        logger.warning(
+            "Reading %s with decimal points, %s could also be a decimal comma, give --decimal-comma if it is.",
+            source,
+            ambiguous[0],
+        )
+    return False
+
+
+def to_number(text: str, comma: bool) -> float:
+    """Convert a number written with a decimal comma or point."""
+    text = text.strip()
+    text = text.replace(".", "").replace(",", ".") if comma else text.replace(",", "")
+    return float(text)
+
+
+def read_csv(path: Path) -> pd.DataFrame:
+    """Read a CSV file with the separator guessed and the numbers in the decimal format it uses."""
+    path = Path(path)
+    text = path.read_text(encoding="utf-8-sig")
+    try:
+        separator = csv.Sniffer().sniff(text[:4096], delimiters=SEPARATORS).delimiter
+    except csv.Error:
+        separator = ","
+    comma = False
+    if separator != "," or decimal_comma:
+        cells = (cell for row in csv.reader(io.StringIO(text), delimiter=separator) for cell in row)
+        comma = detect_decimal_comma(cells, path.name)
+    if comma:
+        return pd.read_csv(io.StringIO(text), sep=separator, decimal=",", thousands=".")
+    return pd.read_csv(io.StringIO(text), sep=separator)
+
+
+def text_number_columns(df: pd.DataFrame, exclude: Iterable[str] = ()) -> dict[str, pd.Series]:
+    """Text of the columns that only contain numbers, some of them with a separator typed as text."""
+    columns = {}
+    for col in df.columns:
+        if col in exclude or df[col].dtype != object:
+            continue
+        values = df[col].dropna()
+        is_text = values.map(lambda value: isinstance(value, str))
+        texts = values[is_text].astype(str)
+        if texts.empty or not texts.map(lambda value: bool(NUMBER.match(value.strip()))).all():
+            continue
+        if not texts.str.contains(r"[.,]").any():
+            continue  # e.g. cell names 001, 002
+        if is_text.all() and not texts.map(uses_decimal_comma).eq(True).any():
+            continue  # Text with points, e.g. names 1.1, 1.2, is not a number typed as text
+        columns[col] = texts
+    return columns
+
+
+def convert_text_numbers(
+    frames: tuple[pd.DataFrame, ...],
+    source: str,
+    exclude: Iterable[str] = (),
+) -> tuple[pd.DataFrame, ...]:
+    """Convert numbers typed as text in sheets read from an Excel file, in the decimal format they use."""
+    exclude = set(exclude)
+    found = [text_number_columns(df, exclude) for df in frames]
+    texts = [value for columns in found for series in columns.values() for value in series]
+    if not texts:
+        return frames
+    comma = detect_decimal_comma(texts, source)
+    converted = []
+    for df, columns in zip(frames, found):
+        df_converted = df.copy()
+        for col in columns:
+            values = df[col].map(lambda value: to_number(value, comma) if isinstance(value, str) else value)
+            df_converted[col] = pd.to_numeric(values)
+        converted.append(df_converted)
+    return tuple(converted)
@@ -0,0 +1,241 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Calibrate the syringes of the liquid handler gravimetrically and correct the dispensed volumes.
+
+A syringe does not dispense exactly the volume it is commanded to, and the error changes with wear.
+To calibrate it, dispense a few commanded volumes onto a balance, e.g. with water, and record the
+masses in a CSV or Excel file with a Commanded (uL) column and a Mass (mg) or Mass (g) column:
+
+    Commanded (uL),Mass (mg)
+    20,19.4
+    50,49.1
+    100,98.6
+
+The dispensed volume is the mass divided by the density of the liquid, --density or
+DISPENSER_CALIBRATION_DENSITY_G_ML in the config, and a straight line dispensed = slope * commanded
++ offset is fitted for the syringe. With only one commanded volume the offset is 0. Every
+calibration run is kept in the Dispenser_Calibration_Table, which import-excel does not replace, and
+the latest run of each syringe is used.
+
+`aurora-rt electrolyte` writes the commanded volumes that dispense the electrolyte amounts of each
+cell with DISPENSER_SYRINGE, and the mixing steps with DISPENSER_MIXING_SYRINGE, see
+electrolyte_calculation.py. A warning is logged if a syringe has no calibration, or if its latest
+calibration is older than DISPENSER_CALIBRATION_MAX_AGE_DAYS.
+
+Usage:
+    `aurora-rt dispenser calibrate 1 calibration.csv`, `aurora-rt dispenser status`.
+"""
+
+import logging
+import sqlite3
+from datetime import datetime
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+import pytz
+
+from aurora_robot_tools.config import (
+    DATABASE_FILEPATH,
+    DISPENSER_CALIBRATION_DENSITY_G_ML,
+    DISPENSER_CALIBRATION_MAX_AGE_DAYS,
+    TIME_ZONE,
+)
+from aurora_robot_tools.database import connect, has_table
+from aurora_robot_tools.decimals import convert_text_numbers, read_csv
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+CALIBRATION_TABLE = "Dispenser_Calibration_Table"
+CALIBRATION_COLUMNS = {
+    "Syringe": "TEXT",
+    "Calibrated": "TEXT",
+    "Commanded (uL)": "REAL",
+    "Mass (mg)": "REAL",
+    "Density (g/mL)": "REAL",
+    "Dispensed (uL)": "REAL",
+}
+MASS_UNITS = {"Mass (mg)": 1.0, "Mass (g)": 1000.0}
+# A syringe that is more than this far off is broken or was calibrated wrongly, e.g. in the wrong unit
+MAX_SLOPE_ERROR = 0.2
+
+
+def create_calibration_table(conn: sqlite3.Connection) -> None:
+    """Create the Dispenser_Calibration_Table."""
+    from aurora_robot_tools.migrations import create_table
+
+    create_table(conn, CALIBRATION_TABLE, CALIBRATION_COLUMNS)
+
+
+def read_points(path: Path) -> pd.DataFrame:
+    """Read the commanded volumes and weighed masses in mg of a calibration run."""
+    path = Path(path)
+    if not path.is_file():
+        msg = f"Calibration file {path} not found."
+        raise ConfigError(msg)
+    if path.suffix.lower() == ".csv":
+        df = read_csv(path)
+    elif path.suffix.lower() in (".xlsx", ".xls"):
+        (df,) = convert_text_numbers((pd.read_excel(path),), path.name)
+    else:
+        msg = f"Calibration file must be a .csv or .xlsx file, got {path.name}."
+        raise ConfigError(msg)
+    mass_column = next((column for column in MASS_UNITS if column in df.columns), None)
+    if "Commanded (uL)" not in df.columns or mass_column is None:
+        msg = f"{path.name} must have a Commanded (uL) column and a Mass (mg) or Mass (g) column."
+        raise ConfigError(msg)
+    points = pd.DataFrame(
+        {
+            "Commanded (uL)": pd.to_numeric(df["Commanded (uL)"], errors="coerce"),
+            "Mass (mg)": pd.to_numeric(df[mass_column], errors="coerce") * MASS_UNITS[mass_column],
+        },
+    ).dropna()
+    if points.empty or (points <= 0).to_numpy().any():
+        msg = f"{path.name} must have a positive commanded volume and mass in every row."
+        raise ConfigError(msg)
+    return points
+
+
+def fit(commanded: pd.Series, dispensed: pd.Series) -> tuple[float, float]:
+    """Fit dispensed = slope * commanded + offset, through the origin if only one volume was commanded."""
+    if commanded.nunique() < 2:
+        return float((dispensed * commanded).sum() / (commanded**2).sum()), 0.0
+    slope, offset = np.polyfit(commanded, dispensed, 1)
+    return float(slope), float(offset)
+
+
+def record_calibration(
+    syringe: str,
+    path: Path,
+    density: float = DISPENSER_CALIBRATION_DENSITY_G_ML,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> tuple[float, float]:
+    """Store a calibration run of a syringe and log the fitted correction.
+
+    Args:
+        syringe: Name of the syringe, as in DISPENSER_SYRINGE
+        path: CSV or Excel file with the commanded volumes and weighed masses
+        density: Density of the calibration liquid in g/mL
+        db_path: Path to the robot database
+        dry_run: Log the fit without storing the run
+
+    Returns:
+        The slope and offset of the fit
+
+    """
+    if not syringe:
+        msg = "Give the name of the syringe."
+        raise ConfigError(msg)
+    if density <= 0:
+        msg = f"Density must be positive, got {density} g/mL."
+        raise ConfigError(msg)
+    points = read_points(path)
+    points["Density (g/mL)"] = density
+    points["Dispensed (uL)"] = points["Mass (mg)"] / density
+    slope, offset = fit(points["Commanded (uL)"], points["Dispensed (uL)"])
+    residual = (points["Dispensed (uL)"] - (slope * points["Commanded (uL)"] + offset)).abs().max()
+    logger.info(
+        "Syringe %s dispenses %.4f x commanded %+.3f uL, from %d points, largest residual %.3f uL.",
+        syringe,
+        slope,
+        offset,
+        len(points),
+        residual,
+    )
+    if abs(slope - 1) > MAX_SLOPE_ERROR:
+        msg = (
+            f"Syringe {syringe} dispenses {slope:.2f} times the commanded volume, check the masses, their unit "
+            f"and the density of {density} g/mL."
+        )
+        raise ConfigError(msg)
+    if dry_run:
+        logger.info("Dry run, would store the calibration of syringe %s.", syringe)
+        return slope, offset
+    points["Syringe"] = syringe
+    points["Calibrated"] = datetime.now(pytz.timezone(TIME_ZONE)).isoformat(timespec="seconds")
+    with connect(db_path) as conn:
+        create_calibration_table(conn)
+        columns = list(CALIBRATION_COLUMNS)
+        conn.executemany(
+            f"INSERT INTO {CALIBRATION_TABLE} ({', '.join(f'`{c}`' for c in columns)}) "  # noqa: S608
+            f"VALUES ({', '.join('?' * len(columns))})",
+            points[columns].itertuples(index=False, name=None),
+        )
+    logger.info("Stored the calibration of syringe %s.", syringe)
+    return slope, offset
+
+
+def read_calibrations(db_path: Path = DATABASE_FILEPATH) -> dict[str, dict]:
+    """Fit the latest calibration run of each syringe, empty for databases without calibrations."""
+    if not has_table(db_path, CALIBRATION_TABLE, "no syringe calibrations are used"):
+        return {}
+    with connect(db_path) as conn:
+        df = pd.read_sql(f"SELECT * FROM {CALIBRATION_TABLE}", conn)  # noqa: S608
+    calibrations = {}
+    for syringe, runs in df.groupby("Syringe"):
+        latest = runs[runs["Calibrated"] == runs["Calibrated"].max()]
+        slope, offset = fit(latest["Commanded (uL)"], latest["Dispensed (uL)"])
+        calibrations[str(syringe)] = {
+            "slope": slope,
+            "offset": offset,
+            "calibrated": latest["Calibrated"].iloc[0],
+            "points": len(latest),
+        }
+    return calibrations
+
+
+def age_days(calibration: dict) -> float:
+    """Days since a calibration."""
+    calibrated = datetime.fromisoformat(calibration["calibrated"])
+    return (datetime.now(pytz.timezone(TIME_ZONE)) - calibrated).total_seconds() / 86400
+
+
+def calibration_for(syringe: str, db_path: Path = DATABASE_FILEPATH) -> dict | None:
+    """The latest calibration of a syringe, with a warning if it has none or it is too old."""
+    if not syringe:
+        return None
+    calibration = read_calibrations(db_path).get(syringe)
+    if calibration is None:
+        logger.warning(
+            "Syringe %s has no calibration, dispensing the volumes uncorrected. Calibrate it with "
+            "`aurora-rt dispenser calibrate %s <file>`.",
+            syringe,
+            syringe,
+        )
+        return None
+    age = age_days(calibration)
+    if DISPENSER_CALIBRATION_MAX_AGE_DAYS > 0 and age > DISPENSER_CALIBRATION_MAX_AGE_DAYS:
+        logger.warning(
+            "The calibration of syringe %s is %d days old, older than %d days, calibrate it again.",
+            syringe,
+            age,
+            DISPENSER_CALIBRATION_MAX_AGE_DAYS,
+        )
+    return calibration
+
+
+def dispense_commands(volumes: pd.Series, calibration: dict | None) -> pd.Series:
+    """The commanded volumes that dispense the target volumes, zero volumes stay zero."""
+    if calibration is None:
+        return volumes.copy()
+    commands = ((volumes - calibration["offset"]) / calibration["slope"]).clip(lower=0)
+    return commands.where(volumes != 0, volumes)
+
+
+def status(db_path: Path = DATABASE_FILEPATH) -> None:
+    """Log the latest calibration of each syringe."""
+    calibrations = read_calibrations(db_path)
+    if not calibrations:
+        logger.info("No dispenser calibrations stored.")
+        return
+    lines = []
+    for syringe, calibration in sorted(calibrations.items()):
+        age = age_days(calibration)
+        expired = DISPENSER_CALIBRATION_MAX_AGE_DAYS > 0 and age > DISPENSER_CALIBRATION_MAX_AGE_DAYS
+        lines.append(
+            f"{syringe:<8} {calibration['slope']:<7.4f} {calibration['offset']:<+11.3f} {calibration['points']:<7} "
+            f"{calibration['calibrated']} ({age:.0f} days{', EXPIRED' if expired else ''})",
+        )
+    logger.info("Syringe | Slope | Offset (uL) | Points | Calibrated\n%s", "\n".join(lines))
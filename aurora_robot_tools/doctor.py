@@ -0,0 +1,198 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Check the environment on the robot PC before running the tools.
+
+Most failures when AutoSuite calls the tools are caused by the environment, e.g. a missing package, a
+wrong database path or a folder without write permissions. This runs all checks and prints a
+pass/fail report, it exits with the environment error code if any check fails.
+
+Usage:
+    Called with `aurora-rt doctor`.
+"""
+
+import logging
+import os
+import re
+import sqlite3
+import sys
+import tempfile
+from collections.abc import Callable
+from importlib import metadata
+from pathlib import Path
+
+from aurora_robot_tools import config
+from aurora_robot_tools.errors import EnvironmentProblemError
+
+logger = logging.getLogger(__name__)
+
+MIN_PYTHON = (3, 10)
+REQUIRED_TABLES = [
+    "Cell_Assembly_Table",
+    "Press_Table",
+    "Electrolyte_Table",
+    "Settings_Table",
+    "Timestamp_Table",
+    "Calibration_Table",
+]
+
+CheckResult = tuple[bool, str]
+
+
+def check_python() -> CheckResult:
+    """Check the Python version."""
+    version = ".".join(str(v) for v in sys.version_info[:3])
+    ok = sys.version_info >= MIN_PYTHON
+    return ok, f"{version} at {sys.executable}"
+
+
+def check_packages() -> CheckResult:
+    """Check the required packages are installed."""
+    try:
+        requirements = metadata.requires("aurora-robot-tools") or []
+    except metadata.PackageNotFoundError:
+        return False, "aurora-robot-tools is not installed, run pip install"
+    missing = []
+    for requirement in requirements:
+        # Skip conditional and optional dependencies, e.g. tomli on newer Python or dev tools
+        if ";" in requirement:
+            continue
+        match = re.match(r"[A-Za-z0-9_.-]+", requirement)
+        if not match:
+            continue
+        try:
+            metadata.version(match.group())
+        except metadata.PackageNotFoundError:
+            missing.append(match.group())
+    if missing:
+        return False, f"Missing packages: {', '.join(missing)}"
+    return True, f"{len(requirements)} requirements installed"
+
+
+def check_database(db_path: Path) -> CheckResult:
+    """Check the database exists and has all tables."""
+    db_path = Path(db_path)
+    if not db_path.exists():
+        return False, f"{db_path} does not exist"
+    try:
+        with sqlite3.connect(db_path) as conn:
+            tables = {row[0] for row in conn.execute("SELECT name FROM sqlite_master WHERE type = 'table'")}
+    except sqlite3.Error as e:
+        return False, f"Cannot read {db_path}: {e}"
+    missing = [table for table in REQUIRED_TABLES if table not in tables]
+    if missing:
+        return False, f"Missing tables: {', '.join(missing)}, run import-excel"
+    if not os.access(db_path, os.W_OK):
+        return False, f"{db_path} is not writable"
+    from aurora_robot_tools.migrations import LATEST_VERSION, get_version
+
+    version = get_version(db_path)
+    if version < LATEST_VERSION:
+        # Still works, the features that need the newer tables are skipped with a warning
+        return True, f"{db_path}, schema version {version} of {LATEST_VERSION}, run `aurora-rt db migrate`"
+    return True, str(db_path)
+
+
+def check_journal_mode(db_path: Path) -> CheckResult:
+    """Check the database is in the journal mode from the config, WAL so the dashboard can read while writing."""
+    if not Path(db_path).exists():
+        return False, f"{db_path} does not exist"
+    try:
+        with sqlite3.connect(db_path) as conn:
+            mode = conn.execute("PRAGMA journal_mode").fetchone()[0]
+    except sqlite3.Error as e:
+        return False, f"Cannot read {db_path}: {e}"
+    expected = config.DB_JOURNAL_MODE.lower()
+    if expected and mode != expected:
+        return False, f"{mode.upper()} mode, not {expected.upper()}, is the database on a network drive?"
+    return True, f"{mode.upper()} mode"
+
+
+def check_writable(folder: Path) -> CheckResult:
+    """Check a file can be created in a folder."""
+    folder = Path(folder)
+    try:
+        folder.mkdir(parents=True, exist_ok=True)
+        with tempfile.TemporaryFile(dir=folder):
+            pass
+    except OSError as e:
+        return False, f"Cannot write to {folder}: {e}"
+    return True, str(folder)
+
+
+def check_presses(db_path: Path) -> CheckResult:
+    """Check the press configuration is consistent, and matches the Press_Table if there is one."""
+    presses = list(config.PRESS_TO_RACK)
+    n_presses = len(presses)
+    if sorted(presses) != list(range(1, n_presses + 1)):
+        return False, f"PRESS_TO_RACK presses must be numbered 1 to {n_presses}"
+    if sorted(config.PRESS_TO_RACK.values()) != list(range(1, n_presses + 1)):
+        return False, f"PRESS_TO_RACK rack positions must be 1 to {n_presses}, each used once"
+    unknown = [p for p in config.DISABLED_PRESSES if p not in config.PRESS_TO_RACK]
+    if unknown:
+        return False, f"DISABLED_PRESSES contains unknown presses {unknown}"
+    db_presses = None
+    if Path(db_path).exists():
+        try:
+            with sqlite3.connect(db_path) as conn:
+                db_presses = [row[0] for row in conn.execute("SELECT `Press Number` FROM Press_Table")]
+        except sqlite3.Error:
+            pass
+    if db_presses is not None and sorted(db_presses) != presses:
+        return False, f"Press_Table has presses {db_presses}, config has {presses}"
+    disabled_list = list(config.DISABLED_PRESSES)
+    if db_presses is not None:
+        from aurora_robot_tools.presses import disabled_presses
+
+        disabled_list = sorted(disabled_presses(db_path))
+    disabled = f", disabled: {disabled_list}" if disabled_list else ""
+    return True, f"{n_presses} presses{disabled}"
+
+
+def check_files() -> CheckResult:
+    """Check the installed files and companion scripts have not been edited."""
+    from aurora_robot_tools.integrity import modified_package_files, modified_scripts
+
+    modified = modified_package_files() + modified_scripts(list(config.SCRIPT_HASHES))
+    if modified:
+        return False, f"modified: {', '.join(str(path) for path in modified)}"
+    return True, f"{len(config.SCRIPT_HASHES)} companion scripts checked"
+
+
+def check_credentials() -> CheckResult:
+    """Check every credential the config refers to could be decrypted."""
+    if config.credential_errors:
+        return False, "; ".join(config.credential_errors.values())
+    return True, "all readable"
+
+
+def main(db_path: Path = config.DATABASE_FILEPATH) -> None:
+    """Run all checks and log a report, raise if any fail."""
+    checks: dict[str, Callable[[], CheckResult]] = {
+        "Python": check_python,
+        "Packages": check_packages,
+        "Database": lambda: check_database(db_path),
+        "Journal mode": lambda: check_journal_mode(db_path),
+        "Backup folder": lambda: check_writable(config.DATABASE_BACKUP_DIR),
+        "Output folder": lambda: check_writable(config.OUTPUT_DIR),
+        "Log folder": lambda: check_writable(config.LOG_DIR),
+        "Presses": lambda: check_presses(db_path),
+        "Files": check_files,
+        "Credentials": check_credentials,
+    }
+    failed = []
+    for name, check in checks.items():
+        ok, detail = check()
+        logger.info("%s  %-14s %s", "PASS" if ok else "FAIL", name, detail)
+        if not ok:
+            failed.append(name)
+    if failed:
+        msg = f"{len(failed)} of {len(checks)} checks failed: {', '.join(failed)}"
+        raise EnvironmentProblemError(msg)
+    logger.info("All checks passed")
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("doctor")
+    main()
@@ -0,0 +1,204 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Generate an electrolyte additive design of experiments for a batch.
+
+Given a base electrolyte from the Electrolyte_Table and levels for one or more components, e.g.
+"FEC (wt%)" at 0, 2 and 5 and "VC (wt%)" at 0 and 1, the formulations of the design are:
+    - full: every combination of the levels, the full factorial design
+    - latin: a Latin square for three components with the same number of levels n, n x n
+      formulations instead of n x n x n, each level of each component paired once with each level
+      of the others
+
+Each formulation is the composition of the base electrolyte with the levels of the design, added
+to the Electrolyte_Table as a new electrolyte with "Composition: " columns, see
+electrolyte_stocks.py, so `aurora-rt electrolyte` calculates how to mix it from the stock solutions
+in the rack. The stocks must contain the components, e.g. an FEC stock. Formulations from an earlier
+design are replaced.
+
+The formulations are assigned to the cells of the batch that have not started assembly in turn, in
+rack position order, so the replicates of each formulation are spread over the rack. The design
+matrix, with the rack positions of each formulation, is written to the output folder as CSV.
+
+Usage:
+    `aurora-rt doe --base LP30 --factor "FEC (wt%)=0,2,5" --factor "VC (wt%)=0,1" --batch 1`, then
+    `aurora-rt electrolyte` as usual.
+"""
+
+import itertools
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, OUTPUT_DIR
+from aurora_robot_tools.database import get_setting, read_tables, write_tables
+from aurora_robot_tools.electrolyte_stocks import COMPOSITION_PREFIX, mix_column
+from aurora_robot_tools.errors import ConfigError, InfeasibleError
+
+logger = logging.getLogger(__name__)
+
+DESIGNS = ("full", "latin")
+DOE_DESCRIPTION = "DOE formulation"  # Description of generated electrolytes, replaced by the next design
+
+
+def parse_factor(value: str) -> tuple[str, list[float]]:
+    """Read a factor like "FEC (wt%)=0,2,5" as the component and its levels."""
+    name, equals, levels = value.partition("=")
+    try:
+        parsed = [float(level) for level in levels.split(",") if level.strip()]
+    except ValueError:
+        parsed = []
+    if not equals or not name.strip() or len(parsed) < 2:
+        msg = f"Factor must be given as component=level1,level2,..., e.g. FEC (wt%)=0,2,5, got '{value}'."
+        raise ConfigError(msg)
+    return name.strip(), parsed
+
+
+def design_runs(factors: dict[str, list[float]], design: str = "full") -> list[tuple[float, ...]]:
+    """Levels of every formulation of the design, in the order of the factors."""
+    levels = list(factors.values())
+    if design == "full":
+        return list(itertools.product(*levels))
+    if design == "latin":
+        n = len(levels[0])
+        if len(levels) != 3 or any(len(factor) != n for factor in levels):
+            msg = "A Latin square needs three factors with the same number of levels, use --design full."
+            raise ConfigError(msg)
+        return [(levels[0][i], levels[1][j], levels[2][(i + j) % n]) for i in range(n) for j in range(n)]
+    msg = f"Design must be one of {', '.join(DESIGNS)}, got '{design}'."
+    raise ConfigError(msg)
+
+
+def formulation_name(base: str, factors: list[str], run: tuple[float, ...]) -> str:
+    """Name of a formulation, e.g. LP30 + FEC (wt%) 2 + VC (wt%) 1."""
+    return " + ".join([base, *(f"{factor} {level:g}" for factor, level in zip(factors, run))])
+
+
+def add_formulations(
+    df_electrolyte: pd.DataFrame,
+    base: str,
+    factors: dict[str, list[float]],
+    runs: list[tuple[float, ...]],
+) -> pd.DataFrame:
+    """Replace earlier designs and add a formulation per run to the electrolyte table."""
+    previous = df_electrolyte["Description"] == DOE_DESCRIPTION
+    df_electrolyte = df_electrolyte[~previous].reset_index(drop=True)
+    base_rows = df_electrolyte[df_electrolyte["Name"] == base]
+    if base_rows.empty:
+        msg = f"Base electrolyte '{base}' is not in the Electrolyte_Table."
+        raise ConfigError(msg)
+    missing = [factor for factor in factors if f"{COMPOSITION_PREFIX}{factor}" not in df_electrolyte.columns]
+    if missing:
+        msg = (
+            f"No {COMPOSITION_PREFIX}column for {', '.join(missing)} in the Electrolyte Properties, "
+            "add the composition of the stocks."
+        )
+        raise ConfigError(msg)
+    first = int(df_electrolyte["Electrolyte Position"].max()) + 1
+    rows = []
+    for i, run in enumerate(runs):
+        row = base_rows.iloc[0].copy()
+        row["Electrolyte Position"] = first + i
+        row["Name"] = formulation_name(base, list(factors), run)
+        row["Description"] = DOE_DESCRIPTION
+        if "Stock" in row.index:
+            row["Stock"] = 0
+        # The mix fractions are calculated from the composition with the stocks
+        for col in row.index:
+            if col.startswith("Mix "):
+                row[col] = np.nan
+        for factor, level in zip(factors, run):
+            row[f"{COMPOSITION_PREFIX}{factor}"] = level
+        rows.append(row)
+    df_electrolyte = pd.concat([df_electrolyte, pd.DataFrame(rows)], ignore_index=True)
+    # Mix columns of the positions of a larger earlier design are not needed anymore
+    stale = [
+        col
+        for col in df_electrolyte.columns
+        if col.startswith("Mix ") and col[4:].isdigit() and int(col[4:]) >= first + len(runs)
+    ]
+    df_electrolyte = df_electrolyte.drop(columns=stale)
+    for position in range(1, first + len(runs)):
+        if mix_column(position) not in df_electrolyte.columns:
+            df_electrolyte[mix_column(position)] = 0.0
+    return df_electrolyte
+
+
+def main(  # noqa: PLR0913
+    base: str,
+    factors: list[str],
+    design: str = "full",
+    batch: int | None = None,
+    db_path: Path = DATABASE_FILEPATH,
+    output_dir: Path = OUTPUT_DIR,
+    dry_run: bool = False,
+) -> None:
+    """Add the formulations of the design, assign them to the cells of a batch and export the design.
+
+    Args:
+        base: Name of the base electrolyte in the Electrolyte_Table
+        factors: Components and their levels, e.g. "FEC (wt%)=0,2,5"
+        design: full or latin
+        batch: Batch number to assign the formulations to, default is every cell
+        db_path: Path to the robot database
+        output_dir: Folder for the design matrix
+        dry_run: Log the changes instead of writing them
+
+    """
+    parsed = dict(parse_factor(factor) for factor in factors)
+    if len(parsed) != len(factors):
+        msg = "Each component can only be given once."
+        raise ConfigError(msg)
+    runs = design_runs(parsed, design)
+    df, df_electrolyte = read_tables(db_path, "Cell_Assembly_Table", "Electrolyte_Table")
+    old_positions = set(df_electrolyte.loc[df_electrolyte["Description"] == DOE_DESCRIPTION, "Electrolyte Position"])
+    df_electrolyte = add_formulations(df_electrolyte, base, parsed, runs)
+
+    cells = (df["Last Completed Step"] == 0) & (df["Error Code"] == 0) & df["Electrolyte Position"].notna()
+    if batch is not None:
+        cells &= df["Batch Number"] == batch
+    rows = df.index[cells].sort_values()
+    if len(rows) < len(runs):
+        msg = f"The design has {len(runs)} formulations, but only {len(rows)} cells can be assigned."
+        raise InfeasibleError(msg)
+    stale = df.loc[~cells & df["Electrolyte Position"].isin(old_positions), "Rack Position"]
+    if not stale.empty:
+        msg = f"Rack positions {', '.join(map(str, stale))} use the earlier design, assign the design to them too."
+        raise ConfigError(msg)
+    positions = df_electrolyte.loc[df_electrolyte["Description"] == DOE_DESCRIPTION, "Electrolyte Position"]
+    positions = positions.astype(int).to_numpy()
+    df.loc[rows, "Electrolyte Position"] = positions[np.arange(len(rows)) % len(runs)]
+    electrolytes = df_electrolyte.set_index("Electrolyte Position")
+    df["Electrolyte Name"] = df["Electrolyte Position"].map(electrolytes["Name"])
+    df["Electrolyte Description"] = df["Electrolyte Position"].map(electrolytes["Description"])
+    if len(rows) % len(runs):
+        logger.warning(
+            "%d cells for %d formulations, some formulations have one replicate more than others.",
+            len(rows),
+            len(runs),
+        )
+
+    assigned = df.loc[rows].groupby("Electrolyte Position")["Rack Position"]
+    design_matrix = pd.DataFrame(
+        [
+            {
+                "Electrolyte Position": position,
+                "Name": electrolytes.loc[position, "Name"],
+                **dict(zip(parsed, run)),
+                "Cells": len(assigned.get_group(position)),
+                "Rack Positions": ", ".join(str(int(rack)) for rack in assigned.get_group(position)),
+            }
+            for position, run in zip(positions, runs)
+        ],
+    )
+    logger.info("%s design with %d formulations for %d cells:\n%s", design, len(runs), len(rows), design_matrix)
+    write_tables(db_path, {"Cell_Assembly_Table": df, "Electrolyte_Table": df_electrolyte}, dry_run=dry_run)
+    if dry_run:
+        return
+    run_id = get_setting(db_path, "Base Sample ID") or "plan"
+    output_dir = Path(output_dir)
+    output_dir.mkdir(parents=True, exist_ok=True)
+    csv_filepath = output_dir / f"{run_id}_doe.csv"
+    design_matrix.to_csv(csv_filepath, index=False)
+    logger.info("Wrote the design matrix to %s, run `aurora-rt electrolyte` to calculate the mixing.", csv_filepath)
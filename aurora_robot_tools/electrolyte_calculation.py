@@ -7,27 +7,59 @@ chemspeedDB database. It then reads the mixing ratios from the Electrolyte_Table
 the of the mixing steps (such as move 100 uL from vial 1 to vial 5, etc.) required to prepare all
 electrolytes for the cells.
 
+If the Electrolyte_Table has compositions and stock solutions, the mixing ratios of the target
+electrolytes are first calculated from the stocks, see electrolyte_stocks.py.
+
+The liquid handler leaves a dead volume in every vial and uses a priming volume before dispensing,
+both set in the config. These are added to every vial that is dispensed from, so the total required
+volume of each vial is written to the Electrolyte_Table. A warning is logged if a vial needs more
+than it holds ("Vial Volume (uL)" in the Electrolyte Properties sheet, or ELECTROLYTE_VIAL_VOLUME_UL),
+or if a cell or mixing step needs less than the minimum dispensable volume.
+
+The liquid handler can only dispense multiples of its resolution and silently truncates anything
+finer. With ELECTROLYTE_RESOLUTION_UL set, e.g. to 0.5, the volumes of every cell and mixing step are
+rounded to the nearest multiple instead, and the change in E/C ratio (electrolyte volume per cathode
+capacity, uL/mAh) this introduces is reported for each cell.
+
+The volumes the liquid handler is commanded to dispense are written next to the amounts, in the
+Electrolyte Dispense Before/After Separator (uL) columns and the Dispense Volume (uL) of the mixing
+steps, which the AutoSuite dispense steps read. With DISPENSER_SYRINGE set they are corrected with the
+gravimetric calibration of the syringe, see dispenser_calibration.py, otherwise they are the amounts.
+
 Usage:
-    The script is called from electrolyte_calculation.exe by the AutoSuite software.
+    The script is called with `aurora-rt electrolyte` by the AutoSuite software.
     It can also be called from the command line.
 """
 
-import sqlite3
+import logging
 import sys
 from pathlib import Path
 
 import numpy as np
 import pandas as pd
 
-from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.cell_state import in_state, set_state
+from aurora_robot_tools.config import (
+    DATABASE_FILEPATH,
+    DISPENSER_MIXING_SYRINGE,
+    DISPENSER_SYRINGE,
+    ELECTROLYTE_DEAD_VOLUME_UL,
+    ELECTROLYTE_MIN_DISPENSE_UL,
+    ELECTROLYTE_PRIMING_VOLUME_UL,
+    ELECTROLYTE_RESOLUTION_UL,
+    ELECTROLYTE_SAFETY_FACTOR,
+    ELECTROLYTE_VIAL_VOLUME_UL,
+)
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.dispenser_calibration import calibration_for, dispense_commands
+from aurora_robot_tools.electrolyte_stocks import add_recipes, calculate_stock_fractions
+
+logger = logging.getLogger(__name__)
 
 
 def read_db(db_path: Path) -> tuple[pd.DataFrame, pd.DataFrame]:
     """Read the Cell_Assembly_Table and Electrolyte_Table from the database."""
-    with sqlite3.connect(db_path) as conn:
-        # Read the tables from the database
-        df = pd.read_sql("SELECT * FROM Cell_Assembly_Table", conn)
-        df_electrolyte = pd.read_sql("SELECT * FROM Electrolyte_Table", conn)
+    df, df_electrolyte = read_tables(db_path, "Cell_Assembly_Table", "Electrolyte_Table")
     return df, df_electrolyte
 
 
@@ -51,24 +83,190 @@ def get_volumnes(
     df: pd.DataFrame,
     mix_fractions: np.ndarray,
     safety_factor: float,
+    overhead: float | None = None,
 ) -> tuple[np.ndarray, np.ndarray]:
     """Calculate the volumes of electrolyte required.
 
-    Cumulative volumes account for the electrolyte being used up in the mixing steps.
+    Cumulative volumes account for the electrolyte being used up in the mixing steps. The overhead,
+    i.e. dead and priming volume, is added once to every vial that is dispensed from.
     """
+    if overhead is None:
+        overhead = ELECTROLYTE_DEAD_VOLUME_UL + ELECTROLYTE_PRIMING_VOLUME_UL
     n = len(mix_fractions)
     volumes = np.zeros(n)
     for i in range(n):
         mask = (df["Electrolyte Position"] == i + 1) & (df["Cell Number"] > 0) & (df["Error Code"] == 0)
         volumes[i] = df.loc[mask, "Electrolyte Amount (uL)"].sum() * safety_factor
+    volumes[volumes > 0] += overhead
     cumulative_volumes = volumes
     remaining_volumes = volumes
     for _ in range(5):
         remaining_volumes = np.matmul(remaining_volumes, mix_fractions)
         cumulative_volumes = cumulative_volumes + remaining_volumes
+    # Vials only used as a source for mixing also keep a dead volume and need priming
+    cumulative_volumes[(cumulative_volumes > 0) & (volumes == 0)] += overhead
     return volumes, cumulative_volumes
 
 
+def check_vial_volumes(
+    df_electrolyte: pd.DataFrame,
+    default_vial_volume: float | None = None,
+) -> list[int]:
+    """Warn about vials that do not hold enough electrolyte for the batch.
+
+    Returns:
+        Electrolyte positions of the vials that are too small
+
+    """
+    if default_vial_volume is None:
+        default_vial_volume = ELECTROLYTE_VIAL_VOLUME_UL
+    if "Vial Volume (uL)" in df_electrolyte.columns:
+        vial_volumes = df_electrolyte["Vial Volume (uL)"].fillna(default_vial_volume)
+    else:
+        vial_volumes = pd.Series(default_vial_volume, index=df_electrolyte.index)
+    too_small = (vial_volumes > 0) & (df_electrolyte["Cumulative Volume Required (uL)"] > vial_volumes)
+    for i in df_electrolyte.index[too_small]:
+        logger.warning(
+            "Vial %d (%s) needs %.1f uL but only holds %.1f uL, it will not cover the batch.",
+            df_electrolyte.loc[i, "Electrolyte Position"],
+            df_electrolyte.loc[i, "Name"],
+            df_electrolyte.loc[i, "Cumulative Volume Required (uL)"],
+            vial_volumes[i],
+        )
+    return df_electrolyte.loc[too_small, "Electrolyte Position"].astype(int).tolist()
+
+
+def check_min_dispense(
+    df: pd.DataFrame,
+    df_mixing_table: pd.DataFrame,
+    minimum: float | None = None,
+) -> None:
+    """Warn about cells and mixing steps that need less than the minimum dispensable volume."""
+    if minimum is None:
+        minimum = ELECTROLYTE_MIN_DISPENSE_UL
+    if minimum <= 0:
+        return
+    cells = df[(df["Cell Number"] > 0) & (df["Error Code"] == 0)]
+    for column in ("Electrolyte Amount Before Separator (uL)", "Electrolyte Amount After Separator (uL)"):
+        if column not in cells.columns:
+            continue
+        too_small = (cells[column] > 0) & (cells[column] < minimum)
+        if too_small.any():
+            logger.warning(
+                "Cells %s have %s below the minimum dispensable volume of %s uL.",
+                cells.loc[too_small, "Cell Number"].tolist(),
+                column[: column.index(" (")].lower(),
+                minimum,
+            )
+    too_small = df_mixing_table["Volume (uL)"] < minimum
+    for _, step in df_mixing_table[too_small].iterrows():
+        logger.warning(
+            "Mixing step from vial %d to vial %d is %.1f uL, below the minimum dispensable volume of %s uL.",
+            step["Source Position"],
+            step["Target Position"],
+            step["Volume (uL)"],
+            minimum,
+        )
+
+
+def round_to_resolution(volumes: pd.Series, resolution: float) -> pd.Series:
+    """Round volumes to the nearest multiple of the resolution."""
+    return (volumes / resolution).round() * resolution
+
+
+def round_cell_volumes(df: pd.DataFrame, resolution: float | None = None) -> None:
+    """Round the electrolyte volumes of the cells in-place and report the E/C ratio error."""
+    if resolution is None:
+        resolution = ELECTROLYTE_RESOLUTION_UL
+    if resolution <= 0:
+        return
+    cells = (df["Cell Number"] > 0) & (df["Error Code"] == 0)
+    before = df.loc[cells, "Electrolyte Amount (uL)"].copy()
+    change = pd.Series(0.0, index=before.index)
+    for column in ("Electrolyte Amount Before Separator (uL)", "Electrolyte Amount After Separator (uL)"):
+        if column not in df.columns:
+            continue
+        rounded = round_to_resolution(df.loc[cells, column].fillna(0), resolution)
+        change += rounded - df.loc[cells, column].fillna(0)
+        df.loc[cells, column] = rounded
+    # The total follows the dispensed volumes
+    df.loc[cells, "Electrolyte Amount (uL)"] = before + change
+    if not change.any():
+        return
+    capacity = df.loc[cells, "Cathode Balancing Capacity (mAh)"].replace(0, np.nan)
+    ec_error = change / capacity
+    lines = [
+        f"{int(df.loc[i, 'Cell Number']):<6} {before[i]:<14.2f} {before[i] + change[i]:<14.2f} {ec_error[i]:+.3f}"
+        for i in before.index
+        if change[i]
+    ]
+    logger.info(
+        "Rounded electrolyte volumes to %s uL:\nCell | Volume (uL) | Rounded (uL) | E/C error (uL/mAh)\n%s",
+        resolution,
+        "\n".join(lines),
+    )
+    logger.info("Largest E/C error from rounding: %.3f uL/mAh", ec_error.abs().max())
+
+
+def round_mixing_volumes(df_mixing_table: pd.DataFrame, resolution: float | None = None) -> None:
+    """Round the volumes of the mixing steps in-place."""
+    if resolution is None:
+        resolution = ELECTROLYTE_RESOLUTION_UL
+    if resolution <= 0 or df_mixing_table.empty:
+        return
+    rounded = round_to_resolution(df_mixing_table["Volume (uL)"], resolution)
+    logger.info(
+        "Rounded mixing steps to %s uL, largest change %.2f uL.",
+        resolution,
+        (rounded - df_mixing_table["Volume (uL)"]).abs().max(),
+    )
+    df_mixing_table["Volume (uL)"] = rounded
+    if (rounded == 0).any():
+        logger.warning("%d mixing steps are below half the resolution and were removed.", (rounded == 0).sum())
+        df_mixing_table.drop(df_mixing_table.index[rounded == 0], inplace=True)
+
+
+def add_dispense_commands(
+    df: pd.DataFrame,
+    df_mixing_table: pd.DataFrame,
+    db_path: Path,
+    resolution: float | None = None,
+) -> None:
+    """Add the commanded volumes that dispense the electrolyte amounts in-place, rounded to the resolution."""
+    if resolution is None:
+        resolution = ELECTROLYTE_RESOLUTION_UL
+    calibration = calibration_for(DISPENSER_SYRINGE, db_path)
+    mixing_syringe = DISPENSER_MIXING_SYRINGE or DISPENSER_SYRINGE
+    mixing_calibration = calibration
+    if mixing_syringe != DISPENSER_SYRINGE:
+        mixing_calibration = calibration_for(mixing_syringe, db_path)
+    corrected = []
+    for when in ("Before", "After"):
+        column = f"Electrolyte Amount {when} Separator (uL)"
+        if column not in df.columns:
+            continue
+        commands = dispense_commands(df[column], calibration)
+        df[f"Electrolyte Dispense {when} Separator (uL)"] = (
+            round_to_resolution(commands, resolution) if resolution > 0 else commands
+        )
+        corrected.append((commands - df[column]).abs())
+    commands = dispense_commands(df_mixing_table["Volume (uL)"], mixing_calibration)
+    df_mixing_table["Dispense Volume (uL)"] = round_to_resolution(commands, resolution) if resolution > 0 else commands
+    if calibration is not None and corrected:
+        logger.info(
+            "Corrected the cell volumes with the calibration of syringe %s from %s, largest change %.2f uL.",
+            DISPENSER_SYRINGE,
+            calibration["calibrated"],
+            max(change.max() for change in corrected),
+        )
+    if mixing_calibration is not None and not df_mixing_table.empty:
+        logger.info(
+            "Corrected the mixing steps with the calibration of syringe %s, largest change %.2f uL.",
+            mixing_syringe,
+            (commands - df_mixing_table["Volume (uL)"]).abs().max(),
+        )
+
+
 def make_mixing_steps(mixing_matrix: np.ndarray) -> pd.DataFrame:
     """Create dataframe containing list of mixing steps.
 
@@ -92,28 +290,50 @@ def make_mixing_steps(mixing_matrix: np.ndarray) -> pd.DataFrame:
     )
 
 
-def write_db(db_path: Path, df_electrolyte: pd.DataFrame, df_mixing_table: pd.DataFrame) -> None:
-    """Write the electrolyte and mixing table back to the database."""
-    with sqlite3.connect(db_path) as conn:
-        df_electrolyte.to_sql("Electrolyte_Table", conn, index=False, if_exists="replace")
-        df_mixing_table.to_sql(
-            "Mixing_Table",
-            conn,
-            index=False,
-            if_exists="replace",
-            dtype={
+def write_db(
+    db_path: Path,
+    df: pd.DataFrame,
+    df_electrolyte: pd.DataFrame,
+    df_mixing_table: pd.DataFrame,
+    dry_run: bool = False,
+) -> None:
+    """Write the cell assembly, electrolyte and mixing tables back to the database."""
+    write_tables(
+        db_path,
+        {"Cell_Assembly_Table": df, "Electrolyte_Table": df_electrolyte, "Mixing_Table": df_mixing_table},
+        dtypes={
+            "Mixing_Table": {
                 "Target Position": "INTEGER",
                 "Source Position": "INTEGER",
                 "Volume (uL)": "REAL",
+                "Dispense Volume (uL)": "REAL",
             },
-        )
+        },
+        dry_run=dry_run,
+    )
+
 
+def main(
+    safety_factor: float = ELECTROLYTE_SAFETY_FACTOR,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+    resume: bool = False,
+) -> None:
+    """Determine the electrolyte mixing steps, with resume only if there are balanced cells without them."""
+    logger.info("Multiplying all electrolyte volumes by %s.", safety_factor)
 
-def main(safety_factor: float = 1.1) -> None:
-    """Determine the electrolyte mixing steps."""
-    print(f"Multiplying all electrolyte volumes by {safety_factor}.")
+    df, df_electrolyte = read_db(db_path)
+    balanced = in_state(df, "balanced")
+    if resume and not balanced.any():
+        logger.info("No balanced cells without electrolyte calculated, nothing to resume.")
+        return
+    if not (balanced | in_state(df, "electrolyte calculated")).any():
+        logger.warning("No cells are balanced, run capacity balancing before calculating the electrolyte.")
 
-    df, df_electrolyte = read_db(DATABASE_FILEPATH)
+    # Calculate mixing ratios from stock solutions, record the recipe of each cell
+    df_electrolyte = calculate_stock_fractions(df_electrolyte)
+    df = add_recipes(df, df_electrolyte)
+    round_cell_volumes(df)
 
     mix_fractions = get_mix_fractions(df_electrolyte)
 
@@ -129,13 +349,26 @@ def main(safety_factor: float = 1.1) -> None:
 
     # Create the list of mixing steps
     df_mixing_table = make_mixing_steps(mixing_matrix)
+    round_mixing_volumes(df_mixing_table)
+    add_dispense_commands(df, df_mixing_table, db_path)
+
+    # Check the liquid handler can dispense the volumes and each vial holds enough
+    check_min_dispense(df, df_mixing_table)
+    check_vial_volumes(df_electrolyte)
 
     # Write the electrolyte and mixing table back to the database
-    write_db(DATABASE_FILEPATH, df_electrolyte, df_mixing_table)
+    set_state(df, balanced, "electrolyte calculated")
+    write_db(db_path, df, df_electrolyte, df_mixing_table, dry_run)
+    if dry_run:
+        logger.info("Mixing steps:\n%s", df_mixing_table.to_string(index=False))
+        return
 
-    print("Successfully calculated the electrolyte mixing steps, wrote to Mixing_Table in database.")
+    logger.info("Successfully calculated the electrolyte mixing steps, wrote to Mixing_Table in database.")
 
 
 if __name__ == "__main__":
-    safety_factor = float(sys.argv[1]) if len(sys.argv) >= 2 else 1.1
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("electrolyte")
+    safety_factor = float(sys.argv[1]) if len(sys.argv) >= 2 else ELECTROLYTE_SAFETY_FACTOR
     main(safety_factor)
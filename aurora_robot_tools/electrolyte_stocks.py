@@ -0,0 +1,128 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Calculate how to mix target electrolyte formulations from stock solutions.
+
+Instead of giving the "Mix n" fractions by hand in the Electrolyte Properties sheet, the composition
+of each electrolyte can be given in columns starting with "Composition: ", e.g.
+"Composition: LiPF6 (M)", "Composition: EC (vol%)", "Composition: FEC (wt%)". Electrolytes with
+"Stock" set to 1 are the stock solutions in the rack. For every other electrolyte with a composition
+and no mix fractions, the volume fractions of the stocks that give that composition are calculated
+and written to the mix columns, which are then used for the mixing steps as usual.
+
+Compositions are assumed to mix linearly with volume, which is exact for molar concentrations and
+volume percentages and an approximation for weight percentages.
+
+The recipe of every cell, e.g. "0.750 x LP30 (1) + 0.250 x FEC stock (2)", is recorded in the
+Electrolyte Recipe column of the Cell_Assembly_Table.
+"""
+
+import logging
+
+import numpy as np
+import pandas as pd
+from scipy.optimize import nnls
+
+from aurora_robot_tools.errors import InfeasibleError
+
+logger = logging.getLogger(__name__)
+
+COMPOSITION_PREFIX = "Composition: "
+TOLERANCE = 0.01  # Largest allowed deviation from the target, relative to the largest value of each component
+
+
+def composition_columns(df_electrolyte: pd.DataFrame) -> list[str]:
+    """Get the composition columns of the electrolyte table."""
+    return [col for col in df_electrolyte.columns if col.startswith(COMPOSITION_PREFIX)]
+
+
+def mix_column(position: int) -> str:
+    """Name of the column with the fraction taken from the vial at a position."""
+    return f"Mix {position}"
+
+
+def solve_fractions(stocks: np.ndarray, target: np.ndarray) -> np.ndarray:
+    """Find non-negative stock fractions that sum to 1 and give the target composition.
+
+    Args:
+        stocks: Compositions of the stocks, shape (n_components, n_stocks)
+        target: Composition of the target, shape (n_components,)
+
+    Returns:
+        Volume fraction of each stock
+
+    Raises:
+        InfeasibleError: If the target can not be made from the stocks
+
+    """
+    # Scale each component so they are weighted equally, and add a strongly weighted row for sum = 1
+    scale = np.maximum(np.abs(stocks).max(axis=1), np.abs(target))
+    scale[scale == 0] = 1
+    a = np.vstack([stocks / scale[:, np.newaxis], 100 * np.ones(stocks.shape[1])])
+    b = np.append(target / scale, 100)
+    fractions, _residual = nnls(a, b)
+    deviation = np.abs(stocks @ fractions - target) / scale
+    if deviation.max() > TOLERANCE or abs(fractions.sum() - 1) > TOLERANCE:
+        msg = f"Cannot mix composition {target} from the stocks, closest deviation {deviation.max():.1%}."
+        raise InfeasibleError(msg)
+    return fractions / fractions.sum()
+
+
+def calculate_stock_fractions(df_electrolyte: pd.DataFrame) -> pd.DataFrame:
+    """Fill in the mix fractions of target electrolytes from their compositions.
+
+    Returns:
+        The electrolyte table with mix columns filled for targets, unchanged if there are no
+        composition columns or stocks
+
+    """
+    columns = composition_columns(df_electrolyte)
+    if not columns or "Stock" not in df_electrolyte.columns:
+        return df_electrolyte
+    df_electrolyte = df_electrolyte.copy()
+    n = int(df_electrolyte["Electrolyte Position"].max())
+    for position in range(1, n + 1):
+        if mix_column(position) not in df_electrolyte.columns:
+            df_electrolyte[mix_column(position)] = 0.0
+    mix_columns = [mix_column(position) for position in range(1, n + 1)]
+
+    is_stock = df_electrolyte["Stock"].fillna(0).astype(bool)
+    stocks = df_electrolyte[is_stock]
+    if stocks.empty:
+        return df_electrolyte
+    stock_compositions = stocks[columns].fillna(0).to_numpy(dtype=float).T
+    has_mix = df_electrolyte[mix_columns].fillna(0).sum(axis=1) > 0
+    has_composition = df_electrolyte[columns].fillna(0).abs().sum(axis=1) > 0
+    targets = df_electrolyte[~is_stock & ~has_mix & has_composition]
+    for idx, row in targets.iterrows():
+        try:
+            fractions = solve_fractions(stock_compositions, row[columns].fillna(0).to_numpy(dtype=float))
+        except InfeasibleError as e:
+            msg = f"Electrolyte {row['Name']} (position {row['Electrolyte Position']}): {e}"
+            raise InfeasibleError(msg) from e
+        for stock_position, fraction in zip(stocks["Electrolyte Position"], fractions):
+            df_electrolyte.loc[idx, mix_column(int(stock_position))] = fraction
+        logger.info("Electrolyte %s mixed from stocks: %s", row["Name"], describe_recipe(df_electrolyte, idx))
+    return df_electrolyte
+
+
+def describe_recipe(df_electrolyte: pd.DataFrame, idx: int) -> str:
+    """Describe how an electrolyte is mixed, e.g. 0.750 x LP30 (1) + 0.250 x FEC stock (2)."""
+    names = df_electrolyte.set_index("Electrolyte Position")["Name"]
+    parts = []
+    for position, name in names.items():
+        col = mix_column(int(position))
+        fraction = df_electrolyte.loc[idx, col] if col in df_electrolyte.columns else 0
+        if fraction and not pd.isna(fraction):
+            parts.append(f"{fraction:.3f} x {name} ({int(position)})")
+    return " + ".join(parts) if parts else str(df_electrolyte.loc[idx, "Name"])
+
+
+def add_recipes(df: pd.DataFrame, df_electrolyte: pd.DataFrame) -> pd.DataFrame:
+    """Add the Electrolyte Recipe column to the cell assembly table."""
+    recipes = {
+        int(df_electrolyte.loc[idx, "Electrolyte Position"]): describe_recipe(df_electrolyte, idx)
+        for idx in df_electrolyte.index
+    }
+    df = df.copy()
+    df["Electrolyte Recipe"] = df["Electrolyte Position"].map(recipes).fillna("")
+    return df
@@ -0,0 +1,107 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Errors and exit codes.
+
+AutoSuite only sees the exit code of aurora-rt, so failures are grouped into classes with their own
+exit code, letting the AutoSuite workflow react differently to e.g. a locked database and a batch
+where no electrodes can be matched.
+
+    0  - Success
+    1  - Unexpected error
+    10 - Configuration or input error, e.g. invalid input file or arguments
+    20 - Database error, e.g. database or table missing
+    21 - Database is locked by another program
+    30 - Calculation infeasible, e.g. no electrode pairs within the N:P ratio limits
+    40 - Environment error, e.g. missing Python package or hardware not connected
+    50 - Timed out
+    51 - Stuck, no activity for ACTIVITY_KILL_S seconds
+    60 - Another aurora-rt command is already using the database
+    70 - Aborted, e.g. with Ctrl+C or by AutoSuite
+"""
+
+import sqlite3
+import sys
+from enum import IntEnum
+
+
+class ExitCode(IntEnum):
+    """Exit codes returned to AutoSuite."""
+
+    OK = 0
+    UNEXPECTED_ERROR = 1
+    CONFIG_ERROR = 10
+    DATABASE_ERROR = 20
+    DATABASE_LOCKED = 21
+    INFEASIBLE = 30
+    ENVIRONMENT_ERROR = 40
+    TIMEOUT = 50
+    STALLED = 51
+    ALREADY_RUNNING = 60
+    ABORTED = 70
+
+
+class AuroraError(Exception):
+    """Base class for errors with a specific exit code."""
+
+    exit_code = ExitCode.UNEXPECTED_ERROR
+
+
+class ConfigError(AuroraError):
+    """Invalid configuration, arguments or input file."""
+
+    exit_code = ExitCode.CONFIG_ERROR
+
+
+class DatabaseError(AuroraError):
+    """Database missing or does not have the expected tables."""
+
+    exit_code = ExitCode.DATABASE_ERROR
+
+
+class DatabaseLockedError(DatabaseError):
+    """Database is locked by another program."""
+
+    exit_code = ExitCode.DATABASE_LOCKED
+
+
+class InfeasibleError(AuroraError):
+    """The calculation has no valid solution."""
+
+    exit_code = ExitCode.INFEASIBLE
+
+
+class EnvironmentProblemError(AuroraError):
+    """Missing Python package or hardware."""
+
+    exit_code = ExitCode.ENVIRONMENT_ERROR
+
+
+class AlreadyRunningError(AuroraError):
+    """Another instance is already using the database."""
+
+    exit_code = ExitCode.ALREADY_RUNNING
+
+
+class AbortedError(AuroraError):
+    """The command was interrupted or its parent process exited."""
+
+    exit_code = ExitCode.ABORTED
+
+
+def get_exit_code(error: BaseException) -> ExitCode:
+    """Get the exit code for an exception."""
+    if isinstance(error, AuroraError):
+        return error.exit_code
+    if isinstance(error, sqlite3.OperationalError) and "locked" in str(error):
+        return ExitCode.DATABASE_LOCKED
+    if isinstance(error, sqlite3.Error):
+        return ExitCode.DATABASE_ERROR
+    # Only check pandas errors if pandas is loaded, the error may be that pandas is missing
+    pd = sys.modules.get("pandas")
+    if pd is not None and isinstance(error, pd.errors.DatabaseError):
+        return ExitCode.DATABASE_LOCKED if "locked" in str(error) else ExitCode.DATABASE_ERROR
+    if isinstance(error, ImportError):
+        return ExitCode.ENVIRONMENT_ERROR
+    if isinstance(error, ValueError):
+        return ExitCode.CONFIG_ERROR
+    return ExitCode.UNEXPECTED_ERROR
@@ -0,0 +1,206 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Estimate how long an assembly run takes and when it finishes.
+
+The run is simulated from the planned cells in the Cell_Assembly_Table, with the typical duration of
+each robot step from STEP_DURATIONS_S in the config:
+    - Electrolyte mixing comes first, MIXING_STEP_DURATION_S for every step in the Mixing_Table,
+      unless assembly has already started
+    - The robot assembles one cell at a time, in cell number order, only doing the steps the cell
+      still needs: spacers only if the cell has one, electrolyte only if the volume is above zero
+    - Each cell then waits for its press, or the first free press if it has none yet, and stays in
+      it for the "Press" duration while the robot carries on with the next cell
+
+Cells that have started only count their remaining steps, finished and failed cells are not counted.
+The estimate is logged by `aurora-rt export-plan` and written to the Summary sheet of the run sheet.
+If RUN_END_TIME is set, e.g. "18:00", a warning is logged when the run would finish later, so a batch
+that cannot finish before the building closes can be split or started the next morning.
+
+Usage:
+    `aurora-rt estimate`, or `aurora-rt estimate --start 08:30` for a run that starts later.
+"""
+
+import logging
+from datetime import datetime, time, timedelta
+from pathlib import Path
+
+import pandas as pd
+import pytz
+
+from aurora_robot_tools.config import (
+    DATABASE_FILEPATH,
+    MIXING_STEP_DURATION_S,
+    PRESS_TO_RACK,
+    RUN_END_TIME,
+    STEP_DEFINITION,
+    STEP_DURATIONS_S,
+    TIME_ZONE,
+)
+from aurora_robot_tools.database import read_query
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+SEPARATOR_STEP = 60  # Step number of the separator, spacers and electrolyte before it are the bottom ones
+PLACED_ONCE = ("Anode", "Cathode")  # Face up or face down, the robot only does one of the two steps
+
+
+def parse_time(value: str) -> time:
+    """Read a time of day like 18:00."""
+    try:
+        return datetime.strptime(value.strip(), "%H:%M").time()  # noqa: DTZ007
+    except ValueError as e:
+        msg = f"Time must be given as HH:MM, got '{value}'."
+        raise ConfigError(msg) from e
+
+
+def is_needed(step: int, name: str, cell: pd.Series) -> bool:
+    """Check if the robot does a step for a cell."""
+    if name == "Spacer":
+        column = "Bottom Spacer Type" if step < SEPARATOR_STEP else "Top Spacer Type"
+        return not pd.isna(cell.get(column)) and str(cell.get(column)).strip() != ""
+    if name == "Electrolyte":
+        column = f"Electrolyte Amount {'Before' if step < SEPARATOR_STEP else 'After'} Separator (uL)"
+        volume = cell.get(column)
+        return pd.isna(volume) or float(volume) > 0
+    return True
+
+
+def remaining_steps(cell: pd.Series) -> list[str]:
+    """Names of the steps a cell still needs, in order."""
+    done = int(cell.get("Last Completed Step", 0) or 0)
+    steps = []
+    for step, definition in STEP_DEFINITION.items():
+        name = definition["Step"]
+        if name in PLACED_ONCE and name in steps:
+            continue
+        if step > done and is_needed(step, name, cell):
+            steps.append(name)
+    return steps
+
+
+def read_cells(db_path: Path) -> pd.DataFrame:
+    """Read the planned cells that have not finished or failed, in assembly order."""
+    from aurora_robot_tools.assign_cells_to_press import RETURN_STEP
+
+    df = read_query(db_path, "SELECT * FROM Cell_Assembly_Table WHERE `Cell Number` > 0")
+    df = df[(df["Last Completed Step"] < RETURN_STEP) & (df["Error Code"] == 0)]
+    return df.sort_values("Cell Number").reset_index(drop=True)
+
+
+def count_mixing_steps(db_path: Path) -> int:
+    """Number of electrolyte mixing steps, 0 if the electrolyte has not been calculated."""
+    tables = read_query(db_path, "SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'Mixing_Table'")
+    if tables.empty:
+        return 0
+    return len(read_query(db_path, "SELECT * FROM Mixing_Table"))
+
+
+def simulate(cells: pd.DataFrame, presses: list[int], durations: dict[str, float]) -> float:
+    """Seconds from the start of assembly until the last cell is returned."""
+    press_time = durations.get("Press", 0.0)
+    press_free = dict.fromkeys(presses, 0.0)
+    robot = 0.0
+    end = 0.0
+    for _, cell in cells.iterrows():
+        steps = remaining_steps(cell)
+        if "Press" not in steps:  # Pressed already, only waiting to be returned
+            robot += sum(durations.get(name, 0.0) for name in steps)
+            end = max(end, robot)
+            continue
+        robot += sum(durations.get(name, 0.0) for name in steps[: steps.index("Press")])
+        press = int(cell.get("Current Press Number", 0) or 0)
+        if press not in press_free:
+            press = min(press_free, key=press_free.__getitem__)
+        # The robot loads the press, so it waits if the press is still busy with the previous cell
+        robot = max(robot, press_free[press])
+        press_free[press] = robot + press_time
+        # Returning the cell later still takes robot time, between the steps of the next cells
+        after_press = sum(durations.get(name, 0.0) for name in steps[steps.index("Press") + 1 :])
+        robot += after_press
+        end = max(end, press_free[press] + after_press)
+    return max(end, robot)
+
+
+def estimate(db_path: Path = DATABASE_FILEPATH, start: datetime | None = None) -> dict:
+    """Estimate the run time and end of the run for the planned cells.
+
+    Args:
+        db_path: Path to the robot database
+        start: When the run starts, default is now
+
+    Returns:
+        The number of cells, presses and mixing steps, the run time in seconds and the start and end
+
+    """
+    from aurora_robot_tools.presses import disabled_presses
+
+    missing = sorted({d["Step"] for d in STEP_DEFINITION.values()} - set(STEP_DURATIONS_S))
+    if missing:
+        logger.warning("No duration for the steps %s in STEP_DURATIONS_S, counted as 0 s.", ", ".join(missing))
+    cells = read_cells(db_path)
+    disabled = disabled_presses(db_path)
+    presses = [press for press in PRESS_TO_RACK if press not in disabled]
+    if not presses and not cells.empty:
+        msg = "All presses are disabled, the run cannot finish."
+        raise ConfigError(msg)
+    started = (cells["Last Completed Step"] > 0).any() if not cells.empty else False
+    mixing_steps = 0 if started else count_mixing_steps(db_path)
+    seconds = mixing_steps * MIXING_STEP_DURATION_S + (simulate(cells, presses, STEP_DURATIONS_S) if presses else 0)
+    start = start or datetime.now(pytz.timezone(TIME_ZONE))
+    return {
+        "cells": len(cells),
+        "presses": len(presses),
+        "mixing_steps": mixing_steps,
+        "run_time_s": round(seconds),
+        "start": start.isoformat(timespec="minutes"),
+        "end": (start + timedelta(seconds=seconds)).isoformat(timespec="minutes"),
+    }
+
+
+def format_duration(seconds: float) -> str:
+    """Format a duration like 5 h 20 min."""
+    minutes = round(seconds / 60)
+    return f"{minutes // 60} h {minutes % 60:02d} min" if minutes >= 60 else f"{minutes} min"
+
+
+def log_estimate(result: dict, end_time: str = RUN_END_TIME) -> None:
+    """Log the estimate, warn if the run finishes after the end time."""
+    end = datetime.fromisoformat(result["end"])
+    logger.info(
+        "Estimated run time %s for %d cells on %d presses with %d mixing steps, finishing at %s.",
+        format_duration(result["run_time_s"]),
+        result["cells"],
+        result["presses"],
+        result["mixing_steps"],
+        end.strftime("%Y-%m-%d %H:%M"),
+    )
+    if not end_time:
+        return
+    start = datetime.fromisoformat(result["start"])
+    closing = datetime.combine(start.date(), parse_time(end_time), tzinfo=start.tzinfo)
+    if end > closing:
+        logger.warning(
+            "The run is estimated to finish at %s, after %s, %s too late.",
+            end.strftime("%H:%M" if end.date() == start.date() else "%Y-%m-%d %H:%M"),
+            end_time,
+            format_duration((end - closing).total_seconds()),
+        )
+
+
+def main(db_path: Path = DATABASE_FILEPATH, start: str | None = None) -> dict:
+    """Log the estimated run time and end of the run, starting now or at a time of day today."""
+    start_time = None
+    if start:
+        tz = pytz.timezone(TIME_ZONE)
+        start_time = tz.localize(datetime.combine(datetime.now(tz).date(), parse_time(start)))
+    result = estimate(db_path, start_time)
+    log_estimate(result)
+    return result
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("estimate")
+    main()
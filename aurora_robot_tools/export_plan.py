@@ -0,0 +1,93 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Export the batch plan as a run sheet for operators.
+
+After capacity balancing and press assignment, the cells to be made are read from the
+Cell_Assembly_Table and written to an Excel and a CSV file in the output folder, named after the base
+sample ID. The Excel file has a second sheet with a summary of the run, including the estimated run
+time and when the run finishes, see estimate.py, which is also logged.
+
+Usage:
+    Called with `aurora-rt export-plan`.
+"""
+
+import logging
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, OUTPUT_DIR
+from aurora_robot_tools.database import get_setting, read_query
+from aurora_robot_tools.estimate import estimate, log_estimate
+
+logger = logging.getLogger(__name__)
+
+PLAN_COLUMNS = [
+    "Cell Number",
+    "Sample ID",
+    "Cell ID",
+    "Batch Number",
+    "Rack Position",
+    "Current Press Number",
+    "Anode Type",
+    "Anode Rack Position",
+    "Anode Balancing Capacity (mAh)",
+    "Cathode Type",
+    "Cathode Rack Position",
+    "Cathode Balancing Capacity (mAh)",
+    "N:P Ratio",
+    "Electrolyte Position",
+    "Electrolyte Amount (uL)",
+    "Bottom Spacer Type",
+    "Top Spacer Type",
+    "Error Code",
+]
+
+
+def read_plan(db_path: Path) -> pd.DataFrame:
+    """Read the cells that are assigned for assembly, sorted by cell number."""
+    df = read_query(db_path, "SELECT * FROM Cell_Assembly_Table WHERE `Cell Number` > 0")
+    columns = [col for col in PLAN_COLUMNS if col in df.columns]
+    return df[columns].sort_values("Cell Number").reset_index(drop=True)
+
+
+def main(db_path: Path = DATABASE_FILEPATH, output_dir: Path = OUTPUT_DIR) -> list[Path]:
+    """Write the batch plan to Excel and CSV files.
+
+    Args:
+        db_path: Path to the robot database
+        output_dir: Folder to write the files to
+
+    Returns:
+        Paths of the files written, empty if no cells are assigned
+
+    """
+    df = read_plan(db_path)
+    if df.empty:
+        logger.info("No cells assigned for assembly, run capacity balancing first. No plan exported.")
+        return []
+
+    run_id = get_setting(db_path, "Base Sample ID") or "plan"
+    output_dir = Path(output_dir)
+    output_dir.mkdir(parents=True, exist_ok=True)
+    xlsx_filepath = output_dir / f"{run_id}_plan.xlsx"
+    csv_filepath = output_dir / f"{run_id}_plan.csv"
+    summary = estimate(db_path)
+    with pd.ExcelWriter(xlsx_filepath) as writer:
+        df.to_excel(writer, sheet_name="Plan", index=False)
+        pd.DataFrame({"Item": list(summary), "Value": list(summary.values())}).to_excel(
+            writer,
+            sheet_name="Summary",
+            index=False,
+        )
+    df.to_csv(csv_filepath, index=False)
+    logger.info("Exported plan for %d cells to %s and %s", len(df), xlsx_filepath, csv_filepath)
+    log_estimate(summary)
+    return [xlsx_filepath, csv_filepath]
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("export-plan")
+    main()
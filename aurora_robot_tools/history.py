@@ -0,0 +1,106 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Record every command that is run, for traceability.
+
+Each aurora-rt invocation adds a row to the Run_History_Table with the command, arguments, relevant
+environment variables, start time, duration, exit code, number of cells changed in the
+Cell_Assembly_Table and the log file. The history is kept in its own database (HISTORY_FILEPATH in
+the config), so it is not lost when a new run is imported into the robot database.
+
+Usage:
+    Recorded automatically, shown with `aurora-rt history`.
+"""
+
+import json
+import logging
+import sqlite3
+from pathlib import Path
+
+from aurora_robot_tools.config import HISTORY_FILEPATH
+
+logger = logging.getLogger(__name__)
+
+CREATE_TABLE = """
+CREATE TABLE IF NOT EXISTS Run_History_Table (
+    `Run ID` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `Command` TEXT,
+    `Arguments` TEXT,
+    `Environment` TEXT,
+    `Database` TEXT,
+    `Dry Run` BOOLEAN,
+    `Started` TEXT,
+    `Duration (s)` REAL,
+    `Exit Code` INTEGER,
+    `Cells Changed` INTEGER,
+    `Log File` TEXT
+)
+"""
+
+
+def record_run(  # noqa: PLR0913
+    command: str,
+    arguments: list[str],
+    environment: dict[str, str],
+    db_path: Path,
+    dry_run: bool,
+    started: str,
+    duration: float,
+    exit_code: int,
+    cells_changed: int,
+    log_file: Path | None,
+    history_path: Path = HISTORY_FILEPATH,
+) -> None:
+    """Add a run to the history, log a warning instead of failing if it can not be written."""
+    try:
+        Path(history_path).parent.mkdir(parents=True, exist_ok=True)
+        with sqlite3.connect(history_path) as conn:
+            conn.execute(CREATE_TABLE)
+            conn.execute(
+                "INSERT INTO Run_History_Table (`Command`, `Arguments`, `Environment`, `Database`, `Dry Run`, "
+                "`Started`, `Duration (s)`, `Exit Code`, `Cells Changed`, `Log File`) "
+                "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+                (
+                    command,
+                    json.dumps(arguments),
+                    json.dumps(environment),
+                    str(db_path),
+                    dry_run,
+                    started,
+                    round(duration, 3),
+                    exit_code,
+                    cells_changed,
+                    str(log_file) if log_file else None,
+                ),
+            )
+    except (sqlite3.Error, OSError) as e:
+        logger.warning("Could not record run in history %s: %s", history_path, e)
+
+
+def read_history(limit: int = 20, command: str | None = None, history_path: Path = HISTORY_FILEPATH) -> list[tuple]:
+    """Read the most recent runs, newest first, optionally only one command."""
+    if not Path(history_path).exists():
+        return []
+    query = (
+        "SELECT `Run ID`, `Started`, `Command`, `Arguments`, `Dry Run`, `Duration (s)`, `Exit Code`, `Cells Changed` "
+        "FROM Run_History_Table"
+    )
+    params: tuple = ()
+    if command:
+        query += " WHERE `Command` = ?"
+        params = (command,)
+    query += " ORDER BY `Run ID` DESC LIMIT ?"
+    with sqlite3.connect(history_path) as conn:
+        return conn.execute(query, (*params, limit)).fetchall()
+
+
+def main(limit: int = 20, command: str | None = None, history_path: Path = HISTORY_FILEPATH) -> None:
+    """Print the most recent runs."""
+    rows = read_history(limit, command, history_path)
+    if not rows:
+        logger.info("No runs recorded in %s", history_path)
+        return
+    lines = [f"{'ID':>5}  {'Started':<25} {'Command':<14} {'Exit':>4} {'Cells':>5} {'Time (s)':>8}  Arguments"]
+    for run_id, started, cmd, arguments, dry_run, duration, exit_code, cells in rows:
+        args = " ".join(json.loads(arguments)) + (" (dry run)" if dry_run else "")
+        lines.append(f"{run_id:>5}  {started:<25} {cmd:<14} {exit_code:>4} {cells:>5} {duration:>8.1f}  {args}")
+    logger.info("\n".join(lines))
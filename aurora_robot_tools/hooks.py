@@ -0,0 +1,133 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Run user-defined commands before and after aurora-rt commands.
+
+HOOKS_BEFORE and HOOKS_AFTER in the config map a command name, or "*" for every command, to a
+command line, e.g. to back up the database with a lab script before balancing and refresh a
+dashboard after assignment:
+
+    [hooks_before]
+    balance = "C:/Scripts/backup.bat"
+
+    [hooks_after]
+    assign = "python C:/Scripts/refresh_dashboard.py"
+
+"python" runs the interpreter of the tools' environment if there is one, see pyenv.py. A hook for
+the command runs after one for "*". The hook gets the run in environment variables:
+    - AURORA_HOOK: "before" or "after"
+    - AURORA_HOOK_COMMAND, AURORA_HOOK_ARGUMENTS: the command, and all arguments as a JSON list
+    - AURORA_HOOK_DATABASE, AURORA_HOOK_RUN_ID, AURORA_HOOK_ROBOT, AURORA_HOOK_LOG_FILE: the
+      database, its base sample ID, the robot profile and the log file of the command
+    - After the command also AURORA_HOOK_EXIT_CODE, AURORA_HOOK_ERROR, AURORA_HOOK_DURATION_S and
+      AURORA_HOOK_CELLS_CHANGED
+
+Before hooks run once the database is locked, so they see the database the command starts from,
+and a before hook that fails or takes longer than HOOK_TIMEOUT_S stops the command. After hooks run
+once the lock is released, also when the command failed, and only log a warning if they fail.
+Hooks do not run on a dry run, and not for aurora-rt commands started by a hook. Anything a hook
+prints is logged.
+
+Usage:
+    Set HOOKS_BEFORE or HOOKS_AFTER in the config, run by `aurora-rt`.
+"""
+
+import json
+import logging
+import os
+import shlex
+import subprocess
+import sys
+from pathlib import Path
+
+from aurora_robot_tools import config
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+
+logger = logging.getLogger(__name__)
+
+
+def hook_commands(stage: str, command: str) -> list[str]:
+    """The hooks of a command, the one for every command first."""
+    hooks = config.HOOKS_BEFORE if stage == "before" else config.HOOKS_AFTER
+    return [hooks[key] for key in ("*", command) if hooks.get(key)]
+
+
+def hook_environment(stage: str, command: str, db_path: Path, log_file: Path | None, **result: object) -> dict:
+    """The environment of a hook, with the run in AURORA_HOOK_* variables."""
+    from aurora_robot_tools.notify import read_base_sample_id
+
+    run = {
+        "COMMAND": command,
+        "ARGUMENTS": json.dumps(sys.argv[1:]),
+        "DATABASE": str(db_path),
+        "RUN_ID": read_base_sample_id(db_path) or "",
+        "ROBOT": config.ROBOT,
+        "LOG_FILE": str(log_file or ""),
+        **{key.upper(): "" if value is None else str(value) for key, value in result.items()},
+    }
+    return {**os.environ, "AURORA_HOOK": stage, **{f"AURORA_HOOK_{key}": value for key, value in run.items()}}
+
+
+def run_hook(line: str, env: dict[str, str]) -> None:
+    """Run one hook, raise if it fails."""
+    from aurora_robot_tools.integrity import check_command
+    from aurora_robot_tools.pyenv import resolve_command
+    from aurora_robot_tools.shutdown import run_child
+
+    args = resolve_command(shlex.split(line, posix=os.name != "nt"))
+    check_command(args)
+    try:
+        # Stopped with the command if it is aborted, see shutdown.py
+        result = run_child(args, "", config.HOOK_TIMEOUT_S, env)
+    except FileNotFoundError as e:
+        msg = f"Hook {args[0]} not found."
+        raise EnvironmentProblemError(msg) from e
+    except subprocess.TimeoutExpired as e:
+        msg = f"Hook '{line}' took longer than {config.HOOK_TIMEOUT_S} s."
+        raise ConfigError(msg) from e
+    for output in (result.stdout, result.stderr):
+        for output_line in output.splitlines():
+            logger.info("Hook: %s", output_line)
+    if result.returncode != 0:
+        msg = f"Hook '{line}' exited with code {result.returncode}."
+        raise ConfigError(msg)
+
+
+def run_hooks(  # noqa: PLR0913
+    stage: str,
+    command: str | None,
+    db_path: Path,
+    log_file: Path | None = None,
+    dry_run: bool = False,
+    **result: object,
+) -> None:
+    """Run the hooks of a command before or after it.
+
+    Args:
+        stage: "before" or "after"
+        command: The aurora-rt command
+        db_path: Path to the robot database
+        log_file: Log file of the command
+        dry_run: Only log the hooks that would run
+        result: Exit code, error, duration and cells changed for the after hooks
+
+    """
+    # Hooks that run aurora-rt would otherwise start their own hooks again
+    if command is None or "AURORA_HOOK" in os.environ:
+        return
+    lines = hook_commands(stage, command)
+    if dry_run:
+        for line in lines:
+            logger.info("Dry run, would run the %s hook '%s'.", stage, line)
+        return
+    if not lines:
+        return
+    env = hook_environment(stage, command, db_path, log_file, **result)
+    for line in lines:
+        logger.info("Running the %s hook '%s'", stage, line)
+        if stage == "before":
+            run_hook(line, env)
+            continue
+        try:
+            run_hook(line, env)
+        except (ConfigError, EnvironmentProblemError, OSError) as e:
+            logger.warning("%s", e)
@@ -0,0 +1,177 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Import the cells to assemble from a sample list exported from the ELN.
+
+Instead of typing every cell into the Input Table, the sample list (.xlsx, first sheet, or .csv) has
+one row per cell. Recognised columns, case does not matter:
+    - Cell Name (or Sample Name, Name): stored as the Cell Name of the cell, must be unique
+    - Anode Lot (or Anode, Anode Type) and Cathode Lot (or Cathode, Cathode Type): electrode types
+      from the Component Properties of the template
+    - N:P Ratio Target, N:P Ratio Minimum, N:P Ratio Maximum (or Target N:P Ratio, ...)
+    - Electrolyte: name from the Electrolyte Properties of the template
+    - Rack Position (optional, cells fill the rack in order otherwise) and Batch (or Batch Number)
+    - Allowed Anode Lots, Allowed Cathode Lots and Keep Lots Separate (optional): lot constraints
+      for balancing, see capacity_balance.py
+    - any other column of the Input Table, e.g. Chemistry Preset or Separator Type
+
+The component and electrolyte properties come from a template, a normal input Excel file. Its Input
+Table gives the values of the columns the sample list does not have, e.g. the casing, separator and
+spacers, and rack positions not in the sample list are left empty. The result is imported exactly
+like `aurora-rt import-excel`, the Base Sample ID is the name of the sample list file.
+
+Usage:
+    Called with `aurora-rt import-batch samples.xlsx --template template.xlsx`.
+"""
+
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.capacity_balance import SEPARATE_LOT_OPTIONS, split_lots
+from aurora_robot_tools.config import DATABASE_FILEPATH, RACK_POSITIONS
+from aurora_robot_tools.decimals import convert_text_numbers, read_csv
+from aurora_robot_tools.errors import ConfigError
+from aurora_robot_tools.import_excel import import_input, read_excel
+
+logger = logging.getLogger(__name__)
+
+# ELN sample list column names, lowercase, and the Input Table columns they fill in
+SAMPLE_LIST_COLUMNS = {
+    "cell name": "Cell Name",
+    "sample name": "Cell Name",
+    "name": "Cell Name",
+    "anode lot": "Anode Type",
+    "anode": "Anode Type",
+    "cathode lot": "Cathode Type",
+    "cathode": "Cathode Type",
+    "target n:p ratio": "N:P Ratio Target",
+    "minimum n:p ratio": "N:P Ratio Minimum",
+    "maximum n:p ratio": "N:P Ratio Maximum",
+    "electrolyte": "Electrolyte",
+    "batch": "Batch Number",
+    "allowed anode lots": "Allowed Anode Lots",
+    "allowed cathode lots": "Allowed Cathode Lots",
+    "keep lots separate": "Keep Lots Separate",
+}
+
+# Columns describing a cell, cleared for rack positions that are not in the sample list
+CELL_COLUMNS = ["Cell Name", "Anode Type", "Cathode Type", "Batch Number", "Chemistry Preset"]
+
+
+def read_sample_list(path: Path, input_columns: list[str]) -> pd.DataFrame:
+    """Read the sample list and rename its columns to the Input Table columns."""
+    path = Path(path)
+    if not path.is_file():
+        msg = f"Sample list {path} not found."
+        raise ConfigError(msg)
+    if path.suffix.lower() == ".csv":
+        # Separator and decimal comma are guessed, ELN exports use commas or semicolons
+        df = read_csv(path)
+    elif path.suffix.lower() in (".xlsx", ".xls"):
+        df = pd.read_excel(path)
+    else:
+        msg = f"Sample list must be a .xlsx or .csv file, got {path.name}."
+        raise ConfigError(msg)
+    df = df.dropna(how="all")
+    known = {col.lower(): col for col in input_columns}
+    renamed = {}
+    for col in df.columns:
+        key = str(col).strip().lower()
+        renamed[col] = known.get(key) or SAMPLE_LIST_COLUMNS.get(key) or str(col).strip()
+    df = df.rename(columns=renamed)
+    if df.columns.duplicated().any():
+        msg = f"Sample list has the same column twice: {', '.join(df.columns[df.columns.duplicated()])}."
+        raise ConfigError(msg)
+    if path.suffix.lower() != ".csv":
+        (df,) = convert_text_numbers((df,), path.name, exclude=("Cell Name", "Bottom Spacer Type", "Top Spacer Type"))
+    return df
+
+
+def validate_sample_list(df: pd.DataFrame, df_components: pd.DataFrame, df_electrolyte: pd.DataFrame) -> None:
+    """Check the sample list can be assembled with the components of the template."""
+    errors = []
+    missing = {"Anode Type", "Cathode Type"} - set(df.columns)
+    if missing:
+        errors.append(f"missing columns {', '.join(sorted(missing))}")
+    if len(df) > RACK_POSITIONS:
+        errors.append(f"{len(df)} cells, the rack only has {RACK_POSITIONS} positions")
+    if "Cell Name" in df.columns:
+        duplicated = df["Cell Name"][df["Cell Name"].duplicated() & df["Cell Name"].notna()]
+        if not duplicated.empty:
+            errors.append(f"duplicate cell names {', '.join(map(str, duplicated.unique()))}")
+    if "Rack Position" in df.columns:
+        positions = pd.to_numeric(df["Rack Position"], errors="coerce")
+        if positions.isna().any() or not positions.between(1, RACK_POSITIONS).all() or positions.duplicated().any():
+            errors.append(f"rack positions must be unique numbers from 1 to {RACK_POSITIONS}")
+    for electrode in ("Anode", "Cathode"):
+        column = f"{electrode} Type"
+        if column not in df.columns:
+            continue
+        unknown = set(df[column].dropna()) - set(df_components[column].dropna())
+        if f"Allowed {electrode} Lots" in df.columns:
+            constrained = set().union(*df[f"Allowed {electrode} Lots"].map(split_lots))
+            unknown |= constrained - set(df_components[column].dropna().astype(str))
+        if unknown:
+            errors.append(f"{electrode.lower()} lots not in the template: {', '.join(sorted(map(str, unknown)))}")
+    if "Keep Lots Separate" in df.columns:
+        options = set().union(*df["Keep Lots Separate"].map(split_lots))
+        if {option.lower() for option in options} - set(SEPARATE_LOT_OPTIONS):
+            errors.append(f"Keep Lots Separate must be {' and/or '.join(SEPARATE_LOT_OPTIONS)}")
+    if "Electrolyte" in df.columns:
+        unknown = set(df["Electrolyte"].dropna()) - set(df_electrolyte["Name"].dropna())
+        if unknown:
+            errors.append(f"electrolytes not in the template: {', '.join(map(str, sorted(unknown)))}")
+    if errors:
+        msg = "Invalid sample list: " + "; ".join(errors) + "."
+        raise ConfigError(msg)
+
+
+def fill_input_table(df_template: pd.DataFrame, df_samples: pd.DataFrame, df_electrolyte: pd.DataFrame) -> pd.DataFrame:
+    """Put the cells of the sample list into the rack positions of the template Input Table."""
+    df = df_template.copy()
+    for col in CELL_COLUMNS:
+        df[col] = pd.Series(np.nan, index=df.index, dtype=object)
+    df_samples = df_samples.copy()
+    if "Electrolyte" in df_samples.columns:
+        positions = df_electrolyte.drop_duplicates("Name").set_index("Name")["Electrolyte Position"]
+        by_name = df_samples.pop("Electrolyte").map(positions)
+        if "Electrolyte Position" in df_samples.columns:
+            by_name = by_name.fillna(df_samples["Electrolyte Position"])
+        df_samples["Electrolyte Position"] = by_name
+    if "Rack Position" not in df_samples.columns:
+        df_samples["Rack Position"] = range(1, len(df_samples) + 1)
+    df_samples["Rack Position"] = df_samples["Rack Position"].astype(int)
+    for col in df_samples.columns:
+        if col not in df.columns:
+            df[col] = np.nan
+        if df[col].dtype != df_samples[col].dtype:
+            df[col] = df[col].astype(object)
+    # Empty values in the sample list keep the template value
+    df = df.set_index("Rack Position")
+    df.update(df_samples.set_index("Rack Position"))
+    logger.info("Filled %d of %d rack positions from the sample list.", len(df_samples), len(df))
+    return df.reset_index().infer_objects()
+
+
+def main(
+    sample_list: Path,
+    template: Path,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Import a sample list using the components and defaults of a template input file.
+
+    Args:
+        sample_list: .xlsx or .csv file with one row per cell
+        template: Input Excel file with the component and electrolyte properties
+        db_path: Path to the robot database
+        dry_run: Check and show the import without writing to the database
+
+    """
+    df_template, df_components, df_electrolyte = read_excel(Path(template))
+    df_samples = read_sample_list(sample_list, df_template.columns.tolist())
+    validate_sample_list(df_samples, df_components, df_electrolyte)
+    df = fill_input_table(df_template, df_samples, df_electrolyte)
+    import_input(Path(sample_list), df, df_components, df_electrolyte, db_path, dry_run)
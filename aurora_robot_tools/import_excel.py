@@ -6,11 +6,15 @@ Users should use the prepared Excel template to input all of the parameters of t
 assembled. The script reads the file, manipulates, and writes to the Chemspeed database which is
 used by the AutoSuite software to assemble the cells.
 
+Parameters that are the same every run can come from a chemistry preset instead, named in the
+optional Chemistry Preset column on any row of a batch. The preset in CHEMISTRY_PRESETS in the config
+fills in the N:P ratios, electrolyte and voltage limits that are left empty for the whole batch.
+
 Usage:
     Run file directly, use the CLI, or call from Autosuite software.
 """
 
-import sqlite3
+import logging
 import warnings
 from pathlib import Path
 from tkinter import Tk, filedialog
@@ -18,11 +22,28 @@ from tkinter import Tk, filedialog
 import numpy as np
 import pandas as pd
 
-from aurora_robot_tools.config import DATABASE_FILEPATH, INPUT_DIR
+from aurora_robot_tools.config import CHEMISTRY_PRESETS, DATABASE_FILEPATH, INPUT_DIR, PRESS_TO_RACK, RACK_POSITIONS
+from aurora_robot_tools.database import write_tables
+from aurora_robot_tools.decimals import convert_text_numbers
+from aurora_robot_tools.migrations import CELL_ASSEMBLY_COLUMNS
+
+logger = logging.getLogger(__name__)
 
 # Ignore the pandas data validation warning
 warnings.filterwarnings("ignore", ".*extension is not supported and will be removed.*")
 
+# Chemistry preset settings and the input columns they fill in
+PRESET_COLUMNS = {
+    "np_ratio_target": "N:P Ratio Target",
+    "np_ratio_minimum": "N:P Ratio Minimum",
+    "np_ratio_maximum": "N:P Ratio Maximum",
+    "electrolyte_position": "Electrolyte Position",
+    "electrolyte_amount_before_separator_ul": "Electrolyte Amount Before Separator (uL)",
+    "electrolyte_amount_after_separator_ul": "Electrolyte Amount After Separator (uL)",
+    "minimum_voltage_v": "Minimum Voltage (V)",
+    "maximum_voltage_v": "Maximum Voltage (V)",
+}
+
 
 def get_input(default: str | Path) -> Path:
     """Open a dialog to select the input file."""
@@ -62,18 +83,24 @@ def read_excel(input_filepath: Path) -> tuple[pd.DataFrame, pd.DataFrame, pd.Dat
             skiprows=1,
         )
     except ValueError:
-        print("CRITICAL: Excel file format not correct. Check your input file and try again.")
+        logger.critical("Excel file format not correct. Check your input file and try again.")
         raise
-    return df, df_components, df_electrolyte
+    # Numbers typed as text in a German Excel, e.g. '3,5'
+    return convert_text_numbers(
+        (df, df_components, df_electrolyte),
+        Path(input_filepath).name,
+        exclude=("Cell Name", "Bottom Spacer Type", "Top Spacer Type"),
+    )
 
 
 def create_aux_tables(input_filepath: Path) -> pd.DataFrame:
     """Create the press, settings and timestamp tables."""
     df_press = pd.DataFrame()
-    df_press["Press Number"] = [1, 2, 3, 4, 5, 6]
+    df_press["Press Number"] = list(PRESS_TO_RACK)
     df_press["Current Cell Number Loaded"] = 0
     df_press["Error Code"] = 0
     df_press["Last Completed Step"] = 0
+    df_press["Loaded Time"] = 0
 
     df_settings = pd.DataFrame()
     df_settings["key"] = ["Input Filepath", "Base Sample ID"]
@@ -84,6 +111,58 @@ def create_aux_tables(input_filepath: Path) -> pd.DataFrame:
     return df_press, df_settings, df_timestamp
 
 
+def preset_values(name: str, preset: dict, df_electrolyte: pd.DataFrame) -> dict:
+    """Get the input column values of a chemistry preset."""
+    values = {}
+    for key, value in preset.items():
+        if key == "electrolyte":
+            # Electrolyte given by name, e.g. "LP57", instead of its position
+            positions = df_electrolyte.loc[df_electrolyte["Name"] == value, "Electrolyte Position"]
+            if positions.empty:
+                msg = f"CRITICAL: Electrolyte '{value}' of chemistry preset '{name}' is not in the input file."
+                raise ValueError(msg)
+            values["Electrolyte Position"] = positions.iloc[0]
+        elif key in PRESET_COLUMNS:
+            values[PRESET_COLUMNS[key]] = value
+        else:
+            msg = f"CRITICAL: Unknown setting '{key}' in chemistry preset '{name}'."
+            raise ValueError(msg)
+    return values
+
+
+def apply_presets(
+    df: pd.DataFrame,
+    df_electrolyte: pd.DataFrame,
+    presets: dict[str, dict] = CHEMISTRY_PRESETS,
+) -> pd.DataFrame:
+    """Fill in empty values from the chemistry preset of each batch."""
+    if "Chemistry Preset" not in df.columns:
+        return df
+    names = df["Chemistry Preset"]
+    df["Chemistry Preset"] = names.where(names.isna(), names.astype(str).str.strip()).replace("", np.nan)
+    # The preset can be given on any row of the batch
+    for batch, names in df.dropna(subset=["Chemistry Preset"]).groupby("Batch Number")["Chemistry Preset"]:
+        if names.nunique() > 1:
+            msg = f"CRITICAL: Batch {batch} has more than one chemistry preset: {', '.join(names.unique())}."
+            raise ValueError(msg)
+        df.loc[df["Batch Number"] == batch, "Chemistry Preset"] = names.iloc[0]
+    unknown = set(df["Chemistry Preset"].dropna()) - set(presets)
+    if unknown:
+        msg = (
+            f"CRITICAL: Unknown chemistry presets {', '.join(sorted(unknown))}, "
+            f"must be one of {', '.join(presets) or 'none'} from CHEMISTRY_PRESETS in the config."
+        )
+        raise ValueError(msg)
+    for name, rows in df.groupby("Chemistry Preset").groups.items():
+        for column, value in preset_values(name, presets[name], df_electrolyte).items():
+            if column not in df.columns:
+                df[column] = np.nan
+            empty = df.loc[rows, column].isna()
+            df.loc[empty.index[empty], column] = value
+        logger.info("Used chemistry preset %s for %d rows.", name, len(rows))
+    return df
+
+
 def merge_electrolyte(df: pd.DataFrame, df_electrolyte: pd.DataFrame) -> pd.DataFrame:
     """Merge electrolyte details into the main dataframe based on electrolyte position."""
     df["Electrolyte Name"] = df["Electrolyte Position"].map(
@@ -152,10 +231,12 @@ def add_extra_columns(df: pd.DataFrame) -> pd.DataFrame:
     df["Anode Mass (mg)"] = 0
     df["Anode Active Material Mass (mg)"] = 0
     df["Anode Balancing Capacity (mAh)"] = 0
+    df["Anode Areal Capacity (mAh/cm2)"] = 0
     df["Anode Rack Position"] = 0
     df["Cathode Mass (mg)"] = 0
     df["Cathode Active Material Mass (mg)"] = 0
     df["Cathode Balancing Capacity (mAh)"] = 0
+    df["Cathode Areal Capacity (mAh/cm2)"] = 0
     df["Cathode Rack Position"] = 0
     df["N:P ratio overlap factor"] = 0
     df["N:P Ratio"] = 0
@@ -165,6 +246,7 @@ def add_extra_columns(df: pd.DataFrame) -> pd.DataFrame:
     df["Error Code"] = 0
     df["Barcode"] = ""
     df["Sample ID"] = ""
+    df["Cell State"] = "planned"
 
     # First filling of anode and cathode positions
     df.loc[df["Anode Type"].notna(), "Anode Rack Position"] = df["Rack Position"]
@@ -172,6 +254,11 @@ def add_extra_columns(df: pd.DataFrame) -> pd.DataFrame:
     df.loc[df["Anode Type"].notna(), "Anode Mass (mg)"] = 0
     df.loc[df["Cathode Type"].notna(), "Cathode Mass (mg)"] = 0
 
+    # The table is replaced, so it needs every column a migrated database has, e.g. Crimp Force (N)
+    for column in CELL_ASSEMBLY_COLUMNS:
+        if column not in df.columns:
+            df[column] = None
+
     return df
 
 
@@ -226,12 +313,13 @@ def sanity_check(df: pd.DataFrame) -> None:
         raise ValueError(msg)
 
     if (df["Electrolyte Amount (uL)"] > 150).any():
-        print(
-            f"WARNING: your input has large electrolyte volumes up to {max(df['Electrolyte Amount (uL)'])} uL.",
+        logger.warning(
+            "Your input has large electrolyte volumes up to %s uL.",
+            max(df["Electrolyte Amount (uL)"]),
         )
 
-    if any(df["Rack Position"].to_numpy() != np.arange(1, 37)):
-        msg = "CRITICAL: Rack positions must be sequential 1-36. Check the input file."
+    if any(df["Rack Position"].to_numpy() != np.arange(1, RACK_POSITIONS + 1)):
+        msg = f"CRITICAL: Rack positions must be sequential 1-{RACK_POSITIONS}. Check the input file."
         raise ValueError(msg)
 
     if any((df["Top Spacer Thickness (mm)"] + df["Bottom Spacer Thickness (mm)"]) > 2.0):
@@ -254,95 +342,87 @@ def write_to_sql(
     df_electrolyte: pd.DataFrame,
     df_settings: pd.DataFrame,
     df_timestamp: pd.DataFrame,
+    dry_run: bool = False,
+    df_spacer: pd.DataFrame | None = None,
 ) -> None:
     """Write the dataframes to an SQLite3 database to be used by the robot."""
-    with sqlite3.connect(db_path) as conn:
-        df.to_sql(
-            "Cell_Assembly_Table",
-            conn,
-            index=False,
-            if_exists="replace",
-            dtype={
-                "Anode Rack Position": "INTEGER",
-                "Cathode Rack Position": "INTEGER",
-                "Cell Number": "INTEGER",
-                "Last Completed Step": "INTEGER",
-                "Current Press Number": "INTEGER",
-                "Error Code": "INTEGER",
-                "Casing Type": "TEXT",
-                "Barcode": "TEXT",
-                "Batch Number": "INTEGER",
-            },
-        )
-        df_press.to_sql(
-            "Press_Table",
-            conn,
-            index=False,
-            if_exists="replace",
-            dtype=dict.fromkeys(df_press.columns, "INTEGER"),
-        )
-        electrolyte_dtype = dict.fromkeys(df_electrolyte.columns, "REAL")
-        electrolyte_dtype["Electrolyte Position"] = "INTEGER"
-        electrolyte_dtype["Name"] = "TEXT"
-        electrolyte_dtype["Description"] = "TEXT"
-        df_electrolyte.to_sql(
-            "Electrolyte_Table",
-            conn,
-            index=False,
-            if_exists="replace",
-            dtype=electrolyte_dtype,
-        )
-        df_settings.to_sql(
-            "Settings_Table",
-            conn,
-            index=False,
-            if_exists="replace",
-            dtype={"key": "TEXT", "value": "TEXT"},
-        )
-        df_timestamp.to_sql(
-            "Timestamp_Table",
-            conn,
-            index=False,
-            if_exists="replace",
-            dtype={
+    electrolyte_dtype = dict.fromkeys(df_electrolyte.columns, "REAL")
+    electrolyte_dtype["Electrolyte Position"] = "INTEGER"
+    electrolyte_dtype["Name"] = "TEXT"
+    electrolyte_dtype["Description"] = "TEXT"
+    df_calibration = pd.DataFrame(
+        columns=["Cell Number", "Step Number", "dx_mm", "dy_mm"],
+    )
+    if df_spacer is None:
+        df_spacer = pd.DataFrame(columns=["Spacer Type", "Spacer Thickness (mm)"])
+    write_tables(
+        db_path,
+        {
+            "Cell_Assembly_Table": df,
+            "Press_Table": df_press,
+            "Electrolyte_Table": df_electrolyte,
+            "Settings_Table": df_settings,
+            "Timestamp_Table": df_timestamp,
+            "Calibration_Table": df_calibration,
+            "Spacer_Table": df_spacer,
+        },
+        dtypes={
+            "Cell_Assembly_Table": {col: t for col, t in CELL_ASSEMBLY_COLUMNS.items() if col in df.columns},
+            "Press_Table": dict.fromkeys(df_press.columns, "INTEGER"),
+            "Electrolyte_Table": electrolyte_dtype,
+            "Settings_Table": {"key": "TEXT", "value": "TEXT"},
+            "Timestamp_Table": {
                 "Cell Number": "INTEGER",
                 "Step Number": "INTEGER",
                 "Timestamp": "VARCHAR(255)",
                 "Complete": "BOOLEAN",
             },
-        )
-        df_calibration = pd.DataFrame(
-            columns=["Cell Number", "Step Number", "dx_mm", "dy_mm"],
-        )
-        df_calibration.to_sql(
-            "Calibration_Table",
-            conn,
-            index=False,
-            if_exists="replace",
-            dtype={
+            "Calibration_Table": {
                 "Cell Number": "INTEGER",
                 "Step Number": "INTEGER",
                 "dx_mm": "REAL",
                 "dy_mm": "REAL",
             },
-        )
+            "Spacer_Table": {"Spacer Type": "TEXT", "Spacer Thickness (mm)": "REAL"},
+        },
+        create=True,
+        dry_run=dry_run,
+    )
 
 
-def main() -> None:
-    """Read in excel input, manipulate, and write to sql database."""
-    input_filepath = get_input(INPUT_DIR)
-    df, df_components, df_electrolyte = read_excel(input_filepath)
+def import_input(  # noqa: PLR0913
+    input_filepath: Path,
+    df: pd.DataFrame,
+    df_components: pd.DataFrame,
+    df_electrolyte: pd.DataFrame,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Merge the input table with the component and electrolyte properties, write to sql database."""
     df_press, df_settings, df_timestamp = create_aux_tables(input_filepath)
+    df = apply_presets(df, df_electrolyte)
     df = merge_electrolyte(df, df_electrolyte)
     df = merge_electrodes(df, df_components)
     df = merge_other_components(df, df_components)
     df = add_extra_columns(df)
     df = reorder_df(df)
-    print("Successfully read and manipulated the Excel file.")
+    logger.info("Successfully read and manipulated the Excel file.")
     sanity_check(df)
-    write_to_sql(Path(DATABASE_FILEPATH), df, df_press, df_electrolyte, df_settings, df_timestamp)
-    print("Successfully updated the database.")
+    df_spacer = df_components[["Spacer Type", "Spacer Thickness (mm)"]].dropna()
+    write_to_sql(Path(db_path), df, df_press, df_electrolyte, df_settings, df_timestamp, dry_run, df_spacer)
+    if not dry_run:
+        logger.info("Successfully updated the database.")
+
+
+def main(db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
+    """Read in excel input, manipulate, and write to sql database."""
+    input_filepath = get_input(INPUT_DIR)
+    df, df_components, df_electrolyte = read_excel(input_filepath)
+    import_input(input_filepath, df, df_components, df_electrolyte, db_path, dry_run)
 
 
 if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("import-excel")
     main()
@@ -0,0 +1,112 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Check the scripts that are run have not been edited on the robot PC.
+
+A quick fix made directly on the robot PC silently diverges from git, and the next person to look at
+a failed run has no idea the code is different. Before a command runs, the SHA-256 hash of every
+Python file of the installed package is compared with the hash pip recorded when it was installed
+(editable installs, e.g. `pip install -e`, have no hashes and are not checked). Companion scripts
+that are run by the tools, e.g. the balancing plugin, are checked before they are started against
+the hashes in SCRIPT_HASHES in the config:
+
+    [script_hashes]
+    "C:/Modules/Plugins/my_pairing.py" = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+A modified file stops the command with exit code 40, or only logs a warning with `--allow-modified`.
+
+Usage:
+    Used by `aurora-rt` for every command, `aurora-rt hash-script FILE` gives the line to put in the
+    config for a companion script.
+"""
+
+import base64
+import hashlib
+import logging
+from importlib import metadata
+from pathlib import Path
+
+from aurora_robot_tools.config import SCRIPT_HASHES
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+
+logger = logging.getLogger(__name__)
+
+DISTRIBUTION = "aurora-robot-tools"
+
+allow_modified = False  # Set by `--allow-modified`, only warn about modified files
+
+
+def file_hash(path: Path) -> str:
+    """SHA-256 hash of a file as a hex string."""
+    return hashlib.sha256(Path(path).read_bytes()).hexdigest()
+
+
+def modified_package_files() -> list[Path]:
+    """Python files of the installed package that differ from the hashes recorded by pip."""
+    try:
+        files = metadata.distribution(DISTRIBUTION).files or []
+    except metadata.PackageNotFoundError:
+        logger.debug("%s is not installed, not checking its files", DISTRIBUTION)
+        return []
+    modified = []
+    for file in files:
+        if file.suffix != ".py" or file.hash is None or file.hash.mode != "sha256":
+            continue
+        path = Path(file.locate())
+        try:
+            digest = hashlib.sha256(path.read_bytes()).digest()
+        except OSError:
+            modified.append(path)
+            continue
+        if base64.urlsafe_b64encode(digest).rstrip(b"=").decode() != file.hash.value:
+            modified.append(path)
+    return modified
+
+
+def modified_scripts(args: list[str]) -> list[Path]:
+    """Companion scripts in a command line that differ from the hashes in SCRIPT_HASHES."""
+    expected = {Path(path).resolve(): digest.lower() for path, digest in SCRIPT_HASHES.items()}
+    modified = []
+    for arg in args:
+        path = Path(arg).resolve()
+        if path not in expected:
+            continue
+        try:
+            if file_hash(path) != expected[path]:
+                modified.append(path)
+        except OSError:
+            modified.append(path)
+    return modified
+
+
+def report(modified: list[Path]) -> None:
+    """Stop the command if files were modified, or warn with --allow-modified."""
+    if not modified:
+        return
+    files = ", ".join(str(path) for path in modified)
+    if allow_modified:
+        logger.warning("Running modified files: %s", files)
+        return
+    msg = (
+        f"These files were modified on this PC and differ from the installed version: {files}. "
+        "Reinstall them from git, or run with --allow-modified."
+    )
+    raise EnvironmentProblemError(msg)
+
+
+def check_package() -> None:
+    """Check the files of the installed package."""
+    report(modified_package_files())
+
+
+def check_command(args: list[str]) -> None:
+    """Check the companion scripts of a command before it is started."""
+    report(modified_scripts(args))
+
+
+def hash_script(path: Path) -> str:
+    """Get the SCRIPT_HASHES line for a companion script."""
+    path = Path(path)
+    if not path.is_file():
+        msg = f"{path} not found."
+        raise ConfigError(msg)
+    return f'"{path.resolve().as_posix()}" = "{file_hash(path)}"'
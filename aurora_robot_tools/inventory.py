@@ -0,0 +1,107 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Track the inventory of punched electrodes in the racks.
+
+The Electrode_Inventory_Table has one row per anode and cathode in the rack, with its type, rack
+position, mass, thickness and status:
+    Unused  - Not assigned to a cell
+    Planned - Assigned to a cell that has not started assembly
+    Used    - Assigned to a cell that has started assembly
+
+The table is rebuilt from the Cell_Assembly_Table every time capacity balancing runs, so it follows
+the planned cells. A warning is given for any batch where one electrode type has run out while the
+other still has unused electrodes, since those can not be made into cells.
+
+Usage:
+    Updated automatically by `aurora-rt balance`, can be refreshed and summarised with
+    `aurora-rt inventory`.
+"""
+
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.database import read_tables, write_tables
+
+logger = logging.getLogger(__name__)
+
+INVENTORY_TABLE = "Electrode_Inventory_Table"
+INVENTORY_DTYPES = {
+    "Electrode": "TEXT",
+    "Type": "TEXT",
+    "Batch Number": "INTEGER",
+    "Rack Position": "INTEGER",
+    "Mass (mg)": "REAL",
+    "Thickness (mm)": "REAL",
+    "Status": "TEXT",
+    "Cell Number": "INTEGER",
+}
+
+
+def build_inventory(df: pd.DataFrame) -> pd.DataFrame:
+    """Build the electrode inventory from the cell assembly table."""
+    inventories = []
+    for electrode in ["Anode", "Cathode"]:
+        has_electrode = df[f"{electrode} Type"].notna() & (df[f"{electrode} Rack Position"] > 0)
+        df_electrode = df[has_electrode]
+        thickness_column = f"{electrode} Thickness (mm)"
+        status = np.where(
+            df_electrode["Cell Number"] > 0,
+            np.where(df_electrode["Last Completed Step"] > 0, "Used", "Planned"),
+            "Unused",
+        )
+        inventories.append(
+            pd.DataFrame(
+                {
+                    "Electrode": electrode,
+                    "Type": df_electrode[f"{electrode} Type"].to_numpy(),
+                    "Batch Number": df_electrode["Batch Number"].to_numpy(),
+                    "Rack Position": df_electrode[f"{electrode} Rack Position"].to_numpy().astype(int),
+                    "Mass (mg)": df_electrode[f"{electrode} Mass (mg)"].to_numpy(),
+                    "Thickness (mm)": (
+                        df_electrode[thickness_column].to_numpy() if thickness_column in df.columns else np.nan
+                    ),
+                    "Status": status,
+                    "Cell Number": df_electrode["Cell Number"].to_numpy().astype(int),
+                },
+            ),
+        )
+    return pd.concat(inventories, ignore_index=True).sort_values(["Electrode", "Rack Position"], ignore_index=True)
+
+
+def warn_if_running_out(inventory: pd.DataFrame) -> None:
+    """Warn for batches where one electrode type has run out but the other has not."""
+    unused = inventory[inventory["Status"] == "Unused"]
+    for batch_number in inventory["Batch Number"].dropna().unique():
+        batch = unused[unused["Batch Number"] == batch_number]
+        n_anodes = (batch["Electrode"] == "Anode").sum()
+        n_cathodes = (batch["Electrode"] == "Cathode").sum()
+        if n_anodes == 0 and n_cathodes > 0:
+            logger.warning("Batch %s has run out of anodes, %d cathodes left unused.", batch_number, n_cathodes)
+        elif n_cathodes == 0 and n_anodes > 0:
+            logger.warning("Batch %s has run out of cathodes, %d anodes left unused.", batch_number, n_anodes)
+
+
+def log_summary(inventory: pd.DataFrame) -> None:
+    """Log the number of electrodes of each type and status."""
+    summary = inventory.groupby(["Electrode", "Type", "Status"]).size().unstack(fill_value=0)
+    logger.info("Electrode inventory:\n%s", summary.to_string())
+
+
+def main(db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
+    """Rebuild the electrode inventory from the cell assembly table and log a summary."""
+    (df,) = read_tables(db_path, "Cell_Assembly_Table")
+    inventory = build_inventory(df)
+    log_summary(inventory)
+    warn_if_running_out(inventory)
+    write_tables(db_path, {INVENTORY_TABLE: inventory}, dtypes={INVENTORY_TABLE: INVENTORY_DTYPES}, dry_run=dry_run)
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("inventory")
+    main()
@@ -0,0 +1,103 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Local TCP listener for job requests, similar to the camera daemon.
+
+Scripts on the AutoSuite side connect to 127.0.0.1 on JOB_PORT and send one JSON request on one line,
+e.g.
+
+    {"command": "balance", "mode": 3}
+
+and receive one JSON line back with the result, same as in `jobs.py`, after which the connection is
+closed. Requests are handled one at a time, a changed config file is reloaded before the next one.
+
+Usage:
+    Start the listener with `aurora-rt listen`, send a request with
+    `aurora-rt send-job '{"command": "balance", "mode": 3}'` or by connecting to the port.
+"""
+
+import json
+import logging
+import socket
+from pathlib import Path
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, JOB_PORT
+from aurora_robot_tools.config_watch import ConfigWatcher
+from aurora_robot_tools.errors import AbortedError, ConfigError, EnvironmentProblemError, ExitCode
+from aurora_robot_tools.jobs import job_arguments, run_job
+
+logger = logging.getLogger(__name__)
+
+MAX_REQUEST_SIZE = 65536
+REQUEST_TIMEOUT = 10.0  # seconds for a client to send its request, so it cannot block the listener
+
+
+def read_line(conn: socket.socket) -> bytes:
+    """Read from a connection until a newline or the client stops sending."""
+    data = b""
+    while b"\n" not in data and len(data) < MAX_REQUEST_SIZE:
+        chunk = conn.recv(4096)
+        if not chunk:
+            break
+        data += chunk
+    return data.split(b"\n", 1)[0]
+
+
+def handle_request(data: bytes, db_path: Path, dry_run: bool) -> dict:
+    """Parse a request and run the job."""
+    try:
+        request = json.loads(data)
+        if not isinstance(request, dict):
+            msg = "Request must be a JSON object"
+            raise TypeError(msg)
+        command, args = job_arguments(request, dry_run)
+    except (json.JSONDecodeError, TypeError, ConfigError) as e:
+        return {"ok": False, "exit_code": int(ExitCode.CONFIG_ERROR), "error": f"Invalid request: {e}"}
+    logger.info("Request: %s %s", command, args)
+    return run_job(command, db_path, args)
+
+
+def listen(port: int = JOB_PORT, db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
+    """Listen for job requests until interrupted."""
+    with socket.socket(socket.AF_INET, socket.SOCK_STREAM) as server_socket:
+        server_socket.bind(("127.0.0.1", port))
+        server_socket.listen(1)
+        logger.info("Listening for job requests on port %d", port)
+        config_watcher = ConfigWatcher()
+        try:
+            while True:
+                conn, addr = server_socket.accept()
+                # A client that sends nothing or goes away must not stop the listener
+                try:
+                    with conn:
+                        logger.info("Connection from %s", addr)
+                        conn.settimeout(REQUEST_TIMEOUT)
+                        data = read_line(conn)
+                        db_path = config_watcher.reload_if_changed(db_path)
+                        result = handle_request(data, db_path, dry_run)
+                        conn.sendall(json.dumps(result, default=str).encode("utf-8") + b"\n")
+                except OSError as e:
+                    logger.warning("Connection from %s failed: %s", addr, e)
+        except (KeyboardInterrupt, AbortedError):
+            logger.info("Listener stopped")
+
+
+def send_job(request: dict, port: int = JOB_PORT) -> dict:
+    """Send a job request to the listener and wait for the result."""
+    with socket.socket(socket.AF_INET, socket.SOCK_STREAM) as client:
+        try:
+            client.connect(("127.0.0.1", port))
+        except ConnectionRefusedError as e:
+            msg = "Job listener not running - start with 'aurora-rt listen'."
+            raise EnvironmentProblemError(msg) from e
+        client.sendall(json.dumps(request).encode("utf-8") + b"\n")
+        data = b""
+        while chunk := client.recv(4096):
+            data += chunk
+    return json.loads(data)
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("listen")
+    listen()
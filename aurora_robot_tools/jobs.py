@@ -0,0 +1,171 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Run tools from a job description instead of command line arguments.
+
+Used by the HTTP server and the drop-folder agent, since AutoSuite can not always pass command line
+arguments. A job is a command name with a dict of the same arguments as the command line, the result
+is a JSON-serialisable dict with the exit code, the messages logged while running, and the cells
+assigned for assembly afterwards.
+"""
+
+import json
+import logging
+from collections.abc import Callable
+from pathlib import Path
+
+from aurora_robot_tools.errors import ConfigError, ExitCode, get_exit_code
+from aurora_robot_tools.lock import acquire_lock
+
+logger = logging.getLogger(__name__)
+
+
+def flag(args: dict, name: str, default: bool) -> bool:
+    """A true or false argument of a job, other JSON values such as "false" are refused."""
+    value = args.get(name, default)
+    if not isinstance(value, bool):
+        msg = f"{name} of a job must be true or false, got {json.dumps(value)}."
+        raise ConfigError(msg)
+    return value
+
+
+def run_balance(db_path: Path, args: dict) -> None:
+    """Run capacity balancing."""
+    from aurora_robot_tools import assembly_order
+    from aurora_robot_tools.capacity_balance import main as balance_main
+
+    assembly_order.strategy = args.get("order")  # Reset for every job, the server keeps running
+    balance_main(
+        int(args.get("mode", 6)),
+        float(args.get("rejection_cost_factor", 2.0)),
+        db_path,
+        args["dry_run"],
+        flag(args, "reject_out_of_spec", False),
+        flag(args, "resume", False),
+    )
+
+
+def run_assign_press(db_path: Path, args: dict) -> None:
+    """Assign cells to presses."""
+    from aurora_robot_tools.assign_cells_to_press import main as assign_main
+
+    assign_main(
+        flag(args, "link", True),
+        int(args.get("limit", 0)),
+        db_path,
+        args["dry_run"],
+        flag(args, "minimize_travel", False),
+    )
+
+
+def run_electrolyte(db_path: Path, args: dict) -> None:
+    """Calculate electrolyte mixing steps."""
+    from aurora_robot_tools.config import ELECTROLYTE_SAFETY_FACTOR
+    from aurora_robot_tools.electrolyte_calculation import main as electrolyte_main
+
+    electrolyte_main(
+        float(args.get("safety_factor", ELECTROLYTE_SAFETY_FACTOR)),
+        db_path,
+        args["dry_run"],
+        flag(args, "resume", False),
+    )
+
+
+def run_commit(db_path: Path, args: dict) -> None:
+    """Approve the staged plan."""
+    from aurora_robot_tools.staging import commit
+
+    commit(db_path, args["dry_run"])
+
+
+def run_press_force(db_path: Path, args: dict) -> None:
+    """Record the peak crimp force of a press."""
+    from aurora_robot_tools.config import PRESS_FORCE_DURATION_S
+    from aurora_robot_tools.press_force import record_force
+
+    record_force(
+        int(args["press"]),
+        float(args.get("duration", PRESS_FORCE_DURATION_S)),
+        db_path,
+        args["dry_run"],
+    )
+
+
+COMMANDS: dict[str, Callable[[Path, dict], None]] = {
+    "balance": run_balance,
+    "assign-press": run_assign_press,
+    "electrolyte": run_electrolyte,
+    "commit": run_commit,
+    "press-force": run_press_force,
+}
+# Commands that change the plan, staged for approval if PLAN_APPROVAL is "required"
+PLAN_COMMANDS = {"balance", "assign-press", "electrolyte"}
+
+
+class MessageCollector(logging.Handler):
+    """Collect the messages logged during one job."""
+
+    def __init__(self) -> None:
+        """Start with no messages."""
+        super().__init__(logging.INFO)
+        self.messages: list[dict[str, str]] = []
+
+    def emit(self, record: logging.LogRecord) -> None:
+        """Store the level, message and the module it came from."""
+        self.messages.append(
+            {"level": record.levelname, "message": record.getMessage(), "source": record.name.rsplit(".", 1)[-1]},
+        )
+
+
+def with_dry_run(args: dict, dry_run: bool) -> dict:
+    """The arguments of a job with its dry run, a service started with --dry-run only runs dry runs."""
+    return {**args, "dry_run": flag(args, "dry_run", False) or dry_run}
+
+
+def job_arguments(request: dict, dry_run: bool) -> tuple[str, dict]:
+    """The command and arguments of a job request, the arguments are in "args" or the other keys."""
+    command = str(request.get("command", ""))
+    args = request.get("args", {key: value for key, value in request.items() if key != "command"})
+    if not isinstance(args, dict):
+        msg = f"The args of a job must be a JSON object, got {json.dumps(args)}."
+        raise ConfigError(msg)
+    return command, with_dry_run(args, dry_run)
+
+
+def run_job(command: str, db_path: Path, args: dict) -> dict:
+    """Run a command, return the result with the exit code, messages and plan."""
+    from aurora_robot_tools.export_plan import read_plan
+    from aurora_robot_tools.staging import approval_required, stage
+
+    if command not in COMMANDS:
+        return {
+            "ok": False,
+            "exit_code": int(ExitCode.CONFIG_ERROR),
+            "error": f"Unknown command '{command}', must be one of {', '.join(COMMANDS)}",
+            "messages": [],
+        }
+    try:
+        args = {**args, "dry_run": flag(args, "dry_run", False)}
+    except ConfigError as e:
+        return {"ok": False, "exit_code": int(ExitCode.CONFIG_ERROR), "error": str(e), "messages": []}
+    collector = MessageCollector()
+    package_logger = logging.getLogger("aurora_robot_tools")
+    package_logger.addHandler(collector)
+    try:
+        plan_db_path = db_path
+        if args["dry_run"]:
+            if command in PLAN_COMMANDS and approval_required():
+                plan_db_path = stage(db_path, dry_run=True)
+            COMMANDS[command](plan_db_path, args)
+        else:
+            with acquire_lock(db_path, command, float(args.get("wait_for_lock", 0))):
+                if command in PLAN_COMMANDS and approval_required():
+                    plan_db_path = stage(db_path)
+                COMMANDS[command](plan_db_path, args)
+        plan = read_plan(plan_db_path).to_dict(orient="records")
+    except Exception as e:
+        exit_code = get_exit_code(e)
+        logger.exception("%s failed (exit code %d)", command, exit_code)
+        return {"ok": False, "exit_code": int(exit_code), "error": str(e), "messages": collector.messages}
+    finally:
+        package_logger.removeHandler(collector)
+    return {"ok": True, "exit_code": 0, "messages": collector.messages, "plan": plan}
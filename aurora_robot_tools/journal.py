@@ -0,0 +1,195 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Journal for operations that write to the database in several steps.
+
+Each write to the database is one transaction, but some operations write several times, e.g.
+rebalancing writes the new pairings and then assigns the presses. If the tool crashes or the PC
+loses power in between, the database is left half-way.
+
+Before such an operation starts, the database is copied next to itself and a journal file records
+the steps. Each step is marked done as it finishes, and both files are removed when the operation
+completes. If a step fails with an error, the database is rolled back to the copy straight away.
+
+If an unfinished journal is found when the next command starts, the command is refused until the
+operation is recovered:
+    - `aurora-rt recover --back` restores the database from the copy, as if it never started
+    - `aurora-rt recover --forward` runs the steps that were not done, only possible if they are
+      all steps that can be re-run by name (the same commands as the job files, see jobs.py)
+    - `--force-clean` rolls it back like `recover --back`, if the process that started it is gone
+
+Usage:
+    Used by the tools, recovered with `aurora-rt recover`.
+"""
+
+import json
+import logging
+import os
+from collections.abc import Iterator
+from contextlib import contextmanager
+from datetime import datetime
+from pathlib import Path
+
+import pytz
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, TIME_ZONE
+from aurora_robot_tools.errors import ConfigError, DatabaseError
+from aurora_robot_tools.shutdown import temporary
+
+logger = logging.getLogger(__name__)
+
+
+def journal_path(db_path: Path) -> Path:
+    """Path of the journal file of a database."""
+    return Path(db_path).with_name(Path(db_path).name + ".journal.json")
+
+
+def snapshot_path(db_path: Path) -> Path:
+    """Path of the copy of the database taken when the operation started."""
+    return Path(db_path).with_name(Path(db_path).name + ".journal.db")
+
+
+def read_journal(db_path: Path) -> dict | None:
+    """Read the unfinished operation of a database, None if there is none."""
+    path = journal_path(db_path)
+    if not path.exists():
+        return None
+    with path.open(encoding="utf-8") as f:
+        return json.load(f)
+
+
+def write_journal(db_path: Path, journal: dict) -> None:
+    """Write the journal, replacing the old one in one step."""
+    path = journal_path(db_path)
+    tmp_path = path.with_suffix(".tmp")
+    with temporary(tmp_path):
+        with tmp_path.open("w", encoding="utf-8") as f:
+            json.dump(journal, f, indent=2)
+        tmp_path.replace(path)
+
+
+def remove_journal(db_path: Path) -> None:
+    """Remove the journal and the copy of the database."""
+    journal_path(db_path).unlink(missing_ok=True)
+    snapshot_path(db_path).unlink(missing_ok=True)
+
+
+class Journal:
+    """Steps of an operation in progress."""
+
+    def __init__(self, db_path: Path, journal: dict) -> None:
+        """Store the database and journal contents."""
+        self.db_path = db_path
+        self.journal = journal
+
+    def done(self, step: int) -> None:
+        """Mark a step as done."""
+        self.journal["steps"][step]["done"] = True
+        write_journal(self.db_path, self.journal)
+
+
+def check_unfinished(db_path: Path) -> None:
+    """Raise an error if the database has an unfinished operation."""
+    journal = read_journal(db_path)
+    if journal is None:
+        return
+    msg = (
+        f"The {journal['operation']} started at {journal['started']} did not finish, the database may be "
+        "inconsistent. Run `aurora-rt recover --back` to undo it or `aurora-rt recover --forward` to finish it."
+    )
+    raise DatabaseError(msg)
+
+
+def clean_unfinished(db_path: Path) -> None:
+    """Roll back an unfinished operation if the command that started it crashed."""
+    from aurora_robot_tools.lock import process_running
+
+    journal = read_journal(db_path)
+    if journal is None:
+        return
+    pid = journal.get("pid")
+    if isinstance(pid, int) and pid != os.getpid() and process_running(pid):
+        return
+    logger.warning(
+        "Rolling back the %s started at %s by process %s, which is no longer running.",
+        journal["operation"],
+        journal["started"],
+        pid or "unknown",
+    )
+    roll_back(db_path)
+
+
+@contextmanager
+def operation(db_path: Path, name: str, steps: list[dict]) -> Iterator[Journal]:
+    """Journal an operation of several steps, roll back if one of them fails.
+
+    Args:
+        db_path: Path to the robot database.
+        name: Name of the operation, e.g. "rebalance".
+        steps: Steps of the operation, each with a "description", and a "command" and "args" if the
+            step can be re-run with the command from jobs.py.
+
+    """
+    from aurora_robot_tools.backup_database import copy_database
+
+    check_unfinished(db_path)
+    copy_database(db_path, snapshot_path(db_path))
+    journal = {
+        "operation": name,
+        "started": datetime.now(pytz.timezone(TIME_ZONE)).isoformat(timespec="seconds"),
+        "pid": os.getpid(),
+        "steps": [{**step, "done": False} for step in steps],
+    }
+    write_journal(db_path, journal)
+    try:
+        yield Journal(db_path, journal)
+    except Exception:
+        logger.error("%s failed, rolling back the database", name.capitalize())
+        roll_back(db_path)
+        raise
+    remove_journal(db_path)
+
+
+def roll_back(db_path: Path) -> None:
+    """Restore the database from the copy taken when the operation started."""
+    from aurora_robot_tools.backup_database import copy_database
+
+    copy_database(snapshot_path(db_path), db_path)
+    remove_journal(db_path)
+    logger.info("Database %s rolled back to before the operation.", db_path)
+
+
+def roll_forward(db_path: Path, journal: dict) -> None:
+    """Run the steps of the operation that were not done."""
+    from aurora_robot_tools.jobs import COMMANDS
+
+    remaining = [i for i, step in enumerate(journal["steps"]) if not step["done"]]
+    not_rerunnable = [
+        journal["steps"][i]["description"] for i in remaining if journal["steps"][i].get("command") not in COMMANDS
+    ]
+    if not_rerunnable:
+        msg = f"Cannot finish the {journal['operation']}, these steps cannot be re-run: {', '.join(not_rerunnable)}."
+        raise ConfigError(msg)
+    for i in remaining:
+        step = journal["steps"][i]
+        logger.info("Running step: %s", step["description"])
+        COMMANDS[step["command"]](db_path, {**step.get("args", {}), "dry_run": False})
+        Journal(db_path, journal).done(i)
+    remove_journal(db_path)
+    logger.info("Finished the %s.", journal["operation"])
+
+
+def recover(db_path: Path = DATABASE_FILEPATH, forward: bool = False, dry_run: bool = False) -> None:
+    """Roll an unfinished operation back or forward."""
+    journal = read_journal(db_path)
+    if journal is None:
+        logger.info("No unfinished operation on %s.", db_path)
+        return
+    for step in journal["steps"]:
+        logger.info("%s: %s", "Done" if step["done"] else "Not done", step["description"])
+    if dry_run:
+        logger.info("Dry run, would roll the %s %s.", journal["operation"], "forward" if forward else "back")
+        return
+    if forward:
+        roll_forward(db_path, journal)
+    else:
+        roll_back(db_path)
@@ -0,0 +1,192 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Generate unique cell IDs and print labels for them.
+
+Every cell in the plan without a Cell ID gets one from CELL_ID_PATTERN in the config, e.g.
+"{project}-{date:%y%m%d}-{chemistry}-{sequence:05d}" gives "AUR-250314-NMC811Gr-00042". The fields
+are:
+    - project: CELL_ID_PROJECT from the config
+    - date: date the ID was reserved
+    - chemistry: cathode type followed by anode type, without spaces or symbols
+    - sequence: counter per project, never reused
+    - sample_id, cell_number, batch: from the Cell_Assembly_Table
+
+IDs are reserved in their own database (CELL_ID_FILEPATH), which is kept between runs like the
+history, so an ID is never given to two cells even if the robot database is replaced. The IDs are
+written to the Cell ID column of the Cell_Assembly_Table.
+
+Labels are written in ZPL for Zebra label printers as a file in the output folder, and sent to the
+printer at the crimper station if LABEL_PRINTER ("host:port", usually port 9100) is set.
+
+Usage:
+    Called with `aurora-rt labels` after balancing, `--reprint` prints the labels of all cells again.
+"""
+
+import logging
+import re
+import socket
+import sqlite3
+import sys
+from datetime import datetime
+from pathlib import Path
+
+import pandas as pd
+import pytz
+
+from aurora_robot_tools.config import (
+    CELL_ID_FILEPATH,
+    CELL_ID_PATTERN,
+    CELL_ID_PROJECT,
+    DATABASE_FILEPATH,
+    LABEL_PRINTER,
+    OUTPUT_DIR,
+    TIME_ZONE,
+)
+from aurora_robot_tools.database import get_setting, read_tables, write_tables
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+
+logger = logging.getLogger(__name__)
+
+CREATE_TABLE = """
+CREATE TABLE IF NOT EXISTS Cell_ID_Table (
+    `Cell ID` TEXT PRIMARY KEY,
+    `Project` TEXT,
+    `Sequence` INTEGER,
+    `Sample ID` TEXT,
+    `Reserved` TEXT
+)
+"""
+
+# 50 x 25 mm label at 203 dpi, QR code of the cell ID with the ID and sample ID as text
+LABEL_TEMPLATE = (
+    "^XA^CI28^PW400^LL200"
+    "^FO10,20^BQN,2,4^FDQA,{cell_id}^FS"
+    "^FO140,40^A0N,24,24^FD{cell_id}^FS"
+    "^FO140,90^A0N,22,22^FD{sample_id}^FS"
+    "^FO140,130^A0N,20,20^FD{chemistry}^FS"
+    "^XZ"
+)
+
+
+def chemistry_code(row: pd.Series) -> str:
+    """Short code of the cell chemistry, cathode then anode type, e.g. NMC811Gr."""
+    return re.sub(r"[^A-Za-z0-9]", "", f"{row['Cathode Type']}{row['Anode Type']}")
+
+
+def format_cell_id(row: pd.Series, sequence: int, date: datetime, pattern: str = CELL_ID_PATTERN) -> str:
+    """Fill in the cell ID pattern for one cell."""
+    try:
+        return pattern.format(
+            project=CELL_ID_PROJECT,
+            date=date,
+            chemistry=chemistry_code(row),
+            sequence=sequence,
+            sample_id=row["Sample ID"],
+            cell_number=int(row["Cell Number"]),
+            batch=int(row["Batch Number"]) if pd.notna(row["Batch Number"]) else 0,
+        )
+    except (KeyError, ValueError) as e:
+        msg = f"Invalid CELL_ID_PATTERN '{pattern}': {e}"
+        raise ConfigError(msg) from e
+
+
+def reserve_cell_ids(df: pd.DataFrame, id_path: Path = CELL_ID_FILEPATH, dry_run: bool = False) -> pd.Series:
+    """Reserve a new cell ID for every planned cell without one.
+
+    Returns:
+        New cell IDs by row index of df
+
+    """
+    cells = df[(df["Cell Number"] > 0) & (df["Cell ID"].isna() | (df["Cell ID"] == ""))]
+    if cells.empty:
+        return pd.Series(dtype=str)
+    now = datetime.now(pytz.timezone(TIME_ZONE))
+    Path(id_path).parent.mkdir(parents=True, exist_ok=True)
+    with sqlite3.connect(id_path) as conn:
+        conn.execute(CREATE_TABLE)
+        last = conn.execute("SELECT MAX(`Sequence`) FROM Cell_ID_Table WHERE `Project` = ?", (CELL_ID_PROJECT,))
+        sequence = (last.fetchone()[0] or 0) + 1
+        cell_ids = {}
+        for i, row in cells.sort_values("Cell Number").iterrows():
+            cell_id = format_cell_id(row, sequence, now)
+            exists = conn.execute("SELECT 1 FROM Cell_ID_Table WHERE `Cell ID` = ?", (cell_id,)).fetchone()
+            if exists or cell_id in cell_ids.values():
+                msg = f"Cell ID {cell_id} is already used, CELL_ID_PATTERN must include the sequence."
+                raise ConfigError(msg)
+            cell_ids[i] = cell_id
+            if not dry_run:
+                conn.execute(
+                    "INSERT INTO Cell_ID_Table VALUES (?, ?, ?, ?, ?)",
+                    (cell_id, CELL_ID_PROJECT, sequence, row["Sample ID"], now.isoformat(timespec="seconds")),
+                )
+            sequence += 1
+    return pd.Series(cell_ids)
+
+
+def make_zpl(df: pd.DataFrame) -> str:
+    """Make the ZPL for one label per cell."""
+    return "\n".join(
+        LABEL_TEMPLATE.format(cell_id=row["Cell ID"], sample_id=row["Sample ID"], chemistry=chemistry_code(row))
+        for _, row in df.sort_values("Cell Number").iterrows()
+    )
+
+
+def send_to_printer(zpl: str, printer: str = LABEL_PRINTER) -> None:
+    """Send ZPL to a network label printer, given as host:port."""
+    host, _, port = printer.rpartition(":")
+    try:
+        with socket.create_connection((host, int(port)), timeout=10) as conn:
+            conn.sendall(zpl.encode("utf-8"))
+    except (OSError, ValueError) as e:
+        msg = f"Could not send labels to printer {printer}: {e}"
+        raise EnvironmentProblemError(msg) from e
+
+
+def main(
+    reprint: bool = False,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+    output_dir: Path = OUTPUT_DIR,
+) -> None:
+    """Reserve cell IDs for the planned cells and print their labels.
+
+    Args:
+        reprint: Print labels for all planned cells, not only the ones that just got an ID
+        db_path: Path to the robot database
+        dry_run: Log the IDs instead of reserving them and printing labels
+        output_dir: Folder to write the ZPL file to
+
+    """
+    (df,) = read_tables(db_path, "Cell_Assembly_Table")
+    if "Cell ID" not in df.columns:
+        df["Cell ID"] = None
+    new_ids = reserve_cell_ids(df, dry_run=dry_run)
+    df.loc[new_ids.index, "Cell ID"] = new_ids
+    logger.info("Reserved %d new cell IDs.", len(new_ids))
+
+    to_print = df[df["Cell Number"] > 0] if reprint else df.loc[new_ids.index]
+    if to_print.empty:
+        logger.info("No labels to print.")
+        return
+    zpl = make_zpl(to_print)
+    if dry_run:
+        logger.info("Dry run, would print labels for:\n%s", "\n".join(to_print["Cell ID"]))
+        return
+    write_tables(db_path, {"Cell_Assembly_Table": df})
+
+    run_id = get_setting(db_path, "Base Sample ID") or "labels"
+    output_dir = Path(output_dir)
+    output_dir.mkdir(parents=True, exist_ok=True)
+    zpl_path = output_dir / f"{run_id}_labels.zpl"
+    zpl_path.write_text(zpl, encoding="utf-8")
+    logger.info("Wrote %d labels to %s", len(to_print), zpl_path)
+    if LABEL_PRINTER:
+        send_to_printer(zpl)
+        logger.info("Sent %d labels to printer %s", len(to_print), LABEL_PRINTER)
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("labels")
+    main(reprint="--reprint" in sys.argv)
@@ -0,0 +1,143 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Tell the operator what to place where before starting the robot.
+
+The load list sums up the consumables the planned cells still need, from the steps each cell has not
+done yet, so it also works for a run that is restarted part-way:
+    - Electrolyte vials: the volume each vial position needs, including the dead and priming volume,
+      from the Electrolyte_Table after `aurora-rt electrolyte`
+    - Bottom and top casings, bottom and top spacers, separators and springs, counted by type
+
+Where each type goes is set with CONSUMABLE_POSITIONS in the config, by type or by item, e.g.
+
+    [consumable_positions]
+    "Celgard 2325" = "Separator magazine 1"
+    "CR2032" = "Casing rack A"
+    "Spring" = "Spring magazine"
+
+LOAD_LIST_SPARES extra of every solid consumable are added, for pick-up failures. Types without a
+position are listed with a warning. The list is logged and written to the output folder as CSV.
+
+Usage:
+    `aurora-rt load-list`, after balancing and the electrolyte calculation.
+"""
+
+import logging
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.config import CONSUMABLE_POSITIONS, DATABASE_FILEPATH, LOAD_LIST_SPARES, OUTPUT_DIR
+from aurora_robot_tools.database import get_setting, read_query
+from aurora_robot_tools.errors import InfeasibleError
+
+logger = logging.getLogger(__name__)
+
+# Consumables placed by the robot: the step that places it, the item, and the column with its type
+CONSUMABLE_STEPS = [
+    (10, "Bottom casing", "Casing Type"),
+    (20, "Bottom spacer", "Bottom Spacer Type"),
+    (60, "Separator", "Separator Type"),
+    (100, "Top spacer", "Top Spacer Type"),
+    (110, "Spring", None),
+    (120, "Top casing", "Casing Type"),
+]
+LOAD_LIST_COLUMNS = ["Item", "Type", "Position", "Quantity", "Unit"]
+
+
+def position(item: str, item_type: str) -> str:
+    """Where the operator places a consumable, empty if it is not in the config."""
+    return CONSUMABLE_POSITIONS.get(item_type) or CONSUMABLE_POSITIONS.get(item) or ""
+
+
+def count_consumables(df: pd.DataFrame, spares: int = LOAD_LIST_SPARES) -> list[dict]:
+    """Count the casings, spacers, separators and springs the cells still need, by type."""
+    rows = []
+    for step, item, column in CONSUMABLE_STEPS:
+        needed = df[df["Last Completed Step"] < step]
+        if column:
+            types = needed[column].dropna().astype(str).str.strip()
+            types = types[types != ""]
+        else:  # Springs have no type
+            types = pd.Series("", index=needed.index)
+        for item_type, count in types.value_counts().sort_index().items():
+            rows.append(
+                {
+                    "Item": item,
+                    "Type": item_type,
+                    "Position": position(item, item_type),
+                    "Quantity": int(count) + spares,
+                    "Unit": "pcs",
+                },
+            )
+    return rows
+
+
+def electrolyte_vials(db_path: Path) -> list[dict]:
+    """The volume of every vial position, empty if the electrolyte has not been calculated."""
+    df = read_query(db_path, "SELECT * FROM Electrolyte_Table")
+    if "Cumulative Volume Required (uL)" not in df.columns:
+        logger.warning("The electrolyte volumes are not calculated yet, run `aurora-rt electrolyte` first.")
+        return []
+    df = df[df["Cumulative Volume Required (uL)"] > 0]
+    return [
+        {
+            "Item": "Electrolyte vial",
+            "Type": row["Name"],
+            "Position": f"Vial {int(row['Electrolyte Position'])}",
+            "Quantity": round(float(row["Cumulative Volume Required (uL)"]), 1),
+            "Unit": "uL",
+        }
+        for _, row in df.sort_values("Electrolyte Position").iterrows()
+    ]
+
+
+def build_load_list(db_path: Path = DATABASE_FILEPATH) -> pd.DataFrame:
+    """Build the load list for the cells that are planned and not finished."""
+    from aurora_robot_tools.assign_cells_to_press import RETURN_STEP
+
+    df = read_query(db_path, "SELECT * FROM Cell_Assembly_Table WHERE `Cell Number` > 0")
+    df = df[(df["Last Completed Step"] < RETURN_STEP) & (df["Error Code"] == 0)]
+    if df.empty:
+        msg = "No cells are planned, run capacity balancing first."
+        raise InfeasibleError(msg)
+    return pd.DataFrame(electrolyte_vials(db_path) + count_consumables(df), columns=LOAD_LIST_COLUMNS)
+
+
+def format_load_list(df: pd.DataFrame) -> str:
+    """Format the load list as aligned lines."""
+    return "\n".join(
+        f"{row['Item']:<17} {row['Type']:<20} {row['Position'] or '?':<24} {row['Quantity']:g} {row['Unit']}"
+        for _, row in df.iterrows()
+    )
+
+
+def main(db_path: Path = DATABASE_FILEPATH, output_dir: Path = OUTPUT_DIR) -> Path:
+    """Log the load list and write it to the output folder.
+
+    Returns:
+        Path of the CSV file
+
+    """
+    df = build_load_list(db_path)
+    unmapped = df[(df["Position"] == "") & (df["Item"] != "Electrolyte vial")]
+    if not unmapped.empty:
+        logger.warning(
+            "No position in CONSUMABLE_POSITIONS for %s, place them by hand.",
+            ", ".join(sorted({f"{row['Item']} {row['Type']}".strip() for _, row in unmapped.iterrows()})),
+        )
+    logger.info("Load list:\n%s", format_load_list(df))
+    run_id = get_setting(db_path, "Base Sample ID") or "plan"
+    output_dir = Path(output_dir)
+    output_dir.mkdir(parents=True, exist_ok=True)
+    csv_filepath = output_dir / f"{run_id}_load_list.csv"
+    df.to_csv(csv_filepath, index=False)
+    logger.info("Wrote the load list to %s", csv_filepath)
+    return csv_filepath
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("load-list")
+    main()
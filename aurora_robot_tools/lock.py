@@ -0,0 +1,156 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Make sure only one command modifies the database at a time.
+
+Commands that write to the database create a lock file next to it, containing the process ID and
+command that holds it. A second command either waits for the lock to be released or exits with the
+already running exit code, rather than both reading and writing the same tables at once.
+
+If a command crashes or is killed, its lock file can be left behind. A lock whose process no longer
+exists is stale: commands warn about it, and `--force-clean` removes it instead of refusing to run.
+"""
+
+import json
+import logging
+import os
+import socket
+import time
+from collections.abc import Iterator
+from contextlib import contextmanager
+from datetime import datetime, timezone
+from pathlib import Path
+
+from aurora_robot_tools.errors import AlreadyRunningError
+from aurora_robot_tools.shutdown import temporary
+
+logger = logging.getLogger(__name__)
+
+POLL_INTERVAL = 0.5  # seconds
+UNREADABLE_STALE_AGE = 60  # seconds, a lock without a process ID older than this is stale
+
+
+def lock_path(db_path: Path) -> Path:
+    """Path of the lock file for a database."""
+    db_path = Path(db_path)
+    return db_path.with_name(db_path.name + ".lock")
+
+
+def read_lock(path: Path) -> dict:
+    """Read the details of the process holding a lock, empty if unreadable."""
+    try:
+        return json.loads(path.read_text(encoding="utf-8"))
+    except (OSError, json.JSONDecodeError):
+        return {}
+
+
+def process_running(pid: int) -> bool:
+    """Check if a process exists, assume it does if that cannot be checked."""
+    if os.name == "nt":
+        import ctypes
+
+        kernel32 = ctypes.WinDLL("kernel32", use_last_error=True)
+        query_limited_information, still_active, access_denied = 0x1000, 259, 5
+        handle = kernel32.OpenProcess(query_limited_information, False, pid)  # noqa: FBT003
+        if not handle:
+            return ctypes.get_last_error() == access_denied
+        exit_code = ctypes.c_ulong()
+        kernel32.GetExitCodeProcess(handle, ctypes.byref(exit_code))
+        kernel32.CloseHandle(handle)
+        return exit_code.value == still_active
+    try:
+        os.kill(pid, 0)
+    except ProcessLookupError:
+        return False
+    except OSError:
+        return True
+    return True
+
+
+def is_stale(path: Path) -> bool:
+    """Check if a lock file was left by a process that no longer exists."""
+    holder = read_lock(path)
+    if not isinstance(holder.get("pid"), int):
+        # Crashed between creating and writing the lock file
+        try:
+            return time.time() - path.stat().st_mtime > UNREADABLE_STALE_AGE
+        except OSError:
+            return False
+    # Processes on other PCs, e.g. for a database on a network share, cannot be checked
+    if holder.get("host", socket.gethostname()) != socket.gethostname():
+        return False
+    return not process_running(holder["pid"])
+
+
+def describe(holder: dict) -> str:
+    """Describe the command holding a lock."""
+    return (
+        f"{holder.get('command', 'unknown')} (process {holder.get('pid', 'unknown')}, "
+        f"started {holder.get('started', 'unknown')})"
+    )
+
+
+def warn_if_stale(db_path: Path) -> None:
+    """Warn if the database has a lock left by a crashed command."""
+    path = lock_path(db_path)
+    if path.exists() and is_stale(path):
+        logger.warning(
+            "The lock of %s was left by %s, which is no longer running. Run with --force-clean to remove it.",
+            db_path,
+            describe(read_lock(path)),
+        )
+
+
+@contextmanager
+def acquire_lock(db_path: Path, command: str, wait: float = 0, force_clean: bool = False) -> Iterator[None]:
+    """Hold the database lock while the context is open.
+
+    Args:
+        db_path: Path to the robot database
+        command: Name of the command, stored in the lock file
+        wait: Seconds to wait for another command to release the lock
+        force_clean: Remove the lock if the process holding it no longer exists
+
+    Raises:
+        AlreadyRunningError: If the lock is still held after waiting, or it is stale and not removed
+
+    """
+    path = lock_path(db_path)
+    deadline = time.monotonic() + wait
+    logged_wait = False
+    while True:
+        try:
+            fd = os.open(path, os.O_CREAT | os.O_EXCL | os.O_WRONLY)
+        except FileExistsError:
+            holder = read_lock(path)
+            if is_stale(path):
+                if not force_clean:
+                    msg = (
+                        f"The database is locked by {describe(holder)}, which is no longer running. "
+                        "Run with --force-clean to remove the lock."
+                    )
+                    raise AlreadyRunningError(msg) from None
+                # Another command may have removed it and taken the lock in the meantime
+                if read_lock(path) == holder:
+                    logger.warning("Removing the lock left by %s, which is no longer running.", describe(holder))
+                    path.unlink(missing_ok=True)
+                continue
+            if time.monotonic() >= deadline:
+                msg = f"Another command is already using the database: {describe(holder)}."
+                raise AlreadyRunningError(msg) from None
+            if not logged_wait:
+                logger.info("Waiting for %s to finish", holder.get("command", "another command"))
+                logged_wait = True
+            time.sleep(POLL_INTERVAL)
+            continue
+        with os.fdopen(fd, "w", encoding="utf-8") as f:
+            holder = {
+                "pid": os.getpid(),
+                "host": socket.gethostname(),
+                "command": command,
+                "started": datetime.now(timezone.utc).isoformat(),
+            }
+            json.dump(holder, f)
+        break
+    # Also removed if the command has to exit without unwinding, see shutdown.py
+    with temporary(path):
+        yield
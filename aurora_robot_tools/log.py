@@ -0,0 +1,159 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Logging for the robot tools.
+
+Messages are printed to stdout so they still appear in the AutoSuite console, and every run also
+writes a log file with one JSON object per line to the log directory, so there is an audit trail
+when a batch fails part-way through assembly.
+
+Everything written to stdout and stderr, including output that does not go through logging, is also
+copied to separate .stdout.txt and .stderr.txt files. Only the files from the most recent
+LOG_KEEP_OUTPUT_RUNS runs are kept.
+
+The JSON records can also be shipped to a central syslog or Elasticsearch server, see
+log_shipping.py.
+"""
+
+import json
+import logging
+import sys
+from datetime import datetime
+from pathlib import Path
+from typing import TextIO
+
+import pytz
+
+from aurora_robot_tools.config import LOG_DIR, LOG_KEEP_OUTPUT_RUNS, TIME_ZONE
+
+logger = logging.getLogger("aurora_robot_tools")
+
+
+class ConsoleFormatter(logging.Formatter):
+    """Plain messages, prefixed with the level for warnings and errors.
+
+    Tracebacks are left out, they are only written to the log file.
+    """
+
+    def format(self, record: logging.LogRecord) -> str:
+        """Format the record as a human-readable line."""
+        message = record.getMessage()
+        if record.levelno >= logging.WARNING:
+            return f"{record.levelname}: {message}"
+        return message
+
+
+class JSONFormatter(logging.Formatter):
+    """One JSON object per record."""
+
+    def __init__(self, run_id: str, command: str) -> None:
+        """Store the run details added to every record."""
+        super().__init__()
+        self.run_id = run_id
+        self.command = command
+
+    def format(self, record: logging.LogRecord) -> str:
+        """Format the record as a JSON string."""
+        entry = {
+            "time": datetime.fromtimestamp(record.created, pytz.timezone(TIME_ZONE)).isoformat(),
+            "level": record.levelname,
+            "logger": record.name,
+            "message": record.getMessage(),
+            "run_id": self.run_id,
+            "command": self.command,
+        }
+        if record.exc_info:
+            entry["exception"] = self.formatException(record.exc_info)
+        return json.dumps(entry)
+
+
+class TeeStream:
+    """Write to a stream and copy everything to a file."""
+
+    def __init__(self, stream: TextIO, file: TextIO) -> None:
+        """Wrap the stream, e.g. sys.stdout."""
+        self.stream = stream
+        self.file = file
+
+    def write(self, text: str) -> int:
+        """Write to both the stream and the file."""
+        self.file.write(text)
+        return self.stream.write(text)
+
+    def flush(self) -> None:
+        """Flush both the stream and the file."""
+        self.file.flush()
+        self.stream.flush()
+
+    def __getattr__(self, name: str) -> object:
+        """Everything else, e.g. encoding or isatty, comes from the wrapped stream."""
+        return getattr(self.stream, name)
+
+
+def capture_output(log_dir: Path, prefix: str) -> None:
+    """Copy stdout and stderr to files in the log directory and remove files from old runs."""
+    if isinstance(sys.stdout, TeeStream):  # Already capturing
+        return
+    for name in ("stdout", "stderr"):
+        file = (log_dir / f"{prefix}.{name}.txt").open("a", encoding="utf-8", buffering=1)
+        setattr(sys, name, TeeStream(getattr(sys, name), file))
+    for name in ("stdout", "stderr"):
+        old_files = sorted(log_dir.glob(f"*.{name}.txt"))[:-LOG_KEEP_OUTPUT_RUNS]
+        for old_file in old_files:
+            old_file.unlink(missing_ok=True)
+
+
+def setup_logging(command: str, log_dir: Path = LOG_DIR) -> Path | None:
+    """Log to the console and to a JSON log file for this run.
+
+    Args:
+        command: Name of the command being run, used in the log filename.
+        log_dir: Folder to write the log file to.
+
+    Returns:
+        Path to the log file, or None if the log file could not be created.
+
+    """
+    logger.setLevel(logging.DEBUG)
+    logger.handlers.clear()
+
+    run_id = datetime.now(pytz.timezone(TIME_ZONE)).strftime("%Y-%m-%d_%H-%M-%S")
+    log_dir = Path(log_dir)
+    try:
+        log_dir.mkdir(parents=True, exist_ok=True)
+        capture_output(log_dir, f"{run_id}_{command}")
+    except OSError as e:
+        # Logging is not set up yet
+        print(f"WARNING: Could not capture output to {log_dir}: {e}", file=sys.stderr)
+
+    console_handler = logging.StreamHandler(sys.stdout)
+    console_handler.setLevel(logging.INFO)
+    console_handler.setFormatter(ConsoleFormatter())
+    console_handler.addFilter(lambda record: getattr(record, "console", True))
+    logger.addHandler(console_handler)
+
+    log_filepath = log_dir / f"{run_id}_{command}.log"
+    try:
+        file_handler = logging.FileHandler(log_filepath, encoding="utf-8")
+    except OSError as e:
+        logger.warning("Could not create log file in %s: %s", log_dir, e)
+        return None
+    file_handler.setLevel(logging.DEBUG)
+    file_handler.setFormatter(JSONFormatter(run_id, command))
+    logger.addHandler(file_handler)
+
+    from aurora_robot_tools.log_shipping import add_shipping
+
+    add_shipping(logger, JSONFormatter(run_id, command), log_dir)
+
+    # Make sure crashes end up in the log file, the traceback is already printed to the console
+    def log_exception(exc_type: type[BaseException], exc_value: BaseException, exc_traceback: object) -> None:
+        if not issubclass(exc_type, KeyboardInterrupt):
+            logger.critical(
+                "Unhandled exception",
+                exc_info=(exc_type, exc_value, exc_traceback),
+                extra={"console": False},
+            )
+        sys.__excepthook__(exc_type, exc_value, exc_traceback)
+
+    sys.excepthook = log_exception
+    return log_filepath
@@ -0,0 +1,188 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Ship the log of every run to a central log server, e.g. the Kibana of the lab.
+
+If LOG_SHIP_URL is set in the config, the JSON log records of each run, the same as in the log file
+plus the host name and robot, are also sent to:
+    - syslog://host:514: a syslog server over UDP, one RFC 5424 message per record
+    - syslog+tcp://host:601: a syslog server over TCP, with octet counting
+    - http://host:9200/_bulk (or https): the Elasticsearch bulk API, into the LOG_SHIP_INDEX index
+
+Records are sent in batches, at the end of the command and every LOG_SHIP_INTERVAL_S seconds in
+long-running services. If the server can not be reached, e.g. when the lab network drops, the batch
+is kept in a spool file in the log directory and sent with the next batch, after waiting
+LOG_SHIP_INTERVAL_S before trying again. The spool is limited to LOG_SHIP_SPOOL_RECORDS records, the
+oldest are dropped first, the log files on the robot PC always have everything.
+
+Shipping never fails a command: errors are only printed once to stderr, not logged.
+
+Usage:
+    Set LOG_SHIP_URL in the config, added to the logging by log.py.
+"""
+
+import json
+import logging
+import os
+import socket
+import sys
+import threading
+import time
+import urllib.request
+from pathlib import Path
+from urllib.parse import urlsplit
+
+from aurora_robot_tools import config
+
+SCHEMES = ("syslog", "syslog+tcp", "http", "https")
+SPOOL_FILE = "unsent_logs.jsonl"
+BATCH_SIZE = 500  # records, sent straight away when this many are waiting
+# Syslog severity of each level, the facility is local0
+SEVERITIES = {"DEBUG": 7, "INFO": 6, "WARNING": 4, "ERROR": 3, "CRITICAL": 2}
+FACILITY = 16
+
+
+def valid_url(url: str) -> bool:
+    """Check the log server URL has a known scheme and a host."""
+    parts = urlsplit(url)
+    return parts.scheme in SCHEMES and bool(parts.hostname)
+
+
+def syslog_message(line: str) -> bytes:
+    """One record as an RFC 5424 syslog message, with the JSON record as the message."""
+    record = json.loads(line)
+    priority = FACILITY * 8 + SEVERITIES.get(record.get("level"), 6)
+    host = record.get("host") or "-"
+    return f"<{priority}>1 {record.get('time', '-')} {host} aurora-rt {os.getpid()} - - {line}".encode()
+
+
+def send_syslog(url: str, lines: list[str], timeout: float) -> None:
+    """Send records to a syslog server over UDP or TCP."""
+    parts = urlsplit(url)
+    if parts.scheme == "syslog":
+        with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as sock:
+            for line in lines:
+                sock.sendto(syslog_message(line), (parts.hostname, parts.port or 514))
+        return
+    with socket.create_connection((parts.hostname, parts.port or 601), timeout=timeout) as sock:
+        for line in lines:
+            message = syslog_message(line)
+            sock.sendall(f"{len(message)} ".encode() + message)
+
+
+def send_bulk(url: str, lines: list[str], timeout: float, index: str) -> None:
+    """Send records to the Elasticsearch bulk API."""
+    action = json.dumps({"index": {"_index": index}})
+    body = "".join(f"{action}\n{line}\n" for line in lines).encode()
+    request = urllib.request.Request(  # noqa: S310
+        url,
+        data=body,
+        headers={"Content-Type": "application/x-ndjson"},
+        method="POST",
+    )
+    with urllib.request.urlopen(request, timeout=timeout) as response:  # noqa: S310
+        result = json.loads(response.read() or b"{}")
+    if result.get("errors"):
+        msg = "the log server rejected some records"
+        raise OSError(msg)
+
+
+def send(url: str, lines: list[str]) -> None:
+    """Send records to the log server, raise OSError if that fails."""
+    if urlsplit(url).scheme.startswith("syslog"):
+        send_syslog(url, lines, config.LOG_SHIP_TIMEOUT)
+    else:
+        send_bulk(url, lines, config.LOG_SHIP_TIMEOUT, config.LOG_SHIP_INDEX)
+
+
+class ShippingHandler(logging.Handler):
+    """Send the formatted records to the log server in batches, spool them when it can not be reached."""
+
+    def __init__(self, url: str, log_dir: Path) -> None:
+        """Ship to the server at url, spool in the log directory."""
+        super().__init__()
+        self.url = url
+        self.spool = Path(log_dir) / SPOOL_FILE
+        self.buffer: list[str] = []
+        self.last_flush = time.monotonic()
+        self.retry_after = 0.0
+        self.warned = False
+        self.host = socket.gethostname()
+        self.send_lock = threading.Lock()
+
+    def emit(self, record: logging.LogRecord) -> None:
+        """Add a record to the batch, send it if the batch is full or due."""
+        try:
+            entry = json.loads(self.format(record))
+            entry["host"] = self.host
+            entry["robot"] = config.ROBOT or None
+            self.buffer.append(json.dumps(entry))
+        except Exception:  # noqa: BLE001
+            self.handleError(record)
+            return
+        due = time.monotonic() - self.last_flush >= config.LOG_SHIP_INTERVAL_S
+        if len(self.buffer) >= BATCH_SIZE or due:
+            self.flush()
+
+    def read_spool(self) -> list[str]:
+        """Records that could not be sent before."""
+        try:
+            return self.spool.read_text(encoding="utf-8").splitlines()
+        except OSError:
+            return []
+
+    def write_spool(self, lines: list[str]) -> None:
+        """Keep records for the next try, only the newest LOG_SHIP_SPOOL_RECORDS."""
+        lines = lines[-config.LOG_SHIP_SPOOL_RECORDS :]
+        try:
+            if lines:
+                self.spool.write_text("".join(f"{line}\n" for line in lines), encoding="utf-8")
+            else:
+                self.spool.unlink(missing_ok=True)
+        except OSError:
+            pass  # The records are still in the log files
+
+    def flush(self) -> None:
+        """Send the waiting records, with the spooled ones first."""
+        with self.send_lock:
+            self.last_flush = time.monotonic()
+            lines, self.buffer = self.buffer, []
+            if time.monotonic() < self.retry_after:
+                if lines:
+                    self.write_spool(self.read_spool() + lines)
+                return
+            spooled = self.read_spool()
+            if not spooled and not lines:
+                return
+            try:
+                send(self.url, spooled + lines)
+            except (OSError, ValueError) as e:
+                self.retry_after = time.monotonic() + config.LOG_SHIP_INTERVAL_S
+                self.write_spool(spooled + lines)
+                if not self.warned:
+                    self.warned = True
+                    print(f"WARNING: Could not ship logs to {self.url}, kept to send later: {e}", file=sys.stderr)
+                return
+            if spooled:
+                self.write_spool([])
+
+    def close(self) -> None:
+        """Send what is left when logging shuts down."""
+        self.flush()
+        super().close()
+
+
+def add_shipping(logger: logging.Logger, formatter: logging.Formatter, log_dir: Path) -> None:
+    """Ship the records of a logger to LOG_SHIP_URL, if it is set."""
+    if not config.LOG_SHIP_URL:
+        return
+    if not valid_url(config.LOG_SHIP_URL):
+        logger.warning(
+            "Not shipping logs, LOG_SHIP_URL must start with one of %s, got '%s'.",
+            ", ".join(f"{scheme}://" for scheme in SCHEMES),
+            config.LOG_SHIP_URL,
+        )
+        return
+    handler = ShippingHandler(config.LOG_SHIP_URL, log_dir)
+    handler.setLevel(config.LOG_SHIP_LEVEL.upper())
+    handler.setFormatter(formatter)
+    logger.addHandler(handler)
@@ -0,0 +1,89 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Count the jobs run by the server, in the Prometheus text format.
+
+Exposed by `aurora-rt serve` at GET /metrics, so lab monitoring can alert when jobs start failing or
+the database is often locked:
+
+    aurora_runs_started_total{command="balance"}      Jobs started
+    aurora_runs_succeeded_total{command="balance"}    Jobs finished with exit code 0
+    aurora_runs_failed_total{command="balance"}       Jobs finished with any other exit code
+    aurora_run_duration_seconds{command="balance"}    Histogram of job durations
+    aurora_db_lock_retries_total                      Retries because the database was locked
+    aurora_up                                         Always 1 while the server is running
+    aurora_uptime_seconds                             Time since the server started
+"""
+
+import sys
+import time
+from collections import Counter
+
+# Upper bounds of the duration histogram buckets in seconds
+DURATION_BUCKETS = (0.5, 1, 5, 10, 30, 60, 120, 300, 600)
+
+
+class Metrics:
+    """Counters and duration histogram of the jobs run by this process."""
+
+    def __init__(self) -> None:
+        """Start with no jobs."""
+        self.start_time = time.monotonic()
+        self.started: Counter[str] = Counter()
+        self.succeeded: Counter[str] = Counter()
+        self.failed: Counter[str] = Counter()
+        # Cumulative count per bucket, sum and count of the durations, so memory and scrapes do not grow
+        # with the number of jobs
+        self.duration_buckets: dict[str, list[int]] = {}
+        self.duration_sum: Counter[str] = Counter()
+        self.duration_count: Counter[str] = Counter()
+
+    def start(self, command: str) -> float:
+        """Count a job as started, returns the start time to pass to finish."""
+        self.started[command] += 1
+        return time.monotonic()
+
+    def finish(self, command: str, start_time: float, exit_code: int) -> None:
+        """Count a job as finished and record its duration."""
+        (self.succeeded if exit_code == 0 else self.failed)[command] += 1
+        duration = time.monotonic() - start_time
+        buckets = self.duration_buckets.setdefault(command, [0] * len(DURATION_BUCKETS))
+        for i, bucket in enumerate(DURATION_BUCKETS):
+            buckets[i] += duration <= bucket
+        self.duration_sum[command] += duration
+        self.duration_count[command] += 1
+
+    def render(self) -> str:
+        """Format all metrics in the Prometheus text exposition format."""
+        lines = []
+        for name, help_text, counter in (
+            ("aurora_runs_started_total", "Jobs started.", self.started),
+            ("aurora_runs_succeeded_total", "Jobs finished with exit code 0.", self.succeeded),
+            ("aurora_runs_failed_total", "Jobs finished with a non-zero exit code.", self.failed),
+        ):
+            lines += [f"# HELP {name} {help_text}", f"# TYPE {name} counter"]
+            lines += [f'{name}{{command="{command}"}} {count}' for command, count in sorted(counter.items())]
+
+        name = "aurora_run_duration_seconds"
+        lines += [f"# HELP {name} Duration of the jobs.", f"# TYPE {name} histogram"]
+        for command, buckets in sorted(self.duration_buckets.items()):
+            for bucket, count in zip(DURATION_BUCKETS, buckets):
+                lines.append(f'{name}_bucket{{command="{command}",le="{bucket}"}} {count}')
+            count = self.duration_count[command]
+            lines.append(f'{name}_bucket{{command="{command}",le="+Inf"}} {count}')
+            lines.append(f'{name}_sum{{command="{command}"}} {self.duration_sum[command]:.3f}')
+            lines.append(f'{name}_count{{command="{command}"}} {count}')
+
+        # Only counted once the database module is used
+        database = sys.modules.get("aurora_robot_tools.database")
+        lines += [
+            "# HELP aurora_db_lock_retries_total Retries because the database was locked.",
+            "# TYPE aurora_db_lock_retries_total counter",
+            f"aurora_db_lock_retries_total {database.lock_retries if database else 0}",
+            "# HELP aurora_up The server is running.",
+            "# TYPE aurora_up gauge",
+            "aurora_up 1",
+            "# HELP aurora_uptime_seconds Time since the server started.",
+            "# TYPE aurora_uptime_seconds gauge",
+            f"aurora_uptime_seconds {time.monotonic() - self.start_time:.0f}",
+        ]
+        return "\n".join(lines) + "\n"
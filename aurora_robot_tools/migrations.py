@@ -0,0 +1,323 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Create and upgrade the tables in the chemspeedDB database.
+
+The schema version is stored in the database with `PRAGMA user_version`. Each migration upgrades the
+database by one version, and is written so it can also run on a database that already has some of
+the changes, e.g. one made by import-excel before migrations existed. Running the migrations on a
+new path creates an empty database the robot and tools can use, so a new robot PC can be set up
+without copying a database from another machine.
+
+Usage:
+    `aurora-rt db migrate` upgrades the database to the latest version, `aurora-rt db status` shows
+    the current version and pending migrations.
+"""
+
+import logging
+import sqlite3
+from collections.abc import Callable
+from pathlib import Path
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, PRESS_TO_RACK, RACK_POSITIONS
+from aurora_robot_tools.errors import DatabaseError
+
+logger = logging.getLogger(__name__)
+
+
+CELL_ASSEMBLY_COLUMNS = {
+    "Rack Position": "INTEGER",
+    "Cell Number": "INTEGER",
+    "Current Press Number": "INTEGER",
+    "Last Completed Step": "INTEGER",
+    "Error Code": "INTEGER",
+    "Comments": "TEXT",
+    "Cell State": "TEXT",
+    "Batch Number": "INTEGER",
+    "Sample ID": "TEXT",
+    "Barcode": "TEXT",
+    "Anode Type": "TEXT",
+    "Anode Rack Position": "INTEGER",
+    "Anode Mass (mg)": "REAL",
+    "Anode Current Collector Mass (mg)": "REAL",
+    "Anode Active Material Mass Fraction": "REAL",
+    "Anode Active Material Mass (mg)": "REAL",
+    "Anode Balancing Specific Capacity (mAh/g)": "REAL",
+    "Anode Balancing Capacity (mAh)": "REAL",
+    "Anode Areal Capacity (mAh/cm2)": "REAL",
+    "Anode Diameter (mm)": "REAL",
+    "Cathode Type": "TEXT",
+    "Cathode Rack Position": "INTEGER",
+    "Cathode Mass (mg)": "REAL",
+    "Cathode Current Collector Mass (mg)": "REAL",
+    "Cathode Active Material Mass Fraction": "REAL",
+    "Cathode Active Material Mass (mg)": "REAL",
+    "Cathode Balancing Specific Capacity (mAh/g)": "REAL",
+    "Cathode Balancing Capacity (mAh)": "REAL",
+    "Cathode Areal Capacity (mAh/cm2)": "REAL",
+    "Cathode Diameter (mm)": "REAL",
+    "N:P Ratio Target": "REAL",
+    "N:P Ratio Minimum": "REAL",
+    "N:P Ratio Maximum": "REAL",
+    "N:P Ratio": "REAL",
+    "N:P ratio overlap factor": "REAL",
+    "Electrolyte Position": "INTEGER",
+    "Electrolyte Name": "TEXT",
+    "Electrolyte Amount (uL)": "REAL",
+    "Electrolyte Amount Before Separator (uL)": "REAL",
+    "Electrolyte Amount After Separator (uL)": "REAL",
+    "Separator Type": "TEXT",
+    "Casing Type": "TEXT",
+    "Bottom Spacer Type": "TEXT",
+    "Bottom Spacer Thickness (mm)": "REAL",
+    "Top Spacer Type": "TEXT",
+    "Top Spacer Thickness (mm)": "REAL",
+    "Allowed Anode Lots": "TEXT",
+    "Allowed Cathode Lots": "TEXT",
+    "Keep Lots Separate": "TEXT",
+    "Pressure Tolerance (%)": "REAL",
+    "Pinned Cathode Rack Position": "INTEGER",
+    "Pinned Press Number": "INTEGER",
+    "Cell Mass (mg)": "REAL",
+    "Expected Cell Mass (mg)": "REAL",
+    "Mass Check": "TEXT",
+    "Crimp Force (N)": "REAL",
+    "Electrolyte Dispense Before Separator (uL)": "REAL",
+    "Electrolyte Dispense After Separator (uL)": "REAL",
+}
+
+
+def create_table(conn: sqlite3.Connection, table: str, columns: dict[str, str]) -> None:
+    """Create a table if it does not exist."""
+    column_sql = ", ".join(f"`{name}` {sql_type}" for name, sql_type in columns.items())
+    conn.execute(f"CREATE TABLE IF NOT EXISTS `{table}` ({column_sql})")
+
+
+def table_columns(conn: sqlite3.Connection, table: str) -> list[str]:
+    """Get the column names of a table."""
+    return [row[1] for row in conn.execute(f"PRAGMA table_info(`{table}`)")]
+
+
+def create_robot_tables(conn: sqlite3.Connection) -> None:
+    """Create the tables used by AutoSuite and the tools, with an empty rack and the presses."""
+    create_table(conn, "Cell_Assembly_Table", CELL_ASSEMBLY_COLUMNS)
+    if conn.execute("SELECT COUNT(*) FROM Cell_Assembly_Table").fetchone()[0] == 0:
+        conn.executemany(
+            "INSERT INTO Cell_Assembly_Table (`Rack Position`, `Cell Number`, `Current Press Number`, "
+            "`Last Completed Step`, `Error Code`) VALUES (?, 0, 0, 0, 0)",
+            [(i,) for i in range(1, RACK_POSITIONS + 1)],
+        )
+    create_table(
+        conn,
+        "Press_Table",
+        {
+            "Press Number": "INTEGER",
+            "Current Cell Number Loaded": "INTEGER",
+            "Error Code": "INTEGER",
+            "Last Completed Step": "INTEGER",
+        },
+    )
+    if conn.execute("SELECT COUNT(*) FROM Press_Table").fetchone()[0] == 0:
+        conn.executemany(
+            "INSERT INTO Press_Table (`Press Number`, `Current Cell Number Loaded`, `Error Code`, "
+            "`Last Completed Step`) VALUES (?, 0, 0, 0)",
+            [(press,) for press in PRESS_TO_RACK],
+        )
+    create_table(
+        conn,
+        "Electrolyte_Table",
+        {"Electrolyte Position": "INTEGER", "Name": "TEXT", "Description": "TEXT"},
+    )
+    create_table(conn, "Settings_Table", {"key": "TEXT", "value": "TEXT"})
+    create_table(
+        conn,
+        "Timestamp_Table",
+        {"Cell Number": "INTEGER", "Step Number": "INTEGER", "Timestamp": "VARCHAR(255)", "Complete": "BOOLEAN"},
+    )
+    create_table(
+        conn,
+        "Calibration_Table",
+        {"Cell Number": "INTEGER", "Step Number": "INTEGER", "dx_mm": "REAL", "dy_mm": "REAL"},
+    )
+    create_table(
+        conn,
+        "Mixing_Table",
+        {"Source Position": "INTEGER", "Target Position": "INTEGER", "Volume (uL)": "REAL"},
+    )
+
+
+def add_press_loaded_time(conn: sqlite3.Connection) -> None:
+    """Add the time each cell was loaded to the Press_Table."""
+    if "Loaded Time" not in table_columns(conn, "Press_Table"):
+        conn.execute("ALTER TABLE Press_Table ADD COLUMN `Loaded Time` INTEGER DEFAULT 0")
+
+
+def create_inventory_table(conn: sqlite3.Connection) -> None:
+    """Create the electrode inventory table."""
+    from aurora_robot_tools.inventory import INVENTORY_DTYPES, INVENTORY_TABLE
+
+    create_table(conn, INVENTORY_TABLE, INVENTORY_DTYPES)
+
+
+def create_spacer_table(conn: sqlite3.Connection) -> None:
+    """Create the table of available spacers."""
+    create_table(conn, "Spacer_Table", {"Spacer Type": "TEXT", "Spacer Thickness (mm)": "REAL"})
+
+
+def create_press_status_table(conn: sqlite3.Connection) -> None:
+    """Create the table of presses taken out of service."""
+    from aurora_robot_tools.presses import PRESS_STATUS_COLUMNS, PRESS_STATUS_TABLE
+
+    create_table(conn, PRESS_STATUS_TABLE, PRESS_STATUS_COLUMNS)
+
+
+def add_areal_capacity(conn: sqlite3.Connection) -> None:
+    """Add the areal capacity of the electrodes to the Cell_Assembly_Table."""
+    columns = table_columns(conn, "Cell_Assembly_Table")
+    for xode in ("Anode", "Cathode"):
+        if f"{xode} Areal Capacity (mAh/cm2)" not in columns:
+            conn.execute(f"ALTER TABLE Cell_Assembly_Table ADD COLUMN `{xode} Areal Capacity (mAh/cm2)` REAL")
+
+
+def add_cell_state(conn: sqlite3.Connection) -> None:
+    """Add the state of each cell in the assembly pipeline to the Cell_Assembly_Table."""
+    if "Cell State" not in table_columns(conn, "Cell_Assembly_Table"):
+        conn.execute("ALTER TABLE Cell_Assembly_Table ADD COLUMN `Cell State` TEXT")
+
+
+def add_lot_constraints(conn: sqlite3.Connection) -> None:
+    """Add the lot constraints of each cell to the Cell_Assembly_Table."""
+    from aurora_robot_tools.capacity_balance import LOT_CONSTRAINT_COLUMNS
+
+    columns = table_columns(conn, "Cell_Assembly_Table")
+    for column in LOT_CONSTRAINT_COLUMNS:
+        if column not in columns:
+            conn.execute(f"ALTER TABLE Cell_Assembly_Table ADD COLUMN `{column}` TEXT")
+
+
+def add_press_calibration(conn: sqlite3.Connection) -> None:
+    """Add the press calibration and the pressure tolerance of each cell."""
+    from aurora_robot_tools.presses import create_status_table
+
+    create_status_table(conn)
+    if "Pressure Tolerance (%)" not in table_columns(conn, "Cell_Assembly_Table"):
+        conn.execute("ALTER TABLE Cell_Assembly_Table ADD COLUMN `Pressure Tolerance (%)` REAL")
+
+
+def add_overrides(conn: sqlite3.Connection) -> None:
+    """Add the cathode and press pins of each cell to the Cell_Assembly_Table."""
+    from aurora_robot_tools.overrides import OVERRIDE_COLUMNS
+
+    columns = table_columns(conn, "Cell_Assembly_Table")
+    for column in OVERRIDE_COLUMNS:
+        if column not in columns:
+            conn.execute(f"ALTER TABLE Cell_Assembly_Table ADD COLUMN `{column}` INTEGER")
+
+
+def add_mass_check(conn: sqlite3.Connection) -> None:
+    """Add the weighed and expected mass of each assembled cell to the Cell_Assembly_Table."""
+    from aurora_robot_tools.verify_mass import MASS_CHECK_COLUMNS
+
+    columns = table_columns(conn, "Cell_Assembly_Table")
+    for column, sql_type in MASS_CHECK_COLUMNS.items():
+        if column not in columns:
+            conn.execute(f"ALTER TABLE Cell_Assembly_Table ADD COLUMN `{column}` {sql_type}")
+
+
+def add_crimp_force(conn: sqlite3.Connection) -> None:
+    """Add the peak crimp force of each cell to the Cell_Assembly_Table."""
+    if "Crimp Force (N)" not in table_columns(conn, "Cell_Assembly_Table"):
+        conn.execute("ALTER TABLE Cell_Assembly_Table ADD COLUMN `Crimp Force (N)` REAL")
+
+
+def add_dispenser_calibration(conn: sqlite3.Connection) -> None:
+    """Add the dispenser calibration and the commanded electrolyte volumes of each cell."""
+    from aurora_robot_tools.dispenser_calibration import create_calibration_table
+
+    create_calibration_table(conn)
+    columns = table_columns(conn, "Cell_Assembly_Table")
+    for when in ("Before", "After"):
+        column = f"Electrolyte Dispense {when} Separator (uL)"
+        if column not in columns:
+            conn.execute(f"ALTER TABLE Cell_Assembly_Table ADD COLUMN `{column}` REAL")
+    if "Dispense Volume (uL)" not in table_columns(conn, "Mixing_Table"):
+        conn.execute("ALTER TABLE Mixing_Table ADD COLUMN `Dispense Volume (uL)` REAL")
+
+
+# Migration from version i to i + 1 is MIGRATIONS[i], only ever add to the end of the list
+MIGRATIONS: list[tuple[str, Callable[[sqlite3.Connection], None]]] = [
+    ("Create robot tables", create_robot_tables),
+    ("Add Loaded Time to Press_Table", add_press_loaded_time),
+    ("Create Electrode_Inventory_Table", create_inventory_table),
+    ("Create Spacer_Table", create_spacer_table),
+    ("Create Press_Status_Table", create_press_status_table),
+    ("Add areal capacities to Cell_Assembly_Table", add_areal_capacity),
+    ("Add Cell State to Cell_Assembly_Table", add_cell_state),
+    ("Add lot constraints to Cell_Assembly_Table", add_lot_constraints),
+    ("Add press calibration and pressure tolerances", add_press_calibration),
+    ("Add cathode and press pins to Cell_Assembly_Table", add_overrides),
+    ("Add mass check to Cell_Assembly_Table", add_mass_check),
+    ("Add crimp force to Cell_Assembly_Table", add_crimp_force),
+    ("Add dispenser calibration and dispense commands", add_dispenser_calibration),
+]
+LATEST_VERSION = len(MIGRATIONS)
+
+
+def get_version(db_path: Path) -> int:
+    """Get the schema version of the database, 0 if it does not exist."""
+    if not Path(db_path).exists():
+        return 0
+    with sqlite3.connect(db_path) as conn:
+        return conn.execute("PRAGMA user_version").fetchone()[0]
+
+
+def pending_migrations(db_path: Path) -> list[str]:
+    """Descriptions of the migrations not yet applied to the database."""
+    return [description for description, _migration in MIGRATIONS[get_version(db_path) :]]
+
+
+def migrate(db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
+    """Upgrade the database to the latest version, creating it if it does not exist."""
+    db_path = Path(db_path)
+    version = get_version(db_path)
+    if version > LATEST_VERSION:
+        msg = f"Database {db_path} is version {version}, newer than these tools (version {LATEST_VERSION})."
+        raise DatabaseError(msg)
+    if version == LATEST_VERSION:
+        logger.info("Database %s is up to date (version %d).", db_path, version)
+        return
+    if dry_run:
+        for i, description in enumerate(pending_migrations(db_path), start=version + 1):
+            logger.info("Dry run, would migrate to version %d: %s", i, description)
+        return
+    if db_path.exists():
+        from aurora_robot_tools.backup_database import auto_backup
+
+        auto_backup(db_path)
+    else:
+        logger.info("Creating database %s", db_path)
+        db_path.parent.mkdir(parents=True, exist_ok=True)
+    conn = sqlite3.connect(db_path)
+    try:
+        for i, (description, migration) in enumerate(MIGRATIONS[version:], start=version + 1):
+            # Apply each migration and its version in one transaction
+            conn.execute("BEGIN")
+            try:
+                migration(conn)
+                conn.execute(f"PRAGMA user_version = {i}")
+                conn.commit()
+            except sqlite3.Error as e:
+                conn.rollback()
+                msg = f"Migration to version {i} ({description}) failed: {e}"
+                raise DatabaseError(msg) from e
+            logger.info("Migrated to version %d: %s", i, description)
+    finally:
+        conn.close()
+
+
+def status(db_path: Path = DATABASE_FILEPATH) -> None:
+    """Log the schema version and pending migrations."""
+    version = get_version(db_path)
+    pending = pending_migrations(db_path)
+    logger.info("Database %s is version %d, latest is %d.", db_path, version, LATEST_VERSION)
+    if pending:
+        logger.info("Pending migrations, run `aurora-rt db migrate`:\n%s", "\n".join(pending))
@@ -0,0 +1,108 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Send a webhook notification when a command finishes or fails.
+
+If WEBHOOK_URL is set in the config, a message with the command, base sample ID of the run, duration,
+exit code and error is posted to it after each command, so the robot does not need to be watched
+overnight. WEBHOOK_FORMAT chooses the message format:
+    - "teams": Microsoft Teams incoming webhook or workflow
+    - "slack": Slack incoming webhook
+    - "generic": plain JSON with one key per field
+
+With WEBHOOK_ON = "failure" only failed commands are notified, with "always" every command is.
+A notification that cannot be sent is logged as a warning and never changes the exit code.
+
+Usage:
+    Sent automatically by `aurora-rt` when WEBHOOK_URL is set.
+"""
+
+import json
+import logging
+import sqlite3
+import urllib.error
+import urllib.request
+from pathlib import Path
+
+from aurora_robot_tools import config
+
+logger = logging.getLogger(__name__)
+
+FORMATS = ("generic", "teams", "slack")
+# Commands that only read or show information, never notified
+QUIET_COMMANDS = {"history", "doctor"}
+
+
+def read_base_sample_id(db_path: Path) -> str | None:
+    """Get the base sample ID of the run in the database, None if it cannot be read."""
+    try:
+        with sqlite3.connect(f"file:{Path(db_path).as_posix()}?mode=ro", uri=True) as conn:
+            row = conn.execute("SELECT value FROM Settings_Table WHERE key = 'Base Sample ID'").fetchone()
+    except sqlite3.Error:
+        return None
+    return row[0] if row else None
+
+
+def build_message(fields: dict, message_format: str) -> dict:
+    """Build the JSON body of the notification in the chosen format."""
+    status = "succeeded" if fields["exit_code"] == 0 else f"failed with exit code {fields['exit_code']}"
+    title = f"aurora-rt {fields['command']} {status}"
+    lines = [f"{key.replace('_', ' ').capitalize()}: {value}" for key, value in fields.items() if value is not None]
+    match message_format:
+        case "teams":
+            return {
+                "type": "message",
+                "attachments": [
+                    {
+                        "contentType": "application/vnd.microsoft.card.adaptive",
+                        "content": {
+                            "type": "AdaptiveCard",
+                            "version": "1.4",
+                            "body": [
+                                {"type": "TextBlock", "text": title, "weight": "Bolder", "wrap": True},
+                                *({"type": "TextBlock", "text": line, "wrap": True} for line in lines),
+                            ],
+                        },
+                    },
+                ],
+            }
+        case "slack":
+            return {"text": "\n".join([f"*{title}*", *lines])}
+        case _:
+            return {"title": title, **fields}
+
+
+def notify(  # noqa: PLR0913
+    command: str | None,
+    exit_code: int,
+    duration: float,
+    error: str | None,
+    db_path: Path,
+    dry_run: bool,
+) -> None:
+    """Post a notification about a finished command to the configured webhook."""
+    if not config.WEBHOOK_URL or command in (None, *QUIET_COMMANDS):
+        return
+    if exit_code == 0 and config.WEBHOOK_ON != "always":
+        return
+    if config.WEBHOOK_FORMAT not in FORMATS:
+        logger.warning("Unknown WEBHOOK_FORMAT '%s', must be one of %s", config.WEBHOOK_FORMAT, ", ".join(FORMATS))
+        return
+    fields = {
+        "command": command,
+        "exit_code": exit_code,
+        "base_sample_id": read_base_sample_id(db_path),
+        "duration": f"{duration:.1f} s",
+        "dry_run": dry_run or None,
+        "error": error,
+    }
+    request = urllib.request.Request(  # noqa: S310
+        config.WEBHOOK_URL,
+        data=json.dumps(build_message(fields, config.WEBHOOK_FORMAT)).encode(),
+        headers={"Content-Type": "application/json"},
+        method="POST",
+    )
+    try:
+        with urllib.request.urlopen(request, timeout=config.WEBHOOK_TIMEOUT):  # noqa: S310
+            pass
+    except (urllib.error.URLError, OSError) as e:
+        logger.warning("Could not send webhook notification: %s", e)
@@ -3,7 +3,7 @@
 Convert the finished database to a JSON file to go to aurora_cycler_manager.
 """
 
-import sqlite3
+import logging
 import sys
 from datetime import datetime
 from pathlib import Path
@@ -13,24 +13,21 @@ import pandas as pd
 import pytz
 
 from aurora_robot_tools.config import DATABASE_FILEPATH, OUTPUT_DIR, STEP_DEFINITION, TIME_ZONE
+from aurora_robot_tools.database import get_setting, read_query
+
+logger = logging.getLogger(__name__)
 
 PRESS_STEP = next(k for k, v in STEP_DEFINITION.items() if v["Step"] == "Press")
 
 
 def read_db(db_path: Path, press_step: int) -> tuple[pd.DataFrame, pd.DataFrame, str]:
     """Read completed cells, timestamps, and run_id from robot database."""
-    with sqlite3.connect(db_path) as conn:
-        df = pd.read_sql(
-            f"SELECT * FROM Cell_Assembly_Table WHERE `Last Completed Step` >= {press_step} AND `Error Code` = 0",
-            conn,
-        )
-        df_timestamp = pd.read_sql(
-            "SELECT * FROM Timestamp_Table WHERE `Complete` = 1",
-            conn,
-        )
-        cursor = conn.cursor()
-        cursor.execute("SELECT `value` FROM Settings_Table WHERE `key` = 'Base Sample ID'")
-        run_id = cursor.fetchone()[0]
+    df = read_query(
+        db_path,
+        f"SELECT * FROM Cell_Assembly_Table WHERE `Last Completed Step` >= {press_step} AND `Error Code` = 0",
+    )
+    df_timestamp = read_query(db_path, "SELECT * FROM Timestamp_Table WHERE `Complete` = 1")
+    run_id = get_setting(db_path, "Base Sample ID")
     df["Run ID"] = run_id
     return df, df_timestamp, run_id
 
@@ -101,17 +98,17 @@ def generate_all_assembly_history(df: pd.DataFrame, df_timestamp: pd.DataFrame)
     return df.merge(df_timestamp["Assembly History"], on="Cell Number")
 
 
-def main() -> None:
+def main(db_path: Path = DATABASE_FILEPATH) -> None:
     """Export sample details from robot database to a JSON file."""
     # Read db
-    df, df_timestamp, run_id = read_db(DATABASE_FILEPATH, PRESS_STEP)
+    df, df_timestamp, run_id = read_db(db_path, PRESS_STEP)
 
     # Ask user for output file path
     output_filepath = user_output_filepath(OUTPUT_DIR, run_id)
 
     # If df is empty (no finished cells), exit
     if df.empty:
-        print("No finished cells found in database. No output file created.")
+        logger.info("No finished cells found in database. No output file created.")
         sys.exit()
 
     # Remove certain columns, these are either unnecessary or will be recalculated
@@ -137,4 +134,7 @@ def main() -> None:
 
 
 if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("output")
     main()
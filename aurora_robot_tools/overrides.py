@@ -0,0 +1,143 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Pin cells to presses or electrode pairs with an overrides file.
+
+When an operator has to intervene by hand, e.g. a cell must go to the press that was just serviced
+or a cathode from a special lot must go with a particular anode, the pins are written in an
+overrides file (.csv or .xlsx, first sheet) with one row per cell, case does not matter:
+    - Rack Position: the rack position of the cell, where its anode is
+    - Cathode Rack Position (or Cathode): the rack position the cathode of the cell is taken from
+    - Press (or Press Number): the press the cell must go to
+
+Empty values are not pinned. `aurora-rt overrides FILE` stores the pins in the Pinned Cathode Rack
+Position and Pinned Press Number columns of the Cell_Assembly_Table, replacing any earlier pins, so
+they are kept until the next import or `aurora-rt overrides --clear`. The balancing and press
+assignment then only optimize the rest:
+    - The cost matrix methods (3-6) only pair the anode of a cell with the pinned cathode, and do not
+      give a pinned cathode to any other cell. A cell that still does not get its pinned cathode,
+      e.g. with the other sorting methods, is not accepted, like a cell breaking a lot constraint
+    - A cell pinned to a press is only loaded into that press, and before any other cell
+
+Usage:
+    `aurora-rt overrides pins.csv`, or `aurora-rt overrides --clear` to remove all pins.
+"""
+
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, PRESS_TO_RACK
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.decimals import read_csv
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+# Columns of the Cell_Assembly_Table with the pins of each cell, empty if not pinned
+OVERRIDE_COLUMNS = ("Pinned Cathode Rack Position", "Pinned Press Number")
+
+# Overrides file column names, lowercase, and the Cell_Assembly_Table columns they fill in
+OVERRIDE_FILE_COLUMNS = {
+    "rack position": "Rack Position",
+    "cathode rack position": "Pinned Cathode Rack Position",
+    "cathode": "Pinned Cathode Rack Position",
+    "press": "Pinned Press Number",
+    "press number": "Pinned Press Number",
+}
+
+
+def read_overrides(path: Path) -> pd.DataFrame:
+    """Read the overrides file, with the pins as numbers and empty values as NaN."""
+    path = Path(path)
+    if not path.is_file():
+        msg = f"Overrides file {path} not found."
+        raise ConfigError(msg)
+    if path.suffix.lower() == ".csv":
+        df = read_csv(path)
+    elif path.suffix.lower() in (".xlsx", ".xls"):
+        df = pd.read_excel(path)
+    else:
+        msg = f"Overrides file must be a .csv or .xlsx file, got {path.name}."
+        raise ConfigError(msg)
+    df = df.dropna(how="all")
+    unknown = [col for col in df.columns if str(col).strip().lower() not in OVERRIDE_FILE_COLUMNS]
+    if unknown:
+        msg = f"Unknown columns in {path.name}: {', '.join(map(str, unknown))}."
+        raise ConfigError(msg)
+    df = df.rename(columns={col: OVERRIDE_FILE_COLUMNS[str(col).strip().lower()] for col in df.columns})
+    if df.columns.duplicated().any() or "Rack Position" not in df.columns:
+        msg = f"{path.name} must have a Rack Position column and each pin only once."
+        raise ConfigError(msg)
+    for column in df.columns:
+        values = pd.to_numeric(df[column], errors="coerce")
+        if (values.isna() & df[column].notna()).any() or (values % 1 > 0).any():
+            msg = f"{column} in {path.name} must be whole numbers."
+            raise ConfigError(msg)
+        df[column] = values
+    return df
+
+
+def validate_overrides(df_overrides: pd.DataFrame, df: pd.DataFrame) -> None:
+    """Check the pins can be honored by the cells in the Cell_Assembly_Table."""
+    errors = []
+    racks = df_overrides["Rack Position"]
+    if racks.isna().any() or racks.duplicated().any():
+        errors.append("every row needs a rack position, each rack position only once")
+    unknown = set(racks.dropna()) - set(df["Rack Position"])
+    if unknown:
+        errors.append(f"rack positions not in the database {', '.join(str(int(r)) for r in sorted(unknown))}")
+    batches = df.set_index("Rack Position")["Batch Number"]
+    cathode_batches = dict(zip(df["Cathode Rack Position"], df["Batch Number"]))
+    pins = df_overrides.get("Pinned Cathode Rack Position", pd.Series(dtype=float))
+    if pins.dropna().duplicated().any():
+        errors.append("a cathode is pinned to more than one cell")
+    for rack, cathode in zip(racks, pins):
+        if pd.isna(cathode) or rack in unknown:
+            continue
+        if cathode not in cathode_batches:
+            errors.append(f"no cathode at rack position {int(cathode)}")
+        elif cathode_batches[cathode] != batches[rack]:
+            errors.append(f"the cathode from {int(cathode)} is not in the batch of rack position {int(rack)}")
+    presses = df_overrides.get("Pinned Press Number", pd.Series(dtype=float))
+    unknown_presses = set(presses.dropna()) - set(PRESS_TO_RACK)
+    if unknown_presses:
+        errors.append(f"unknown presses {', '.join(str(int(p)) for p in sorted(unknown_presses))}")
+    if errors:
+        msg = f"Cannot apply the overrides: {'; '.join(errors)}."
+        raise ConfigError(msg)
+
+
+def main(
+    path: Path | None = None,
+    clear: bool = False,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Store the pins of an overrides file in the database, or remove all pins."""
+    if (path is None) == (not clear):
+        msg = "Give either an overrides file or --clear."
+        raise ConfigError(msg)
+    (df,) = read_tables(db_path, "Cell_Assembly_Table")
+    for column in OVERRIDE_COLUMNS:
+        df[column] = np.nan
+    dtypes = {"Cell_Assembly_Table": dict.fromkeys(OVERRIDE_COLUMNS, "INTEGER")}
+    if clear:
+        write_tables(db_path, {"Cell_Assembly_Table": df}, dtypes=dtypes, dry_run=dry_run)
+        logger.info("Removed all pins.")
+        return
+    df_overrides = read_overrides(path)
+    validate_overrides(df_overrides, df)
+    rows = df["Rack Position"].map(dict(zip(df_overrides["Rack Position"], df_overrides.index)))
+    for column in OVERRIDE_COLUMNS:
+        if column in df_overrides.columns:
+            df[column] = rows.map(df_overrides[column])
+    write_tables(db_path, {"Cell_Assembly_Table": df}, dtypes=dtypes, dry_run=dry_run)
+    logger.info(
+        "Pinned %d cathodes and %d presses for %d cells from %s.",
+        df["Pinned Cathode Rack Position"].notna().sum(),
+        df["Pinned Press Number"].notna().sum(),
+        len(df_overrides),
+        Path(path).name,
+    )
@@ -0,0 +1,125 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Compare two plans to review what a re-balance or re-planning changed.
+
+A plan can be the CSV or Excel run sheet from `aurora-rt export-plan`, JSON from `--output json` or
+a job result (the "plan" key, or a list of cells), or a database. Without a second plan the first
+one is compared with the current robot database.
+
+Cells are matched by rack position, since the anode of a cell stays in its rack position, and for
+each one the changes of cell number, press, pairing (anode and cathode rack positions) and
+electrolyte are listed, as well as cells only in one of the plans.
+
+Usage:
+    `aurora-rt diff old_plan.csv` or `aurora-rt diff old_plan.json new_plan.json`.
+"""
+
+import json
+import logging
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+# Columns compared between the plans, and how each change is described
+COMPARED_COLUMNS = {
+    "Cell Number": "cell",
+    "Current Press Number": "press",
+    "Anode Rack Position": "anode from rack",
+    "Cathode Rack Position": "cathode from rack",
+    "Electrolyte Position": "electrolyte",
+    "Electrolyte Amount (uL)": "electrolyte uL",
+}
+VOLUME_TOLERANCE_UL = 0.01  # Smaller electrolyte changes are rounding, not a change of plan
+
+
+def load_plan(source: Path) -> pd.DataFrame:
+    """Read a plan from a run sheet, JSON output or database, indexed by rack position."""
+    from aurora_robot_tools.export_plan import read_plan
+
+    source = Path(source)
+    if not source.is_file():
+        msg = f"Plan {source} not found."
+        raise ConfigError(msg)
+    suffix = source.suffix.lower()
+    if suffix == ".csv":
+        df = pd.read_csv(source)
+    elif suffix in (".xlsx", ".xls"):
+        df = pd.read_excel(source)
+    elif suffix == ".json":
+        data = json.loads(source.read_text(encoding="utf-8"))
+        df = pd.DataFrame(data.get("plan", []) if isinstance(data, dict) else data)
+    elif suffix in (".db", ".sqlite"):
+        df = read_plan(source)
+    else:
+        msg = f"Plan must be a .csv, .xlsx, .json or .db file, got {source.name}."
+        raise ConfigError(msg)
+    if "Rack Position" not in df.columns:
+        msg = f"Plan {source.name} has no Rack Position column."
+        raise ConfigError(msg)
+    return df.set_index("Rack Position")
+
+
+def describe(value: object) -> str:
+    """Format a plan value for the diff."""
+    if pd.isna(value):
+        return "-"
+    if isinstance(value, float):
+        return f"{value:g}"
+    return str(value)
+
+
+def is_changed(column: str, old: object, new: object) -> bool:
+    """Check if a value changed between the plans."""
+    if pd.isna(old) and pd.isna(new):
+        return False
+    if pd.isna(old) or pd.isna(new):
+        return True
+    if column == "Electrolyte Amount (uL)":
+        return abs(float(old) - float(new)) > VOLUME_TOLERANCE_UL
+    return describe(old) != describe(new)
+
+
+def compare(df_old: pd.DataFrame, df_new: pd.DataFrame) -> list[str]:
+    """Describe the differences between two plans, one line per rack position that changed."""
+    lines = []
+    for rack in sorted(set(df_old.index) | set(df_new.index)):
+        if rack not in df_new.index:
+            lines.append(f"Rack {rack}: cell {describe(df_old.loc[rack].get('Cell Number'))} removed")
+            continue
+        if rack not in df_old.index:
+            lines.append(f"Rack {rack}: cell {describe(df_new.loc[rack].get('Cell Number'))} added")
+            continue
+        old, new = df_old.loc[rack], df_new.loc[rack]
+        changes = [
+            f"{label} {describe(old.get(column))} -> {describe(new.get(column))}"
+            for column, label in COMPARED_COLUMNS.items()
+            if is_changed(column, old.get(column), new.get(column))
+        ]
+        if changes:
+            lines.append(f"Rack {rack}: {', '.join(changes)}")
+    return lines
+
+
+def main(old: Path, new: Path | None = None, db_path: Path = DATABASE_FILEPATH) -> list[str]:
+    """Log the differences between two plans, or between a plan and the database.
+
+    Returns:
+        One line per rack position that changed
+
+    """
+    from aurora_robot_tools.export_plan import read_plan
+
+    df_old = load_plan(old)
+    df_new = load_plan(new) if new is not None else read_plan(db_path).set_index("Rack Position")
+    lines = compare(df_old, df_new)
+    name_new = Path(new).name if new is not None else "the database"
+    if not lines:
+        logger.info("No changes between %s and %s.", Path(old).name, name_new)
+        return lines
+    logger.info("%d rack positions changed from %s to %s:\n%s", len(lines), Path(old).name, name_new, "\n".join(lines))
+    return lines
@@ -0,0 +1,216 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Draw a map of the presses and rack positions with the planned cells, to check the loading by eye.
+
+The map shows the presses, with the cell loaded in each and whether it is disabled, and the rack in
+rows of len(PRESS_TO_RACK) positions, the same layout rack positions are linked to presses with.
+Each planned cell shows its rack position, cell ID (or sample ID if no IDs are reserved yet), cell
+number and press, coloured by chemistry, the anode and cathode type, with a legend.
+
+The map is either text, logged and optionally written to a file, or an SVG image in the output
+folder, which prints on one page from a browser to tape next to the robot.
+
+Usage:
+    `aurora-rt plan show`, or `aurora-rt plan show --format svg` for the printable map.
+"""
+
+import html
+import logging
+from datetime import datetime
+from pathlib import Path
+
+import pandas as pd
+import pytz
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, OUTPUT_DIR, PRESS_TO_RACK, RACK_POSITIONS, TIME_ZONE
+from aurora_robot_tools.database import get_setting, read_query
+from aurora_robot_tools.errors import ConfigError
+from aurora_robot_tools.export_plan import read_plan
+from aurora_robot_tools.presses import disabled_presses
+
+logger = logging.getLogger(__name__)
+
+MAP_FORMATS = ("text", "svg")
+# Colour-blind safe palette, chemistries after the eighth are told apart by their letter
+COLORS = ("#E69F00", "#56B4E9", "#009E73", "#F0E442", "#0072B2", "#D55E00", "#CC79A7", "#999999")
+SLOT_WIDTH = 150
+SLOT_HEIGHT = 64
+MARGIN = 20
+TEXT_WIDTH = 14  # characters per rack position in the text map
+
+
+def chemistry(row: pd.Series) -> str:
+    """Name of the chemistry of a cell, its anode and cathode type."""
+    return f"{row.get('Anode Type', '?')} | {row.get('Cathode Type', '?')}"
+
+
+def cell_label(row: pd.Series) -> str:
+    """Cell ID of a cell, or its sample ID if no ID is reserved yet."""
+    cell_id = row.get("Cell ID")
+    return str(cell_id) if pd.notna(cell_id) and cell_id else str(row.get("Sample ID", ""))
+
+
+def read_map(db_path: Path) -> dict:
+    """Collect the presses, rack positions and chemistries to draw."""
+    df = read_plan(db_path)
+    if df.empty:
+        msg = "No cells assigned for assembly, run capacity balancing first."
+        raise ConfigError(msg)
+    press_table = read_query(db_path, "SELECT `Press Number`, `Current Cell Number Loaded` FROM Press_Table")
+    loaded = dict(zip(press_table["Press Number"].astype(int), press_table["Current Cell Number Loaded"]))
+    chemistries = list(dict.fromkeys(chemistry(row) for _, row in df.iterrows()))
+    slots = {}
+    for _, row in df.iterrows():
+        press = row.get("Current Press Number")
+        slots[int(row["Rack Position"])] = {
+            "label": cell_label(row),
+            "cell": int(row["Cell Number"]),
+            "press": int(press) if pd.notna(press) and press > 0 else None,
+            "chemistry": chemistries.index(chemistry(row)),
+        }
+    disabled = disabled_presses(db_path)
+    presses = {
+        press: {"cell": int(loaded.get(press) or 0), "disabled": disabled.get(press)} for press in PRESS_TO_RACK
+    }
+    title = get_setting(db_path, "Base Sample ID") or "plan"
+    date = datetime.now(pytz.timezone(TIME_ZONE)).strftime("%Y-%m-%d %H:%M")
+    return {"title": title, "date": date, "presses": presses, "slots": slots, "chemistries": chemistries}
+
+
+def letter(index: int) -> str:
+    """Letter of a chemistry in the legend, A, B, ..."""
+    return chr(ord("A") + index % 26)
+
+
+def press_text(press: int, status: dict) -> str:
+    """One press in the maps."""
+    if status["disabled"]:
+        return f"Press {press}: disabled ({status['disabled']})"
+    return f"Press {press}: cell {status['cell']}" if status["cell"] else f"Press {press}: empty"
+
+
+def render_text(plan_map: dict) -> str:
+    """Draw the map as text, the chemistry of each cell as a letter."""
+    columns = len(PRESS_TO_RACK)
+    border = "+" + "+".join("-" * TEXT_WIDTH for _ in range(columns)) + "+"
+    lines = [f"{plan_map['title']} - {plan_map['date']}", ""]
+    lines += [press_text(press, status) for press, status in plan_map["presses"].items()]
+    lines += ["", border]
+    for first in range(1, RACK_POSITIONS + 1, columns):
+        top, bottom = [], []
+        for position in range(first, min(first + columns, RACK_POSITIONS + 1)):
+            slot = plan_map["slots"].get(position)
+            if slot is None:
+                top.append(f" {position:<3}-".ljust(TEXT_WIDTH))
+                bottom.append(" " * TEXT_WIDTH)
+                continue
+            press = f"P{slot['press']}" if slot["press"] else "P-"
+            text = f" {position:<3}{letter(slot['chemistry'])} #{slot['cell']} {press}"
+            top.append(text[:TEXT_WIDTH].ljust(TEXT_WIDTH))
+            # Long sample IDs differ at the end, e.g. 250314_kigr_01_05
+            label = slot["label"] if len(slot["label"]) < TEXT_WIDTH else ".." + slot["label"][3 - TEXT_WIDTH :]
+            bottom.append(f" {label}".ljust(TEXT_WIDTH))
+        lines += ["|" + "|".join(top) + "|", "|" + "|".join(bottom) + "|", border]
+    lines += ["", *(f"{letter(i)}: {name}" for i, name in enumerate(plan_map["chemistries"]))]
+    return "\n".join(lines)
+
+
+def svg_text(x: float, y: float, text: str, size: int = 12, weight: str = "normal") -> str:
+    """SVG text element, escaped."""
+    return (
+        f'<text x="{x}" y="{y}" font-family="sans-serif" font-size="{size}" font-weight="{weight}">'
+        f"{html.escape(text)}</text>"
+    )
+
+
+def render_svg(plan_map: dict) -> str:
+    """Draw the map as an SVG image."""
+    columns = len(PRESS_TO_RACK)
+    rows = -(-RACK_POSITIONS // columns)
+    width = 2 * MARGIN + columns * SLOT_WIDTH
+    press_top = MARGIN + 40
+    rack_top = press_top + SLOT_HEIGHT // 2 + 40
+    legend_top = rack_top + rows * SLOT_HEIGHT + 30
+    height = legend_top + 20 * len(plan_map["chemistries"]) + MARGIN
+    parts = [
+        f'<svg xmlns="http://www.w3.org/2000/svg" width="{width}" height="{height}" viewBox="0 0 {width} {height}">',
+        f'<rect width="{width}" height="{height}" fill="white"/>',
+        svg_text(MARGIN, MARGIN + 16, f"{plan_map['title']} - {plan_map['date']}", 16, "bold"),
+    ]
+    for i, (press, status) in enumerate(plan_map["presses"].items()):
+        x = MARGIN + i * SLOT_WIDTH
+        fill = "#DDDDDD" if status["disabled"] else "white"
+        parts.append(
+            f'<rect x="{x + 2}" y="{press_top}" width="{SLOT_WIDTH - 4}" height="{SLOT_HEIGHT // 2}" '
+            f'fill="{fill}" stroke="black" stroke-width="2"/>',
+        )
+        state = "disabled" if status["disabled"] else (f"cell {status['cell']}" if status["cell"] else "empty")
+        parts.append(svg_text(x + 8, press_top + 20, f"Press {press}: {state}", 12, "bold"))
+    parts.append(svg_text(MARGIN, rack_top - 10, "Rack", 14, "bold"))
+    for position in range(1, RACK_POSITIONS + 1):
+        x = MARGIN + (position - 1) % columns * SLOT_WIDTH
+        y = rack_top + (position - 1) // columns * SLOT_HEIGHT
+        slot = plan_map["slots"].get(position)
+        if slot is None:
+            parts.append(
+                f'<rect x="{x + 2}" y="{y + 2}" width="{SLOT_WIDTH - 4}" height="{SLOT_HEIGHT - 4}" '
+                'fill="white" stroke="#999999" stroke-dasharray="4 3"/>',
+            )
+            parts.append(svg_text(x + 8, y + 18, str(position), 11))
+            continue
+        color = COLORS[slot["chemistry"] % len(COLORS)]
+        parts.append(
+            f'<rect x="{x + 2}" y="{y + 2}" width="{SLOT_WIDTH - 4}" height="{SLOT_HEIGHT - 4}" '
+            f'fill="{color}" fill-opacity="0.6" stroke="black"/>',
+        )
+        press = f"press {slot['press']}" if slot["press"] else "no press"
+        text = f"{position}  {letter(slot['chemistry'])}  #{slot['cell']}  {press}"
+        parts.append(svg_text(x + 8, y + 18, text, 11))
+        parts.append(svg_text(x + 8, y + 40, slot["label"], 13, "bold"))
+    for i, name in enumerate(plan_map["chemistries"]):
+        y = legend_top + 20 * i
+        color = COLORS[i % len(COLORS)]
+        parts.append(
+            f'<rect x="{MARGIN}" y="{y}" width="14" height="14" fill="{color}" fill-opacity="0.6" stroke="black"/>',
+        )
+        parts.append(svg_text(MARGIN + 22, y + 12, f"{letter(i)}: {name}", 12))
+    parts.append("</svg>")
+    return "\n".join(parts)
+
+
+def main(
+    db_path: Path = DATABASE_FILEPATH,
+    map_format: str = "text",
+    out: Path | None = None,
+    output_dir: Path = OUTPUT_DIR,
+) -> Path | None:
+    """Draw the map of the planned cells.
+
+    Args:
+        db_path: Path to the robot database
+        map_format: text to log the map, svg to write an image
+        out: File to write the map to, default for svg is the output folder, for text none
+        output_dir: Folder for the SVG map if out is not given
+
+    Returns:
+        The path of the file written, None if the map was only logged
+
+    """
+    if map_format not in MAP_FORMATS:
+        msg = f"Format must be one of {', '.join(MAP_FORMATS)}, got '{map_format}'."
+        raise ConfigError(msg)
+    plan_map = read_map(db_path)
+    if map_format == "text":
+        text = render_text(plan_map)
+        logger.info("\n%s", text)
+        if out is None:
+            return None
+        content = text + "\n"
+    else:
+        content = render_svg(plan_map)
+        out = out or Path(output_dir) / f"{plan_map['title']}_map.svg"
+    out = Path(out)
+    out.parent.mkdir(parents=True, exist_ok=True)
+    out.write_text(content, encoding="utf-8")
+    logger.info("Wrote the plan map to %s", out)
+    return out
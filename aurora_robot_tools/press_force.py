@@ -0,0 +1,242 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Record the peak crimp force of each cell from the load cells of the presses.
+
+The press controllers publish the live force of their load cell over OPC-UA or in a Modbus TCP
+holding register. While a cell is crimped, the force is read every PRESS_FORCE_POLL_S seconds for
+PRESS_FORCE_DURATION_S, and the highest force is stored as the Crimp Force (N) of the cell loaded in
+that press, see the Press_Table. The crimp force is included in the cycler export, so the assembly
+quality can be correlated with the cycling performance later.
+
+The controllers are set with PRESS_FORCE_CONTROLLERS in the config, press number: address.
+
+An address "opc.tcp://host:port" is an OPC-UA server, the force in N is the value of the node
+PRESS_FORCE_NODE, e.g. "ns=2;s=Force". OPC-UA needs the optional asyncua package, installed with
+`pip install aurora-robot-tools[opcua]`.
+
+Any other address, "host:port", is a Modbus TCP controller, which needs no extra packages.
+PRESS_FORCE_REGISTER is the address of the force, read as one signed 16-bit register or, with
+PRESS_FORCE_WORDS = 2, as a signed 32-bit value from two registers, high word first. The raw value
+times PRESS_FORCE_SCALE_N is the force in N.
+
+Usage:
+    `aurora-rt press force 3` in the AutoSuite workflow right before press 3 crimps, or the
+    "press-force" job with {"press": 3}.
+"""
+
+import logging
+import math
+import socket
+import struct
+import time
+from pathlib import Path
+
+from aurora_robot_tools.config import (
+    DATABASE_FILEPATH,
+    PRESS_FORCE_CONTROLLERS,
+    PRESS_FORCE_DURATION_S,
+    PRESS_FORCE_NODE,
+    PRESS_FORCE_POLL_S,
+    PRESS_FORCE_REGISTER,
+    PRESS_FORCE_SCALE_N,
+    PRESS_FORCE_UNIT_ID,
+    PRESS_FORCE_WORDS,
+)
+from aurora_robot_tools.database import connect, has_column
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError, InfeasibleError
+from aurora_robot_tools.presses import check_press
+
+logger = logging.getLogger(__name__)
+
+OPCUA_SCHEME = "opc.tcp://"
+READ_HOLDING_REGISTERS = 3
+TIMEOUT_S = 2.0
+
+
+class ModbusClient:
+    """Minimal Modbus TCP client, only reads holding registers."""
+
+    def __init__(self, address: str, unit_id: int = PRESS_FORCE_UNIT_ID) -> None:
+        """Connect to a controller at "host:port", port 502 if not given."""
+        host, _, port = address.partition(":")
+        self.unit_id = unit_id
+        self.transaction = 0
+        try:
+            self.sock = socket.create_connection((host, int(port or 502)), timeout=TIMEOUT_S)
+        except OSError as e:
+            msg = f"Cannot connect to the press controller at {address}: {e}"
+            raise EnvironmentProblemError(msg) from e
+
+    def receive(self, n_bytes: int) -> bytes:
+        """Read exactly n bytes from the controller."""
+        data = b""
+        while len(data) < n_bytes:
+            chunk = self.sock.recv(n_bytes - len(data))
+            if not chunk:
+                msg = "Press controller closed the connection."
+                raise EnvironmentProblemError(msg)
+            data += chunk
+        return data
+
+    def read_registers(self, address: int, count: int) -> list[int]:
+        """Read consecutive holding registers as unsigned 16-bit values."""
+        self.transaction = (self.transaction + 1) % 0x10000
+        request = struct.pack(
+            ">HHHBBHH", self.transaction, 0, 6, self.unit_id, READ_HOLDING_REGISTERS, address, count
+        )
+        try:
+            self.sock.sendall(request)
+            transaction, _protocol, length, _unit = struct.unpack(">HHHB", self.receive(7))
+            pdu = self.receive(length - 1)
+        except OSError as e:
+            msg = f"Cannot read the press controller: {e}"
+            raise EnvironmentProblemError(msg) from e
+        if transaction != self.transaction:
+            msg = f"Press controller answered transaction {transaction}, expected {self.transaction}."
+            raise EnvironmentProblemError(msg)
+        if pdu[0] & 0x80:
+            msg = f"Press controller returned Modbus exception {pdu[1]} reading register {address}."
+            raise EnvironmentProblemError(msg)
+        return list(struct.unpack(f">{count}H", pdu[2 : 2 + 2 * count]))
+
+    def read_force(self) -> float:
+        """Read the force in N."""
+        return to_force(self.read_registers(PRESS_FORCE_REGISTER, PRESS_FORCE_WORDS), PRESS_FORCE_SCALE_N)
+
+    def close(self) -> None:
+        """Close the connection."""
+        self.sock.close()
+
+
+class OpcUaClient:
+    """OPC-UA client reading the force from one node, needs the optional asyncua package."""
+
+    def __init__(self, address: str, node_id: str = PRESS_FORCE_NODE) -> None:
+        """Connect to a server at "opc.tcp://host:port"."""
+        try:
+            from asyncua.sync import Client
+        except ImportError as e:
+            msg = (
+                f"Press controller {address} uses OPC-UA, which needs the asyncua package. "
+                "Install it with `pip install aurora-robot-tools[opcua]`."
+            )
+            raise EnvironmentProblemError(msg) from e
+        self.client = Client(address, timeout=TIMEOUT_S)
+        try:
+            self.client.connect()
+        except (OSError, TimeoutError) as e:
+            msg = f"Cannot connect to the press controller at {address}: {e}"
+            raise EnvironmentProblemError(msg) from e
+        self.node = self.client.get_node(node_id)
+        self.node_id = node_id
+
+    def read_force(self) -> float:
+        """Read the force in N."""
+        try:
+            value = self.node.read_value()
+        except Exception as e:
+            msg = f"Cannot read node {self.node_id} of the press controller: {e}"
+            raise EnvironmentProblemError(msg) from e
+        try:
+            return float(value)
+        except (TypeError, ValueError) as e:
+            msg = f"Node {self.node_id} of the press controller is not a number: {value!r}"
+            raise EnvironmentProblemError(msg) from e
+
+    def close(self) -> None:
+        """Close the connection."""
+        self.client.disconnect()
+
+
+def connect_controller(address: str) -> ModbusClient | OpcUaClient:
+    """Client for a press controller, OPC-UA for an "opc.tcp://" address, otherwise Modbus TCP."""
+    if address.startswith(OPCUA_SCHEME):
+        return OpcUaClient(address, PRESS_FORCE_NODE)
+    return ModbusClient(address, PRESS_FORCE_UNIT_ID)
+
+
+def to_force(registers: list[int], scale: float = PRESS_FORCE_SCALE_N) -> float:
+    """Convert the raw registers to a force in N, as a signed 16 or 32-bit value."""
+    if len(registers) == 2:
+        (raw,) = struct.unpack(">i", struct.pack(">HH", *registers))
+    else:
+        (raw,) = struct.unpack(">h", struct.pack(">H", registers[0]))
+    return raw * scale
+
+
+def peak_force(
+    client: ModbusClient | OpcUaClient,
+    duration: float = PRESS_FORCE_DURATION_S,
+    poll_interval: float = PRESS_FORCE_POLL_S,
+) -> float:
+    """Read the force until the duration has passed, return the highest in N."""
+    if not duration > 0:
+        msg = f"The duration to read the crimp force must be positive, got {duration} s."
+        raise ConfigError(msg)
+    peak = float("-inf")
+    end_time = time.monotonic() + duration
+    while time.monotonic() < end_time:
+        peak = max(peak, client.read_force())
+        time.sleep(poll_interval)
+    if not math.isfinite(peak):
+        msg = f"No valid crimp force was read within {duration} s."
+        raise InfeasibleError(msg)
+    return peak
+
+
+def loaded_cell(db_path: Path, press: int) -> int:
+    """Cell number loaded in a press."""
+    with connect(db_path) as conn:
+        row = conn.execute(
+            "SELECT `Current Cell Number Loaded` FROM Press_Table WHERE `Press Number` = ?",
+            (press,),
+        ).fetchone()
+    if not row or not row[0]:
+        msg = f"No cell is loaded in press {press}."
+        raise InfeasibleError(msg)
+    return int(row[0])
+
+
+def record_force(
+    press: int,
+    duration: float = PRESS_FORCE_DURATION_S,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> float:
+    """Read the peak crimp force of a press and store it for the cell loaded in it.
+
+    Args:
+        press: Press number
+        duration: Seconds to read the force for, should cover the whole crimp
+        db_path: Path to the robot database
+        dry_run: Log the force instead of storing it
+
+    Returns:
+        The peak force in N
+
+    """
+    check_press(press)
+    if not duration > 0:
+        msg = f"The duration to read the crimp force must be positive, got {duration} s."
+        raise ConfigError(msg)
+    if press not in PRESS_FORCE_CONTROLLERS:
+        msg = f"No controller for press {press}, add it to PRESS_FORCE_CONTROLLERS in the config."
+        raise ConfigError(msg)
+    cell = loaded_cell(db_path, press)
+    client = connect_controller(PRESS_FORCE_CONTROLLERS[press])
+    try:
+        force = peak_force(client, duration, PRESS_FORCE_POLL_S)
+    finally:
+        client.close()
+    logger.info("Peak crimp force of cell %d in press %d: %.1f N", cell, press, force)
+    if dry_run:
+        logger.info("Dry run, crimp force not stored.")
+        return force
+    if not has_column(db_path, "Cell_Assembly_Table", "Crimp Force (N)", "the crimp force is only logged"):
+        return force
+    with connect(db_path) as conn:
+        conn.execute(
+            "UPDATE Cell_Assembly_Table SET `Crimp Force (N)` = ? WHERE `Cell Number` = ?",
+            (force, cell),
+        )
+    return force
@@ -0,0 +1,181 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Take presses out of service and back, store their calibration, and show the state of each press.
+
+A press that needs maintenance, e.g. because its load cell drifts, is disabled with a reason and no
+cells are assigned to it until it is enabled again. The flags are stored in the Press_Status_Table,
+which import-excel does not replace, so they stay set between runs. Presses can also be disabled
+permanently for one robot PC with DISABLED_PRESSES in the config.
+
+The calibration error of each press, i.e. how far the force measured by its load cell is off from a
+reference load cell in percent, is stored in the same table. Cells with a Pressure Tolerance (%) in
+the input are only assigned to presses calibrated at least that well, see assign_cells_to_press.py.
+
+Usage:
+    `aurora-rt press disable 3 --reason "load cell drift"`, `aurora-rt press enable 3`,
+    `aurora-rt press calibrate 3 --error 1.5` and `aurora-rt press status`.
+"""
+
+import logging
+import sqlite3
+from datetime import datetime
+from pathlib import Path
+
+import pytz
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, DISABLED_PRESSES, PRESS_TO_RACK, TIME_ZONE
+from aurora_robot_tools.database import connect, has_table
+from aurora_robot_tools.errors import ConfigError
+
+logger = logging.getLogger(__name__)
+
+PRESS_STATUS_TABLE = "Press_Status_Table"
+PRESS_STATUS_COLUMNS = {
+    "Press Number": "INTEGER PRIMARY KEY",
+    "Disabled": "INTEGER",
+    "Reason": "TEXT",
+    "Changed": "TEXT",
+    "Calibration Error (%)": "REAL",
+    "Calibrated": "TEXT",
+}
+
+
+def create_status_table(conn: sqlite3.Connection) -> None:
+    """Create the Press_Status_Table, or add the columns missing from older versions."""
+    from aurora_robot_tools.migrations import create_table, table_columns
+
+    create_table(conn, PRESS_STATUS_TABLE, PRESS_STATUS_COLUMNS)
+    columns = table_columns(conn, PRESS_STATUS_TABLE)
+    for column, sql_type in PRESS_STATUS_COLUMNS.items():
+        if column not in columns:
+            conn.execute(f"ALTER TABLE {PRESS_STATUS_TABLE} ADD COLUMN `{column}` {sql_type}")
+
+
+def read_press_status(db_path: Path = DATABASE_FILEPATH) -> dict[int, dict]:
+    """Get the stored status of each press, empty for databases without a Press_Status_Table."""
+    skipped = "presses are only disabled by DISABLED_PRESSES and their calibration is not used"
+    if not has_table(db_path, PRESS_STATUS_TABLE, skipped):
+        return {}
+    with connect(db_path) as conn:
+        cursor = conn.execute(f"SELECT * FROM {PRESS_STATUS_TABLE}")  # noqa: S608
+        columns = [column[0] for column in cursor.description]
+        rows = [dict(zip(columns, row)) for row in cursor.fetchall()]
+    return {
+        int(row["Press Number"]): {
+            "disabled": bool(row["Disabled"]),
+            "reason": row["Reason"],
+            "changed": row["Changed"],
+            "calibration_error": row.get("Calibration Error (%)"),
+            "calibrated": row.get("Calibrated"),
+        }
+        for row in rows
+    }
+
+
+def press_calibration(db_path: Path = DATABASE_FILEPATH) -> dict[int, float]:
+    """Get the calibration error in percent of each press that has been calibrated."""
+    return {
+        press: float(status["calibration_error"])
+        for press, status in read_press_status(db_path).items()
+        if status["calibration_error"] is not None
+    }
+
+
+def check_press(press: int) -> None:
+    """Raise an error if the press is not in the config."""
+    if press not in PRESS_TO_RACK:
+        msg = f"Unknown press {press}, must be one of {', '.join(str(p) for p in PRESS_TO_RACK)}."
+        raise ConfigError(msg)
+
+
+def update_press_status(db_path: Path, press: int, values: dict) -> None:
+    """Set some columns of the status of a press, the other columns keep their values."""
+    with connect(db_path) as conn:
+        create_status_table(conn)
+        conn.execute(
+            f"INSERT OR IGNORE INTO {PRESS_STATUS_TABLE} (`Press Number`, `Disabled`) VALUES (?, 0)",  # noqa: S608
+            (press,),
+        )
+        assignments = ", ".join(f"`{column}` = ?" for column in values)
+        conn.execute(
+            f"UPDATE {PRESS_STATUS_TABLE} SET {assignments} WHERE `Press Number` = ?",  # noqa: S608
+            (*values.values(), press),
+        )
+
+
+def disabled_presses(db_path: Path = DATABASE_FILEPATH) -> dict[int, str]:
+    """Get the presses that must not be used, with the reason."""
+    disabled = {press: "disabled in config" for press in DISABLED_PRESSES}
+    for press, status in read_press_status(db_path).items():
+        if status["disabled"] and press not in disabled:
+            disabled[press] = status["reason"] or "disabled"
+    return disabled
+
+
+def set_disabled(
+    press: int,
+    disabled: bool,
+    reason: str = "",
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Disable or enable a press."""
+    check_press(press)
+    action = "disable" if disabled else "enable"
+    if dry_run:
+        logger.info("Dry run, would %s press %d%s.", action, press, f" ({reason})" if reason else "")
+        return
+    changed = datetime.now(pytz.timezone(TIME_ZONE)).isoformat(timespec="seconds")
+    update_press_status(
+        db_path,
+        press,
+        {"Disabled": int(disabled), "Reason": reason if disabled else "", "Changed": changed},
+    )
+    logger.info("Press %d %sd%s.", press, action, f": {reason}" if disabled and reason else "")
+    if not disabled and press in DISABLED_PRESSES:
+        logger.warning("Press %d is still disabled by DISABLED_PRESSES in the config.", press)
+
+
+def set_calibration(
+    press: int,
+    error: float,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Store the calibration error of a press in percent, measured against a reference load cell."""
+    check_press(press)
+    if error < 0:
+        msg = f"Calibration error must be 0 or more percent, got {error}."
+        raise ConfigError(msg)
+    if dry_run:
+        logger.info("Dry run, would set the calibration error of press %d to %.2f %%.", press, error)
+        return
+    calibrated = datetime.now(pytz.timezone(TIME_ZONE)).isoformat(timespec="seconds")
+    update_press_status(db_path, press, {"Calibration Error (%)": error, "Calibrated": calibrated})
+    logger.info("Press %d calibrated, error %.2f %%.", press, error)
+
+
+def status(db_path: Path = DATABASE_FILEPATH) -> None:
+    """Log the state of each press."""
+    stored = read_press_status(db_path)
+    disabled = disabled_presses(db_path)
+    with connect(db_path) as conn:
+        rows = conn.execute("SELECT `Press Number`, `Current Cell Number Loaded`, `Error Code` FROM Press_Table")
+        press_table = {int(press): (cell, error) for press, cell, error in rows}
+    lines = []
+    for press in PRESS_TO_RACK:
+        cell, error = press_table.get(press, (0, 0))
+        if press in disabled:
+            state = f"disabled: {disabled[press]}"
+            if press not in DISABLED_PRESSES:
+                state += f" (since {stored[press]['changed']})"
+        elif error:
+            state = f"error code {error}"
+        elif cell:
+            state = f"loaded with cell {cell}"
+        else:
+            state = "available"
+        error = stored.get(press, {}).get("calibration_error")
+        calibration = f"{error:.2f} %" if error is not None else "-"
+        lines.append(f"{press:<7} {PRESS_TO_RACK[press]:<6} {calibration:<13} {state}")
+    logger.info("Press | Rack | Calibration | State\n%s", "\n".join(lines))
@@ -0,0 +1,26 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Refuse to write to the production database by mistake.
+
+If a `production` profile is defined in DATABASE_PROFILES, commands refuse to write to that database,
+however it is selected, e.g. with --db, --db-profile or DATABASE_FILEPATH, unless --allow-production
+is given. The services check a DATABASE_FILEPATH reloaded while they run again, see config_watch.py.
+
+Usage:
+    Checked by the command line, `aurora-rt --allow-production balance` to write to production.
+"""
+
+from pathlib import Path
+
+from aurora_robot_tools import config
+from aurora_robot_tools.errors import ConfigError
+
+allowed = False  # --allow-production, or --dry-run of a service, set by the command line
+
+
+def refuse_production(db_path: Path) -> None:
+    """Refuse to write to the production profile database."""
+    production = config.DATABASE_PROFILES.get("production")
+    if production is not None and Path(db_path).resolve() == Path(production).resolve():
+        msg = f"{db_path} is the production database, use --allow-production to write to it."
+        raise ConfigError(msg)
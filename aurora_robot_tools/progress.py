@@ -0,0 +1,99 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Report the progress of long-running commands.
+
+Progress is printed as lines of the form `PROGRESS <percent> <message>`, so anything reading the
+output can parse it, and written to a status file (STATUS_FILE in the config) that AutoSuite or an
+operator can poll, e.g.
+
+    {"command": "balance", "pid": 1234, "state": "running", "percent": 40, "message": "Batch 2 of 5",
+     "started": "...", "updated": "..."}
+
+The state is "running" while the command runs, then "finished" or "failed" with the exit code.
+"""
+
+import json
+import logging
+import os
+from datetime import datetime, timezone
+from pathlib import Path
+
+from aurora_robot_tools import chaos, watchdog
+from aurora_robot_tools.config import STATUS_FILE
+from aurora_robot_tools.shutdown import temporary
+
+logger = logging.getLogger(__name__)
+
+status: dict = {}
+
+
+def write_status(status_file: Path = STATUS_FILE) -> None:
+    """Write the status file atomically, ignore errors so progress never breaks a command."""
+    status["updated"] = datetime.now(timezone.utc).isoformat()
+    status_file = Path(status_file)
+    tmp_path = status_file.with_suffix(".tmp")
+    try:
+        status_file.parent.mkdir(parents=True, exist_ok=True)
+        with temporary(tmp_path):
+            tmp_path.write_text(json.dumps(status), encoding="utf-8")
+            tmp_path.replace(status_file)
+    except OSError as e:
+        logger.debug("Could not write status file %s: %s", status_file, e)
+
+
+def warn_if_crashed(status_file: Path = STATUS_FILE) -> None:
+    """Warn if the previous command is still marked as running, but its process no longer exists."""
+    from aurora_robot_tools.lock import process_running
+
+    try:
+        previous = json.loads(Path(status_file).read_text(encoding="utf-8"))
+    except (OSError, json.JSONDecodeError):
+        return
+    pid = previous.get("pid")
+    if previous.get("state") == "running" and isinstance(pid, int) and pid != os.getpid() and not process_running(pid):
+        logger.warning(
+            "The previous command %s (process %d) did not finish, it probably crashed or was killed at %s%% (%s).",
+            previous.get("command", "unknown"),
+            pid,
+            previous.get("percent", "?"),
+            previous.get("message", ""),
+        )
+
+
+def start(command: str) -> None:
+    """Start reporting progress for a command."""
+    warn_if_crashed()
+    status.clear()
+    status.update(
+        {
+            "command": command,
+            "pid": os.getpid(),
+            "state": "running",
+            "percent": 0,
+            "message": "Started",
+            "started": datetime.now(timezone.utc).isoformat(),
+        },
+    )
+    write_status()
+
+
+def report(percent: float, message: str) -> None:
+    """Report progress, percent from 0 to 100."""
+    chaos.on_step()
+    watchdog.activity("progress report")
+    percent = round(min(max(percent, 0), 100))
+    logger.info("PROGRESS %d %s", percent, message)
+    if status:
+        status.update({"percent": percent, "message": message})
+        write_status()
+
+
+def finish(exit_code: int) -> None:
+    """Mark the command as finished or failed."""
+    if not status:
+        return
+    if exit_code == 0:
+        status.update({"state": "finished", "percent": 100, "message": "Finished", "exit_code": 0})
+    else:
+        status.update({"state": "failed", "exit_code": int(exit_code)})
+    write_status()
@@ -0,0 +1,178 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Report exactly which version of the tools is running, for support tickets.
+
+The version number alone does not say which code runs on a robot PC, so the git commit and build
+date are found from, in this order:
+    - The git checkout the tools are run from, e.g. an editable install, with "-dirty" if it has
+      uncommitted changes
+    - BUILD_INFO.json in the package, written by `aurora-rt version --stamp` before building a wheel
+    - The commit pip recorded when the tools were installed from git (direct_url.json)
+
+`aurora-rt --version`, also after any command, e.g. `aurora-rt balance --version`, prints the
+version, commit and build date. `aurora-rt version` also reports Python, the schema version of the
+database and the SHA-256 hash of every companion script, from SCRIPT_HASHES and the balancing
+plugin, with whether it matches the expected hash.
+
+Usage:
+    `aurora-rt version`, paste the output into the ticket.
+"""
+
+import json
+import logging
+import platform
+import shlex
+import subprocess
+import sys
+from datetime import datetime, timezone
+from importlib import metadata
+from pathlib import Path
+
+from aurora_robot_tools.version import __version__
+
+logger = logging.getLogger(__name__)
+
+DISTRIBUTION = "aurora-robot-tools"
+PACKAGE_DIR = Path(__file__).resolve().parent
+BUILD_INFO = PACKAGE_DIR / "BUILD_INFO.json"
+
+
+def git(*args: str) -> str | None:
+    """Output of a git command in the folder of the package, None if it is not a git checkout."""
+    try:
+        result = subprocess.run(  # noqa: S603
+            ["git", "-C", str(PACKAGE_DIR), *args],  # noqa: S607
+            capture_output=True,
+            text=True,
+            check=False,
+            timeout=5,
+        )
+    except (OSError, subprocess.TimeoutExpired):
+        return None
+    return result.stdout.strip() if result.returncode == 0 else None
+
+
+def from_git() -> dict[str, str] | None:
+    """Commit and commit date of the git checkout the package is run from."""
+    if not (PACKAGE_DIR.parent / "pyproject.toml").is_file():
+        return None  # Installed, e.g. into a virtual environment inside another repository
+    commit = git("rev-parse", "HEAD")
+    if not commit:
+        return None
+    if git("status", "--porcelain", "--untracked-files=no"):
+        commit += "-dirty"
+    return {"commit": commit, "build_date": git("log", "-1", "--format=%cI") or "", "source": "git checkout"}
+
+
+def from_installation() -> dict[str, str] | None:
+    """Commit pip recorded when installing from git, and the installation date."""
+    try:
+        distribution = metadata.distribution(DISTRIBUTION)
+    except metadata.PackageNotFoundError:
+        return None
+    direct_url = json.loads(distribution.read_text("direct_url.json") or "{}")
+    commit = direct_url.get("vcs_info", {}).get("commit_id")
+    if not commit:
+        return None
+    # The metadata files are written when the package is installed
+    metadata_file = next((Path(file.locate()) for file in distribution.files or [] if file.name == "METADATA"), None)
+    installed = ""
+    if metadata_file is not None and metadata_file.is_file():
+        installed = datetime.fromtimestamp(metadata_file.stat().st_mtime, timezone.utc).isoformat(timespec="seconds")
+    return {"commit": commit, "build_date": installed, "source": f"pip install from {direct_url.get('url')}"}
+
+
+def build_info() -> dict[str, str]:
+    """Version, commit, build date and where they were found."""
+    info = from_git()
+    if info is None and BUILD_INFO.is_file():
+        try:
+            info = {**json.loads(BUILD_INFO.read_text(encoding="utf-8")), "source": "build stamp"}
+        except (OSError, json.JSONDecodeError):
+            info = None
+    info = info or from_installation() or {"commit": "unknown", "build_date": "", "source": "unknown"}
+    return {**info, "version": __version__}
+
+
+def short_version() -> str:
+    """One line with the version, commit and build date."""
+    info = build_info()
+    built = f", built {info['build_date']}" if info.get("build_date") else ""
+    commit = info["commit"][:12] + ("-dirty" if info["commit"].endswith("-dirty") else "")
+    return f"aurora-rt {info['version']} (commit {commit}{built})"
+
+
+def stamp() -> Path:
+    """Write the commit and date of the git checkout into the package, before building a wheel."""
+    from aurora_robot_tools.errors import EnvironmentProblemError
+
+    info = from_git()
+    if info is None:
+        msg = f"{PACKAGE_DIR} is not a git checkout, can not stamp the build."
+        raise EnvironmentProblemError(msg)
+    stamped = {
+        "version": __version__,
+        "commit": info["commit"],
+        "build_date": datetime.now(timezone.utc).isoformat(timespec="seconds"),
+    }
+    BUILD_INFO.write_text(json.dumps(stamped, indent=2) + "\n", encoding="utf-8")
+    logger.info("Stamped %s with commit %s", BUILD_INFO, stamped["commit"])
+    return BUILD_INFO
+
+
+def companion_scripts() -> list[dict]:
+    """Hash of each companion script, with the expected hash from SCRIPT_HASHES if there is one."""
+    from aurora_robot_tools.config import BALANCE_PLUGIN, SCRIPT_HASHES
+    from aurora_robot_tools.integrity import file_hash
+
+    scripts = {Path(path).resolve(): expected.lower() for path, expected in SCRIPT_HASHES.items()}
+    if BALANCE_PLUGIN:
+        for arg in shlex.split(BALANCE_PLUGIN, posix=False):
+            path = Path(arg.strip('"')).resolve()
+            if path.suffix == ".py":
+                scripts.setdefault(path, None)
+    rows = []
+    for path, expected in scripts.items():
+        digest = file_hash(path) if path.is_file() else None
+        status = "missing" if digest is None else ("no expected hash" if expected is None else "ok")
+        if digest and expected and digest != expected:
+            status = "MODIFIED"
+        rows.append({"path": str(path), "sha256": digest, "status": status})
+    return rows
+
+
+def versions(db_path: Path) -> dict:
+    """Everything the version command reports."""
+    from aurora_robot_tools.migrations import LATEST_VERSION, get_version
+
+    try:
+        schema = get_version(db_path)
+    except Exception as e:  # noqa: BLE001
+        schema = f"unreadable ({e})"
+    return {
+        **build_info(),
+        "python": f"{platform.python_version()} at {sys.executable}",
+        "database": str(db_path),
+        "schema_version": schema,
+        "latest_schema_version": LATEST_VERSION,
+        "companion_scripts": companion_scripts(),
+    }
+
+
+def main(db_path: Path) -> dict:
+    """Log the version report."""
+    report = versions(db_path)
+    lines = [
+        f"aurora-robot-tools {report['version']}",
+        f"Commit: {report['commit']} ({report['source']})",
+        f"Build date: {report['build_date'] or 'unknown'}",
+        f"Python: {report['python']}",
+        f"Database: {report['database']}, schema version {report['schema_version']} "
+        f"(latest {report['latest_schema_version']})",
+    ]
+    if not report["companion_scripts"]:
+        lines.append("Companion scripts: none")
+    for script in report["companion_scripts"]:
+        lines.append(f"Companion script: {script['path']} sha256 {script['sha256'] or '-'} ({script['status']})")
+    logger.info("\n".join(lines))
+    return report
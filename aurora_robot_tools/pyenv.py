@@ -0,0 +1,171 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Manage a dedicated Python environment for the tools on each robot PC.
+
+When the tools are installed into whatever Python happens to be on a PC, the package versions drift
+between robot PCs and a command that works on one fails on the other. Instead, every PC gets a
+virtual environment at PYTHON_ENV_DIR, built from one pinned requirements file, PYTHON_REQUIREMENTS,
+with exact versions of every package:
+
+    - `aurora-rt pyenv freeze` writes the requirements file from a PC where everything works
+    - `aurora-rt pyenv setup` (re)creates the environment from the requirements file and installs
+      the tools into it, AutoSuite then calls the aurora-rt in its Scripts folder
+    - `aurora-rt pyenv status` shows if the environment matches the requirements file, and if the
+      running aurora-rt is the one from the environment
+
+Companion scripts started with "python" or "py", e.g. the balancing plugin, are run with the
+interpreter of the environment, so they see the same packages as the tools.
+
+Usage:
+    `aurora-rt pyenv setup`, `aurora-rt pyenv freeze` and `aurora-rt pyenv status`.
+"""
+
+import logging
+import os
+import subprocess
+import sys
+import venv
+from pathlib import Path
+
+from aurora_robot_tools.config import PYTHON_ENV_DIR, PYTHON_REQUIREMENTS
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+from aurora_robot_tools.version import __version__
+
+logger = logging.getLogger(__name__)
+
+DISTRIBUTION = "aurora-robot-tools"
+PYTHON_COMMANDS = ("python", "python3", "python.exe", "py", "py.exe")
+
+
+def env_python(env_dir: Path = PYTHON_ENV_DIR) -> Path:
+    """Path of the interpreter in an environment."""
+    if os.name == "nt":
+        return Path(env_dir) / "Scripts" / "python.exe"
+    return Path(env_dir) / "bin" / "python"
+
+
+def in_env(env_dir: Path = PYTHON_ENV_DIR) -> bool:
+    """Check if the tools are running from the environment."""
+    return Path(sys.prefix).resolve() == Path(env_dir).resolve()
+
+
+def interpreter(env_dir: Path = PYTHON_ENV_DIR) -> str:
+    """Interpreter for companion scripts, from the environment if it exists."""
+    python = env_python(env_dir)
+    return str(python) if python.exists() else sys.executable
+
+
+def resolve_command(args: list[str]) -> list[str]:
+    """Run companion scripts started with python or py with the interpreter of the environment."""
+    if args and Path(args[0]).name.lower() in PYTHON_COMMANDS:
+        return [interpreter(), *args[1:]]
+    return args
+
+
+def parse_pinned(text: str) -> dict[str, str]:
+    """Read the pinned versions of a requirements file or pip freeze output, package name to version."""
+    pinned = {}
+    for line in text.splitlines():
+        name, pinned_with, version = line.split("#")[0].split(";")[0].partition("==")
+        if pinned_with:
+            pinned[normalize(name)] = version.strip()
+    return pinned
+
+
+def normalize(name: str) -> str:
+    """Normalize a package name for comparison, e.g. Typer_Slim and typer-slim are the same."""
+    return name.strip().lower().replace("_", "-").replace(".", "-")
+
+
+def pip(python: Path | str, *args: str) -> str:
+    """Run pip with an interpreter, return its output."""
+    command = [str(python), "-m", "pip", *args]
+    logger.debug("Running %s", " ".join(command))
+    try:
+        result = subprocess.run(command, capture_output=True, text=True, check=False)  # noqa: S603
+    except OSError as e:
+        msg = f"Cannot run {python}: {e}"
+        raise EnvironmentProblemError(msg) from e
+    if result.returncode != 0:
+        errors = result.stderr.strip().splitlines()
+        msg = f"pip {args[0]} failed: {errors[-1] if errors else f'exit code {result.returncode}'}"
+        raise EnvironmentProblemError(msg)
+    return result.stdout
+
+
+def package_source() -> str:
+    """What to install the tools from, the git checkout if running from one, else this version."""
+    project = Path(__file__).resolve().parent.parent
+    if (project / "pyproject.toml").is_file():
+        return str(project)
+    return f"{DISTRIBUTION}=={__version__}"
+
+
+def freeze(requirements: Path = PYTHON_REQUIREMENTS, dry_run: bool = False) -> None:
+    """Write the pinned versions of the packages of the running Python to the requirements file."""
+    lines = [
+        line
+        for line in pip(sys.executable, "freeze", "--exclude-editable").splitlines()
+        if "==" in line and normalize(line.partition("==")[0]) != DISTRIBUTION
+    ]
+    if dry_run:
+        logger.info("Dry run, would write %d pinned packages to %s.", len(lines), requirements)
+        return
+    requirements = Path(requirements)
+    requirements.parent.mkdir(parents=True, exist_ok=True)
+    requirements.write_text("\n".join(lines) + "\n", encoding="utf-8")
+    logger.info("Wrote %d pinned packages to %s.", len(lines), requirements)
+
+
+def setup(
+    env_dir: Path = PYTHON_ENV_DIR,
+    requirements: Path = PYTHON_REQUIREMENTS,
+    package: str | None = None,
+    dry_run: bool = False,
+) -> None:
+    """Create the environment from scratch, install the pinned requirements and the tools."""
+    requirements = Path(requirements)
+    if not requirements.is_file():
+        msg = f"Requirements file {requirements} not found, write it with `aurora-rt pyenv freeze`."
+        raise ConfigError(msg)
+    if in_env(env_dir):
+        msg = f"Cannot rebuild {env_dir} while running from it, run this with another Python."
+        raise EnvironmentProblemError(msg)
+    package = package or package_source()
+    if dry_run:
+        logger.info("Dry run, would create %s from %s and install %s.", env_dir, requirements, package)
+        return
+    logger.info("Creating environment %s", env_dir)
+    venv.EnvBuilder(with_pip=True, clear=True).create(env_dir)
+    python = env_python(env_dir)
+    logger.info("Installing the pinned requirements from %s", requirements)
+    pip(python, "install", "--no-input", "-r", str(requirements))
+    # The requirements pin every dependency, only the tools themselves are left to install
+    pip(python, "install", "--no-input", "--no-deps", package)
+    logger.info("Environment ready, call %s from AutoSuite.", Path(python).with_name("aurora-rt"))
+
+
+def status(env_dir: Path = PYTHON_ENV_DIR, requirements: Path = PYTHON_REQUIREMENTS) -> None:
+    """Log if the environment exists, matches the requirements and is the one running."""
+    python = env_python(env_dir)
+    if not python.exists():
+        logger.warning("No environment at %s, create it with `aurora-rt pyenv setup`.", env_dir)
+        return
+    if in_env(env_dir):
+        logger.info("Running from the environment %s.", env_dir)
+    else:
+        logger.warning("Running from %s, not from the environment %s.", sys.prefix, env_dir)
+    if not Path(requirements).is_file():
+        logger.warning("Requirements file %s not found.", requirements)
+        return
+    pinned = parse_pinned(Path(requirements).read_text(encoding="utf-8"))
+    installed = parse_pinned(pip(python, "freeze", "--exclude-editable"))
+    drift = [
+        f"{name} {installed.get(name, 'missing')} (pinned {version})"
+        for name, version in sorted(pinned.items())
+        if installed.get(name) != version
+    ]
+    if drift:
+        logger.warning("%d packages differ from %s:\n%s", len(drift), requirements, "\n".join(drift))
+    else:
+        logger.info("All %d pinned packages match %s.", len(pinned), requirements)
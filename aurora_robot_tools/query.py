@@ -0,0 +1,115 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Run SQL queries on the robot database from the command line.
+
+The robot PCs are locked down and DB Browser cannot be installed, so the database is inspected with
+the tools instead. Queries open the database read-only, so they cannot change anything by mistake,
+do not take the lock and also work on the production database while a command or the robot is
+using it. The result is printed as a table, or as CSV or JSON to pass on to other programs.
+
+Changing the database by hand is possible with `--write`, which takes the lock, backs up the
+database first and is refused on the production database without `--allow-production`, like every
+other command that writes.
+
+Usage:
+    `aurora-rt db query "SELECT * FROM Press_Table"`, with `--format csv` or `--format json`, or
+    `aurora-rt db query --write "UPDATE ..."`.
+"""
+
+import csv
+import json
+import logging
+import sqlite3
+import sys
+from contextlib import closing
+from pathlib import Path
+
+from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.database import connect
+from aurora_robot_tools.errors import ConfigError, DatabaseError
+
+logger = logging.getLogger(__name__)
+
+QUERY_FORMATS = ("table", "csv", "json")
+
+
+def connect_read_only(db_path: Path) -> sqlite3.Connection:
+    """Open a connection that cannot change the database."""
+    db_path = Path(db_path)
+    if not db_path.exists():
+        msg = f"Database {db_path} does not exist."
+        raise DatabaseError(msg)
+    conn = sqlite3.connect(f"file:{db_path.as_posix()}?mode=ro", uri=True)
+    conn.execute("PRAGMA query_only = ON")
+    return conn
+
+
+def format_table(columns: list[str], rows: list[tuple]) -> str:
+    """Format rows as a table with aligned columns."""
+    cells = [["" if value is None else str(value) for value in row] for row in rows]
+    widths = [max([len(column), *(len(row[i]) for row in cells)]) for i, column in enumerate(columns)]
+    lines = [
+        " | ".join(column.ljust(width) for column, width in zip(columns, widths)),
+        "-+-".join("-" * width for width in widths),
+    ]
+    lines += [" | ".join(value.ljust(width) for value, width in zip(row, widths)) for row in cells]
+    return "\n".join(line.rstrip() for line in lines)
+
+
+def print_rows(columns: list[str], rows: list[tuple], file_format: str) -> None:
+    """Print the result of a query in a format."""
+    if file_format == "csv":
+        writer = csv.writer(sys.stdout, lineterminator="\n")
+        writer.writerow(columns)
+        writer.writerows(rows)
+    elif file_format == "json":
+        print(json.dumps([dict(zip(columns, row)) for row in rows], indent=4, default=str))
+    else:
+        print(format_table(columns, rows))
+
+
+def main(
+    sql: str,
+    file_format: str = "table",
+    write: bool = False,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Run a query on the database and print the result.
+
+    Args:
+        sql: a single SQL statement
+        file_format: table, csv or json
+        write: allow the statement to change the database
+        db_path: the database
+        dry_run: with write, roll back the changes
+
+    """
+    if file_format not in QUERY_FORMATS:
+        msg = f"Format must be one of {', '.join(QUERY_FORMATS)}, got '{file_format}'."
+        raise ConfigError(msg)
+    if write and not dry_run:
+        from aurora_robot_tools.backup_database import auto_backup
+
+        auto_backup(db_path)
+    with closing(connect(db_path) if write else connect_read_only(db_path)) as conn:
+        try:
+            if write and dry_run:
+                # sqlite3 only opens a transaction before INSERT, UPDATE and DELETE, so DROP, ALTER and
+                # CREATE could not be rolled back otherwise
+                conn.execute("BEGIN")
+            cursor = conn.execute(sql)
+            rows = cursor.fetchall()
+        except sqlite3.OperationalError as e:
+            if "readonly" in str(e) or "query_only" in str(e):
+                msg = f"The database is opened read-only, use --write to change it: {e}"
+                raise DatabaseError(msg) from e
+            raise
+        if cursor.description is not None:
+            print_rows([column[0] for column in cursor.description], rows, file_format)
+        if write and dry_run:
+            conn.rollback()
+            logger.info("Dry run, %d rows would change.", max(cursor.rowcount, 0))
+        elif write:
+            conn.commit()
+            logger.info("%d rows changed.", max(cursor.rowcount, 0))
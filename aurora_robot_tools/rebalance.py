@@ -0,0 +1,211 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Reject cells part-way through a run and re-balance the rest of the batch.
+
+If cells fail during assembly, e.g. a dropped electrode or a failed crimp, the rejected cells are
+removed and the electrodes that are still in the rack are matched again with the other cells that
+have not started assembly, without restarting the run.
+
+For each rejected cell:
+    - If the cell has not started assembly, its electrodes stay in the pool, except the ones listed
+      as lost.
+    - If the cell has started assembly, it gets error code 202 and keeps its cell number. Electrodes
+      that are not lost and have not been placed yet (Last Completed Step is before the first step
+      placing that electrode in STEP_DEFINITION) are moved to a free rack position in the same batch,
+      if there is one.
+
+Cells that have started assembly keep their cell numbers. The remaining cells are re-balanced with
+the chosen sorting method, numbered after the last started cell in the assembly order, see
+assembly_order.py, and their press assignments are recomputed. Writing the cells and assigning the
+presses are journalled as one operation, see journal.py.
+
+Usage:
+    `aurora-rt rebalance 5 12 --lost anode` rejects cells 5 and 12, where the anodes were lost.
+"""
+
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools import progress
+from aurora_robot_tools.assembly_order import log_order, order_cells
+from aurora_robot_tools.assign_cells_to_press import main as assign_main
+from aurora_robot_tools.capacity_balance import (
+    balance_batches,
+    calculate_capacity,
+    calculate_np_ratio,
+    find_out_of_spec,
+)
+from aurora_robot_tools.cell_state import set_state
+from aurora_robot_tools.config import DATABASE_FILEPATH, STEP_DEFINITION
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.errors import ConfigError
+from aurora_robot_tools.inventory import INVENTORY_DTYPES, INVENTORY_TABLE, build_inventory, warn_if_running_out
+from aurora_robot_tools.journal import operation
+
+logger = logging.getLogger(__name__)
+
+REJECTED_ERROR_CODE = 202  # Error code for cells rejected during assembly
+
+ELECTRODES = ["Anode", "Cathode"]
+LOST_OPTIONS = {"both": ELECTRODES, "anode": ["Anode"], "cathode": ["Cathode"], "none": []}
+
+# First step of the robot recipe that places each electrode, before this it is still in the rack
+PLACE_STEPS = {e: min(k for k, v in STEP_DEFINITION.items() if v["Step"] == e) for e in ELECTRODES}
+
+
+def electrode_columns(df: pd.DataFrame, electrode: str) -> list[str]:
+    """Get the columns belonging to the anode or cathode."""
+    return [col for col in df.columns if electrode in col]
+
+
+def unassign_presses(df: pd.DataFrame, df_press: pd.DataFrame) -> None:
+    """Remove cells that have not started assembly from the presses, so they can be re-assigned."""
+    not_started = (df["Last Completed Step"] == 0) & (df["Current Press Number"] > 0)
+    if not not_started.any():
+        return
+    logger.info("Removing press assignment of cells %s", df.loc[not_started, "Cell Number"].tolist())
+    press_mask = df_press["Current Cell Number Loaded"].isin(df.loc[not_started, "Cell Number"])
+    df_press.loc[press_mask, "Current Cell Number Loaded"] = 0
+    if "Loaded Time" in df_press.columns:
+        df_press.loc[press_mask, "Loaded Time"] = 0
+    df.loc[not_started, "Current Press Number"] = 0
+
+
+def reject_cells(df: pd.DataFrame, cell_numbers: list[int], lost: list[str]) -> None:
+    """Reject cells in-place and return their electrodes to the pool where possible."""
+    for cell_number in cell_numbers:
+        row = df.index[df["Cell Number"] == cell_number][0]
+        last_step = df.loc[row, "Last Completed Step"]
+        for electrode in lost:
+            df.loc[row, electrode_columns(df, electrode)] = np.nan
+
+        if last_step == 0:
+            df.loc[row, "Cell Number"] = 0
+            kept = [e.lower() for e in ELECTRODES if e not in lost]
+            logger.info(
+                "Cell %d had not started, returning %s to the pool",
+                cell_number,
+                " and ".join(kept) or "nothing",
+            )
+            continue
+
+        df.loc[row, "Error Code"] = REJECTED_ERROR_CODE
+        for electrode in ELECTRODES:
+            if electrode in lost or last_step >= PLACE_STEPS[electrode]:
+                continue
+            free = np.where(
+                (df["Batch Number"] == df.loc[row, "Batch Number"])
+                & (df["Last Completed Step"] == 0)
+                & (df["Error Code"] == 0)
+                & df[f"{electrode} Type"].isna(),
+            )[0]
+            if free.size == 0:
+                logger.warning(
+                    "No free rack position for the %s of cell %d, not returned",
+                    electrode.lower(),
+                    cell_number,
+                )
+                continue
+            columns = electrode_columns(df, electrode)
+            df.loc[[row, free[0]], columns] = df.loc[[free[0], row], columns].to_numpy()
+            logger.info("Returning the %s of cell %d to the pool", electrode.lower(), cell_number)
+        logger.info("Rejected cell %d with error code %d", cell_number, REJECTED_ERROR_CODE)
+
+
+def number_remaining_cells(df: pd.DataFrame, accepted: pd.Series, base_sample_id: str) -> None:
+    """Number the accepted cells after the last started cell, started cells keep their numbers."""
+    started = (df["Last Completed Step"] > 0) & (df["Cell Number"] > 0)
+    first_number = int(df.loc[started, "Cell Number"].max()) + 1 if started.any() else 1
+    df.loc[~started, "Cell Number"] = 0
+    for cell_number, cell_index in enumerate(order_cells(df, np.where(accepted & ~started)[0]), start=first_number):
+        df.loc[cell_index, "Cell Number"] = cell_number
+        df.loc[cell_index, "Sample ID"] = f"{base_sample_id}_{cell_number:02d}"
+
+
+def main(  # noqa: PLR0913
+    cell_numbers: list[int],
+    lost: str = "both",
+    sorting_method: int = 6,
+    rejection_cost_factor: float = 2,
+    link_rack_pos_to_press: bool = True,
+    limit_electrolytes_per_batch: int = 0,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+    reject_out_of_spec: bool = False,
+) -> None:
+    """Reject cells, re-balance the remaining cells and re-assign presses.
+
+    Args:
+        cell_numbers: Cell numbers to reject.
+        lost: Which electrodes of the rejected cells are lost, "both", "anode", "cathode" or "none".
+        sorting_method: The method to use for sorting the electrodes, see capacity_balance.
+        rejection_cost_factor: The cost of rejecting a cell in the cost matrix methods.
+        link_rack_pos_to_press: Passed to press assignment, see assign_cells_to_press.
+        limit_electrolytes_per_batch: Passed to press assignment, see assign_cells_to_press.
+        db_path: Path to the robot database.
+        dry_run: Log the changes instead of writing them to the database.
+        reject_out_of_spec: Reject cells with N:P ratio outside the config limits instead of aborting.
+
+    """
+    if lost not in LOST_OPTIONS:
+        msg = f"Lost electrodes must be one of {', '.join(LOST_OPTIONS)}, got '{lost}'."
+        raise ConfigError(msg)
+
+    df, df_settings, df_press = read_tables(db_path, "Cell_Assembly_Table", "Settings_Table", "Press_Table")
+    base_sample_id = df_settings.loc[df_settings["key"] == "Base Sample ID", "value"].to_numpy()[0]
+    missing = sorted(set(cell_numbers) - set(df.loc[df["Cell Number"] > 0, "Cell Number"]))
+    if missing:
+        msg = f"Cells {', '.join(str(c) for c in missing)} are not in the Cell_Assembly_Table."
+        raise ConfigError(msg)
+
+    unassign_presses(df, df_press)
+    reject_cells(df, cell_numbers, LOST_OPTIONS[lost])
+
+    calculate_capacity(df)
+    breaks_constraints = balance_batches(df, sorting_method, rejection_cost_factor, include_incomplete=True)
+
+    # Accept cells that have not started, keep to the lot constraints and are within the N:P ratio limits
+    remaining = (df["Last Completed Step"] == 0) & (df["Error Code"] == 0) & ~breaks_constraints
+    if sorting_method == 0:
+        accepted = remaining & df["Anode Type"].notna() & df["Cathode Type"].notna()
+    else:
+        df["N:P Ratio"] = calculate_np_ratio(df)
+        accepted = (
+            remaining & (df["N:P Ratio"] >= df["N:P Ratio Minimum"]) & (df["N:P Ratio"] <= df["N:P Ratio Maximum"])
+        )
+    number_remaining_cells(df, accepted, base_sample_id)
+    out_of_spec = find_out_of_spec(df, accepted, reject_out_of_spec)
+    if out_of_spec.any():
+        number_remaining_cells(df, accepted & ~out_of_spec, base_sample_id)
+    set_state(df, df["Last Completed Step"] == 0, "planned")
+    set_state(df, accepted & ~out_of_spec, "balanced")
+    log_order(df)
+    logger.info("%d cells left to assemble after rejecting %d.", (accepted & ~out_of_spec).sum(), len(cell_numbers))
+
+    inventory = build_inventory(df)
+    warn_if_running_out(inventory)
+
+    progress.report(95, "Writing to database")
+    tables = {"Cell_Assembly_Table": df, "Press_Table": df_press, INVENTORY_TABLE: inventory}
+    if dry_run:
+        write_tables(db_path, tables, dtypes={INVENTORY_TABLE: INVENTORY_DTYPES}, dry_run=True)
+        logger.info("Dry run, press assignment not recomputed.")
+        return
+    # Writing the cells and assigning the presses are separate writes, journal them as one operation
+    steps = [
+        {"description": "Write the re-balanced cells"},
+        {
+            "description": "Assign cells to presses",
+            "command": "assign-press",
+            "args": {"link": link_rack_pos_to_press, "limit": limit_electrolytes_per_batch},
+        },
+    ]
+    with operation(db_path, "rebalance", steps) as journal:
+        write_tables(db_path, tables, dtypes={INVENTORY_TABLE: INVENTORY_DTYPES})
+        journal.done(0)
+        logger.info("Updated database successfully, recomputing press assignment")
+        assign_main(link_rack_pos_to_press, limit_electrolytes_per_batch, db_path)
+        journal.done(1)
@@ -0,0 +1,226 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Write a batch report after a run, to attach to the electronic lab notebook.
+
+The report is a single HTML file in the output folder, named after the base sample ID, with:
+    - Plan: the cells as planned, anode, cathode, N:P ratio, electrolyte and press
+    - Assembly: for each cell the last completed step, state, press and error code from the robot
+    - Warnings: every warning and error logged by the commands run on the database since the batch
+      was imported, read from the run history and the log files
+    - Software: the version of the tools, Python and the packages that do the calculations
+    - Database: the SHA-256 hash of the database the report was made from
+
+The SHA-256 checksum of the report itself is embedded in it, in a meta tag and at the end, so an
+edited report can be found with `aurora-rt report --verify FILE`, which exits with the environment
+error code if it was changed. This is a checksum, not a signature, it shows the report was not
+changed after it was written but not who wrote it. For a PDF, print the HTML file from a browser.
+
+Usage:
+    `aurora-rt report`, or `aurora-rt report --verify C:/Modules/Output/250314_kigr_01_report.html`.
+"""
+
+import hashlib
+import html
+import json
+import logging
+import platform
+import re
+import sqlite3
+from datetime import datetime
+from importlib import metadata
+from pathlib import Path
+
+import pandas as pd
+import pytz
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, HISTORY_FILEPATH, OUTPUT_DIR, TIME_ZONE
+from aurora_robot_tools.database import get_setting, read_query
+from aurora_robot_tools.errors import ConfigError, DatabaseError, EnvironmentProblemError
+from aurora_robot_tools.integrity import file_hash
+from aurora_robot_tools.version import __version__
+
+logger = logging.getLogger(__name__)
+
+ASSEMBLY_COLUMNS = [
+    "Cell Number",
+    "Sample ID",
+    "Rack Position",
+    "Current Press Number",
+    "Last Completed Step",
+    "Cell State",
+    "Error Code",
+]
+IMPORT_COMMANDS = ("import-excel", "import-batch")
+PACKAGES = ("pandas", "numpy", "scipy", "pulp", "openpyxl")
+CHECKSUM_PLACEHOLDER = "0" * 64
+CHECKSUM_PATTERN = re.compile(r'<meta name="checksum" content="([0-9a-f]{64})">')
+
+STYLE = """
+body { font-family: sans-serif; font-size: 10pt; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #999; padding: 2px 6px; text-align: left; }
+th { background: #eee; }
+.checksum { font-family: monospace; color: #555; }
+"""
+
+
+def read_assembly(db_path: Path) -> pd.DataFrame:
+    """Read the assembly progress of the planned cells."""
+    df = read_query(db_path, "SELECT * FROM Cell_Assembly_Table WHERE `Cell Number` > 0")
+    columns = [col for col in ASSEMBLY_COLUMNS if col in df.columns]
+    return df[columns].sort_values("Cell Number").reset_index(drop=True)
+
+
+def batch_log_files(db_path: Path, history_path: Path = HISTORY_FILEPATH) -> list[Path]:
+    """Log files of the commands run on the database since the batch was imported, oldest first."""
+    if not Path(history_path).exists():
+        return []
+    with sqlite3.connect(history_path) as conn:
+        rows = conn.execute(
+            "SELECT `Command`, `Log File` FROM Run_History_Table WHERE `Database` = ? ORDER BY `Run ID`",
+            (str(db_path),),
+        ).fetchall()
+    log_files: list[Path] = []
+    for command, log_file in rows:
+        if command in IMPORT_COMMANDS:
+            log_files = []
+        if log_file:
+            log_files.append(Path(log_file))
+    return log_files
+
+
+def read_warnings(log_files: list[Path]) -> list[dict]:
+    """Warnings and errors from JSON log files, skipping files that are gone."""
+    warnings = []
+    for log_file in log_files:
+        try:
+            lines = log_file.read_text(encoding="utf-8").splitlines()
+        except OSError:
+            logger.debug("Log file %s not found, skipping it", log_file)
+            continue
+        for line in lines:
+            try:
+                entry = json.loads(line)
+            except json.JSONDecodeError:
+                continue
+            if entry.get("level") in ("WARNING", "ERROR", "CRITICAL"):
+                warnings.append(entry)
+    return warnings
+
+
+def software_versions() -> dict[str, str]:
+    """Versions of the tools, Python and the packages used for the calculations."""
+    versions = {"aurora-robot-tools": __version__, "Python": platform.python_version()}
+    for package in PACKAGES:
+        try:
+            versions[package] = metadata.version(package)
+        except metadata.PackageNotFoundError:
+            versions[package] = "not installed"
+    return versions
+
+
+def html_table(df: pd.DataFrame) -> str:
+    """Format a table as HTML, empty cells for missing values."""
+    header = "".join(f"<th>{html.escape(str(col))}</th>" for col in df.columns)
+    rows = [
+        "<tr>" + "".join(f"<td>{'' if pd.isna(value) else html.escape(str(value))}</td>" for value in row) + "</tr>"
+        for row in df.itertuples(index=False)
+    ]
+    return f"<table><tr>{header}</tr>{''.join(rows)}</table>"
+
+
+def add_checksum(document: str) -> str:
+    """Embed the SHA-256 checksum of the document in place of the placeholders."""
+    digest = hashlib.sha256(document.encode("utf-8")).hexdigest()
+    return document.replace(CHECKSUM_PLACEHOLDER, digest)
+
+
+def build_report(db_path: Path, history_path: Path = HISTORY_FILEPATH) -> str:
+    """Write the report as an HTML document with its checksum."""
+    from aurora_robot_tools.export_plan import read_plan
+
+    db_path = Path(db_path)
+    if not db_path.exists():
+        msg = f"Database {db_path} does not exist."
+        raise DatabaseError(msg)
+    run_id = get_setting(db_path, "Base Sample ID") or "batch"
+    created = datetime.now(pytz.timezone(TIME_ZONE)).isoformat(timespec="seconds")
+    warnings = read_warnings(batch_log_files(db_path, history_path))
+    df_warnings = pd.DataFrame(
+        [(w.get("time"), w.get("command"), w.get("level"), w.get("message")) for w in warnings],
+        columns=["Time", "Command", "Level", "Message"],
+    )
+    df_software = pd.DataFrame(list(software_versions().items()), columns=["Package", "Version"])
+    df_database = pd.DataFrame(
+        [("Path", str(db_path)), ("SHA-256", file_hash(db_path))],
+        columns=["Item", "Value"],
+    )
+    title = html.escape(f"Batch report {run_id}")
+    sections = [
+        f"<h1>{title}</h1>",
+        f"<p>Created {html.escape(created)}</p>",
+        "<h2>Plan</h2>",
+        html_table(read_plan(db_path)),
+        "<h2>Assembly</h2>",
+        html_table(read_assembly(db_path)),
+        f"<h2>Warnings ({len(df_warnings)})</h2>",
+        html_table(df_warnings) if warnings else "<p>No warnings.</p>",
+        "<h2>Software</h2>",
+        html_table(df_software),
+        "<h2>Database</h2>",
+        html_table(df_database),
+        f'<p class="checksum">Report SHA-256: {CHECKSUM_PLACEHOLDER}</p>',
+    ]
+    document = (
+        '<!DOCTYPE html>\n<html><head><meta charset="utf-8">'
+        f'<meta name="checksum" content="{CHECKSUM_PLACEHOLDER}">'
+        f"<title>{title}</title><style>{STYLE}</style></head>\n<body>\n"
+        + "\n".join(sections)
+        + "\n</body></html>\n"
+    )
+    return add_checksum(document)
+
+
+def verify(report_path: Path) -> None:
+    """Check the embedded checksum of a report, fail if it was changed since it was written."""
+    report_path = Path(report_path)
+    if not report_path.is_file():
+        msg = f"Report {report_path} not found."
+        raise ConfigError(msg)
+    document = report_path.read_text(encoding="utf-8")
+    match = CHECKSUM_PATTERN.search(document)
+    if match is None:
+        msg = f"{report_path.name} has no embedded checksum, it is not a batch report."
+        raise ConfigError(msg)
+    digest = match.group(1)
+    expected = hashlib.sha256(document.replace(digest, CHECKSUM_PLACEHOLDER).encode("utf-8")).hexdigest()
+    if expected != digest:
+        msg = f"{report_path.name} was changed after it was written, the checksum does not match."
+        raise EnvironmentProblemError(msg)
+    logger.info("%s is unchanged, checksum %s.", report_path.name, digest)
+
+
+def main(db_path: Path = DATABASE_FILEPATH, output_dir: Path = OUTPUT_DIR, dry_run: bool = False) -> Path | None:
+    """Write the batch report to the output folder.
+
+    Returns:
+        Path of the report, None for a dry run
+
+    """
+    document = build_report(db_path)
+    run_id = get_setting(db_path, "Base Sample ID") or "batch"
+    report_path = Path(output_dir) / f"{run_id}_report.html"
+    if dry_run:
+        logger.info("Dry run, would write the batch report to %s.", report_path)
+        return None
+    report_path.parent.mkdir(parents=True, exist_ok=True)
+    report_path.write_text(document, encoding="utf-8")
+    logger.info("Wrote the batch report to %s.", report_path)
+    return report_path
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("report")
+    main()
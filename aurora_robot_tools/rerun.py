@@ -0,0 +1,105 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Recognise a command that is run again for work that is already done, e.g. a retry by AutoSuite.
+
+AutoSuite retries a step when it thinks the tool did not answer, so e.g. import-excel or balance can
+be called twice for the same batch. Running them again would import the cells a second time, pair
+the electrodes differently or reserve new cell IDs.
+
+After a planning command finishes successfully, the command, its arguments, the input files it
+read and a fingerprint of the database are recorded in a file next to the database. If the same
+command is run again with the same arguments and inputs, and the database is still exactly as that
+command left it, nothing is done and the command succeeds straight away, with the same plan in the
+JSON output. Any change to the database in between, e.g. the robot assembling a cell, or a changed
+input file means the command runs as usual.
+
+Usage:
+    Checked automatically, `aurora-rt --force balance` runs the command again anyway.
+"""
+
+import hashlib
+import json
+import logging
+from datetime import datetime, timezone
+from pathlib import Path
+
+from aurora_robot_tools.staging import fingerprint
+
+logger = logging.getLogger(__name__)
+
+# Commands that are skipped if they already ran, commands that read the operator or hardware are not
+RERUN_COMMANDS = {
+    "import-excel",
+    "import-batch",
+    "electrolyte",
+    "doe",
+    "balance",
+    "rebalance",
+    "assign",
+    "labels",
+    "batch",
+}
+
+
+def record_path(db_path: Path) -> Path:
+    """Path of the file with the last run of a database."""
+    db_path = Path(db_path)
+    return db_path.with_name(db_path.name + ".last-run.json")
+
+
+def input_files(command: str, arguments: list[str]) -> list[Path]:
+    """Files the command reads, the arguments that are files and the input folder of import-excel."""
+    files = [Path(arg) for arg in arguments if not arg.startswith("-") and Path(arg).is_file()]
+    if command == "import-excel":
+        from aurora_robot_tools.config import INPUT_DIR
+
+        folder = Path(INPUT_DIR)
+        files += sorted(folder.iterdir()) if folder.is_dir() else []
+    return files
+
+
+def inputs_fingerprint(command: str, arguments: list[str], defaults: object = None) -> str:
+    """Hash the command, its arguments, the default arguments from --args-file and the input files."""
+    digest = hashlib.sha256(json.dumps([command, arguments, defaults], default=str).encode())
+    for path in input_files(command, arguments):
+        if path.is_file():
+            digest.update(str(path).encode())
+            digest.update(path.read_bytes())
+    return digest.hexdigest()
+
+
+def already_done(db_path: Path, command: str, arguments: list[str], defaults: object = None) -> bool:
+    """Check if the same command already ran and the database has not changed since."""
+    path = record_path(db_path)
+    try:
+        with path.open(encoding="utf-8") as f:
+            last_run = json.load(f)
+    except (OSError, json.JSONDecodeError):
+        return False
+    if last_run.get("inputs") != inputs_fingerprint(command, arguments, defaults):
+        return False
+    if last_run.get("database") != fingerprint(db_path):
+        return False
+    logger.info(
+        "%s already ran at %s with the same arguments and the database has not changed since, nothing to do. "
+        "Use --force to run it again.",
+        command,
+        last_run.get("finished"),
+    )
+    return True
+
+
+def record(db_path: Path, command: str, arguments: list[str], defaults: object = None) -> None:
+    """Record a successful run, log a warning instead of failing if it can not be written."""
+    last_run = {
+        "command": command,
+        "arguments": arguments,
+        "inputs": inputs_fingerprint(command, arguments, defaults),
+        "database": fingerprint(db_path),
+        "finished": datetime.now(timezone.utc).isoformat(timespec="seconds"),
+    }
+    try:
+        with record_path(db_path).open("w", encoding="utf-8") as f:
+            json.dump(last_run, f, indent=2)
+    except OSError as e:
+        logger.warning("Could not record the run for re-run detection: %s", e)
@@ -0,0 +1,132 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Write the result of each command to a file that AutoSuite can read.
+
+AutoSuite can read files but cannot reliably capture the output of a process, so after every command
+the result is written to RESULT_FILE in the config. A path ending in .json gives a JSON object, any
+other path an INI file with one [result] section, e.g.
+
+    [result]
+    command = balance
+    status = ok
+    exit_code = 0
+    exit_name = OK
+    message = Finished
+    warnings = 2
+    last_warning = 2 cells rejected in batch 3
+    warning_summary = inventory: Anode lot A is running out | capacity_balance: 2 cells rejected in batch 3
+    dry_run = False
+    base_sample_id = 250314_kigr_01
+    cells_planned = 36
+    cells_changed = 36
+    duration_s = 4.2
+    finished = 2025-03-14T10:12:00+00:00
+    log_file = C:/Modules/Logs/balance.log
+
+Warnings are collected during the run, so a cell slightly off the N:P ratio target or a rounded
+volume is not lost in the output, and logged again together at the end of the run.
+
+The file is replaced in one step, so it is never read half-written, and a file that cannot be
+written only logs a warning.
+
+Usage:
+    Written automatically by `aurora-rt` after every command.
+"""
+
+import json
+import logging
+import sqlite3
+from datetime import datetime, timezone
+from pathlib import Path
+
+from aurora_robot_tools.config import RESULT_FILE
+from aurora_robot_tools.errors import ExitCode
+from aurora_robot_tools.shutdown import temporary
+
+logger = logging.getLogger(__name__)
+
+
+def count_planned_cells(db_path: Path) -> int | None:
+    """Count the cells in the plan, None if the database cannot be read."""
+    try:
+        with sqlite3.connect(f"file:{Path(db_path).as_posix()}?mode=ro", uri=True) as conn:
+            row = conn.execute("SELECT COUNT(*) FROM Cell_Assembly_Table WHERE `Cell Number` > 0").fetchone()
+    except sqlite3.Error:
+        return None
+    return row[0]
+
+
+WARNING_LEVELS = ("WARNING", "ERROR", "CRITICAL")
+
+
+def summarize_warnings(messages: list[dict[str, str]]) -> list[str]:
+    """Get the warnings of a run in order, with the module they came from, repeats counted once."""
+    counts: dict[str, int] = {}
+    for message in messages:
+        if message["level"] in WARNING_LEVELS and message["level"] != "CRITICAL":  # Critical is the error
+            line = " ".join(message["message"].splitlines())
+            if message.get("source"):
+                line = f"{message['source']}: {line}"
+            counts[line] = counts.get(line, 0) + 1
+    return [f"{line} (x{count})" if count > 1 else line for line, count in counts.items()]
+
+
+def log_warning_summary(messages: list[dict[str, str]]) -> None:
+    """Log all the warnings of the run together."""
+    summary = summarize_warnings(messages)
+    if summary:
+        logger.info("Warnings during the run (%d):\n%s", len(summary), "\n".join(f"  - {line}" for line in summary))
+
+
+def build_result(  # noqa: PLR0913
+    command: str,
+    exit_code: int,
+    error: str | None,
+    messages: list[dict[str, str]],
+    duration: float,
+    db_path: Path,
+    dry_run: bool,
+    cells_changed: int,
+    log_file: Path | None,
+) -> dict:
+    """Collect the status, message and key outputs of a finished command."""
+    from aurora_robot_tools.notify import read_base_sample_id
+
+    warnings = [m["message"] for m in messages if m["level"] in WARNING_LEVELS]
+    exit_name = ExitCode(exit_code).name if exit_code in ExitCode._value2member_map_ else "UNKNOWN"
+    return {
+        "command": command,
+        "status": "ok" if exit_code == 0 else "failed",
+        "exit_code": exit_code,
+        "exit_name": exit_name,
+        "message": error or ("Finished" if exit_code == 0 else f"Failed with exit code {exit_code}"),
+        "warnings": len(warnings),
+        "last_warning": warnings[-1] if warnings else "",
+        "warning_summary": " | ".join(summarize_warnings(messages)),
+        "dry_run": dry_run,
+        "base_sample_id": read_base_sample_id(db_path) or "",
+        "cells_planned": count_planned_cells(db_path),
+        "cells_changed": cells_changed,
+        "duration_s": round(duration, 1),
+        "finished": datetime.now(timezone.utc).isoformat(timespec="seconds"),
+        "log_file": str(log_file or ""),
+    }
+
+
+def write_result(result: dict, result_file: Path = RESULT_FILE, section: str = "result") -> None:
+    """Write the result as JSON or INI depending on the file extension, section is the INI section."""
+    result_file = Path(result_file)
+    if result_file.suffix.lower() == ".json":
+        text = json.dumps(result, indent=2, default=str)
+    else:
+        # One line per value, so multi-line errors cannot break the INI format
+        values = {key: " ".join(str("" if value is None else value).splitlines()) for key, value in result.items()}
+        text = "\n".join([f"[{section}]", *(f"{key} = {value}" for key, value in values.items())]) + "\n"
+    tmp_path = result_file.with_suffix(result_file.suffix + ".tmp")
+    try:
+        result_file.parent.mkdir(parents=True, exist_ok=True)
+        with temporary(tmp_path):
+            tmp_path.write_text(text, encoding="utf-8")
+            tmp_path.replace(result_file)
+    except OSError as e:
+        logger.warning("Could not write result file %s: %s", result_file, e)
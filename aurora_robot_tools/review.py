@@ -0,0 +1,149 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Review and edit the cell pairings interactively before assembly.
+
+Shows the planned cells with their anode, cathode, N:P ratio and press, and lets the operator swap
+electrodes between cells or exclude cells, instead of editing the database by hand. Only cells that
+have not started assembly can be changed. Nothing is written until the plan is committed.
+
+Commands:
+    show                     Show the plan
+    swap A B [anode]         Swap the cathodes (or anodes) of cells A and B
+    exclude A                Remove cell A from the plan
+    reset                    Discard the changes
+    commit                   Write the edited plan to the database and exit
+    quit                     Exit without writing
+
+If cells are excluded, the remaining cells that have not started are renumbered and their press
+assignments are removed, so `aurora-rt assign` should be run again.
+
+Usage:
+    Called with `aurora-rt review`.
+"""
+
+import logging
+from collections.abc import Callable
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.capacity_balance import calculate_np_ratio
+from aurora_robot_tools.cell_state import set_state
+from aurora_robot_tools.config import DATABASE_FILEPATH
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.inventory import INVENTORY_DTYPES, INVENTORY_TABLE, build_inventory
+from aurora_robot_tools.rebalance import electrode_columns, number_remaining_cells, unassign_presses
+
+logger = logging.getLogger(__name__)
+
+HELP = __doc__.split("Commands:\n")[1].split("\n\n")[0]
+
+
+def format_plan(df: pd.DataFrame) -> str:
+    """Format the planned cells as a table."""
+    plan = df[df["Cell Number"] > 0].sort_values("Cell Number")
+    lines = [
+        f"{'Cell':>4} {'Rack':>4} {'Press':>5}  {'Anode':<16} {'Rack':>4}  {'Cathode':<16} {'Rack':>4}"
+        f"  {'N:P':>6}  {'Limits':<11} Status",
+    ]
+    for _, row in plan.iterrows():
+        in_limits = row["N:P Ratio Minimum"] <= row["N:P Ratio"] <= row["N:P Ratio Maximum"]
+        status = "started" if row["Last Completed Step"] > 0 else ("" if in_limits else "OUT OF LIMITS")
+        lines.append(
+            f"{row['Cell Number']:>4} {row['Rack Position']:>4} {row['Current Press Number'] or '':>5}  "
+            f"{str(row['Anode Type']):<16.16} {row['Anode Rack Position']:>4.0f}  "
+            f"{str(row['Cathode Type']):<16.16} {row['Cathode Rack Position']:>4.0f}  "
+            f"{row['N:P Ratio']:>6.3f}  {row['N:P Ratio Minimum']:.2f}-{row['N:P Ratio Maximum']:<6.2f} {status}",
+        )
+    return "\n".join(lines)
+
+
+def find_cell(df: pd.DataFrame, cell: str) -> int:
+    """Get the row index of a cell that has not started assembly."""
+    rows = df.index[df["Cell Number"] == int(cell)]
+    if len(rows) == 0:
+        msg = f"Cell {cell} is not in the plan"
+        raise ValueError(msg)
+    if df.loc[rows[0], "Last Completed Step"] > 0:
+        msg = f"Cell {cell} has started assembly and cannot be changed"
+        raise ValueError(msg)
+    return rows[0]
+
+
+def swap(df: pd.DataFrame, cell_a: str, cell_b: str, electrode: str = "cathode") -> None:
+    """Swap the electrodes of two cells in-place."""
+    electrode = electrode.capitalize()
+    if electrode not in ("Anode", "Cathode"):
+        msg = f"Can only swap anode or cathode, not {electrode.lower()}"
+        raise ValueError(msg)
+    row_a, row_b = find_cell(df, cell_a), find_cell(df, cell_b)
+    columns = electrode_columns(df, electrode)
+    df.loc[[row_a, row_b], columns] = df.loc[[row_b, row_a], columns].to_numpy()
+    df["N:P Ratio"] = calculate_np_ratio(df)
+
+
+def main(
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+    read_command: Callable[[str], str] = input,
+) -> None:
+    """Review the plan interactively and write it to the database if committed."""
+    df_original, df_press_original, df_settings = read_tables(
+        db_path,
+        "Cell_Assembly_Table",
+        "Press_Table",
+        "Settings_Table",
+    )
+    base_sample_id = df_settings.loc[df_settings["key"] == "Base Sample ID", "value"].to_numpy()[0]
+    df, excluded = df_original.copy(), []
+    print(format_plan(df))
+    print(f"\nCommands:\n{HELP}")
+    while True:
+        try:
+            command, *args = read_command("review> ").split() or ["show"]
+        except EOFError:
+            command, args = "quit", []
+        try:
+            match command:
+                case "show":
+                    print(format_plan(df))
+                case "swap" if len(args) in (2, 3):
+                    swap(df, *args)
+                    print(format_plan(df))
+                case "exclude" if len(args) == 1:
+                    row = find_cell(df, args[0])
+                    df.loc[row, "Cell Number"] = 0
+                    excluded.append(int(args[0]))
+                    print(f"Excluded cell {args[0]}")
+                case "reset":
+                    df, excluded = df_original.copy(), []
+                    print(format_plan(df))
+                case "commit":
+                    break
+                case "quit":
+                    logger.info("Review cancelled, database not changed.")
+                    return
+                case _:
+                    print(f"Unknown command '{command} {' '.join(args)}'\nCommands:\n{HELP}")
+        except ValueError as e:
+            print(e)
+
+    tables = {"Cell_Assembly_Table": df}
+    if excluded:
+        logger.info("Excluded cells %s, renumbering the remaining cells.", excluded)
+        df_press = df_press_original.copy()
+        # Excluded cells already have cell number 0, free their presses by the original number
+        excluded_presses = df_press["Current Cell Number Loaded"].isin(excluded)
+        df_press.loc[excluded_presses, [c for c in ("Current Cell Number Loaded", "Loaded Time") if c in df_press]] = 0
+        unassign_presses(df, df_press)
+        number_remaining_cells(df, df["Cell Number"] > 0, base_sample_id)
+        # Back to balanced so assign loads the remaining cells again
+        set_state(df, df["Last Completed Step"] == 0, "planned")
+        set_state(df, (df["Cell Number"] > 0) & (df["Last Completed Step"] == 0), "balanced")
+        tables["Press_Table"] = df_press
+    tables[INVENTORY_TABLE] = build_inventory(df)
+    write_tables(db_path, tables, dtypes={INVENTORY_TABLE: INVENTORY_DTYPES}, dry_run=dry_run)
+    if not dry_run:
+        logger.info("Committed the reviewed plan to the database.")
+        if excluded:
+            logger.info("Press assignments were removed, run `aurora-rt assign` again.")
@@ -0,0 +1,160 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+HTTP server to run the robot tools remotely.
+
+Lets lab orchestration software trigger calculations without logging in to the robot PC. Each
+endpoint takes a JSON body with the same arguments as the command line and returns JSON with the
+messages logged during the run, and the cells assigned for assembly afterwards.
+
+    POST /balance       {"mode": 6, "rejection_cost_factor": 2.0}
+    POST /assign-press  {"link": true, "limit": 0}
+    POST /electrolyte   {"safety_factor": 1.1}
+    POST /press-force   {"press": 3, "duration": 10}
+
+All endpoints also accept "dry_run", a server started with --dry-run only runs dry runs. Options
+such as "dry_run" and "link" must be JSON true or false, otherwise the response is 400. Requests
+are handled one at a time, so two calculations never modify the database at the same time. A changed
+config file is reloaded before the next request, see config_watch.py.
+
+For monitoring, GET /healthz returns 200 with the version and uptime if the database can be read,
+otherwise 503, and GET /metrics returns job counts and durations for Prometheus, see metrics.py.
+
+Note that assigning cells to presses can still ask for confirmation on the robot PC if cells are
+already loaded.
+
+Usage:
+    Started with `aurora-rt serve`.
+"""
+
+import json
+import logging
+import sqlite3
+import time
+from http.server import BaseHTTPRequestHandler, HTTPServer
+from pathlib import Path
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, SERVER_HOST, SERVER_PORT
+from aurora_robot_tools.config_watch import ConfigWatcher
+from aurora_robot_tools.errors import AbortedError, ConfigError, ExitCode
+from aurora_robot_tools.jobs import COMMANDS, run_job, with_dry_run
+from aurora_robot_tools.lock import lock_path, read_lock
+from aurora_robot_tools.metrics import Metrics
+from aurora_robot_tools.version import __version__
+
+logger = logging.getLogger(__name__)
+
+
+class RequestHandler(BaseHTTPRequestHandler):
+    """Handle POST requests to the tool endpoints."""
+
+    db_path = DATABASE_FILEPATH
+    dry_run = False
+    metrics = Metrics()
+    config_watcher = ConfigWatcher()
+
+    def reload_config(self) -> None:
+        """Reload the config if it changed since the last request."""
+        RequestHandler.db_path = self.config_watcher.reload_if_changed(self.db_path)
+
+    def send_json(self, status: int, body: dict) -> None:
+        """Send a JSON response."""
+        data = json.dumps(body, default=str).encode("utf-8")
+        self.send_data(status, data, "application/json")
+
+    def send_data(self, status: int, data: bytes, content_type: str) -> None:
+        """Send a response with a body."""
+        self.send_response(status)
+        self.send_header("Content-Type", content_type)
+        self.send_header("Content-Length", str(len(data)))
+        self.end_headers()
+        self.wfile.write(data)
+
+    def health(self) -> tuple[int, dict]:
+        """Check the database can be read."""
+        body = {
+            "version": __version__,
+            "uptime_s": round(time.monotonic() - self.metrics.start_time),
+            "database": str(self.db_path),
+            "lock": read_lock(lock_path(self.db_path)) or None,
+        }
+        try:
+            with sqlite3.connect(f"file:{Path(self.db_path).as_posix()}?mode=ro", uri=True) as conn:
+                conn.execute("SELECT COUNT(*) FROM Cell_Assembly_Table").fetchone()
+        except sqlite3.Error as e:
+            return 503, {"ok": False, **body, "error": f"Cannot read database: {e}"}
+        return 200, {"ok": True, **body}
+
+    def do_GET(self) -> None:
+        """Health check and metrics for monitoring."""
+        self.reload_config()
+        match self.path.split("?")[0]:
+            case "/healthz":
+                self.send_json(*self.health())
+            case "/metrics":
+                self.send_data(200, self.metrics.render().encode("utf-8"), "text/plain; version=0.0.4")
+            case _:
+                self.send_json(404, {"ok": False, "error": f"Unknown endpoint {self.path}"})
+
+    def do_POST(self) -> None:
+        """Run a tool, arguments are given as a JSON object in the body."""
+        self.reload_config()
+        command = self.path.strip("/")
+        if command not in COMMANDS:
+            self.send_json(404, {"ok": False, "error": f"Unknown endpoint {self.path}"})
+            return
+        length = int(self.headers.get("Content-Length", 0))
+        try:
+            args = json.loads(self.rfile.read(length) or b"{}")
+        except json.JSONDecodeError as e:
+            self.send_json(400, {"ok": False, "error": f"Invalid JSON: {e}"})
+            return
+        if not isinstance(args, dict):
+            self.send_json(400, {"ok": False, "error": "Body must be a JSON object"})
+            return
+        try:
+            args = with_dry_run(args, self.dry_run)
+        except ConfigError as e:
+            self.send_json(400, {"ok": False, "error": str(e)})
+            return
+        logger.info("%s %s from %s", self.command, self.path, self.client_address[0])
+        start_time = self.metrics.start(command)
+        body = run_job(command, self.db_path, args)
+        self.metrics.finish(command, start_time, body["exit_code"])
+        if body["ok"]:
+            status = 200
+        elif body["exit_code"] == ExitCode.UNEXPECTED_ERROR:
+            status = 500
+        else:
+            status = 400
+        self.send_json(status, body)
+
+    def log_message(self, format: str, *args: object) -> None:  # noqa: A002
+        """Log requests with the package logger instead of printing to stderr."""
+        logger.debug(format, *args)
+
+
+def main(
+    host: str = SERVER_HOST,
+    port: int = SERVER_PORT,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Serve the tool endpoints until interrupted."""
+    RequestHandler.db_path = db_path
+    RequestHandler.dry_run = dry_run
+    RequestHandler.config_watcher = ConfigWatcher()
+    server = HTTPServer((host, port), RequestHandler)
+    logger.info("Serving on http://%s:%d, endpoints: %s", host, port, ", ".join(f"/{c}" for c in COMMANDS))
+    try:
+        server.serve_forever()
+    except (KeyboardInterrupt, AbortedError):
+        logger.info("Server stopped")
+    finally:
+        server.server_close()
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("serve")
+    main()
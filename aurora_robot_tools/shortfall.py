@@ -0,0 +1,215 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Check there is enough of everything for the plan, and list what to order.
+
+The plan needs, for the cells that are planned and not finished, counted like the load list:
+    - Anodes and cathodes, by type, for the cells that have not placed them yet
+    - Bottom and top casings, spacers, separators and springs, by type, with LOAD_LIST_SPARES spares
+    - Electrolyte, in uL of each electrolyte, including the dead and priming volume, after
+      `aurora-rt electrolyte`
+
+What is in stock is read from a CSV or Excel file, `--stock` or STOCK_FILE in the config, with a
+Type and a Quantity column, in pieces or uL, and optionally an Item column, e.g.
+
+    Item,Type,Quantity
+    Separator,Celgard 2325,150
+    Spring,,400
+    Electrolyte vial,LP30,12000
+
+A row matches by item and type, type or item, like COMPONENT_MASSES_MG. Electrodes that are not in
+the file are counted from the rack, anything else that is not in the file is listed as unknown, so
+without a stock file only the electrodes are checked.
+
+The report is logged, and the shopping list, the rows that are short, is written to the output
+folder as CSV or JSON for ordering. `aurora-rt commit` logs the report before committing a staged
+plan if STOCK_FILE is set.
+
+Usage:
+    `aurora-rt shortfall --stock stock.csv`, or `aurora-rt shortfall --format json`.
+"""
+
+import logging
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, LOAD_LIST_SPARES, OUTPUT_DIR, STEP_DEFINITION, STOCK_FILE
+from aurora_robot_tools.database import get_setting, read_query
+from aurora_robot_tools.decimals import convert_text_numbers, read_csv
+from aurora_robot_tools.errors import ConfigError, InfeasibleError
+from aurora_robot_tools.inventory import build_inventory
+from aurora_robot_tools.load_list import count_consumables, electrolyte_vials
+
+logger = logging.getLogger(__name__)
+
+FORMATS = ("csv", "json")
+SHORTFALL_COLUMNS = ["Item", "Type", "Needed", "In Stock", "Shortfall", "Unit"]
+ELECTRODES = ("Anode", "Cathode")
+
+
+def read_stock(path: Path) -> dict[str, float]:
+    """Read the stock file, the quantity of each item and type, type or item."""
+    path = Path(path)
+    if not path.is_file():
+        msg = f"Stock file {path} not found."
+        raise ConfigError(msg)
+    if path.suffix.lower() == ".csv":
+        df = read_csv(path)
+    elif path.suffix.lower() in (".xlsx", ".xls"):
+        (df,) = convert_text_numbers((pd.read_excel(path),), path.name, exclude=("Item", "Type"))
+    else:
+        msg = f"Stock file must be a .csv or .xlsx file, got {path.name}."
+        raise ConfigError(msg)
+    df = df.rename(columns={col: str(col).strip().title() for col in df.columns}).dropna(how="all")
+    if "Quantity" not in df.columns or ("Type" not in df.columns and "Item" not in df.columns):
+        msg = f"{path.name} must have a Quantity column and a Type or Item column."
+        raise ConfigError(msg)
+    quantities = pd.to_numeric(df["Quantity"], errors="coerce")
+    if quantities.isna().any():
+        msg = f"Quantity in {path.name} must be a number in every row."
+        raise ConfigError(msg)
+    items = df.get("Item", pd.Series("", index=df.index)).fillna("").astype(str).str.strip()
+    types = df.get("Type", pd.Series("", index=df.index)).fillna("").astype(str).str.strip()
+    stock: dict[str, float] = {}
+    for item, item_type, quantity in zip(items, types, quantities):
+        key = f"{item} {item_type}".strip()
+        stock[key] = stock.get(key, 0) + float(quantity)
+    return stock
+
+
+def in_stock(stock: dict[str, float], item: str, item_type: str) -> float | None:
+    """Quantity of an item in stock, None if the stock file does not list it."""
+    for key in (f"{item} {item_type}".strip(), item_type, item):
+        if key and key in stock:
+            return stock[key]
+    return None
+
+
+def electrode_needs(df: pd.DataFrame) -> list[dict]:
+    """Count the anodes and cathodes the cells still need, and how many are in the rack, by type."""
+    inventory = build_inventory(df)
+    in_rack = inventory[inventory["Status"].isin(["Planned", "Unused"])]
+    rows = []
+    for electrode in ELECTRODES:
+        step = min(k for k, v in STEP_DEFINITION.items() if v["Step"] == electrode)
+        types = df.loc[df["Last Completed Step"] < step, f"{electrode} Type"].dropna().astype(str)
+        for item_type, count in types.value_counts().sort_index().items():
+            rack = in_rack[(in_rack["Electrode"] == electrode) & (in_rack["Type"].astype(str) == item_type)]
+            rows.append(
+                {"Item": electrode, "Type": item_type, "Needed": int(count), "Unit": "pcs", "Rack": len(rack)},
+            )
+    return rows
+
+
+def build_report(db_path: Path = DATABASE_FILEPATH, stock_file: Path | None = None) -> pd.DataFrame:
+    """Compare what the plan needs with what is in stock."""
+    from aurora_robot_tools.assign_cells_to_press import RETURN_STEP
+
+    df = read_query(db_path, "SELECT * FROM Cell_Assembly_Table WHERE `Cell Number` > 0")
+    df = df[(df["Last Completed Step"] < RETURN_STEP) & (df["Error Code"] == 0)]
+    if df.empty:
+        msg = "No cells are planned, run capacity balancing first."
+        raise InfeasibleError(msg)
+    stock = read_stock(stock_file) if stock_file else {}
+    needs = electrode_needs(df)
+    for row in count_consumables(df, LOAD_LIST_SPARES) + electrolyte_vials(db_path):
+        needs.append({"Item": row["Item"], "Type": row["Type"], "Needed": row["Quantity"], "Unit": row["Unit"]})
+    rows = []
+    for need in needs:
+        available = in_stock(stock, need["Item"], need["Type"])
+        if available is None:
+            available = need.get("Rack")  # Electrodes are counted from the rack, anything else is unknown
+        shortfall = None if available is None else max(need["Needed"] - available, 0)
+        rows.append({**need, "In Stock": available, "Shortfall": shortfall})
+    return pd.DataFrame(rows, columns=SHORTFALL_COLUMNS)
+
+
+def format_report(df: pd.DataFrame) -> str:
+    """Format the report as aligned lines."""
+
+    def number(value: object) -> str:
+        return "?" if pd.isna(value) else f"{value:g}"
+
+    return "\n".join(
+        f"{row['Item']:<17} {row['Type']:<20} need {number(row['Needed']):>8}  have {number(row['In Stock']):>8}  "
+        f"short {number(row['Shortfall']):>8} {row['Unit']}"
+        for _, row in df.iterrows()
+    )
+
+
+def log_report(df: pd.DataFrame) -> pd.DataFrame:
+    """Log the report and warn about what is short, return the shopping list."""
+    logger.info("Needed for the plan and in stock:\n%s", format_report(df))
+    unknown = df[df["In Stock"].isna()]
+    if not unknown.empty:
+        logger.warning(
+            "Stock unknown for %s, add them to the stock file.",
+            ", ".join(f"{row['Item']} {row['Type']}".strip() for _, row in unknown.iterrows()),
+        )
+    shopping = df[df["Shortfall"].fillna(0) > 0]
+    if shopping.empty:
+        logger.info("Everything the plan needs is in stock.")
+    else:
+        logger.warning(
+            "Not enough in stock for the plan: %s",
+            ", ".join(
+                f"{row['Shortfall']:g} {row['Unit']} {row['Item']} {row['Type']}".strip()
+                for _, row in shopping.iterrows()
+            ),
+        )
+    return shopping
+
+
+def check_before_commit(db_path: Path) -> None:
+    """Log the report for a staged plan before it is committed, if STOCK_FILE is set."""
+    if not STOCK_FILE:
+        return
+    try:
+        log_report(build_report(db_path, Path(STOCK_FILE)))
+    except (ConfigError, InfeasibleError) as e:
+        logger.warning("Could not check the stock for the plan: %s", e)
+
+
+def main(
+    db_path: Path = DATABASE_FILEPATH,
+    stock_file: Path | None = None,
+    output_format: str = "csv",
+    output_dir: Path = OUTPUT_DIR,
+) -> Path:
+    """Log the shortfall report and write the shopping list.
+
+    Args:
+        db_path: Path to the robot database
+        stock_file: CSV or Excel file with what is in stock, default STOCK_FILE from the config
+        output_format: "csv" or "json"
+        output_dir: Folder to write the shopping list to
+
+    Returns:
+        Path of the shopping list
+
+    """
+    if output_format not in FORMATS:
+        msg = f"Format must be one of {', '.join(FORMATS)}, got '{output_format}'."
+        raise ConfigError(msg)
+    stock_file = stock_file or (Path(STOCK_FILE) if STOCK_FILE else None)
+    if stock_file is None:
+        logger.warning("No stock file given with --stock or STOCK_FILE, only the electrodes in the rack are checked.")
+    shopping = log_report(build_report(db_path, stock_file))
+    run_id = get_setting(db_path, "Base Sample ID") or "plan"
+    output_dir = Path(output_dir)
+    output_dir.mkdir(parents=True, exist_ok=True)
+    filepath = output_dir / f"{run_id}_shopping_list.{output_format}"
+    columns = ["Item", "Type", "Shortfall", "Unit"]
+    if output_format == "json":
+        shopping[columns].to_json(filepath, orient="records", indent=4)
+    else:
+        shopping[columns].to_csv(filepath, index=False)
+    logger.info("Wrote the shopping list with %d items to %s", len(shopping), filepath)
+    return filepath
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("shortfall")
+    main()
@@ -0,0 +1,261 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Stop cleanly when the command is interrupted or the program that started it goes away.
+
+When AutoSuite aborts a step it kills the process it started, which may only be the aurora-rt
+launcher, leaving the Python process behind it running with the database lock held. To avoid this:
+    - Ctrl+C, Ctrl+Break and termination signals raise AbortedError, so the database lock is released,
+      an unfinished operation is rolled back (see journal.py) and the command exits with code 70
+    - if the parent process exits, the command stops the same way
+    - child processes, e.g. the balancing plugin, run in their own process group which is stopped
+      with the command, and on Windows the command is put in a Job Object so its children are killed
+      even if it is killed itself. Their output is read as it comes, so it counts as activity for the
+      watchdog, see watchdog.py
+    - temporary files and the lock are removed if the command has to exit without unwinding
+
+Usage:
+    Installed by `aurora-rt` for every command.
+"""
+
+import logging
+import os
+import signal
+import subprocess
+import threading
+import time
+from collections.abc import Iterator
+from contextlib import contextmanager, suppress
+from pathlib import Path
+from typing import TextIO
+
+from aurora_robot_tools.errors import AbortedError, ExitCode
+
+logger = logging.getLogger(__name__)
+
+PARENT_POLL_INTERVAL = 1.0  # seconds
+EXIT_GRACE_PERIOD = 10.0  # seconds to stop cleanly before exiting without unwinding
+CHILD_GRACE_PERIOD = 5.0  # seconds for a child process to stop before it is killed
+
+# Files removed if the process has to exit without unwinding, e.g. half-written files
+cleanup_paths: set[Path] = set()
+# Child processes that are running, stopped if the process has to exit without unwinding
+children: set[subprocess.Popen] = set()
+stopping = threading.Event()
+
+
+@contextmanager
+def temporary(path: Path) -> Iterator[Path]:
+    """Remove a temporary file when the context closes, or when the process exits early."""
+    cleanup_paths.add(path)
+    try:
+        yield path
+    finally:
+        path.unlink(missing_ok=True)
+        cleanup_paths.discard(path)
+
+
+def cleanup() -> None:
+    """Remove the temporary files and lock of this process."""
+    for path in list(cleanup_paths):
+        try:
+            path.unlink(missing_ok=True)
+        except OSError as e:
+            logger.debug("Could not remove %s: %s", path, e)
+    cleanup_paths.clear()
+
+
+def handle_signal(signum: int, _frame: object) -> None:
+    """Stop the command by raising an error in the main thread."""
+    if stopping.is_set():
+        logger.warning("Already stopping, please wait")
+        return
+    stopping.set()
+    msg = f"Aborted by {signal.Signals(signum).name}"
+    raise AbortedError(msg)
+
+
+def exit_after_grace_period() -> None:
+    """Exit without unwinding if the command has not stopped by itself."""
+    time.sleep(EXIT_GRACE_PERIOD)
+    logger.error("Command did not stop within %s seconds, exiting.", EXIT_GRACE_PERIOD)
+    cleanup()
+    logging.shutdown()
+    os._exit(ExitCode.ABORTED)
+
+
+def wait_for_parent_exit(ppid: int) -> bool:
+    """Block until the parent process has exited, False if it cannot be watched."""
+    if os.name == "nt":
+        import ctypes
+
+        kernel32 = ctypes.WinDLL("kernel32", use_last_error=True)
+        synchronize, infinite = 0x00100000, 0xFFFFFFFF
+        handle = kernel32.OpenProcess(synchronize, False, ppid)  # noqa: FBT003
+        if not handle:
+            return False
+        kernel32.WaitForSingleObject(handle, infinite)
+        kernel32.CloseHandle(handle)
+        return True
+    while os.getppid() == ppid:
+        time.sleep(PARENT_POLL_INTERVAL)
+    return True
+
+
+def watch_parent() -> None:
+    """Stop the command if the process that started it exits."""
+    ppid = os.getppid()
+    if not wait_for_parent_exit(ppid):
+        logger.debug("Cannot watch parent process %d", ppid)
+        return
+    if stopping.is_set():
+        return
+    logger.warning("Parent process %d exited, stopping.", ppid)
+    threading.Thread(target=exit_after_grace_period, daemon=True).start()
+    # The handler runs in the main thread and raises AbortedError there, on Windows only SIGINT
+    # interrupts a sleeping main thread
+    if os.name == "nt":
+        signal.raise_signal(signal.SIGINT)
+    else:
+        signal.pthread_kill(threading.main_thread().ident, signal.SIGTERM)
+
+
+def kill_children_with_process() -> None:
+    """On Windows, put this process in a Job Object that kills all its children when it exits."""
+    import ctypes
+    from ctypes import wintypes
+
+    class BasicLimits(ctypes.Structure):
+        _fields_ = (
+            ("PerProcessUserTimeLimit", ctypes.c_int64),
+            ("PerJobUserTimeLimit", ctypes.c_int64),
+            ("LimitFlags", wintypes.DWORD),
+            ("MinimumWorkingSetSize", ctypes.c_size_t),
+            ("MaximumWorkingSetSize", ctypes.c_size_t),
+            ("ActiveProcessLimit", wintypes.DWORD),
+            ("Affinity", ctypes.c_size_t),
+            ("PriorityClass", wintypes.DWORD),
+            ("SchedulingClass", wintypes.DWORD),
+        )
+
+    class ExtendedLimits(ctypes.Structure):
+        _fields_ = (
+            ("BasicLimitInformation", BasicLimits),
+            ("IoInfo", ctypes.c_uint64 * 6),
+            ("ProcessMemoryLimit", ctypes.c_size_t),
+            ("JobMemoryLimit", ctypes.c_size_t),
+            ("PeakProcessMemoryUsed", ctypes.c_size_t),
+            ("PeakJobMemoryUsed", ctypes.c_size_t),
+        )
+
+    kill_on_job_close, extended_limit_information = 0x2000, 9
+    kernel32 = ctypes.WinDLL("kernel32", use_last_error=True)
+    job = kernel32.CreateJobObjectW(None, None)
+    limits = ExtendedLimits()
+    limits.BasicLimitInformation.LimitFlags = kill_on_job_close
+    size = ctypes.sizeof(limits)
+    # The job handle is never closed, so it only closes when this process exits
+    if not (
+        job
+        and kernel32.SetInformationJobObject(job, extended_limit_information, ctypes.byref(limits), size)
+        and kernel32.AssignProcessToJobObject(job, kernel32.GetCurrentProcess())
+    ):
+        logger.debug("Could not create Job Object, error %d", ctypes.get_last_error())
+
+
+def install() -> None:
+    """Stop cleanly on signals and when the parent process exits, must be called from the main thread."""
+    for name in ("SIGINT", "SIGTERM", "SIGBREAK", "SIGHUP"):
+        if hasattr(signal, name):
+            signal.signal(getattr(signal, name), handle_signal)
+    if os.name == "nt":
+        kill_children_with_process()
+    threading.Thread(target=watch_parent, daemon=True).start()
+
+
+def stop_child(process: subprocess.Popen) -> None:
+    """Stop a child process and its process group, kill it if it does not stop."""
+    try:
+        if os.name == "nt":
+            process.send_signal(signal.CTRL_BREAK_EVENT)
+        else:
+            os.killpg(process.pid, signal.SIGTERM)
+        process.wait(CHILD_GRACE_PERIOD)
+    except subprocess.TimeoutExpired:
+        logger.warning("Child process %d did not stop, killing it.", process.pid)
+        if os.name == "nt":
+            process.kill()
+        else:
+            os.killpg(process.pid, signal.SIGKILL)
+    except OSError as e:
+        logger.debug("Could not stop child process %d: %s", process.pid, e)
+
+
+def stop_children() -> None:
+    """Stop the child processes that are still running."""
+    for process in list(children):
+        stop_child(process)
+
+
+def read_output(stream: TextIO, lines: list[str], name: str) -> None:
+    """Collect the output of a child process line by line, each line counts as activity."""
+    from aurora_robot_tools import watchdog
+
+    for line in stream:
+        lines.append(line)
+        watchdog.activity(f"output of {name}")
+
+
+def run_child(
+    args: list[str],
+    input_text: str,
+    timeout: float,
+    env: dict[str, str] | None = None,
+) -> subprocess.CompletedProcess:
+    """Run a child process in its own process group, which is stopped if this command stops.
+
+    Raises:
+        subprocess.TimeoutExpired: If the child process takes longer than timeout seconds
+
+    """
+    from aurora_robot_tools import trace
+
+    if os.name == "nt":
+        options = {"creationflags": subprocess.CREATE_NEW_PROCESS_GROUP}
+    else:
+        options = {"start_new_session": True}
+    started = trace.child_started(args)
+    stdout: list[str] = []
+    stderr: list[str] = []
+    name = Path(args[0]).name
+    with subprocess.Popen(  # noqa: S603
+        args,
+        stdin=subprocess.PIPE,
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE,
+        text=True,
+        env=env,
+        **options,
+    ) as process:
+        children.add(process)
+        readers = [
+            threading.Thread(target=read_output, args=(stream, lines, name), daemon=True)
+            for stream, lines in ((process.stdout, stdout), (process.stderr, stderr))
+        ]
+        try:
+            for reader in readers:
+                reader.start()
+            # The child may exit without reading its input, the pipe is then broken
+            with suppress(OSError):
+                process.stdin.write(input_text)
+            with suppress(OSError):
+                process.stdin.close()
+            process.wait(timeout)
+            for reader in readers:
+                reader.join()
+        except BaseException:
+            stop_child(process)
+            raise
+        finally:
+            children.discard(process)
+    trace.child_finished(args, process.returncode, started)
+    return subprocess.CompletedProcess(args, process.returncode, "".join(stdout), "".join(stderr))
@@ -0,0 +1,52 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Run a command on a throwaway copy of the database, for what-if planning during a run.
+
+With `--snapshot`, the database is copied to a temporary folder with the SQLite backup API, so the
+copy is consistent even while the robot is writing to the live database, and the command works on
+the copy instead. It does not take the lock, so it also runs while another command holds it, and
+the production database is never opened for writing. The copy is deleted when the command has finished,
+so only the output is kept, e.g. the log, `--output json` with the plan, or an exported run sheet.
+
+The copy is a file, not an in-memory database, since the tools open the database by path, also in
+the separate process the exact 3D matching runs in.
+
+Usage:
+    e.g. `aurora-rt --snapshot --output json balance 5` to see a plan without changing anything.
+"""
+
+import logging
+import shutil
+import tempfile
+from pathlib import Path
+
+from aurora_robot_tools.errors import DatabaseError
+
+logger = logging.getLogger(__name__)
+
+
+def create_snapshot(db_path: Path) -> Path:
+    """Copy the database to a temporary folder, return the path of the copy."""
+    from aurora_robot_tools import database
+    from aurora_robot_tools.backup_database import copy_database
+
+    db_path = Path(db_path)
+    if not db_path.exists():
+        msg = f"Database {db_path} does not exist."
+        raise DatabaseError(msg)
+    folder = Path(tempfile.mkdtemp(prefix="aurora-snapshot-"))
+    copy = folder / db_path.name
+    try:
+        copy_database(db_path, copy)
+    except Exception:
+        shutil.rmtree(folder, ignore_errors=True)
+        raise
+    # The copy is thrown away, so it does not need automatic backups before writing
+    database.backed_up.add(copy)
+    logger.info("Working on a snapshot of %s, changes are not kept.", db_path)
+    return copy
+
+
+def remove_snapshot(copy: Path) -> None:
+    """Delete the copy and its temporary folder."""
+    shutil.rmtree(Path(copy).parent, ignore_errors=True)
@@ -0,0 +1,103 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Choose the spacers of each cell so the stack has the right height for the target pressure.
+
+The stack in a coin cell is the anode, separator, cathode and spacers. If the stack is too thin the
+electrodes are not pressed together, too thick and the cell is over-compressed. The target stack
+height is "Casing Stack Height (mm)" from the Casing columns of the input file, or
+STACK_TARGET_HEIGHT_MM from the config if the casing has no value.
+
+The electrode and separator thicknesses are the "Anode Thickness (mm)", "Cathode Thickness (mm)" and
+"Separator Thickness (mm)" columns from the input file, missing thicknesses count as 0. The spacers
+available are the Spacer Type and Spacer Thickness (mm) columns of the input file, stored in the
+Spacer_Table. For each cell the combination of bottom and top spacer closest to the target is
+chosen, using at most MAX_SPACER_THICKNESS_MM in total, preferring fewer spacers. A warning is logged
+for cells that cannot get within STACK_TOLERANCE_MM of the target.
+
+Only cells that have not started assembly are changed. The spacers are chosen when cells are
+assigned to presses, and written to the Bottom and Top Spacer columns the robot uses.
+"""
+
+import itertools
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, STACK_TARGET_HEIGHT_MM, STACK_TOLERANCE_MM
+from aurora_robot_tools.database import has_table, read_tables
+
+logger = logging.getLogger(__name__)
+
+SPACER_TABLE = "Spacer_Table"
+MAX_SPACER_THICKNESS_MM = 2.0  # Same safety limit as checked on import
+STACK_COLUMNS = ["Anode Thickness (mm)", "Cathode Thickness (mm)", "Separator Thickness (mm)"]
+
+
+def read_spacers(db_path: Path = DATABASE_FILEPATH) -> pd.DataFrame:
+    """Read the available spacers, empty if the database has no Spacer_Table."""
+    if not has_table(db_path, SPACER_TABLE):
+        return pd.DataFrame(columns=["Spacer Type", "Spacer Thickness (mm)"])
+    (df_spacer,) = read_tables(db_path, SPACER_TABLE)
+    return df_spacer
+
+
+def spacer_combinations(df_spacer: pd.DataFrame) -> list[tuple[str | None, float, str | None, float]]:
+    """All (bottom type, bottom thickness, top type, top thickness) options, fewest spacers first."""
+    options = [(None, 0.0)] + [
+        (row["Spacer Type"], float(row["Spacer Thickness (mm)"]))
+        for _, row in df_spacer.dropna().sort_values("Spacer Thickness (mm)", ascending=False).iterrows()
+    ]
+    combinations = [
+        (bottom[0], bottom[1], top[0], top[1])
+        for bottom, top in itertools.product(options, repeat=2)
+        if bottom[1] + top[1] <= MAX_SPACER_THICKNESS_MM
+    ]
+    return sorted(combinations, key=lambda c: (c[0] is not None) + (c[2] is not None))
+
+
+def stack_targets(df: pd.DataFrame, default_target: float | None = None) -> pd.Series:
+    """Target stack height of each cell, 0 if there is no target."""
+    if default_target is None:
+        default_target = STACK_TARGET_HEIGHT_MM
+    if "Casing Stack Height (mm)" in df.columns:
+        return df["Casing Stack Height (mm)"].fillna(default_target).replace(0, default_target)
+    return pd.Series(default_target, index=df.index)
+
+
+def assign_spacers(
+    df: pd.DataFrame,
+    df_spacer: pd.DataFrame,
+    tolerance: float | None = None,
+) -> None:
+    """Choose the spacers in-place for cells that have not started assembly."""
+    if tolerance is None:
+        tolerance = STACK_TOLERANCE_MM
+    targets = stack_targets(df)
+    cells = (df["Cell Number"] > 0) & (df["Last Completed Step"] == 0) & (targets > 0)
+    if not cells.any():
+        return
+    if df_spacer.empty:
+        logger.warning("No spacers in the Spacer_Table, the spacers of %d cells are not chosen.", cells.sum())
+        return
+    combinations = spacer_combinations(df_spacer)
+    totals = np.array([c[1] + c[3] for c in combinations])
+    stack = sum((df[col].fillna(0) for col in STACK_COLUMNS if col in df.columns), pd.Series(0.0, index=df.index))
+    off_target = []
+    for i in df.index[cells]:
+        required = targets[i] - stack[i]
+        # First of the closest, i.e. the fewest spacers
+        best = int(np.argmin(np.round(np.abs(totals - required), 6)))
+        bottom_type, bottom_thickness, top_type, top_thickness = combinations[best]
+        df.loc[i, ["Bottom Spacer Type", "Top Spacer Type"]] = [bottom_type, top_type]
+        df.loc[i, ["Bottom Spacer Thickness (mm)", "Top Spacer Thickness (mm)"]] = [bottom_thickness, top_thickness]
+        if abs(totals[best] - required) > tolerance:
+            off_target.append(f"{df.loc[i, 'Cell Number']} ({totals[best] - required:+.3f} mm)")
+    logger.info("Chose spacers for %d cells to reach the target stack height.", cells.sum())
+    if off_target:
+        logger.warning(
+            "No spacers within %s mm of the target stack height for cells %s",
+            tolerance,
+            ", ".join(off_target),
+        )
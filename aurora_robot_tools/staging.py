@@ -0,0 +1,163 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Stage plans for approval before the robot can read them.
+
+With PLAN_APPROVAL = "required" in the config, the planning commands (import, balancing, press
+assignment, electrolyte, etc.) do not change the robot database. They work on a staging copy next to
+it, created from the robot database by the first planning command, so each one sees the proposal of
+the previous ones. AutoSuite only reads the robot database, so a plan that is still being reviewed or
+edited can never be picked up.
+
+`aurora-rt --dry-run commit` logs what the proposal changes, `aurora-rt commit` replaces the robot
+database tables with the staged ones in one transaction, and `aurora-rt discard` throws the proposal
+away. The commit is refused if the robot database changed since the proposal was staged, e.g. the
+robot finished a cell, because the proposal would undo that change. If STOCK_FILE is set, what the
+proposal needs is checked against the stock first, see shortfall.py.
+
+Usage:
+    Used automatically with PLAN_APPROVAL = "required", approved with `aurora-rt commit`, also
+    available as the "commit" job, see jobs.py.
+"""
+
+import hashlib
+import logging
+import sqlite3
+from contextlib import closing
+from pathlib import Path
+
+from aurora_robot_tools.config import DATABASE_FILEPATH, PLAN_APPROVAL
+from aurora_robot_tools.errors import ConfigError, DatabaseError
+
+logger = logging.getLogger(__name__)
+
+APPROVAL_MODES = ("off", "required")
+STAGING_TABLE = "_Staging_Table"  # Fingerprint of the robot database when the proposal was staged
+
+
+def approval_required() -> bool:
+    """Check if planning commands must be staged."""
+    if PLAN_APPROVAL not in APPROVAL_MODES:
+        msg = f"PLAN_APPROVAL must be one of {', '.join(APPROVAL_MODES)}, got '{PLAN_APPROVAL}'."
+        raise ConfigError(msg)
+    return PLAN_APPROVAL == "required"
+
+
+def staging_path(db_path: Path) -> Path:
+    """Path of the staging copy of a database."""
+    return Path(db_path).with_name(Path(db_path).name + ".staged.db")
+
+
+def user_tables(conn: sqlite3.Connection, schema: str = "main") -> dict[str, str]:
+    """Get the tables of a database with their CREATE statements, without internal tables."""
+    rows = conn.execute(f"SELECT name, sql FROM {schema}.sqlite_master WHERE type = 'table'").fetchall()  # noqa: S608
+    return {name: sql for name, sql in rows if not name.startswith(("_", "sqlite_"))}
+
+
+def fingerprint(db_path: Path) -> str:
+    """Hash the contents of all tables of a database, empty if it does not exist."""
+    if not Path(db_path).exists():
+        return ""
+    digest = hashlib.sha256()
+    with closing(sqlite3.connect(f"file:{Path(db_path).as_posix()}?mode=ro", uri=True)) as conn:
+        for table, sql in sorted(user_tables(conn).items()):
+            digest.update(sql.encode())
+            for row in conn.execute(f"SELECT * FROM `{table}` ORDER BY rowid"):  # noqa: S608
+                digest.update(repr(row).encode())
+    return digest.hexdigest()
+
+
+def stage(db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> Path:
+    """Get the database planning commands should use, creating the staging copy if needed.
+
+    Returns:
+        The staging database, or the robot database in a dry run without a staging copy
+
+    """
+    staged = staging_path(db_path)
+    if staged.exists():
+        return staged
+    if dry_run:
+        return Path(db_path)
+    from aurora_robot_tools.backup_database import copy_database
+
+    live_fingerprint = fingerprint(db_path)
+    if Path(db_path).exists():
+        copy_database(db_path, staged)
+    with closing(sqlite3.connect(staged)) as conn, conn:
+        conn.execute(f"CREATE TABLE IF NOT EXISTS {STAGING_TABLE} (`key` TEXT PRIMARY KEY, `value` TEXT)")
+        conn.execute(f"INSERT OR REPLACE INTO {STAGING_TABLE} VALUES ('Fingerprint', ?)", (live_fingerprint,))
+    logger.info("Plan changes are staged in %s, approve them with `aurora-rt commit`.", staged)
+    return staged
+
+
+def check_unchanged(db_path: Path, staged: Path) -> None:
+    """Raise an error if the robot database changed since the proposal was staged."""
+    with closing(sqlite3.connect(staged)) as conn:
+        row = conn.execute(f"SELECT `value` FROM {STAGING_TABLE} WHERE `key` = 'Fingerprint'").fetchone()
+    if row is None or row[0] != fingerprint(db_path):
+        msg = (
+            f"{db_path} changed since the plan was staged, committing would undo those changes. "
+            "Run `aurora-rt discard` and plan again."
+        )
+        raise DatabaseError(msg)
+
+
+def commit(db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
+    """Replace the robot database tables with the staged proposal in one transaction."""
+    from aurora_robot_tools import database
+    from aurora_robot_tools.backup_database import auto_backup
+    from aurora_robot_tools.journal import check_unfinished
+    from aurora_robot_tools.shortfall import check_before_commit
+
+    staged = staging_path(db_path)
+    if not staged.exists():
+        logger.info("No staged plan to commit.")
+        return
+    check_unfinished(staged)
+    check_unchanged(db_path, staged)
+    with closing(sqlite3.connect(staged)) as conn:
+        tables = user_tables(conn)
+    check_before_commit(staged)
+    if dry_run:
+        for table in tables:
+            (df,) = database.read_tables(staged, table)
+            database.log_changes(db_path, table, df)
+        logger.info("Dry run, staged plan not committed.")
+        return
+
+    n_cells_changed = 0
+    if "Cell_Assembly_Table" in tables:
+        (df_cells,) = database.read_tables(staged, "Cell_Assembly_Table")
+        n_cells_changed = database.count_cells_changed(db_path, df_cells)
+    if Path(db_path).exists():
+        auto_backup(db_path)
+    with closing(sqlite3.connect(db_path)) as conn:
+        conn.execute("ATTACH DATABASE ? AS staged", (str(staged),))
+        try:
+            conn.execute("BEGIN")
+            for table in user_tables(conn):
+                conn.execute(f"DROP TABLE `{table}`")
+            for table, sql in tables.items():
+                conn.execute(sql)
+                conn.execute(f"INSERT INTO main.`{table}` SELECT * FROM staged.`{table}`")  # noqa: S608
+            conn.commit()
+        except sqlite3.Error:
+            conn.rollback()
+            raise
+        conn.execute("DETACH DATABASE staged")
+    database.cells_changed += n_cells_changed
+    staged.unlink()
+    logger.info("Committed the staged plan to %s.", db_path)
+
+
+def discard(db_path: Path = DATABASE_FILEPATH, dry_run: bool = False) -> None:
+    """Throw away the staged proposal."""
+    staged = staging_path(db_path)
+    if not staged.exists():
+        logger.info("No staged plan to discard.")
+        return
+    if dry_run:
+        logger.info("Dry run, would discard the staged plan %s.", staged)
+        return
+    staged.unlink()
+    logger.info("Discarded the staged plan.")
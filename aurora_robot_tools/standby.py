@@ -0,0 +1,188 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Keep checking the robot database so AutoSuite can fail fast before any hardware moves.
+
+The watcher runs in the background and every STANDBY_INTERVAL seconds checks:
+    - Database: the database exists, passes the SQLite integrity check and has the robot tables
+    - Disk space: at least STANDBY_MIN_FREE_MB are free next to the database, for backups and logs
+    - Plan: no unfinished operation, no staged plan waiting for approval, and every cell number and
+      press assignment in the Cell_Assembly_Table is unique and matches the presses in the config
+
+The result is written to STANDBY_FILE, an INI file with one [standby] section or JSON for a .json
+path, replaced in one step like the result file, e.g.
+
+    [standby]
+    status = green
+    message = All checks passed
+    checked = 2025-03-14T10:12:00+00:00
+    valid_until = 2025-03-14T10:12:30+00:00
+    database = PASS C:/Modules/Database/chemspeedDB.db
+    disk_space = PASS 20480 MB free
+    plan = PASS 36 cells planned
+
+The first step of the AutoSuite workflow reads the file and stops unless the status is green and
+valid_until has not passed, which also catches a watcher that stopped or hangs. When the watcher is
+stopped it writes a red status.
+
+Usage:
+    Started with `aurora-rt standby` in the background, e.g. with Task Scheduler, or run the checks
+    once with `aurora-rt standby --once`, which exits with the environment error code if a check fails.
+"""
+
+import logging
+import shutil
+import sqlite3
+import time
+from collections import Counter
+from collections.abc import Callable
+from contextlib import closing
+from datetime import datetime, timedelta, timezone
+from pathlib import Path
+
+from aurora_robot_tools.config import (
+    DATABASE_FILEPATH,
+    PRESS_TO_RACK,
+    STANDBY_FILE,
+    STANDBY_INTERVAL,
+    STANDBY_MIN_FREE_MB,
+)
+from aurora_robot_tools.errors import AbortedError, EnvironmentProblemError
+from aurora_robot_tools.result import write_result
+
+logger = logging.getLogger(__name__)
+
+CheckResult = tuple[bool, str]
+
+
+def check_database(db_path: Path) -> CheckResult:
+    """Check the database is intact and has the robot tables."""
+    from aurora_robot_tools.doctor import REQUIRED_TABLES
+
+    db_path = Path(db_path)
+    if not db_path.exists():
+        return False, f"{db_path} does not exist"
+    try:
+        with closing(sqlite3.connect(f"file:{db_path.as_posix()}?mode=ro", uri=True)) as conn:
+            problems = [row[0] for row in conn.execute("PRAGMA quick_check")]
+            tables = {row[0] for row in conn.execute("SELECT name FROM sqlite_master WHERE type = 'table'")}
+    except sqlite3.Error as e:
+        return False, f"Cannot read {db_path}: {e}"
+    if problems != ["ok"]:
+        return False, f"integrity check failed: {'; '.join(problems[:3])}"
+    missing = [table for table in REQUIRED_TABLES if table not in tables]
+    if missing:
+        return False, f"Missing tables: {', '.join(missing)}"
+    return True, str(db_path)
+
+
+def check_disk_space(db_path: Path, min_free_mb: float = STANDBY_MIN_FREE_MB) -> CheckResult:
+    """Check there is enough free space on the drive of the database."""
+    try:
+        free_mb = shutil.disk_usage(Path(db_path).parent).free / 1e6
+    except OSError as e:
+        return False, f"Cannot check free space: {e}"
+    if free_mb < min_free_mb:
+        return False, f"{free_mb:.0f} MB free, need {min_free_mb:.0f} MB"
+    return True, f"{free_mb:.0f} MB free"
+
+
+def check_plan(db_path: Path) -> CheckResult:
+    """Check there are no unfinished changes and the planned cells are consistent."""
+    from aurora_robot_tools.journal import read_journal
+    from aurora_robot_tools.staging import staging_path
+
+    journal = read_journal(db_path)
+    if journal is not None:
+        return False, f"the {journal['operation']} started at {journal['started']} did not finish"
+    if staging_path(db_path).exists():
+        return False, "a staged plan is waiting, run `aurora-rt commit` or `aurora-rt discard`"
+    try:
+        with closing(sqlite3.connect(f"file:{Path(db_path).as_posix()}?mode=ro", uri=True)) as conn:
+            rows = conn.execute(
+                "SELECT `Cell Number`, `Current Press Number` FROM Cell_Assembly_Table WHERE `Cell Number` > 0",
+            ).fetchall()
+    except sqlite3.Error as e:
+        return False, f"Cannot read the Cell_Assembly_Table: {e}"
+    cells = Counter(cell for cell, _press in rows)
+    duplicated = sorted(cell for cell, count in cells.items() if count > 1)
+    if duplicated:
+        return False, f"cell numbers used twice: {', '.join(str(cell) for cell in duplicated)}"
+    presses = Counter(press for _cell, press in rows if press)
+    unknown = sorted(press for press in presses if press not in PRESS_TO_RACK)
+    if unknown:
+        return False, f"cells assigned to unknown presses {', '.join(str(press) for press in unknown)}"
+    shared = sorted(press for press, count in presses.items() if count > 1)
+    if shared:
+        return False, f"more than one cell assigned to press {', '.join(str(press) for press in shared)}"
+    return True, f"{len(rows)} cells planned"
+
+
+def run_checks(db_path: Path) -> dict[str, CheckResult]:
+    """Run all checks."""
+    checks: dict[str, Callable[[], CheckResult]] = {
+        "database": lambda: check_database(db_path),
+        "disk_space": lambda: check_disk_space(db_path),
+        "plan": lambda: check_plan(db_path),
+    }
+    results = {}
+    for name, check in checks.items():
+        try:
+            results[name] = check()
+        except Exception as e:  # noqa: BLE001
+            results[name] = (False, f"check failed: {e}")
+    return results
+
+
+def build_status(results: dict[str, CheckResult], interval: float) -> dict:
+    """Combine the check results into the status file contents."""
+    now = datetime.now(timezone.utc)
+    failed = [f"{name}: {detail}" for name, (ok, detail) in results.items() if not ok]
+    return {
+        "status": "red" if failed else "green",
+        "message": "; ".join(failed) or "All checks passed",
+        "checked": now.isoformat(timespec="seconds"),
+        # A watcher that stopped updating the file must not leave it green
+        "valid_until": (now + timedelta(seconds=3 * interval)).isoformat(timespec="seconds"),
+        **{name: f"{'PASS' if ok else 'FAIL'} {detail}" for name, (ok, detail) in results.items()},
+    }
+
+
+def stopped_status() -> dict:
+    """Status file contents when the watcher is not running."""
+    now = datetime.now(timezone.utc).isoformat(timespec="seconds")
+    return {"status": "red", "message": "Standby watcher stopped", "checked": now, "valid_until": now}
+
+
+def main(
+    db_path: Path = DATABASE_FILEPATH,
+    status_file: Path = STANDBY_FILE,
+    interval: float = STANDBY_INTERVAL,
+    once: bool = False,
+) -> None:
+    """Check the database and write the status file, every interval until stopped, or once."""
+    if not once:
+        logger.info("Checking %s every %.0f s, status in %s", db_path, interval, status_file)
+    last_status = None
+    try:
+        while True:
+            status = build_status(run_checks(db_path), interval)
+            write_result(status, status_file, section="standby")
+            if once and status["status"] != "green":
+                raise EnvironmentProblemError(status["message"])
+            if status["status"] != last_status or once:
+                log = logger.info if status["status"] == "green" else logger.error
+                log("Standby status %s: %s", status["status"], status["message"])
+                last_status = status["status"]
+            if once:
+                return
+            time.sleep(interval)
+    except (KeyboardInterrupt, AbortedError):
+        write_result(stopped_status(), status_file, section="standby")
+        logger.info("Standby watcher stopped")
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("standby")
+    main()
@@ -0,0 +1,97 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Verbose trace output for debugging how a command was launched.
+
+When a command works from cmd but fails when AutoSuite starts it, the difference is usually the
+interpreter, the working directory or the environment. With `-v` the command first prints:
+    - the Python interpreter and version, the aurora-rt script and the package it runs from
+    - the exact arguments and the working directory
+    - the config files found and the environment variables the tools read, e.g. AURORA_*, PATH
+    - debug messages, including every child process command line, e.g. the balancing plugin
+
+`-vv` also prefixes every console line with the seconds since the start, logs how long child
+processes took, and prints tracebacks on the console instead of only in the log file.
+
+Usage:
+    `aurora-rt -v balance` or `aurora-rt -vv balance`, or set AURORA_VERBOSE=1 or 2 in the
+    environment AutoSuite starts the command with.
+"""
+
+import logging
+import os
+import shlex
+import subprocess
+import sys
+import time
+from pathlib import Path
+
+from aurora_robot_tools.log import ConsoleFormatter
+
+logger = logging.getLogger(__name__)
+
+# Environment variables that change how the tools run, besides the AURORA_ settings
+ENVIRONMENT_VARIABLES = ("PATH", "PYTHONPATH", "PYTHONHOME", "VIRTUAL_ENV", "CONDA_PREFIX", "APPDATA", "TZ")
+
+level = 0  # 0 normal, 1 with -v, 2 with -vv
+start_wall_time = time.time()
+
+
+class ElapsedFormatter(ConsoleFormatter):
+    """Console messages prefixed with the seconds since the start, with tracebacks."""
+
+    def format(self, record: logging.LogRecord) -> str:
+        """Format the record with the elapsed time."""
+        message = f"[{record.created - start_wall_time:8.3f}s] {super().format(record)}"
+        if record.exc_info:
+            message += "\n" + self.formatException(record.exc_info)
+        return message
+
+
+def command_line(args: list[str]) -> str:
+    """Quote the arguments as they would be typed in the shell."""
+    if os.name == "nt":
+        return subprocess.list2cmdline(args)
+    return shlex.join(args)
+
+
+def enable(verbosity: int) -> None:
+    """Show debug messages on the console, with timings for verbosity 2."""
+    global level  # noqa: PLW0603
+    level = verbosity
+    root = logging.getLogger("aurora_robot_tools")
+    for handler in root.handlers:
+        if type(handler) is logging.StreamHandler:  # The console, not the log file
+            handler.setLevel(logging.DEBUG)
+            if verbosity >= 2:  # noqa: PLR2004
+                handler.setFormatter(ElapsedFormatter())
+
+
+def describe_launch() -> None:
+    """Log how the command was launched."""
+    from aurora_robot_tools import config
+
+    package = Path(__file__).resolve().parent
+    logger.debug("Interpreter: %s (Python %s)", sys.executable, sys.version.split()[0])
+    logger.debug("Script: %s", Path(sys.argv[0]).resolve())
+    logger.debug("Package: %s", package)
+    logger.debug("Arguments: %s", command_line(sys.argv[1:]))
+    logger.debug("Working directory: %s", Path.cwd())
+    for path in config.config_files():
+        logger.debug("Config file: %s (%s)", path, "found" if path.is_file() else "not found")
+    for name, value in sorted(os.environ.items()):
+        if name.startswith("AURORA_") or name in ENVIRONMENT_VARIABLES:
+            logger.debug("Environment: %s=%s", name, value)
+
+
+def child_started(args: list[str]) -> float:
+    """Log the command line of a child process, returns the start time."""
+    logger.debug("Running: %s", command_line(args))
+    return time.monotonic()
+
+
+def child_finished(args: list[str], returncode: int, started: float) -> None:
+    """Log the exit code and, with -vv, the duration of a child process."""
+    if level >= 2:  # noqa: PLR2004
+        logger.debug("%s exited with code %d after %.3f s", Path(args[0]).name, returncode, time.monotonic() - started)
+    else:
+        logger.debug("%s exited with code %d", Path(args[0]).name, returncode)
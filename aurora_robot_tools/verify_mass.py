@@ -0,0 +1,225 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Weigh the crimped cells and flag cells with a missing or extra component before they are cycled.
+
+The expected mass of each cell is the sum of its components:
+    - The anode and cathode masses from the Cell_Assembly_Table
+    - The casings, spacers, separator and spring, from COMPONENT_MASSES_MG in the config, by item and
+      type, type or item, e.g. "Bottom casing CR2032", "Celgard 2325" or "Spring"
+    - The electrolyte, from the electrolyte amount and ELECTROLYTE_DENSITY_G_ML
+
+The cell masses are read from a CSV file with a Cell Number, Rack Position, Sample ID or Cell ID
+column and a Mass (mg) or Mass (g) column, or weighed on the analytical balance one cell at a time,
+see weigh.py. A cell whose mass differs from the expected mass by more than CELL_MASS_TOLERANCE_MG
+fails. If the difference matches one of its components, that component is named as likely missing,
+or as likely extra if the cell is too heavy, e.g. a separator that was not placed.
+
+The weighed and expected masses and the result, "ok" or what is wrong, are stored in the Cell Mass
+(mg), Expected Cell Mass (mg) and Mass Check columns, and the result is included in the cycler
+export. Weighing a cell again replaces its result.
+
+Usage:
+    `aurora-rt verify-mass masses.csv`, or `aurora-rt verify-mass` to weigh on the balance.
+"""
+
+import logging
+from collections.abc import Callable
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+
+from aurora_robot_tools.config import (
+    BALANCE_BAUD_RATE,
+    BALANCE_PORT,
+    BALANCE_PROTOCOL,
+    CELL_MASS_TOLERANCE_MG,
+    COMPONENT_MASSES_MG,
+    DATABASE_FILEPATH,
+    ELECTROLYTE_DENSITY_G_ML,
+)
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.decimals import read_csv
+from aurora_robot_tools.errors import ConfigError, InfeasibleError
+from aurora_robot_tools.load_list import CONSUMABLE_STEPS
+
+logger = logging.getLogger(__name__)
+
+MASS_CHECK_COLUMNS = {"Cell Mass (mg)": "REAL", "Expected Cell Mass (mg)": "REAL", "Mass Check": "TEXT"}
+CELL_KEYS = ("Cell Number", "Rack Position", "Sample ID", "Cell ID")
+MASS_UNITS = {"Mass (mg)": 1.0, "Cell Mass (mg)": 1.0, "Mass (g)": 1000.0}
+
+
+def component_mass(item: str, item_type: str) -> float | None:
+    """Mass of one consumable in mg, None if it is not in the config."""
+    for key in (f"{item} {item_type}".strip(), item_type, item):
+        if key and key in COMPONENT_MASSES_MG:
+            return COMPONENT_MASSES_MG[key]
+    return None
+
+
+def cell_components(row: pd.Series) -> list[tuple[str, float | None]]:
+    """Name and mass in mg of every component of a cell."""
+    components = [
+        ("anode", row["Anode Mass (mg)"]),
+        ("cathode", row["Cathode Mass (mg)"]),
+        ("electrolyte", np.nan_to_num(row["Electrolyte Amount (uL)"]) * ELECTROLYTE_DENSITY_G_ML),
+    ]
+    for _step, item, column in CONSUMABLE_STEPS:
+        item_type = "" if column is None or pd.isna(row.get(column)) else str(row[column]).strip()
+        if column is not None and not item_type:
+            continue  # e.g. no top spacer
+        name = f"{item.lower()} ({item_type})" if item_type else item.lower()
+        components.append((name, component_mass(item, item_type)))
+    return components
+
+
+def check_mass(row: pd.Series, mass: float, tolerance: float = CELL_MASS_TOLERANCE_MG) -> tuple[float, str]:
+    """Compare the weighed mass of a cell with the sum of its components.
+
+    Returns:
+        The expected mass in mg, and "ok" or what is wrong with the cell
+
+    """
+    components = cell_components(row)
+    expected = sum(mass_mg for _name, mass_mg in components)
+    deviation = mass - expected
+    if abs(deviation) <= tolerance:
+        return expected, "ok"
+    # A missing component makes the cell lighter by its mass, an extra one heavier
+    likely = [name for name, mass_mg in components if abs(abs(deviation) - mass_mg) <= tolerance]
+    if likely:
+        return expected, f"{'missing' if deviation < 0 else 'extra'} {' or '.join(likely)}?"
+    return expected, f"{abs(deviation):.1f} mg too {'light' if deviation < 0 else 'heavy'}"
+
+
+def missing_masses(df: pd.DataFrame) -> list[str]:
+    """Components of the cells without a mass, in the config or the cell table."""
+    missing = set()
+    for _, row in df.iterrows():
+        missing |= {name for name, mass_mg in cell_components(row) if pd.isna(mass_mg)}
+    return sorted(missing)
+
+
+def read_masses(path: Path, df: pd.DataFrame) -> dict[int, float]:
+    """Read the weighed masses from a CSV file, by row of the cell table."""
+    path = Path(path)
+    if not path.is_file():
+        msg = f"Mass file {path} not found."
+        raise ConfigError(msg)
+    df_masses = read_csv(path)
+    key = next((col for col in CELL_KEYS if col in df_masses.columns), None)
+    unit = next((col for col in MASS_UNITS if col in df_masses.columns), None)
+    if key is None or unit is None:
+        msg = f"{path.name} needs one of the columns {', '.join(CELL_KEYS)} and one of {', '.join(MASS_UNITS)}."
+        raise ConfigError(msg)
+    rows = {value: i for i, value in df[key].items() if pd.notna(value)}
+    masses = {}
+    unknown = []
+    for value, mass in zip(df_masses[key], df_masses[unit]):
+        if pd.isna(mass):
+            continue
+        if key in ("Cell Number", "Rack Position") and pd.notna(value):
+            value = int(value)  # noqa: PLW2901
+        if value not in rows:
+            unknown.append(str(value))
+            continue
+        masses[rows[value]] = float(mass) * MASS_UNITS[unit]
+    if unknown:
+        logger.warning("No cell with %s %s in the database, skipped.", key, ", ".join(unknown))
+    return masses
+
+
+def weigh_cells(
+    df: pd.DataFrame,
+    read_mass: Callable[[], float | None],
+    read_command: Callable[[str], str] = input,
+) -> dict[int, float]:
+    """Ask the operator to place each cell on the balance and read the masses.
+
+    Returns:
+        Mass in mg by row of the cell table
+
+    """
+    rows = list(df.sort_values("Cell Number").index)
+    masses: dict[int, float] = {}
+    i = 0
+    while i < len(rows):
+        row = df.loc[rows[i]]
+        name = f"cell {int(row['Cell Number'])} ({row['Sample ID']})"
+        reply = read_command(f"Place {name} on the balance, Enter to weigh, s to skip, q to finish: ")
+        if reply.strip().lower() == "q":
+            break
+        if reply.strip().lower() == "s":
+            i += 1
+            continue
+        mass = read_mass()
+        if mass is None:
+            logger.warning("No stable reading for %s, try again", name)
+            continue
+        masses[rows[i]] = mass
+        logger.info("%s: %.2f mg", name.capitalize(), mass)
+        i += 1
+    return masses
+
+
+def main(  # noqa: PLR0913
+    path: Path | None = None,
+    port: str = BALANCE_PORT,
+    baud_rate: int = BALANCE_BAUD_RATE,
+    protocol: str = BALANCE_PROTOCOL,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Check the masses of the assembled cells and store the results.
+
+    Args:
+        path: CSV file with the cell masses, default is to weigh the cells on the balance
+        port: Serial port of the balance, e.g. COM4
+        baud_rate: Baud rate of the balance
+        protocol: "mt-sics" or "sartorius"
+        db_path: Path to the robot database
+        dry_run: Log the results instead of writing them to the database
+
+    """
+    from aurora_robot_tools.assign_cells_to_press import RETURN_STEP
+
+    (df,) = read_tables(db_path, "Cell_Assembly_Table")
+    assembled = df[(df["Cell Number"] > 0) & (df["Last Completed Step"] >= RETURN_STEP)]
+    if assembled.empty:
+        msg = "No assembled cells to weigh."
+        raise InfeasibleError(msg)
+    missing = missing_masses(assembled)
+    if missing:
+        msg = f"No mass for {', '.join(missing)}, weigh the electrodes or add the consumables to COMPONENT_MASSES_MG."
+        raise ConfigError(msg)
+
+    if path is not None:
+        masses = read_masses(path, assembled)
+    else:
+        from aurora_robot_tools.weigh import Balance, read_stable_mass
+
+        balance = Balance(port, baud_rate, protocol)
+        logger.info("Reading cell masses from %s balance on %s", protocol, port)
+        try:
+            masses = weigh_cells(assembled, lambda: read_stable_mass(balance.read))
+        finally:
+            balance.close()
+    if not masses:
+        logger.info("No masses read, database not updated.")
+        return
+
+    for column in MASS_CHECK_COLUMNS:
+        if column not in df.columns:
+            df[column] = None
+    failed = []
+    for i, mass in masses.items():
+        expected, result = check_mass(df.loc[i], mass)
+        df.loc[i, ["Cell Mass (mg)", "Expected Cell Mass (mg)", "Mass Check"]] = [mass, expected, result]
+        if result != "ok":
+            failed.append(f"cell {int(df.loc[i, 'Cell Number'])} ({df.loc[i, 'Sample ID']}): {result}")
+    if failed:
+        logger.warning("%d of %d cells failed the mass check:\n%s", len(failed), len(masses), "\n".join(failed))
+    else:
+        logger.info("All %d weighed cells passed the mass check.", len(masses))
+    write_tables(db_path, {"Cell_Assembly_Table": df}, dry_run=dry_run)
@@ -0,0 +1,152 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Abort a command that runs for too long, and detect one that is stuck.
+
+If a tool hangs, e.g. waiting on a locked database, AutoSuite waits forever and the whole workflow
+is blocked. With a timeout the process exits with a distinct exit code instead, so the AutoSuite
+workflow can handle the failure.
+
+A hard timeout has to allow for the slowest run, e.g. balancing a large batch, so a command that
+hangs early is only noticed much later. The activity watchdog tells a slow command from a stuck one
+by its progress instead: progress reports, output of child processes such as the balancing plugin,
+and writes to the database all count as activity. If there is none for ACTIVITY_WARNING_S seconds a
+warning is logged with where the command is waiting, and if the command carries on a message says
+it was only slow. With ACTIVITY_KILL_S set, a command without activity for that long is aborted with
+exit code 51, independent of --timeout. Time spent waiting for the operator, e.g. a dialog of a
+watched command, is not counted, see waiting().
+
+Usage:
+    `aurora-rt --timeout 300 balance`, ACTIVITY_WARNING_S and ACTIVITY_KILL_S in the config.
+"""
+
+import logging
+import os
+import sys
+import threading
+import time
+import traceback
+from collections.abc import Iterator
+from contextlib import contextmanager
+from pathlib import Path
+
+from aurora_robot_tools.errors import ExitCode
+
+logger = logging.getLogger(__name__)
+
+STACK_FRAMES = 8  # innermost frames of the main thread logged when there is no activity
+
+last_activity = {"time": time.monotonic(), "source": "start of the command", "waiting": False}
+
+
+def abort(exit_code: ExitCode, msg: str, *args: object) -> None:
+    """Log an error and exit the process immediately, stopping its child processes."""
+    from aurora_robot_tools import progress
+    from aurora_robot_tools.shutdown import cleanup, stop_children
+
+    logger.error(msg, *args)
+    progress.finish(exit_code)
+    stop_children()
+    cleanup()
+    logging.shutdown()
+    sys.stdout.flush()
+    sys.stderr.flush()
+    # Hard exit, the main thread may be stuck and cannot be interrupted
+    os._exit(exit_code)
+
+
+def start_timeout(seconds: float) -> threading.Timer:
+    """Exit the process with the timeout exit code if it is still running after some seconds."""
+    timer = threading.Timer(
+        seconds,
+        abort,
+        (ExitCode.TIMEOUT, "Command did not finish within %s seconds, aborting.", seconds),
+    )
+    timer.daemon = True
+    timer.start()
+    return timer
+
+
+def activity(source: str) -> None:
+    """Record that the command made progress, e.g. "progress report" or "output of a child process"."""
+    last_activity.update(time=time.monotonic(), source=source)
+
+
+@contextmanager
+def waiting(source: str) -> Iterator[None]:
+    """Count the time in the context as activity, e.g. "a dialog" the operator has to answer."""
+    activity(f"waiting for {source}")
+    last_activity["waiting"] = True
+    try:
+        yield
+    finally:
+        last_activity["waiting"] = False
+        activity(f"end of waiting for {source}")
+
+
+def database_mtime(db_path: Path) -> float:
+    """Latest modification time of the database and its journal files, 0 if there are none."""
+    mtimes = []
+    for suffix in ("", "-wal", "-journal"):
+        try:
+            mtimes.append(Path(f"{db_path}{suffix}").stat().st_mtime)
+        except OSError:
+            continue
+    return max(mtimes, default=0.0)
+
+
+def main_thread_stack() -> str:
+    """Where the main thread is, the innermost frames first."""
+    frame = sys._current_frames().get(threading.main_thread().ident)  # noqa: SLF001
+    if frame is None:
+        return "  unknown"
+    return "".join(reversed(traceback.format_stack(frame)[-STACK_FRAMES:])).rstrip()
+
+
+def watch_activity(warn_after: float, kill_after: float, db_path: Path) -> None:
+    """Warn, and abort if kill_after is set, when the command has had no activity for too long."""
+    from aurora_robot_tools import progress
+
+    interval = min(warn_after, kill_after or warn_after) / 10
+    db_mtime = database_mtime(db_path)
+    warned = False
+    # The progress status stops running when the command finishes, see progress.finish()
+    while progress.status.get("state", "running") == "running":
+        time.sleep(interval)
+        mtime = database_mtime(db_path)
+        if mtime != db_mtime:
+            db_mtime = mtime
+            activity("database write")
+        if last_activity["waiting"]:
+            last_activity["time"] = time.monotonic()
+        idle = time.monotonic() - last_activity["time"]
+        if warned and idle < warn_after:
+            logger.info("Activity again (%s), the command was slow but not stuck.", last_activity["source"])
+            warned = False
+        if kill_after and idle >= kill_after:
+            abort(
+                ExitCode.STALLED,
+                "No activity for %d seconds, the last was %s, the command is stuck. Aborting in:\n%s",
+                idle,
+                last_activity["source"],
+                main_thread_stack(),
+            )
+        if not warned and idle >= warn_after:
+            logger.warning(
+                "No activity for %d seconds, the last was %s. The command may be stuck in:\n%s",
+                idle,
+                last_activity["source"],
+                main_thread_stack(),
+            )
+            warned = True
+
+
+def start_activity_watchdog(warn_after: float, kill_after: float, db_path: Path) -> threading.Thread | None:
+    """Watch the activity of the command in a background thread, None if the watchdog is disabled."""
+    if warn_after <= 0 and kill_after <= 0:
+        return None
+    if warn_after <= 0 or (kill_after > 0 and kill_after < warn_after):
+        warn_after = kill_after
+    activity("start of the command")
+    thread = threading.Thread(target=watch_activity, args=(warn_after, kill_after, db_path), daemon=True)
+    thread.start()
+    return thread
@@ -0,0 +1,208 @@
+"""Copyright © 2025, Empa, Graham Kimbell, Enea Svaluto-Ferro, Ruben Kuhnel, Corsin Battaglia.
+
+Weigh electrodes on an analytical balance and store the masses in the database.
+
+The masses are read from a Mettler Toledo (MT-SICS) or Sartorius (SBI) balance over a serial port,
+instead of being typed in by hand. The operator is asked to place the electrode from each rack
+position on the balance in turn. A mass is only accepted once the balance reports it as stable for
+BALANCE_STABLE_READINGS consecutive readings within BALANCE_TOLERANCE_MG.
+
+The masses are written to the "Anode Mass (mg)" or "Cathode Mass (mg)" column of the
+Cell_Assembly_Table for the row with that electrode rack position, when the operator finishes.
+
+Usage:
+    `aurora-rt weigh anode`, the port and protocol are set in the config or with `--port` and
+    `--protocol`.
+"""
+
+import logging
+import re
+import sys
+import time
+from collections.abc import Callable
+from pathlib import Path
+
+import pandas as pd
+
+from aurora_robot_tools.config import (
+    BALANCE_BAUD_RATE,
+    BALANCE_PORT,
+    BALANCE_PROTOCOL,
+    BALANCE_STABLE_READINGS,
+    BALANCE_TIMEOUT,
+    BALANCE_TOLERANCE_MG,
+    DATABASE_FILEPATH,
+)
+from aurora_robot_tools.database import read_tables, write_tables
+from aurora_robot_tools.errors import ConfigError, EnvironmentProblemError
+
+logger = logging.getLogger(__name__)
+
+UNIT_TO_MG = {"g": 1000.0, "mg": 1.0, "kg": 1e6}
+
+# Command to request the current weight, and pattern of the reply
+# MT-SICS replies "S S     12.3456 g" when stable and "S D     12.3456 g" when not
+# Sartorius SBI replies "+    12.3456 g" when stable and leaves out the unit when not
+PROTOCOLS = {
+    "mt-sics": (b"SI\r\n", re.compile(r"^S\s+(?P<stable>[SD])\s+(?P<value>-?\d+(\.\d*)?)\s*(?P<unit>[a-z]+)")),
+    "sartorius": (b"\x1bP\r\n", re.compile(r"(?P<sign>[+-])?\s*(?P<value>\d+(\.\d*)?)\s*(?P<unit>[a-z]*)\s*$")),
+}
+
+
+def parse_reading(line: str, protocol: str) -> tuple[float, bool] | None:
+    """Parse a reply from the balance into the mass in mg and whether it is stable.
+
+    Returns:
+        Mass in mg and stability, or None if the reply is not a weight, e.g. an error
+
+    """
+    _request, pattern = PROTOCOLS[protocol]
+    match = pattern.search(line.strip())
+    if not match or (match["unit"] and match["unit"] not in UNIT_TO_MG):
+        return None
+    value = float(match["value"])
+    if protocol == "sartorius":
+        value = -value if match["sign"] == "-" else value
+        stable = bool(match["unit"])
+    else:
+        stable = match["stable"] == "S"
+    return round(value * UNIT_TO_MG.get(match["unit"] or "g", 1.0), 4), stable
+
+
+class Balance:
+    """Serial connection to an analytical balance."""
+
+    def __init__(self, port: str, baud_rate: int, protocol: str) -> None:
+        """Open the serial port."""
+        import serial
+
+        if protocol not in PROTOCOLS:
+            msg = f"Unknown balance protocol '{protocol}', must be one of {', '.join(PROTOCOLS)}."
+            raise ConfigError(msg)
+        self.protocol = protocol
+        try:
+            self.serial = serial.Serial(port, baud_rate, timeout=1)
+        except serial.SerialException as e:
+            msg = f"Cannot open balance on {port}: {e}"
+            raise EnvironmentProblemError(msg) from e
+
+    def read(self) -> tuple[float, bool] | None:
+        """Request and parse one reading."""
+        request, _pattern = PROTOCOLS[self.protocol]
+        self.serial.reset_input_buffer()
+        self.serial.write(request)
+        line = self.serial.readline().decode("ascii", errors="replace")
+        return parse_reading(line, self.protocol)
+
+    def close(self) -> None:
+        """Close the serial port."""
+        self.serial.close()
+
+
+def read_stable_mass(
+    read: Callable[[], tuple[float, bool] | None],
+    n_readings: int = BALANCE_STABLE_READINGS,
+    tolerance_mg: float = BALANCE_TOLERANCE_MG,
+    timeout: float = BALANCE_TIMEOUT,
+) -> float | None:
+    """Read until the last n readings are stable and agree within the tolerance.
+
+    Returns:
+        Mass in mg, or None if no stable reading within the timeout
+
+    """
+    readings: list[float] = []
+    end_time = time.monotonic() + timeout
+    while time.monotonic() < end_time:
+        reading = read()
+        if reading is None or not reading[1]:
+            readings.clear()
+            continue
+        readings = [*readings, reading[0]][-n_readings:]
+        if len(readings) == n_readings and max(readings) - min(readings) <= tolerance_mg:
+            return readings[-1]
+    return None
+
+
+def weigh_electrodes(
+    df: pd.DataFrame,
+    electrode: str,
+    read_mass: Callable[[], float | None],
+    read_command: Callable[[str], str] = input,
+) -> dict[int, float]:
+    """Ask the operator to place each electrode on the balance and read the masses.
+
+    Returns:
+        Mass in mg by electrode rack position
+
+    """
+    positions = df.loc[df[f"{electrode} Type"].notna() & (df[f"{electrode} Rack Position"] > 0)]
+    positions = sorted(positions[f"{electrode} Rack Position"].astype(int))
+    masses: dict[int, float] = {}
+    i = 0
+    while i < len(positions):
+        position = positions[i]
+        reply = read_command(
+            f"Place {electrode.lower()} {position} on the balance, Enter to weigh, s to skip, q to finish: ",
+        )
+        if reply.strip().lower() == "q":
+            break
+        if reply.strip().lower() == "s":
+            i += 1
+            continue
+        mass = read_mass()
+        if mass is None:
+            logger.warning("No stable reading for %s %d, try again", electrode.lower(), position)
+            continue
+        masses[position] = mass
+        logger.info("%s %d: %.3f mg", electrode, position, mass)
+        i += 1
+    return masses
+
+
+def main(  # noqa: PLR0913
+    electrode: str,
+    port: str = BALANCE_PORT,
+    baud_rate: int = BALANCE_BAUD_RATE,
+    protocol: str = BALANCE_PROTOCOL,
+    db_path: Path = DATABASE_FILEPATH,
+    dry_run: bool = False,
+) -> None:
+    """Weigh the anodes or cathodes and write their masses to the database.
+
+    Args:
+        electrode: "anode" or "cathode"
+        port: Serial port of the balance, e.g. COM4
+        baud_rate: Baud rate of the balance
+        protocol: "mt-sics" or "sartorius"
+        db_path: Path to the robot database
+        dry_run: Log the changes instead of writing them to the database
+
+    """
+    electrode = electrode.capitalize()
+    if electrode not in ("Anode", "Cathode"):
+        msg = f"Electrode must be anode or cathode, got '{electrode.lower()}'."
+        raise ConfigError(msg)
+    (df,) = read_tables(db_path, "Cell_Assembly_Table")
+
+    balance = Balance(port, baud_rate, protocol)
+    logger.info("Reading masses from %s balance on %s", protocol, port)
+    try:
+        masses = weigh_electrodes(df, electrode, lambda: read_stable_mass(balance.read))
+    finally:
+        balance.close()
+
+    if not masses:
+        logger.info("No masses read, database not updated.")
+        return
+    for position, mass in masses.items():
+        df.loc[df[f"{electrode} Rack Position"] == position, f"{electrode} Mass (mg)"] = mass
+    logger.info("Storing %d %s masses", len(masses), electrode.lower())
+    write_tables(db_path, {"Cell_Assembly_Table": df}, dry_run=dry_run)
+
+
+if __name__ == "__main__":
+    from aurora_robot_tools.log import setup_logging
+
+    setup_logging("weigh")
+    main(sys.argv[1] if len(sys.argv) >= 2 else "anode")
@@ -0,0 +1,40 @@
+"""Test converting settings from config files and environment variables."""
+
+from pathlib import Path
+
+import pytest
+
+from aurora_robot_tools.config import convert_setting
+from aurora_robot_tools.errors import ConfigError
+
+
+class TestConvertSetting:
+    """Settings are converted to the type of their default."""
+
+    @pytest.mark.parametrize(
+        ("name", "value", "expected"),
+        [
+            ("PRESS_TO_RACK", {"1": 4, "2": 2}, {1: 4, 2: 2}),
+            ("PRESS_TO_RACK", '{"1": 4, "2": 2}', {1: 4, 2: 2}),
+            ("SPECIFIC_CAPACITIES", '{"NMC811": 195}', {"NMC811": 195.0}),
+            ("PRESS_FORCE_CONTROLLERS", '{"3": "opc.tcp://press3:4840"}', {3: "opc.tcp://press3:4840"}),
+            ("DATABASE_PROFILES", '{"test": "C:/test.db"}', {"test": Path("C:/test.db")}),
+            ("DISABLED_PRESSES", "2,5", [2, 5]),
+            ("DISABLED_PRESSES", "", []),
+            ("RACK_POSITIONS", "36", 36),
+        ],
+    )
+    def test_convert(self, name: str, value: object, expected: object) -> None:
+        """Tables from environment variables are JSON objects, lists are comma separated."""
+        assert convert_setting(name, value) == expected
+
+    @pytest.mark.parametrize("value", ["1=4", "[1, 4]", '"1: 4"'])
+    def test_not_a_json_object(self, value: str) -> None:
+        """A table given as text that is not a JSON object is refused."""
+        with pytest.raises(ConfigError, match="AURORA_PRESS_TO_RACK must be a JSON object"):
+            convert_setting("PRESS_TO_RACK", value)
+
+    def test_not_a_table(self) -> None:
+        """A table in the config file must be a table."""
+        with pytest.raises(ConfigError, match="must be a table"):
+            convert_setting("PRESS_TO_RACK", [1, 4])
@@ -0,0 +1,64 @@
+"""Test reloading the config in the services."""
+
+import sys
+from pathlib import Path
+from types import ModuleType
+
+import pytest
+
+from aurora_robot_tools import config, production
+from aurora_robot_tools.config_watch import propagate, switch_database
+
+
+@pytest.fixture
+def module(monkeypatch: pytest.MonkeyPatch) -> ModuleType:
+    """A module of the tools that imported NP_RATIO_MINIMUM from the config."""
+    module = ModuleType("aurora_robot_tools.reloaded")
+    module.NP_RATIO_MINIMUM = 1.0
+    monkeypatch.setitem(sys.modules, module.__name__, module)
+    return module
+
+
+class TestPropagate:
+    """Replacing the old values of changed settings in the imported modules."""
+
+    def test_replaces_old_value(self, module: ModuleType) -> None:
+        """A module attribute that is the old value gets the new one."""
+        propagate({"NP_RATIO_MINIMUM": (module.NP_RATIO_MINIMUM, 1.05)})
+        assert module.NP_RATIO_MINIMUM == 1.05
+
+    def test_keeps_other_values(self, module: ModuleType) -> None:
+        """An attribute with the same name but another value was not imported from the config."""
+        propagate({"NP_RATIO_MINIMUM": (0.9, 1.05)})
+        assert module.NP_RATIO_MINIMUM == 1.0
+
+    def test_other_packages(self, monkeypatch: pytest.MonkeyPatch) -> None:
+        """Only modules of the tools are changed."""
+        other = ModuleType("other_package")
+        other.NP_RATIO_MINIMUM = 1.0
+        monkeypatch.setitem(sys.modules, other.__name__, other)
+        propagate({"NP_RATIO_MINIMUM": (other.NP_RATIO_MINIMUM, 1.05)})
+        assert other.NP_RATIO_MINIMUM == 1.0
+
+
+class TestSwitchDatabase:
+    """Following a new DATABASE_FILEPATH."""
+
+    @pytest.fixture(autouse=True)
+    def production_profile(self, monkeypatch: pytest.MonkeyPatch, tmp_path: Path) -> None:
+        """A production profile, writing to it is not allowed."""
+        monkeypatch.setattr(config, "DATABASE_PROFILES", {"production": tmp_path / "production.db"})
+        monkeypatch.setattr(production, "allowed", False)
+
+    def test_new_database(self, tmp_path: Path) -> None:
+        """The service switches to a database that is not production."""
+        assert switch_database(tmp_path / "old.db", tmp_path / "new.db") == tmp_path / "new.db"
+
+    def test_production_refused(self, tmp_path: Path) -> None:
+        """The service keeps the old database instead of switching to production."""
+        assert switch_database(tmp_path / "old.db", tmp_path / "production.db") == tmp_path / "old.db"
+
+    def test_production_allowed(self, monkeypatch: pytest.MonkeyPatch, tmp_path: Path) -> None:
+        """With --allow-production the service switches to production."""
+        monkeypatch.setattr(production, "allowed", True)
+        assert switch_database(tmp_path / "old.db", tmp_path / "production.db") == tmp_path / "production.db"
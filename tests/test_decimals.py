@@ -0,0 +1,109 @@
+"""Test reading numbers written with a decimal comma or a decimal point."""
+
+import logging
+from pathlib import Path
+
+import pytest
+
+from aurora_robot_tools import decimals
+from aurora_robot_tools.decimals import detect_decimal_comma, read_csv, uses_decimal_comma
+from aurora_robot_tools.errors import ConfigError
+
+
+class TestUsesDecimalComma:
+    """Telling the decimal separator of a single number."""
+
+    @pytest.mark.parametrize(
+        ("text", "expected"),
+        [
+            ("3,5", True),
+            ("3.5", False),
+            (" 3,5 ", True),
+            ("0,25", True),
+            ("1.234,5", True),
+            ("1,234.5", False),
+            ("1.234.567", True),
+            ("1,234,567", False),
+            ("1,234", None),
+            ("1.234", None),
+            ("42", None),
+        ],
+    )
+    def test_uses_decimal_comma(self, text: str, expected: bool | None) -> None:
+        """A single separator followed by three digits can be read either way."""
+        assert uses_decimal_comma(text) is expected
+
+
+class TestDetectDecimalComma:
+    """Deciding the decimal separator of a file from all its numbers."""
+
+    @pytest.mark.parametrize(
+        ("values", "expected"),
+        [
+            (["3,5", "4,25"], True),
+            (["3.5", "4.25"], False),
+            (["1.234,5", "2,5"], True),
+            (["1,234", "2,5"], True),
+            (["1,234", "3.5"], False),
+            (["Sample", "3,5", "A1"], True),
+            (["42", "Sample"], False),
+            ([], False),
+        ],
+    )
+    def test_detect_decimal_comma(self, values: list[str], expected: bool) -> None:
+        """Ambiguous numbers follow the unambiguous ones, text is ignored."""
+        assert detect_decimal_comma(values, "test.csv") is expected
+
+    def test_only_ambiguous_warns(self, caplog: pytest.LogCaptureFixture) -> None:
+        """A file with only values like 1,234 is read with decimal points and a warning."""
+        with caplog.at_level(logging.WARNING, logger=decimals.__name__):
+            assert detect_decimal_comma(["1,234", "5,678"], "test.csv") is False
+        assert "1,234 could also be a decimal comma" in caplog.text
+
+    @pytest.mark.parametrize("values", [["3,5", "3.5"], ["1.234,5", "1,234.5"]])
+    def test_mixed_is_refused(self, values: list[str]) -> None:
+        """Decimal commas and decimal points in the same file cannot both be read."""
+        with pytest.raises(ConfigError, match="--decimal-comma or --decimal-point"):
+            detect_decimal_comma(values, "test.csv")
+
+    @pytest.mark.parametrize("setting", [True, False])
+    def test_option_skips_detection(self, monkeypatch: pytest.MonkeyPatch, setting: bool) -> None:
+        """--decimal-comma or --decimal-point is used even for a mixed file."""
+        monkeypatch.setattr(decimals, "decimal_comma", setting)
+        assert detect_decimal_comma(["3,5", "3.5"], "test.csv") is setting
+
+
+class TestReadCsv:
+    """Reading CSV files with the separator and the decimal format guessed."""
+
+    @pytest.mark.parametrize(
+        ("text", "expected"),
+        [
+            ("Sample,Mass (mg)\nA,3.5\nB,4.25\nC,12.0\n", [3.5, 4.25, 12.0]),
+            ("Sample;Mass (mg)\nA;3,5\nB;4,25\nC;12,0\n", [3.5, 4.25, 12.0]),
+            ("Sample;Mass (mg)\nA;3.5\nB;4.25\nC;12.0\n", [3.5, 4.25, 12.0]),
+            ("Sample;Mass (mg)\nA;1.234,5\nB;2,5\nC;3,0\n", [1234.5, 2.5, 3.0]),
+            ("Sample;Mass (mg)\nA;1,234\nB;2,5\nC;3,0\n", [1.234, 2.5, 3.0]),
+            ("Sample\tMass (mg)\nA\t3,5\nB\t4,25\nC\t12,0\n", [3.5, 4.25, 12.0]),
+        ],
+    )
+    def test_read_csv(self, tmp_path: Path, text: str, expected: list[float]) -> None:
+        """Semicolon and tab separated files may use decimal commas, comma separated ones do not."""
+        path = tmp_path / "masses.csv"
+        path.write_text(text, encoding="utf-8")
+        df = read_csv(path)
+        assert df.columns.tolist() == ["Sample", "Mass (mg)"]
+        assert df["Mass (mg)"].tolist() == pytest.approx(expected)
+
+    def test_mixed_file_is_refused(self, tmp_path: Path) -> None:
+        """A semicolon separated file with decimal commas and decimal points is refused."""
+        path = tmp_path / "masses.csv"
+        path.write_text("Sample;Mass (mg)\nA;3,5\nB;4.25\nC;12,0\n", encoding="utf-8")
+        with pytest.raises(ConfigError, match="masses.csv"):
+            read_csv(path)
+
+    def test_byte_order_mark(self, tmp_path: Path) -> None:
+        """The byte order mark Excel writes at the start of a CSV file is not part of the header."""
+        path = tmp_path / "masses.csv"
+        path.write_text("Sample;Mass (mg)\nA;3,5\nB;4,25\n", encoding="utf-8-sig")
+        assert read_csv(path).columns.tolist() == ["Sample", "Mass (mg)"]
@@ -0,0 +1,47 @@
+"""Test the calibration of the dispenser syringes."""
+
+import pandas as pd
+import pytest
+
+from aurora_robot_tools.dispenser_calibration import dispense_commands, fit
+
+
+class TestFit:
+    """Fitting the dispensed volume to the commanded volume."""
+
+    def test_straight_line(self) -> None:
+        """Slope and offset are recovered from several commanded volumes."""
+        commanded = pd.Series([20.0, 50.0, 100.0])
+        slope, offset = fit(commanded, 0.98 * commanded + 0.3)
+        assert slope == pytest.approx(0.98)
+        assert offset == pytest.approx(0.3)
+
+    def test_one_volume_through_origin(self) -> None:
+        """With only one commanded volume the line goes through the origin."""
+        slope, offset = fit(pd.Series([50.0, 50.0]), pd.Series([49.0, 49.2]))
+        assert slope == pytest.approx(0.982)
+        assert offset == 0
+
+
+class TestDispenseCommands:
+    """Commanded volumes that dispense the target volumes."""
+
+    CALIBRATION = {"slope": 0.98, "offset": 0.3}
+
+    def test_uncalibrated(self) -> None:
+        """Without a calibration the commands are a copy of the volumes."""
+        volumes = pd.Series([10.0, 0.0])
+        commands = dispense_commands(volumes, None)
+        assert commands.tolist() == [10.0, 0.0]
+        assert commands is not volumes
+
+    def test_inverts_the_fit(self) -> None:
+        """Dispensing the commands with the fitted syringe gives the target volumes."""
+        volumes = pd.Series([20.0, 50.0])
+        commands = dispense_commands(volumes, self.CALIBRATION)
+        assert (0.98 * commands + 0.3).tolist() == pytest.approx([20.0, 50.0])
+
+    def test_zero_and_small_volumes(self) -> None:
+        """Zero stays zero and volumes below the offset are not commanded negative."""
+        commands = dispense_commands(pd.Series([0.0, 0.1]), self.CALIBRATION)
+        assert commands.tolist() == [0.0, 0.0]
@@ -0,0 +1,172 @@
+"""Test the electrolyte volumes and mixing steps."""
+
+import logging
+from pathlib import Path
+
+import numpy as np
+import pandas as pd
+import pytest
+
+from aurora_robot_tools import electrolyte_calculation
+from aurora_robot_tools.electrolyte_calculation import (
+    add_dispense_commands,
+    get_volumnes,
+    round_cell_volumes,
+    round_mixing_volumes,
+)
+
+# Vial 1 is a stock, vial 2 is mixed entirely from vial 1
+MIX_FRACTIONS = np.array([[0.0, 0.0], [1.0, 0.0]])
+
+
+def cells() -> pd.DataFrame:
+    """Two cells with electrolyte from vial 2, one rejected and one not assigned."""
+    return pd.DataFrame(
+        {
+            "Cell Number": [1, 2, 3, 0],
+            "Error Code": [0, 0, 1, 0],
+            "Electrolyte Position": [2, 2, 2, 2],
+            "Electrolyte Amount (uL)": [50.0, 50.0, 50.0, 50.0],
+        },
+    )
+
+
+class TestGetVolumes:
+    """Volumes required in each vial."""
+
+    def test_without_overhead(self) -> None:
+        """Only the assigned cells without errors count, the stock covers the mixed vial."""
+        volumes, cumulative = get_volumnes(cells(), MIX_FRACTIONS, 1.0, overhead=0)
+        np.testing.assert_allclose(volumes, [0, 100])
+        np.testing.assert_allclose(cumulative, [100, 100])
+
+    def test_safety_factor(self) -> None:
+        """The safety factor scales the volume of the cells."""
+        volumes, cumulative = get_volumnes(cells(), MIX_FRACTIONS, 1.5, overhead=0)
+        np.testing.assert_allclose(volumes, [0, 150])
+        np.testing.assert_allclose(cumulative, [150, 150])
+
+    def test_overhead_once_per_vial(self) -> None:
+        """The overhead is added to the dispensed vial, and to the stock it is mixed from."""
+        volumes, cumulative = get_volumnes(cells(), MIX_FRACTIONS, 1.0, overhead=10)
+        np.testing.assert_allclose(volumes, [0, 110])
+        np.testing.assert_allclose(cumulative, [120, 110])
+
+    def test_overhead_from_config(self, monkeypatch: pytest.MonkeyPatch) -> None:
+        """Without an overhead the dead and priming volume are looked up when called, e.g. after a reload."""
+        monkeypatch.setattr(electrolyte_calculation, "ELECTROLYTE_DEAD_VOLUME_UL", 6.0)
+        monkeypatch.setattr(electrolyte_calculation, "ELECTROLYTE_PRIMING_VOLUME_UL", 4.0)
+        volumes, cumulative = get_volumnes(cells(), MIX_FRACTIONS, 1.0)
+        np.testing.assert_allclose(volumes, [0, 110])
+        np.testing.assert_allclose(cumulative, [120, 110])
+
+    def test_unused_vial_has_no_overhead(self) -> None:
+        """A vial nothing is dispensed or mixed from needs nothing."""
+        volumes, cumulative = get_volumnes(cells(), np.zeros((3, 3)), 1.0, overhead=10)
+        np.testing.assert_allclose(volumes, [0, 110, 0])
+        np.testing.assert_allclose(cumulative, [0, 110, 0])
+
+
+def dispensed_cells() -> pd.DataFrame:
+    """Cells with electrolyte dispensed before and after the separator."""
+    return pd.DataFrame(
+        {
+            "Cell Number": [1, 2, 0],
+            "Error Code": [0, 0, 0],
+            "Cathode Balancing Capacity (mAh)": [2.0, 2.0, 2.0],
+            "Electrolyte Amount (uL)": [50.2, 40.0, 30.2],
+            "Electrolyte Amount Before Separator (uL)": [30.2, 0.0, 30.2],
+            "Electrolyte Amount After Separator (uL)": [20.0, 40.0, 0.0],
+        },
+    )
+
+
+class TestRoundCellVolumes:
+    """Rounding the electrolyte of the cells to the dispenser resolution."""
+
+    def test_rounds_assigned_cells(self) -> None:
+        """The dispensed volumes of assigned cells are rounded and the total follows them."""
+        df = dispensed_cells()
+        round_cell_volumes(df, 0.5)
+        assert df["Electrolyte Amount Before Separator (uL)"].tolist() == pytest.approx([30.0, 0.0, 30.2])
+        assert df["Electrolyte Amount After Separator (uL)"].tolist() == pytest.approx([20.0, 40.0, 0.0])
+        assert df["Electrolyte Amount (uL)"].tolist() == pytest.approx([50.0, 40.0, 30.2])
+
+    def test_reports_ec_error(self, caplog: pytest.LogCaptureFixture) -> None:
+        """The change in E/C ratio is reported for the cells that changed."""
+        df = dispensed_cells()
+        with caplog.at_level(logging.INFO, logger=electrolyte_calculation.__name__):
+            round_cell_volumes(df, 0.5)
+        report = next(r.getMessage() for r in caplog.records if "E/C error (uL/mAh)" in r.getMessage())
+        rows = report.splitlines()[2:]
+        assert len(rows) == 1
+        assert rows[0].split() == ["1", "50.20", "50.00", "-0.100"]
+        assert "Largest E/C error from rounding: 0.100 uL/mAh" in caplog.text
+
+    def test_no_report_without_change(self, caplog: pytest.LogCaptureFixture) -> None:
+        """Volumes that are already multiples of the resolution are not reported."""
+        df = dispensed_cells()
+        df["Electrolyte Amount Before Separator (uL)"] = 30.0
+        with caplog.at_level(logging.INFO, logger=electrolyte_calculation.__name__):
+            round_cell_volumes(df, 0.5)
+        assert "E/C error" not in caplog.text
+
+    def test_no_resolution(self) -> None:
+        """A resolution of 0 leaves the volumes as they are."""
+        df = dispensed_cells()
+        round_cell_volumes(df, 0)
+        pd.testing.assert_frame_equal(df, dispensed_cells())
+
+
+class TestRoundMixingVolumes:
+    """Rounding the mixing steps to the dispenser resolution."""
+
+    def test_drops_steps_below_half_the_resolution(self, caplog: pytest.LogCaptureFixture) -> None:
+        """Steps that round to zero are removed with a warning, the others are rounded."""
+        df_mixing = pd.DataFrame({"Source Position": [1, 1, 2], "Target Position": [3, 4, 4]})
+        df_mixing["Volume (uL)"] = [100.2, 0.2, 0.3]
+        with caplog.at_level(logging.WARNING, logger=electrolyte_calculation.__name__):
+            round_mixing_volumes(df_mixing, 0.5)
+        assert df_mixing.index.tolist() == [0, 2]
+        assert df_mixing["Volume (uL)"].tolist() == pytest.approx([100.0, 0.5])
+        assert "1 mixing steps are below half the resolution" in caplog.text
+
+    def test_empty(self) -> None:
+        """No mixing steps is not an error."""
+        df_mixing = pd.DataFrame({"Source Position": [], "Target Position": [], "Volume (uL)": []})
+        round_mixing_volumes(df_mixing, 0.5)
+        assert df_mixing.empty
+
+
+class TestAddDispenseCommands:
+    """Commanded volumes written next to the electrolyte amounts."""
+
+    CALIBRATION = {"slope": 0.98, "offset": 0.1, "calibrated": "2025-01-01T00:00:00+00:00", "points": 3}
+
+    def test_uncalibrated(self, monkeypatch: pytest.MonkeyPatch) -> None:
+        """Without a calibration the commands are the amounts."""
+        monkeypatch.setattr(electrolyte_calculation, "calibration_for", lambda _syringe, _db_path: None)
+        df = dispensed_cells()
+        df_mixing = pd.DataFrame({"Volume (uL)": [100.2]})
+        add_dispense_commands(df, df_mixing, Path("unused.db"), 0)
+        assert df["Electrolyte Dispense Before Separator (uL)"].tolist() == [30.2, 0.0, 30.2]
+        assert df["Electrolyte Dispense After Separator (uL)"].tolist() == [20.0, 40.0, 0.0]
+        assert df_mixing["Dispense Volume (uL)"].tolist() == [100.2]
+
+    def test_calibrated(self, monkeypatch: pytest.MonkeyPatch) -> None:
+        """The commands are corrected with the calibration and rounded, zero volumes stay zero."""
+        monkeypatch.setattr(electrolyte_calculation, "calibration_for", lambda _syringe, _db_path: self.CALIBRATION)
+        df = pd.DataFrame(
+            {
+                "Electrolyte Amount Before Separator (uL)": [49.1, 0.0],
+                "Electrolyte Amount After Separator (uL)": [0.0, 19.7],
+            },
+        )
+        df_mixing = pd.DataFrame({"Volume (uL)": [98.1]})
+        add_dispense_commands(df, df_mixing, Path("unused.db"), 0.5)
+        # (49.1 - 0.1) / 0.98 = 50, (19.7 - 0.1) / 0.98 = 20, (98.1 - 0.1) / 0.98 = 100
+        assert df["Electrolyte Dispense Before Separator (uL)"].tolist() == pytest.approx([50.0, 0.0])
+        assert df["Electrolyte Dispense After Separator (uL)"].tolist() == pytest.approx([0.0, 20.0])
+        assert df_mixing["Dispense Volume (uL)"].tolist() == pytest.approx([100.0])
+        # The amounts themselves are not changed
+        assert df["Electrolyte Amount Before Separator (uL)"].tolist() == [49.1, 0.0]
@@ -0,0 +1,119 @@
+"""Test the arguments of jobs run by the HTTP server, the drop-folder agent and the job socket."""
+
+from pathlib import Path
+
+import pandas as pd
+import pytest
+
+from aurora_robot_tools import assign_cells_to_press, export_plan, jobs, staging
+from aurora_robot_tools.errors import ConfigError, ExitCode
+from aurora_robot_tools.jobs import flag, job_arguments, run_assign_press, run_job, with_dry_run
+
+
+@pytest.fixture
+def calls(monkeypatch: pytest.MonkeyPatch) -> list[dict]:
+    """Record the arguments of balance jobs instead of balancing, without staging or a plan."""
+    recorded: list[dict] = []
+    monkeypatch.setitem(jobs.COMMANDS, "balance", lambda _db_path, args: recorded.append(args))
+    monkeypatch.setattr(staging, "approval_required", lambda: False)
+    monkeypatch.setattr(export_plan, "read_plan", lambda _db_path: pd.DataFrame())
+    return recorded
+
+
+class TestDryRun:
+    """A service started with --dry-run only runs dry runs."""
+
+    @pytest.mark.parametrize("request_dry_run", [{}, {"dry_run": False}, {"dry_run": True}])
+    def test_service_dry_run_wins(self, request_dry_run: dict) -> None:
+        """A job cannot switch off the dry run of the service."""
+        _command, args = job_arguments({"command": "balance", **request_dry_run}, dry_run=True)
+        assert args["dry_run"] is True
+
+    @pytest.mark.parametrize(("request_dry_run", "expected"), [({}, False), ({"dry_run": True}, True)])
+    def test_job_dry_run(self, request_dry_run: dict, expected: bool) -> None:
+        """Without --dry-run each job decides, the default is to write."""
+        _command, args = job_arguments({"command": "balance", "args": request_dry_run}, dry_run=False)
+        assert args["dry_run"] is expected
+
+    def test_server_arguments(self) -> None:
+        """The body of a POST request to a --dry-run server is also a dry run."""
+        assert with_dry_run({"mode": 6, "dry_run": False}, dry_run=True) == {"mode": 6, "dry_run": True}
+
+    def test_no_write(self, calls: list[dict], monkeypatch: pytest.MonkeyPatch, tmp_path: Path) -> None:
+        """A job sending dry_run false to a --dry-run service runs without the lock, as a dry run."""
+
+        def no_lock(*_args: object) -> None:
+            msg = "a dry run must not lock the database"
+            raise AssertionError(msg)
+
+        monkeypatch.setattr(jobs, "acquire_lock", no_lock)
+        command, args = job_arguments({"command": "balance", "dry_run": False}, dry_run=True)
+        result = run_job(command, tmp_path / "database.db", args)
+        assert result["ok"], result
+        assert [call["dry_run"] for call in calls] == [True]
+
+
+class TestFlags:
+    """Options of a job are JSON true or false."""
+
+    @pytest.mark.parametrize(("args", "expected"), [({}, False), ({"link": True}, True), ({"link": False}, False)])
+    def test_flag(self, args: dict, expected: bool) -> None:
+        """A missing option is the default."""
+        assert flag(args, "link", False) is expected
+
+    @pytest.mark.parametrize("value", ["false", "true", 0, 1, None])
+    def test_not_a_boolean(self, value: object) -> None:
+        """Strings such as "false" would be true with bool(), they are refused."""
+        with pytest.raises(ConfigError, match="link of a job must be true or false"):
+            flag({"link": value}, "link", False)
+
+    @pytest.mark.parametrize("service_dry_run", [False, True])
+    def test_dry_run_string(self, service_dry_run: bool) -> None:
+        """A dry run given as a string is refused, also by a --dry-run service."""
+        with pytest.raises(ConfigError, match="dry_run"):
+            with_dry_run({"dry_run": "false"}, service_dry_run)
+
+    def test_run_job_refuses(self, calls: list[dict], tmp_path: Path) -> None:
+        """A job with a dry run that is not true or false fails with the config error and is not run."""
+        result = run_job("balance", tmp_path / "database.db", {"dry_run": "false"})
+        assert not result["ok"]
+        assert result["exit_code"] == ExitCode.CONFIG_ERROR
+        assert calls == []
+
+
+class TestJobArguments:
+    """The command and arguments of a job request."""
+
+    @pytest.mark.parametrize(
+        "request_",
+        [{"command": "balance", "mode": 6}, {"command": "balance", "args": {"mode": 6}}],
+    )
+    def test_arguments(self, request_: dict) -> None:
+        """The arguments are in "args" or the other keys of the request."""
+        assert job_arguments(request_, dry_run=False) == ("balance", {"mode": 6, "dry_run": False})
+
+    def test_request_unchanged(self) -> None:
+        """The request is not changed, the arguments are a new dict."""
+        args = {"mode": 6, "dry_run": False}
+        request_ = {"command": "balance", "args": args}
+        _command, job_args = job_arguments(request_, dry_run=True)
+        assert job_args["dry_run"] is True
+        assert request_ == {"command": "balance", "args": {"mode": 6, "dry_run": False}}
+        assert job_args is not args
+
+    def test_args_not_an_object(self) -> None:
+        """The args of a job must be a JSON object."""
+        with pytest.raises(ConfigError, match="must be a JSON object"):
+            job_arguments({"command": "balance", "args": [6]}, dry_run=False)
+
+
+class TestAssignPress:
+    """The assign-press job matches the assign command."""
+
+    @pytest.mark.parametrize(("args", "expected"), [({}, True), ({"link": True}, True), ({"link": False}, False)])
+    def test_link(self, monkeypatch: pytest.MonkeyPatch, tmp_path: Path, args: dict, expected: bool) -> None:
+        """Rack positions are linked to presses unless the job says not to, as for `aurora-rt assign`."""
+        links = []
+        monkeypatch.setattr(assign_cells_to_press, "main", lambda link, *_args: links.append(link))
+        run_assign_press(tmp_path / "database.db", {**args, "dry_run": True})
+        assert links == [expected]
@@ -0,0 +1,160 @@
+"""Test journaling operations of several steps and recovering unfinished ones."""
+
+import sqlite3
+from contextlib import closing
+from pathlib import Path
+
+import pytest
+
+from aurora_robot_tools import jobs, lock
+from aurora_robot_tools.errors import ConfigError, DatabaseError
+from aurora_robot_tools.journal import (
+    Journal,
+    check_unfinished,
+    clean_unfinished,
+    journal_path,
+    operation,
+    read_journal,
+    recover,
+    snapshot_path,
+    write_journal,
+)
+
+STEPS = [
+    {"description": "Balance the cells", "command": "balance", "args": {"mode": 6}},
+    {"description": "Assign the presses", "command": "assign-press", "args": {"limit": 0}},
+]
+
+
+def load(db_path: Path, press: int, cell: int) -> None:
+    """Load a cell into a press."""
+    with closing(sqlite3.connect(db_path)) as conn, conn:
+        conn.execute("UPDATE Press_Table SET `Current Cell Number Loaded` = ? WHERE `Press Number` = ?", (cell, press))
+
+
+def loaded(db_path: Path) -> list[int]:
+    """Cell loaded in each press."""
+    with closing(sqlite3.connect(db_path)) as conn:
+        rows = conn.execute("SELECT `Current Cell Number Loaded` FROM Press_Table ORDER BY `Press Number`")
+        return [row[0] for row in rows]
+
+
+def crash(db_path: Path, steps: list[dict], done: int = 0) -> Journal:
+    """Start an operation, finish some steps and change the database, then stop as if the tool crashed."""
+    journal = operation(db_path, "rebalance", steps).__enter__()
+    for step in range(done):
+        journal.done(step)
+    load(db_path, 1, 5)
+    return journal
+
+
+def fail(db_path: Path) -> None:
+    """Run an operation whose step fails after changing the database."""
+    with operation(db_path, "rebalance", STEPS):
+        load(db_path, 1, 5)
+        msg = "step failed"
+        raise RuntimeError(msg)
+
+
+@pytest.fixture
+def db_path(tmp_path: Path) -> Path:
+    """Robot database with two empty presses."""
+    db_path = tmp_path / "robot.db"
+    with closing(sqlite3.connect(db_path)) as conn, conn:
+        conn.execute("CREATE TABLE Press_Table (`Press Number` INTEGER, `Current Cell Number Loaded` INTEGER)")
+        conn.executemany("INSERT INTO Press_Table VALUES (?, 0)", [(1,), (2,)])
+    return db_path
+
+
+class TestOperation:
+    """Journaling an operation while it runs."""
+
+    def test_finished(self, db_path: Path) -> None:
+        """A finished operation keeps its changes and removes the journal and the copy."""
+        with operation(db_path, "rebalance", STEPS) as journal:
+            load(db_path, 1, 5)
+            journal.done(0)
+            assert read_journal(db_path)["steps"][0]["done"] is True
+        assert loaded(db_path) == [5, 0]
+        assert not journal_path(db_path).exists()
+        assert not snapshot_path(db_path).exists()
+
+    def test_failed_step_rolls_back(self, db_path: Path) -> None:
+        """If a step fails the database is restored straight away."""
+        with pytest.raises(RuntimeError, match="step failed"):
+            fail(db_path)
+        assert loaded(db_path) == [0, 0]
+        assert read_journal(db_path) is None
+
+    def test_unfinished_refused(self, db_path: Path) -> None:
+        """Commands and new operations are refused until an unfinished one is recovered."""
+        crash(db_path, STEPS)
+        with pytest.raises(DatabaseError, match="recover --back"):
+            check_unfinished(db_path)
+        with pytest.raises(DatabaseError, match="did not finish"):
+            crash(db_path, STEPS)
+
+
+class TestRecover:
+    """Rolling an unfinished operation back or forward."""
+
+    def test_back(self, db_path: Path) -> None:
+        """Rolling back restores the database from before the operation."""
+        crash(db_path, STEPS, done=1)
+        recover(db_path)
+        assert loaded(db_path) == [0, 0]
+        assert read_journal(db_path) is None
+        assert not snapshot_path(db_path).exists()
+
+    def test_forward(self, db_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """Rolling forward runs only the steps that were not done, and keeps the changes."""
+        calls = []
+        monkeypatch.setitem(jobs.COMMANDS, "balance", lambda _db_path, args: calls.append(("balance", args)))
+        monkeypatch.setitem(jobs.COMMANDS, "assign-press", lambda _db_path, args: calls.append(("assign", args)))
+        crash(db_path, STEPS, done=1)
+        recover(db_path, forward=True)
+        assert calls == [("assign", {"limit": 0, "dry_run": False})]
+        assert loaded(db_path) == [5, 0]
+        assert read_journal(db_path) is None
+
+    def test_forward_not_rerunnable(self, db_path: Path) -> None:
+        """A step without a job command cannot be run again, the journal is kept to roll back."""
+        crash(db_path, [{"description": "Swap the electrodes"}])
+        with pytest.raises(ConfigError, match="Swap the electrodes"):
+            recover(db_path, forward=True)
+        assert read_journal(db_path) is not None
+
+    @pytest.mark.parametrize("forward", [False, True])
+    def test_dry_run(self, db_path: Path, forward: bool) -> None:
+        """A dry run only logs the steps."""
+        crash(db_path, STEPS)
+        recover(db_path, forward=forward, dry_run=True)
+        assert loaded(db_path) == [5, 0]
+        assert read_journal(db_path) is not None
+
+    def test_nothing_to_recover(self, db_path: Path) -> None:
+        """Without an unfinished operation nothing changes."""
+        recover(db_path)
+        assert loaded(db_path) == [0, 0]
+
+
+class TestCleanUnfinished:
+    """Rolling back the operation of a crashed command with --force-clean."""
+
+    def test_crashed_process(self, db_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """The operation of a process that is gone is rolled back."""
+        journal = crash(db_path, STEPS)
+        write_journal(db_path, {**journal.journal, "pid": 1_000_000})
+        monkeypatch.setattr(lock, "process_running", lambda _pid: False)
+        clean_unfinished(db_path)
+        assert loaded(db_path) == [0, 0]
+        assert read_journal(db_path) is None
+
+    def test_running_process(self, db_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """The operation of a process that is still running is left alone."""
+        journal = crash(db_path, STEPS)
+        write_journal(db_path, {**journal.journal, "pid": 1_000_000})
+        monkeypatch.setattr(lock, "process_running", lambda _pid: True)
+        clean_unfinished(db_path)
+        assert loaded(db_path) == [5, 0]
+        assert read_journal(db_path) is not None
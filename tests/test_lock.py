@@ -0,0 +1,97 @@
+"""Test the lock that lets only one command modify the database at a time."""
+
+import json
+import os
+import socket
+from pathlib import Path
+
+import pytest
+
+from aurora_robot_tools import lock
+from aurora_robot_tools.errors import AlreadyRunningError
+from aurora_robot_tools.lock import acquire_lock, is_stale, lock_path, read_lock
+
+
+def hold(db_path: Path, pid: int, host: str | None = None) -> Path:
+    """Write the lock of another process."""
+    path = lock_path(db_path)
+    holder = {"pid": pid, "host": host or socket.gethostname(), "command": "balance", "started": "earlier"}
+    path.write_text(json.dumps(holder), encoding="utf-8")
+    return path
+
+
+class TestAcquireLock:
+    """Holding the lock while a command runs."""
+
+    def test_held_while_running(self, tmp_path: Path) -> None:
+        """The lock names the process and command, and is removed afterwards."""
+        db_path = tmp_path / "robot.db"
+        with acquire_lock(db_path, "balance"):
+            holder = read_lock(lock_path(db_path))
+            assert holder["pid"] == os.getpid()
+            assert holder["command"] == "balance"
+        assert not lock_path(db_path).exists()
+
+    def test_released_after_error(self, tmp_path: Path) -> None:
+        """The lock is also removed if the command fails."""
+        db_path = tmp_path / "robot.db"
+
+        def fail() -> None:
+            with acquire_lock(db_path, "balance"):
+                msg = "command failed"
+                raise RuntimeError(msg)
+
+        with pytest.raises(RuntimeError, match="command failed"):
+            fail()
+        assert not lock_path(db_path).exists()
+
+    def test_already_running(self, tmp_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """A second command exits while another one that is still running holds the lock."""
+        db_path = tmp_path / "robot.db"
+        path = hold(db_path, 1_000_000)
+        monkeypatch.setattr(lock, "process_running", lambda _pid: True)
+        with pytest.raises(AlreadyRunningError, match="already using the database"), acquire_lock(db_path, "assign"):
+            pass
+        assert read_lock(path)["command"] == "balance"
+
+    def test_stale_refused(self, tmp_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """A lock left by a crashed command is not removed without --force-clean."""
+        db_path = tmp_path / "robot.db"
+        hold(db_path, 1_000_000)
+        monkeypatch.setattr(lock, "process_running", lambda _pid: False)
+        with pytest.raises(AlreadyRunningError, match="--force-clean"), acquire_lock(db_path, "assign"):
+            pass
+
+    def test_stale_removed(self, tmp_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """With --force-clean the lock of a crashed command is replaced."""
+        db_path = tmp_path / "robot.db"
+        hold(db_path, 1_000_000)
+        monkeypatch.setattr(lock, "process_running", lambda _pid: False)
+        with acquire_lock(db_path, "assign", force_clean=True):
+            assert read_lock(lock_path(db_path))["command"] == "assign"
+
+
+class TestIsStale:
+    """Telling a lock of a crashed command from one in use."""
+
+    @pytest.mark.parametrize(("running", "expected"), [(True, False), (False, True)])
+    def test_process(self, tmp_path: Path, monkeypatch: pytest.MonkeyPatch, running: bool, expected: bool) -> None:
+        """A lock is stale if its process is gone."""
+        path = hold(tmp_path / "robot.db", 1_000_000)
+        monkeypatch.setattr(lock, "process_running", lambda _pid: running)
+        assert is_stale(path) is expected
+
+    def test_other_host(self, tmp_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """The processes of another PC cannot be checked, so its lock is never stale."""
+        path = hold(tmp_path / "robot.db", 1_000_000, host="other-pc")
+        monkeypatch.setattr(lock, "process_running", lambda _pid: False)
+        assert is_stale(path) is False
+
+    def test_unreadable(self, tmp_path: Path) -> None:
+        """A lock without a process ID is only stale once it is old."""
+        path = lock_path(tmp_path / "robot.db")
+        path.write_text("", encoding="utf-8")
+        assert is_stale(path) is False
+        old = path.stat().st_mtime - lock.UNREADABLE_STALE_AGE - 1
+        os.utime(path, (old, old))
+        assert is_stale(path) is True
@@ -0,0 +1,120 @@
+"""Test the lot constraints and pinned cathodes of capacity balancing."""
+
+import numpy as np
+import pandas as pd
+import pytest
+
+from aurora_robot_tools.capacity_balance import allowed_pairs, cost_matrix_assign, split_lots
+from aurora_robot_tools.errors import ConfigError
+
+
+def cells(**constraints: list) -> pd.DataFrame:
+    """Three cells of equal capacity, with anode lots A1, A1, A2 and cathode lots C1, C2, C2."""
+    df = pd.DataFrame(
+        {
+            "Rack Position": [1, 2, 3],
+            "Anode Type": ["A1", "A1", "A2"],
+            "Cathode Type": ["C1", "C2", "C2"],
+            "Cathode Rack Position": [1, 2, 3],
+            "Anode Balancing Capacity (mAh)": [1.1, 1.1, 1.1],
+            "Cathode Balancing Capacity (mAh)": [1.0, 1.0, 1.0],
+            "Anode Diameter (mm)": [15.0, 15.0, 15.0],
+            "Cathode Diameter (mm)": [15.0, 15.0, 15.0],
+            "N:P Ratio Minimum": [1.0, 1.0, 1.0],
+            "N:P Ratio Target": [1.1, 1.1, 1.1],
+            "N:P Ratio Maximum": [1.2, 1.2, 1.2],
+        },
+    )
+    for column, values in constraints.items():
+        df[column.replace("_", " ")] = values
+    return df
+
+
+class TestSplitLots:
+    """Parsing the comma separated lists of lots."""
+
+    @pytest.mark.parametrize(
+        ("value", "expected"),
+        [
+            (None, set()),
+            (np.nan, set()),
+            ("", set()),
+            ("L1", {"L1"}),
+            (" L1 , L2,,", {"L1", "L2"}),
+        ],
+    )
+    def test_split_lots(self, value: object, expected: set[str]) -> None:
+        """Empty values are no constraint, lots are stripped."""
+        assert split_lots(value) == expected
+
+
+class TestAllowedPairs:
+    """Which anodes and cathodes can be paired."""
+
+    def test_no_constraints(self) -> None:
+        """Without constraint columns every pair is allowed."""
+        assert allowed_pairs(cells()).all()
+
+    def test_allowed_cathode_lots(self) -> None:
+        """A cell can only get cathodes of its allowed lots."""
+        allowed = allowed_pairs(cells(Allowed_Cathode_Lots=["C2", None, "C1, C2"]))
+        np.testing.assert_array_equal(allowed, [[False, True, True], [True, True, True], [True, True, True]])
+
+    def test_forbidden_anode_lot(self) -> None:
+        """A cell whose anode is not of an allowed lot cannot get any cathode."""
+        allowed = allowed_pairs(cells(Allowed_Anode_Lots=["A2", None, "A2"]))
+        np.testing.assert_array_equal(allowed, [[False, False, False], [True, True, True], [True, True, True]])
+
+    def test_keep_anode_lots_separate(self) -> None:
+        """Cathodes are only exchanged between cells with the same anode lot."""
+        allowed = allowed_pairs(cells(Keep_Lots_Separate=["anode", "anode", "anode"]))
+        np.testing.assert_array_equal(allowed, [[True, True, False], [True, True, False], [False, False, True]])
+
+    def test_keep_cathode_lots_separate(self) -> None:
+        """A cell only gets cathodes of the lot in its own position."""
+        allowed = allowed_pairs(cells(Keep_Lots_Separate=["Cathode", None, None]))
+        np.testing.assert_array_equal(allowed, [[True, False, False], [True, True, True], [True, True, True]])
+
+    def test_invalid_keep_lots_separate(self) -> None:
+        """Anything but anode and cathode is a config error."""
+        with pytest.raises(ConfigError, match="rack position 2"):
+            allowed_pairs(cells(Keep_Lots_Separate=[None, "separator", None]))
+
+    def test_pinned_cathode(self) -> None:
+        """A pinned cathode can only go to its cell, and the cell can only get that cathode."""
+        allowed = allowed_pairs(cells(Pinned_Cathode_Rack_Position=[np.nan, 3, np.nan]))
+        np.testing.assert_array_equal(allowed, [[True, True, False], [False, False, True], [True, True, False]])
+
+    def test_constraints_and_pins_combine(self) -> None:
+        """A pin does not lift the lot constraints of the cell."""
+        allowed = allowed_pairs(
+            cells(Allowed_Cathode_Lots=["C1", None, None], Pinned_Cathode_Rack_Position=[2, np.nan, np.nan]),
+        )
+        assert not allowed[0].any()
+        assert not allowed[:, 1].any()
+
+
+class TestCostMatrixAssign:
+    """Matching under the lot constraints."""
+
+    def test_follows_constraints(self) -> None:
+        """The matching uses the only cathode a cell is allowed."""
+        df = cells(Allowed_Cathode_Lots=["C2", None, "C1"])
+        anode_ind, cathode_ind = cost_matrix_assign(df)
+        matched = dict(zip(anode_ind, cathode_ind))
+        assert matched[2] == 0
+        assert matched[0] in (1, 2)
+        assert allowed_pairs(df)[anode_ind, cathode_ind].all()
+
+    def test_pin(self) -> None:
+        """A pinned cathode goes to its cell."""
+        df = cells(Pinned_Cathode_Rack_Position=[3, np.nan, np.nan])
+        anode_ind, cathode_ind = cost_matrix_assign(df)
+        assert dict(zip(anode_ind, cathode_ind))[0] == 2
+
+    def test_infeasible_lot(self) -> None:
+        """A cell that no cathode is allowed for gets a forbidden pair, which balancing rejects."""
+        df = cells(Allowed_Cathode_Lots=["C9", None, None])
+        anode_ind, cathode_ind = cost_matrix_assign(df)
+        allowed = allowed_pairs(df)[anode_ind, cathode_ind]
+        assert allowed.tolist() == [anode != 0 for anode in anode_ind]
@@ -0,0 +1,63 @@
+"""Test recognising a command that is run again for work that is already done."""
+
+import sqlite3
+from contextlib import closing
+from pathlib import Path
+
+import pytest
+
+from aurora_robot_tools.rerun import already_done, record, record_path
+
+
+@pytest.fixture
+def db_path(tmp_path: Path) -> Path:
+    """Robot database with one empty press."""
+    db_path = tmp_path / "robot.db"
+    with closing(sqlite3.connect(db_path)) as conn, conn:
+        conn.execute("CREATE TABLE Press_Table (`Press Number` INTEGER, `Current Cell Number Loaded` INTEGER)")
+        conn.execute("INSERT INTO Press_Table VALUES (1, 0)")
+    return db_path
+
+
+class TestAlreadyDone:
+    """Skipping a command only if nothing changed since it ran."""
+
+    def test_same_run(self, db_path: Path) -> None:
+        """The same command with the same arguments on an unchanged database is already done."""
+        record(db_path, "balance", ["--mode", "6"])
+        assert already_done(db_path, "balance", ["--mode", "6"])
+
+    def test_never_ran(self, db_path: Path) -> None:
+        """Without a recorded run the command runs."""
+        assert not record_path(db_path).exists()
+        assert not already_done(db_path, "balance", [])
+
+    @pytest.mark.parametrize(
+        ("command", "arguments", "defaults"),
+        [("assign", ["--mode", "6"], None), ("balance", ["--mode", "3"], None), ("balance", ["--mode", "6"], {"x": 1})],
+    )
+    def test_different_run(self, db_path: Path, command: str, arguments: list[str], defaults: object) -> None:
+        """Another command, other arguments or other defaults from --args-file run as usual."""
+        record(db_path, "balance", ["--mode", "6"])
+        assert not already_done(db_path, command, arguments, defaults)
+
+    def test_database_changed(self, db_path: Path) -> None:
+        """If the robot changed the database since, the command runs again."""
+        record(db_path, "balance", [])
+        with closing(sqlite3.connect(db_path)) as conn, conn:
+            conn.execute("UPDATE Press_Table SET `Current Cell Number Loaded` = 5")
+        assert not already_done(db_path, "balance", [])
+
+    def test_input_file_changed(self, db_path: Path, tmp_path: Path) -> None:
+        """If an input file given as an argument changed, the command runs again."""
+        samples = tmp_path / "samples.csv"
+        samples.write_text("Sample,Mass (mg)\nA,3.5\n", encoding="utf-8")
+        record(db_path, "import-batch", [str(samples)])
+        assert already_done(db_path, "import-batch", [str(samples)])
+        samples.write_text("Sample,Mass (mg)\nA,3.6\n", encoding="utf-8")
+        assert not already_done(db_path, "import-batch", [str(samples)])
+
+    def test_unreadable_record(self, db_path: Path) -> None:
+        """A damaged record is ignored and the command runs."""
+        record_path(db_path).write_text("{", encoding="utf-8")
+        assert not already_done(db_path, "balance", [])
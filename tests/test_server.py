@@ -0,0 +1,85 @@
+"""Test the arguments of requests to the HTTP server."""
+
+import http.client
+import json
+import threading
+from collections.abc import Iterator
+
+import pytest
+
+from aurora_robot_tools import server
+from aurora_robot_tools.server import RequestHandler
+
+
+class Server:
+    """A server on a free port, recording the jobs instead of running them."""
+
+    def __init__(self) -> None:
+        """Start serving in a background thread."""
+        self.jobs: list[tuple[str, dict]] = []
+        self.httpd = server.HTTPServer(("127.0.0.1", 0), RequestHandler)
+        self.thread = threading.Thread(target=self.httpd.serve_forever, daemon=True)
+        self.thread.start()
+
+    def run_job(self, command: str, _db_path: object, args: dict) -> dict:
+        """Record the job and succeed."""
+        self.jobs.append((command, args))
+        return {"ok": True, "exit_code": 0, "messages": [], "plan": []}
+
+    def post(self, path: str, body: bytes) -> tuple[int, dict]:
+        """Send a POST request, return the status and the JSON response."""
+        conn = http.client.HTTPConnection(*self.httpd.server_address, timeout=10)
+        try:
+            conn.request("POST", path, body, {"Content-Type": "application/json"})
+            response = conn.getresponse()
+            return response.status, json.loads(response.read())
+        finally:
+            conn.close()
+
+    def stop(self) -> None:
+        """Stop serving."""
+        self.httpd.shutdown()
+        self.httpd.server_close()
+
+
+@pytest.fixture
+def service(monkeypatch: pytest.MonkeyPatch) -> Iterator[Server]:
+    """A running server, started without --dry-run unless a test sets it."""
+    running = Server()
+    monkeypatch.setattr(server, "run_job", running.run_job)
+    monkeypatch.setattr(RequestHandler, "dry_run", False)
+    yield running
+    running.stop()
+
+
+class TestPost:
+    """Running a job from a POST request."""
+
+    @pytest.mark.parametrize("dry_run", [False, True])
+    def test_dry_run_false(self, service: Server, monkeypatch: pytest.MonkeyPatch, dry_run: bool) -> None:
+        """A request cannot switch off the dry run of a --dry-run server."""
+        monkeypatch.setattr(RequestHandler, "dry_run", dry_run)
+        status, _body = service.post("/balance", b'{"mode": 6, "dry_run": false}')
+        assert status == 200
+        assert service.jobs == [("balance", {"mode": 6, "dry_run": dry_run})]
+
+    @pytest.mark.parametrize("dry_run", [False, True])
+    def test_dry_run_true(self, service: Server, monkeypatch: pytest.MonkeyPatch, dry_run: bool) -> None:
+        """A request can always ask for a dry run."""
+        monkeypatch.setattr(RequestHandler, "dry_run", dry_run)
+        service.post("/balance", b'{"dry_run": true}')
+        assert service.jobs == [("balance", {"dry_run": True})]
+
+    @pytest.mark.parametrize("value", [b'"false"', b'"true"', b"0", b"null"])
+    def test_dry_run_not_a_boolean(self, service: Server, value: bytes) -> None:
+        """A dry run that is not JSON true or false is refused with 400 and the job is not run."""
+        status, body = service.post("/balance", b'{"dry_run": ' + value + b"}")
+        assert status == 400
+        assert "dry_run of a job must be true or false" in body["error"]
+        assert service.jobs == []
+
+    @pytest.mark.parametrize(("path", "body", "status"), [("/unknown", b"{}", 404), ("/balance", b"{", 400)])
+    def test_bad_request(self, service: Server, path: str, body: bytes, status: int) -> None:
+        """Unknown endpoints and invalid JSON are refused."""
+        assert service.post(path, body)[0] == status
+        assert service.jobs == []
@@ -0,0 +1,102 @@
+"""Test staging plans for approval and committing them to the robot database."""
+
+import logging
+import sqlite3
+from contextlib import closing
+from pathlib import Path
+
+import pytest
+
+from aurora_robot_tools import backup_database, database, staging
+from aurora_robot_tools.errors import DatabaseError
+from aurora_robot_tools.staging import check_unchanged, commit, discard, stage, staging_path
+
+
+def load(db_path: Path, press: int, cell: int) -> None:
+    """Load a cell into a press."""
+    with closing(sqlite3.connect(db_path)) as conn, conn:
+        conn.execute("UPDATE Press_Table SET `Current Cell Number Loaded` = ? WHERE `Press Number` = ?", (cell, press))
+
+
+def loaded(db_path: Path) -> list[int]:
+    """Cell loaded in each press."""
+    with closing(sqlite3.connect(db_path)) as conn:
+        rows = conn.execute("SELECT `Current Cell Number Loaded` FROM Press_Table ORDER BY `Press Number`")
+        return [row[0] for row in rows]
+
+
+@pytest.fixture
+def db_path(monkeypatch: pytest.MonkeyPatch, tmp_path: Path) -> Path:
+    """Robot database with two empty presses, without backups when committing."""
+    monkeypatch.setattr(backup_database, "auto_backup", lambda _db_path: None)
+    db_path = tmp_path / "robot.db"
+    with closing(sqlite3.connect(db_path)) as conn, conn:
+        conn.execute("CREATE TABLE Press_Table (`Press Number` INTEGER, `Current Cell Number Loaded` INTEGER)")
+        conn.executemany("INSERT INTO Press_Table VALUES (?, 0)", [(1,), (2,)])
+    return db_path
+
+
+class TestStage:
+    """Creating the staging copy."""
+
+    def test_copy(self, db_path: Path) -> None:
+        """The first planning command copies the robot database, later ones use the same copy."""
+        staged = stage(db_path)
+        assert staged == staging_path(db_path)
+        assert loaded(staged) == [0, 0]
+        load(staged, 1, 5)
+        assert stage(db_path) == staged
+        assert loaded(staged) == [5, 0]
+        assert loaded(db_path) == [0, 0]
+
+    def test_dry_run(self, db_path: Path) -> None:
+        """A dry run without a staging copy reads the robot database and creates no copy."""
+        assert stage(db_path, dry_run=True) == db_path
+        assert not staging_path(db_path).exists()
+
+    def test_unchanged(self, db_path: Path) -> None:
+        """The robot database has not changed since it was staged."""
+        check_unchanged(db_path, stage(db_path))
+
+
+class TestCommit:
+    """Replacing the robot database tables with the staged ones."""
+
+    def test_commit(self, db_path: Path) -> None:
+        """The staged plan replaces the robot database and the copy is removed."""
+        load(stage(db_path), 1, 5)
+        commit(db_path)
+        assert loaded(db_path) == [5, 0]
+        assert not staging_path(db_path).exists()
+
+    def test_changed_database_refused(self, db_path: Path) -> None:
+        """If the robot loaded a cell since staging, committing would undo it and is refused."""
+        staged = stage(db_path)
+        load(staged, 1, 5)
+        load(db_path, 2, 7)
+        with pytest.raises(DatabaseError, match="changed since the plan was staged"):
+            commit(db_path)
+        assert loaded(db_path) == [0, 7]
+        assert loaded(staged) == [5, 0]
+
+    def test_dry_run(self, db_path: Path, monkeypatch: pytest.MonkeyPatch) -> None:
+        """A dry run only logs the changes, the staged plan is kept."""
+        monkeypatch.setattr(database, "log_changes", lambda *_args: None)
+        load(stage(db_path), 1, 5)
+        commit(db_path, dry_run=True)
+        assert loaded(db_path) == [0, 0]
+        assert staging_path(db_path).exists()
+
+    def test_nothing_staged(self, db_path: Path, caplog: pytest.LogCaptureFixture) -> None:
+        """Without a staged plan nothing changes."""
+        with caplog.at_level(logging.INFO, logger=staging.__name__):
+            commit(db_path)
+        assert "No staged plan to commit." in caplog.text
+        assert loaded(db_path) == [0, 0]
+
+    def test_discard(self, db_path: Path) -> None:
+        """Discarding removes the staged plan without changing the robot database."""
+        load(stage(db_path), 1, 5)
+        discard(db_path)
+        assert not staging_path(db_path).exists()
+        assert loaded(db_path) == [0, 0]